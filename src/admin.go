@@ -0,0 +1,127 @@
+/*
+ *     admin.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/internal/handler"
+)
+
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// runAdmin implements the "admin" commands talking to a running webhook.
+// It returns the exit code of the process.
+func runAdmin(args []string) int {
+	fs := flag.NewFlagSet("admin", flag.ExitOnError)
+	server := fs.String("server", "https://localhost:9090", "base URL of the webhook")
+	tokenFile := fs.String("token-file", serviceAccountTokenFile, "file containing the bearer token used to authenticate")
+	caFile := fs.String("ca", "", "CA certificate used to verify the webhook; system roots if empty")
+	insecure := fs.Bool("insecure-skip-verify", false, "do not verify the certificate of the webhook")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin [flags] reindex\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || fs.Arg(0) != "reindex" {
+		fs.Usage()
+		return 2
+	}
+
+	client, err := adminClient(*caFile, *insecure)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	token, err := os.ReadFile(*tokenFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading token: %s\n", err)
+		return 1
+	}
+
+	if err := reindex(client, strings.TrimSuffix(*server, "/"), strings.TrimSpace(string(token)), os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func adminClient(caFile string, insecure bool) (*http.Client, error) {
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}, nil
+}
+
+// reindex triggers a reindex and prints the progress reported by the webhook to out.
+func reindex(client *http.Client, server, token string, out io.Writer) error {
+	req, err := http.NewRequest(http.MethodPost, server+"/-/reindex", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("triggering reindex: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("triggering reindex: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var ev handler.ReindexEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return fmt.Errorf("decoding progress: %w", err)
+		}
+		switch {
+		case ev.Error != "":
+			return fmt.Errorf("reindex failed: %s", ev.Error)
+		case ev.Progress != nil:
+			fmt.Fprintf(out, "scanned scope %q (%d/%d)\n", ev.Progress.Scope, ev.Progress.Done, ev.Progress.Total)
+		case ev.Report != nil:
+			fmt.Fprintf(out, "reindex complete: %d violations\n", ev.Report.Violations)
+		}
+	}
+	return scanner.Err()
+}
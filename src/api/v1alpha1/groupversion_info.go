@@ -0,0 +1,48 @@
+/*
+ *     groupversion_info.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	// GroupVersion is the group and version of the resources in this package.
+	GroupVersion = schema.GroupVersion{Group: "unik.io", Version: "v1alpha1"}
+
+	// UniqueAnnotationPolicies is the resource of UniqueAnnotationPolicy.
+	UniqueAnnotationPolicies = GroupVersion.WithResource("uniqueannotationpolicies")
+
+	// UnikControllerStates is the resource of UnikControllerState.
+	UnikControllerStates = GroupVersion.WithResource("unikcontrollerstates")
+
+	// SchemeBuilder registers the types of this package with a scheme.
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+
+	// AddToScheme adds the types of this package to a scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(scheme *runtime.Scheme) error {
+	scheme.AddKnownTypes(GroupVersion, &UniqueAnnotationPolicy{}, &UniqueAnnotationPolicyList{},
+		&UnikControllerState{}, &UnikControllerStateList{})
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+ *     unikcontrollerstate_types.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StateName is the name of the singleton UnikControllerState.
+const StateName = "unik"
+
+// SubsystemHealth is the health of a subsystem of the controller.
+type SubsystemHealth struct {
+	// Name is the name of the subsystem as reported by /readyz.
+	Name string `json:"name"`
+
+	// Critical subsystems make the controller unready when they fail.
+	Critical bool `json:"critical"`
+
+	// Healthy reports whether the last check of the subsystem succeeded.
+	Healthy bool `json:"healthy"`
+
+	// Message is the error of an unhealthy subsystem.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// UnikControllerStateStatus is the state of the controller as seen by the
+// leader among its replicas.
+type UnikControllerStateStatus struct {
+	// Version is the version of the leader.
+	Version string `json:"version"`
+
+	// Commit is the commit the leader was built from.
+	Commit string `json:"commit"`
+
+	// ConfigHash is the SHA-256 of the effective configuration, merged
+	// from all sources. Replicas with the same hash protect the same
+	// annotations.
+	ConfigHash string `json:"configHash"`
+
+	// FeatureGates maps the known feature gates to whether they are enabled.
+	// +optional
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+
+	// Ready reports whether the leader is ready to decide requests.
+	Ready bool `json:"ready"`
+
+	// Subsystems lists the health of the subsystems of the leader.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Subsystems []SubsystemHealth `json:"subsystems,omitempty"`
+
+	// Leader is the identity of the replica which published the state.
+	Leader string `json:"leader"`
+
+	// LastUpdateTime is when the state last changed.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime"`
+}
+
+// UnikControllerState reports the state of the running controller. The
+// leader among its replicas maintains a single instance named StateName.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=ucs
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+// +kubebuilder:printcolumn:name="Ready",type=boolean,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Leader",type=string,JSONPath=`.status.leader`
+// +kubebuilder:printcolumn:name="Updated",type=date,JSONPath=`.status.lastUpdateTime`
+type UnikControllerState struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status UnikControllerStateStatus `json:"status,omitempty"`
+}
+
+// UnikControllerStateList is a list of UnikControllerStates.
+// +kubebuilder:object:root=true
+type UnikControllerStateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []UnikControllerState `json:"items"`
+}
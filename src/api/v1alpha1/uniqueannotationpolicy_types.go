@@ -0,0 +1,91 @@
+/*
+ *     uniqueannotationpolicy_types.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package v1alpha1
+
+import (
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionAccepted reports whether the annotations of a policy have
+	// been merged into the protected annotations.
+	ConditionAccepted = "Accepted"
+
+	// ReasonValid is the reason of an accepted policy.
+	ReasonValid = "Valid"
+	// ReasonInvalid is the reason of a policy rejected for invalid annotations.
+	ReasonInvalid = "Invalid"
+	// ReasonConflict is the reason of a policy rejected because an older
+	// policy already protects one of its annotations in the same scope.
+	ReasonConflict = "Conflict"
+)
+
+// UniqueAnnotationPolicySpec declares annotations whose values must be unique.
+type UniqueAnnotationPolicySpec struct {
+	// Namespace restricts the policy to services in the given namespace,
+	// or in the namespaces matching a glob pattern such as "team-*", a
+	// regular expression starting with "^" or a label selector such as
+	// "env=prod". By default, values must be unique across the whole cluster.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Annotations lists the protected annotations.
+	// +kubebuilder:validation:MinItems=1
+	Annotations []validator.ProtectedAnnotation `json:"annotations"`
+}
+
+// UniqueAnnotationPolicyStatus reports whether a policy is in effect.
+type UniqueAnnotationPolicyStatus struct {
+	// ObservedGeneration is the generation of the spec the status refers to.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions holds the Accepted condition.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// UniqueAnnotationPolicy declares annotations whose values must be unique
+// among the services of the cluster or of a namespace.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=uap
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.spec.namespace`
+// +kubebuilder:printcolumn:name="Accepted",type=string,JSONPath=`.status.conditions[?(@.type=="Accepted")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type UniqueAnnotationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UniqueAnnotationPolicySpec   `json:"spec"`
+	Status UniqueAnnotationPolicyStatus `json:"status,omitempty"`
+}
+
+// UniqueAnnotationPolicyList is a list of UniqueAnnotationPolicies.
+// +kubebuilder:object:root=true
+type UniqueAnnotationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []UniqueAnnotationPolicy `json:"items"`
+}
@@ -0,0 +1,173 @@
+/*
+ *     cli.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// exitCode is returned by commands to exit with a specific code, after
+// they reported the reason themselves.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+// execute runs the command given by args and returns the exit code of the
+// process.
+func execute(args []string, stdout, stderr io.Writer) int {
+	root := newRootCommand()
+	root.SetArgs(serveByDefault(args))
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+	err := root.Execute()
+	var code exitCode
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	}
+	fmt.Fprintln(stderr, err)
+	return 1
+}
+
+// serveByDefault prepends the serve command to args not starting with a
+// command, so deployments predating the commands keep working.
+func serveByDefault(args []string) []string {
+	if len(args) == 0 {
+		return []string{"serve"}
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		return args
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return append([]string{"serve"}, args...)
+	}
+	return args
+}
+
+// newRootCommand returns the command line interface. Commands which
+// existed before it parse their flags themselves, so their flags keep
+// their names and single dash syntax.
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "unik",
+		Short:         "Admission controller keeping annotation values unique",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	root.CompletionOptions.DisableDefaultCmd = true
+	root.AddCommand(
+		newServeCommand(),
+		newVersionCommand(),
+		flagCommand("admin", "Talk to the admin API of a running webhook", runAdmin),
+		flagCommand("inventory", "Report the annotations used by existing services", runInventory),
+		flagCommand("manifests", "Generate the RBAC manifests for the enabled features", runManifests),
+		flagCommand("migrate", "Migrate a deployment of the legacy binary", runMigrate),
+		flagCommand("selftest", "Test the webhook registered in the cluster", runSelftest),
+	)
+	return root
+}
+
+// flagCommand wraps run, which parses args with its own flag.FlagSet and
+// returns the exit code of the process.
+func flagCommand(name, short string, run func(args []string) int) *cobra.Command {
+	return &cobra.Command{
+		Use:                name,
+		Short:              short,
+		DisableFlagParsing: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if code := run(args); code != 0 {
+				return exitCode(code)
+			}
+			return nil
+		},
+	}
+}
+
+// newServeCommand returns the command running the webhook. Its flags are
+// the ones of flag.CommandLine, which the manifests command shares.
+func newServeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                "serve",
+		Short:              "Run the webhook; the default without a command",
+		DisableFlagParsing: true,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := flag.CommandLine.Parse(args); err != nil {
+				return exitCode(2)
+			}
+			if flag.NArg() > 0 {
+				return fmt.Errorf("unexpected arguments %q", flag.Args())
+			}
+			if code := serve(); code != 0 {
+				return exitCode(code)
+			}
+			return nil
+		},
+	}
+}
+
+// buildInfo is printed by the version command.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+func newVersionCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version of the binary",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			info := buildInfo{
+				Version:   version,
+				Commit:    commit,
+				GoVersion: runtime.Version(),
+				Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+			}
+			switch output {
+			case "text":
+				fmt.Fprintf(cmd.OutOrStdout(), "unik %s (commit %s, %s, %s)\n", info.Version, info.Commit, info.GoVersion, info.Platform)
+				return nil
+			case "json":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(info)
+			}
+			return fmt.Errorf("unknown output format %q", output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "output format, text or json")
+	return cmd
+}
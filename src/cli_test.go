@@ -0,0 +1,63 @@
+/*
+ *     cli_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeByDefault(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"serve"}},
+		{[]string{"-cert=/tls/tls.crt"}, []string{"serve", "-cert=/tls/tls.crt"}},
+		{[]string{"--debug"}, []string{"serve", "--debug"}},
+		{[]string{"serve", "-debug"}, []string{"serve", "-debug"}},
+		{[]string{"version"}, []string{"version"}},
+		{[]string{"--help"}, []string{"--help"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, serveByDefault(tt.args), "%q", tt.args)
+	}
+}
+
+func TestVersionCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	require.Equal(t, 0, execute([]string{"version", "-o", "json"}, &stdout, &stderr), stderr.String())
+	var info buildInfo
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &info))
+	assert.Equal(t, version, info.Version)
+	assert.Equal(t, commit, info.Commit)
+
+	stdout.Reset()
+	require.Equal(t, 0, execute([]string{"version"}, &stdout, &stderr))
+	assert.Contains(t, stdout.String(), "unik dev (commit unknown")
+
+	assert.Equal(t, 1, execute([]string{"version", "-o", "yaml"}, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown output format "yaml"`)
+	assert.Equal(t, 1, execute([]string{"unknown"}, &stdout, &stderr))
+}
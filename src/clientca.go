@@ -0,0 +1,192 @@
+/*
+ *     clientca.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// tlsGeneration is the serving certificate and client CA in effect
+// between two reloads.
+type tlsGeneration struct {
+	cert   tls.Certificate
+	pool   *x509.CertPool
+	caHash [sha256.Size]byte
+}
+
+// clientCA requires clients of the webhook to present a certificate
+// issued by the CA in caFile. The CA and the serving certificate are
+// reloaded while serving: new handshakes use the new CA right away, while
+// connections authenticated under the old one are closed after grace, so
+// rotating the CA does not need a restart of the webhook.
+type clientCA struct {
+	logger                    *zap.Logger
+	certFile, keyFile, caFile string
+	grace                     time.Duration
+
+	current atomic.Pointer[tlsGeneration]
+
+	lock sync.Mutex
+	// conns holds the open connections of all servers using the CA.
+	conns map[net.Conn]struct{}
+}
+
+func newClientCA(logger *zap.Logger, certFile, keyFile, caFile string, grace time.Duration) (*clientCA, error) {
+	c := &clientCA{logger: logger, certFile: certFile, keyFile: keyFile, caFile: caFile, grace: grace, conns: make(map[net.Conn]struct{})}
+	gen, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.current.Store(gen)
+	return c, nil
+}
+
+// load reads the serving certificate and the client CA.
+func (c *clientCA) load() (*tlsGeneration, error) {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(c.caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s holds no PEM encoded certificates", c.caFile)
+	}
+	return &tlsGeneration{cert: cert, pool: pool, caHash: sha256.Sum256(bytes.TrimSpace(data))}, nil
+}
+
+// configure makes srv require client certificates issued by the current
+// CA and tracks its connections. It must be called after configureHTTP2,
+// whose protocols are kept.
+func (c *clientCA) configure(srv *http.Server) {
+	if srv.TLSConfig == nil {
+		srv.TLSConfig = &tls.Config{}
+	}
+	base := srv.TLSConfig
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		gen := c.current.Load()
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.Certificates = []tls.Certificate{gen.cert}
+		cfg.ClientCAs = gen.pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		return cfg, nil
+	}
+	track := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		c.track(conn, state)
+		if track != nil {
+			track(conn, state)
+		}
+	}
+}
+
+func (c *clientCA) track(conn net.Conn, state http.ConnState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch state {
+	case http.StateNew:
+		c.conns[conn] = struct{}{}
+	case http.StateClosed, http.StateHijacked:
+		delete(c.conns, conn)
+	}
+}
+
+// Reload loads the certificate and CA again. If the CA changed, all
+// connections open now were authenticated under the old one and are
+// closed after the grace period, unless they are closed before. A
+// failing reload keeps the configuration in effect.
+func (c *clientCA) Reload() error {
+	gen, err := c.load()
+	if err != nil {
+		metrics.TLSReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	previous := c.current.Swap(gen)
+	metrics.TLSReloads.WithLabelValues("success").Inc()
+	if previous.caHash == gen.caHash {
+		return nil
+	}
+
+	c.lock.Lock()
+	stale := make([]net.Conn, 0, len(c.conns))
+	for conn := range c.conns {
+		stale = append(stale, conn)
+	}
+	c.lock.Unlock()
+	c.logger.Info("Client CA changed, closing connections authenticated under the previous one after the grace period",
+		zap.Int("connections", len(stale)), zap.Duration("grace", c.grace))
+	time.AfterFunc(c.grace, func() { c.closeStale(stale) })
+	return nil
+}
+
+// closeStale closes the connections of stale which are still open.
+func (c *clientCA) closeStale(stale []net.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	closed := 0
+	for _, conn := range stale {
+		if _, open := c.conns[conn]; !open {
+			continue
+		}
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.logger.Warn("Failed to close connection", zap.Stringer("remote", conn.RemoteAddr()), zap.Error(err))
+		}
+		delete(c.conns, conn)
+		closed++
+	}
+	if closed > 0 {
+		c.logger.Info("Closed connections authenticated under the previous client CA", zap.Int("connections", closed))
+	}
+}
+
+// Watch reloads the certificate and CA every interval until ctx is done.
+func (c *clientCA) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Reload(); err != nil {
+				c.logger.Error("Failed to reload client CA, keeping the previous one", zap.String("file", c.caFile), zap.Error(err))
+			}
+		}
+	}
+}
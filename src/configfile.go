@@ -0,0 +1,285 @@
+/*
+ *     configfile.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// fileConfig is the file given by -config. Besides the protected
+// annotations, it holds settings also available as flags, which apply
+// unless the flag is given on the command line.
+type fileConfig struct {
+	config.Config
+
+	TLS    tlsSettings    `json:"tls,omitempty"`
+	Server serverSettings `json:"server,omitempty"`
+
+	// ExemptNamespaces corresponds to -exempt-namespaces.
+	ExemptNamespaces []string `json:"exemptNamespaces,omitempty"`
+
+	// Messages replaces denial and warning texts. A catalog given with
+	// -messages takes precedence.
+	Messages validator.Catalog `json:"messages,omitempty"`
+
+	// DenialTemplate renders denial messages. A template given with
+	// -denial-template takes precedence.
+	DenialTemplate string `json:"denialTemplate,omitempty"`
+
+	// FeatureGates corresponds to -feature-gates.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// tlsSettings correspond to -cert, -key and -client-ca.
+type tlsSettings struct {
+	Cert     string `json:"cert,omitempty"`
+	Key      string `json:"key,omitempty"`
+	ClientCA string `json:"clientCA,omitempty"`
+}
+
+// serverSettings correspond to the flags of the same names.
+type serverSettings struct {
+	Addrs               []string         `json:"addrs,omitempty"`
+	MetricsAddr         *string          `json:"metricsAddr,omitempty"`
+	AdminAddr           string           `json:"adminAddr,omitempty"`
+	AllowedHosts        []string         `json:"allowedHosts,omitempty"`
+	MaxRequestBytes     int64            `json:"maxRequestBytes,omitempty"`
+	MaxHeaderBytes      int              `json:"maxHeaderBytes,omitempty"`
+	ReadHeaderTimeout   *metav1.Duration `json:"readHeaderTimeout,omitempty"`
+	ReadTimeout         *metav1.Duration `json:"readTimeout,omitempty"`
+	WriteTimeout        *metav1.Duration `json:"writeTimeout,omitempty"`
+	IdleTimeout         *metav1.Duration `json:"idleTimeout,omitempty"`
+	ShutdownGracePeriod *metav1.Duration `json:"shutdownGracePeriod,omitempty"`
+	HTTP2               *bool            `json:"http2,omitempty"`
+}
+
+// loadConfigFile reads and validates the YAML or JSON file at path.
+// Unknown fields are rejected, so typos do not silently drop protection.
+func loadConfigFile(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc fileConfig
+	if err := yaml.UnmarshalStrict(data, &fc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := fc.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &fc, nil
+}
+
+// loadCatalog reads the message catalog in the YAML or JSON file at path,
+// which maps message keys to their texts.
+func loadCatalog(path string) (validator.Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c validator.Catalog
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// denialTemplate parses the template given with -denial-template or in
+// fc, if any. Invalid templates are logged and nil is returned, so the
+// default messages are kept instead of failing on a cosmetic setting.
+func denialTemplate(logger *zap.Logger, fc *fileConfig) *validator.DenialTemplate {
+	var text string
+	source := denialTemplateFile
+	switch {
+	case denialTemplateFile != "":
+		data, err := os.ReadFile(denialTemplateFile)
+		if err != nil {
+			logger.Error("Failed to read denial template, keeping the default messages", zap.String("file", denialTemplateFile), zap.Error(err))
+			return nil
+		}
+		text = string(data)
+	case fc != nil && fc.DenialTemplate != "":
+		text, source = fc.DenialTemplate, configFile
+	default:
+		return nil
+	}
+	t, err := validator.ParseDenialTemplate(text)
+	if err != nil {
+		logger.Error("Invalid denial template, keeping the default messages", zap.String("file", source), zap.Error(err))
+		return nil
+	}
+	return t
+}
+
+// validate checks fc for errors and returns all of them.
+func (fc *fileConfig) validate() error {
+	errs := []error{fc.Config.Validate(), fc.Messages.Validate()}
+	if (fc.TLS.Cert == "") != (fc.TLS.Key == "") {
+		errs = append(errs, errors.New("tls: cert and key must be given together"))
+	}
+	if fc.Server.MaxRequestBytes < 0 {
+		errs = append(errs, errors.New("server: maxRequestBytes must not be negative"))
+	}
+	if fc.Server.MaxHeaderBytes < 0 {
+		errs = append(errs, errors.New("server: maxHeaderBytes must not be negative"))
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"readHeaderTimeout":   fc.Server.ReadHeaderTimeout,
+		"readTimeout":         fc.Server.ReadTimeout,
+		"writeTimeout":        fc.Server.WriteTimeout,
+		"idleTimeout":         fc.Server.IdleTimeout,
+		"shutdownGracePeriod": fc.Server.ShutdownGracePeriod,
+	} {
+		if d != nil && d.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("server: %s must be positive", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// apply sets the flags of fs to the settings of fc, except for flags given
+// on the command line.
+func (fc *fileConfig) apply(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	values := make(map[string][]string)
+	set := func(name string, value ...string) {
+		values[name] = value
+	}
+	if fc.TLS.Cert != "" {
+		set("cert", fc.TLS.Cert)
+		set("key", fc.TLS.Key)
+	}
+	if fc.TLS.ClientCA != "" {
+		set("client-ca", fc.TLS.ClientCA)
+	}
+	if len(fc.FeatureGates) > 0 {
+		gates := make([]string, 0, len(fc.FeatureGates))
+		for name, enabled := range fc.FeatureGates {
+			gates = append(gates, name+"="+strconv.FormatBool(enabled))
+		}
+		sort.Strings(gates)
+		set("feature-gates", strings.Join(gates, ","))
+	}
+	if fc.ExemptNamespaces != nil {
+		set("exempt-namespaces", strings.Join(fc.ExemptNamespaces, ","))
+	}
+	s := fc.Server
+	if len(s.Addrs) > 0 {
+		set("addr", s.Addrs...)
+	}
+	if s.MetricsAddr != nil {
+		set("metrics-addr", *s.MetricsAddr)
+	}
+	if s.AdminAddr != "" {
+		set("admin-addr", s.AdminAddr)
+	}
+	if len(s.AllowedHosts) > 0 {
+		set("allowed-hosts", strings.Join(s.AllowedHosts, ","))
+	}
+	if s.MaxRequestBytes > 0 {
+		set("max-request-bytes", strconv.FormatInt(s.MaxRequestBytes, 10))
+	}
+	if s.MaxHeaderBytes > 0 {
+		set("max-header-bytes", strconv.Itoa(s.MaxHeaderBytes))
+	}
+	for name, d := range map[string]*metav1.Duration{
+		"read-header-timeout":   s.ReadHeaderTimeout,
+		"read-timeout":          s.ReadTimeout,
+		"write-timeout":         s.WriteTimeout,
+		"idle-timeout":          s.IdleTimeout,
+		"shutdown-grace-period": s.ShutdownGracePeriod,
+	} {
+		if d != nil {
+			set(name, d.Duration.String())
+		}
+	}
+	if s.HTTP2 != nil {
+		set("http2", strconv.FormatBool(*s.HTTP2))
+	}
+
+	for name, vs := range values {
+		if given[name] {
+			continue
+		}
+		for _, v := range vs {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("setting -%s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// exitInvalidConfig is the exit code if the configuration is invalid. It
+// follows EX_CONFIG of sysexits.h, so restarts caused by a bad
+// configuration can be told apart from crashes.
+const exitInvalidConfig = 78
+
+// exitInvalid logs every problem of err as a separate, structured entry
+// and exits with exitInvalidConfig.
+func exitInvalid(logger *zap.Logger, msg string, err error) {
+	errs := leafErrors(err)
+	for _, err := range errs {
+		var p *config.Problem
+		if errors.As(err, &p) {
+			logger.Error(msg, zap.String("domain", p.Domain), zap.String("scope", p.Scope), zap.String("annotation", p.Annotation), zap.String("problem", p.Message))
+			continue
+		}
+		logger.Error(msg, zap.Error(err))
+	}
+	logger.Error("Refusing to start with invalid configuration", zap.Int("problems", len(errs)), zap.Int("exit_code", exitInvalidConfig))
+	logger.Sync()
+	os.Exit(exitInvalidConfig)
+}
+
+// leafErrors returns the errors joined in err. Wrapped joins are unpacked,
+// dropping the context added by wrapping.
+func leafErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var errs []error
+		for _, err := range joined.Unwrap() {
+			errs = append(errs, leafErrors(err)...)
+		}
+		return errs
+	}
+	if inner := errors.Unwrap(err); inner != nil {
+		if _, ok := inner.(interface{ Unwrap() []error }); ok {
+			return leafErrors(inner)
+		}
+	}
+	return []error{err}
+}
@@ -0,0 +1,157 @@
+/*
+ *     configfile_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/featuregate"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap/zaptest"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"valid", `
+protected:
+  "*":
+  - key: ncp/snat_pool
+tls:
+  cert: /tls/tls.crt
+  key: /tls/tls.key
+server:
+  readTimeout: 5s
+`, ""},
+		{"unknown field", "server:\n  readTimout: 5s\n", `unknown field "readTimout"`},
+		{"invalid annotation", "protected:\n  \"*\":\n  - key: \"\"\n", "empty key"},
+		{"cert without key", "tls:\n  cert: /tls/tls.crt\n", "cert and key must be given together"},
+		{"negative timeout", "server:\n  idleTimeout: -1s\n", "idleTimeout must be positive"},
+		{"syntax", "protected: [\n", "config.yaml"},
+		{"messages", "messages:\n  annotation-conflict: \"%[3]s: %[4]s ist bereits vergeben\"\n", ""},
+		{"unknown message", "messages:\n  annotation-conflicts: taken\n", `message "annotation-conflicts": unknown key`},
+		{"invalid denial template", "denialTemplate: \"{{.Holder}}\"\n", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfigFile(writeConfigFile(t, tc.content))
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestDenialTemplate(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	assert.Nil(t, denialTemplate(logger, nil))
+	assert.Nil(t, denialTemplate(logger, &fileConfig{DenialTemplate: "{{.Holder}}"}), "invalid templates keep the default messages")
+	assert.NotNil(t, denialTemplate(logger, &fileConfig{DenialTemplate: "{{.Message}}, see https://wiki.example.com/unik"}))
+
+	denialTemplateFile = filepath.Join(t.TempDir(), "missing.tmpl")
+	defer func() { denialTemplateFile = "" }()
+	assert.Nil(t, denialTemplate(logger, &fileConfig{DenialTemplate: "{{.Message}}"}), "the flag takes precedence")
+}
+
+func TestConfigFileApply(t *testing.T) {
+	fc, err := loadConfigFile(writeConfigFile(t, `
+protected:
+  team-a:
+  - key: example.com/ip
+tls:
+  cert: /tls/tls.crt
+  key: /tls/tls.key
+  clientCA: /tls/ca.crt
+exemptNamespaces: [kube-system, unik]
+server:
+  addrs: [":8443", "[::]:8443"]
+  readTimeout: 5s
+  http2: false
+featureGates:
+  Beta: false
+  Alpha: true
+`))
+	require.NoError(t, err)
+	assert.Equal(t, validator.UniqueList{"team-a": {{Key: "example.com/ip"}}}, fc.Protected)
+
+	var (
+		fs     = flag.NewFlagSet("test", flag.ContinueOnError)
+		addrs  addrList
+		cert   = fs.String("cert", "/etc/certs/tls.crt", "")
+		key    = fs.String("key", "/etc/certs/tls.key", "")
+		ca     = fs.String("client-ca", "", "")
+		read   = fs.Duration("read-timeout", 10*time.Second, "")
+		http2  = fs.Bool("http2", true, "")
+		exempt = fs.String("exempt-namespaces", "kube-system,kube-public", "")
+	)
+	fs.Var(&addrs, "addr", "")
+	gates := featuregate.New(map[featuregate.Feature]featuregate.Spec{
+		"Alpha": {Stage: featuregate.Alpha},
+		"Beta":  {Default: true, Stage: featuregate.Beta},
+	})
+	fs.Var(gates, "feature-gates", "")
+	require.NoError(t, fs.Parse([]string{"-cert=/override/tls.crt"}))
+	require.NoError(t, fc.apply(fs))
+
+	assert.Equal(t, "/override/tls.crt", *cert, "flags given on the command line win")
+	assert.Equal(t, "/tls/tls.key", *key)
+	assert.Equal(t, "/tls/ca.crt", *ca)
+	assert.Equal(t, addrList{":8443", "[::]:8443"}, addrs)
+	assert.Equal(t, 5*time.Second, *read)
+	assert.False(t, *http2)
+	assert.Equal(t, "kube-system,unik", *exempt)
+	assert.Equal(t, "Alpha=true,Beta=false", gates.String())
+}
+
+func TestLeafErrors(t *testing.T) {
+	path := writeConfigFile(t, `
+protected:
+  Team_A:
+  - key: a
+tls:
+  cert: /etc/certs/tls.crt
+`)
+	_, err := loadConfigFile(path)
+	require.Error(t, err)
+
+	errs := leafErrors(err)
+	require.Len(t, errs, 2, "each problem is reported on its own")
+	var p *config.Problem
+	require.ErrorAs(t, errs[0], &p)
+	assert.Equal(t, "Team_A", p.Scope)
+	assert.EqualError(t, errs[1], "tls: cert and key must be given together")
+}
@@ -0,0 +1,59 @@
+/*
+ *     conflicts.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/internal/registration"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// warnConflictingWebhooks logs and exports the webhooks of other
+// configurations validating services on the operations protected needs,
+// such as a release of this controller left behind by an upgrade.
+func warnConflictingWebhooks(ctx context.Context, logger *zap.Logger, clientset kubernetes.Interface, protected validator.UniqueList) {
+	var operations []admissionregistrationv1.OperationType
+	for _, rule := range protected.WebhookRules() {
+		operations = append(operations, rule.Operations...)
+	}
+	if len(operations) == 0 {
+		return
+	}
+	conflicts, err := registration.FindConflicts(ctx, clientset, webhookConfiguration, webhookName, operations)
+	if err != nil {
+		logger.Warn("Failed to check for conflicting webhooks", zap.Error(err))
+		return
+	}
+	for _, c := range conflicts {
+		metrics.ConflictingWebhooks.WithLabelValues(c.Configuration, c.Webhook, strconv.FormatBool(c.Duplicate)).Set(1)
+		l := logger.With(zap.String("configuration", c.Configuration), zap.String("webhook", c.Webhook), zap.Any("operations", c.Operations))
+		if c.Duplicate {
+			l.Warn("Another release of the controller validates services as well; requests are denied twice until it is removed")
+			continue
+		}
+		l.Info("Another webhook validates services as well")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ *     domains.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+)
+
+// domainPrefix is the path below which policy domains are served.
+const domainPrefix = "/validate/"
+
+// policyDomains keeps a validator for each policy domain of the
+// configuration and serves them through a handler.Domains.
+type policyDomains struct {
+	logger         *zap.Logger
+	options        []validator.ValidationHandlerOption
+	handlerOptions []handler.RequestHandlerOption
+
+	// lock guards validators against flushes from outside of update.
+	lock       sync.Mutex
+	validators map[string]*validator.AdmitHandlerV1
+	handlers   map[string]http.Handler
+	mux        *handler.Domains
+}
+
+func newPolicyDomains(logger *zap.Logger, options []validator.ValidationHandlerOption, handlerOptions []handler.RequestHandlerOption) *policyDomains {
+	return &policyDomains{
+		logger:         logger,
+		options:        options,
+		handlerOptions: handlerOptions,
+		validators:     make(map[string]*validator.AdmitHandlerV1),
+		handlers:       make(map[string]http.Handler),
+		mux:            handler.NewDomains(domainPrefix),
+	}
+}
+
+// update creates validators for new domains of c, reconfigures existing
+// ones and drops those of removed domains. A domain whose validator cannot
+// be created is not served, leaving its requests to the failurePolicy of
+// its webhook.
+func (d *policyDomains) update(c *config.Config) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for name := range d.validators {
+		if _, ok := c.Domains[name]; !ok {
+			d.logger.Info("Removing policy domain", zap.String("domain", name))
+			delete(d.validators, name)
+			delete(d.handlers, name)
+		}
+	}
+	for name, list := range c.Domains {
+		if v, ok := d.validators[name]; ok {
+			v.SetUniqueList(list)
+			continue
+		}
+		l := d.logger.With(zap.String("domain", name))
+		v, err := validator.NewValidationHandlerV1(append(slices.Clone(d.options),
+			validator.WithLogger(l),
+			validator.WithDomain(name),
+			validator.WithUniqueList(list))...)
+		if err != nil {
+			l.Error("Failed to create validator for policy domain", zap.Error(err))
+			continue
+		}
+		h, err := handler.AdmissionReviewRequesthandler(v, d.handlerOptions...)
+		if err != nil {
+			l.Error("Failed to create request handler for policy domain", zap.Error(err))
+			continue
+		}
+		l.Info("Adding policy domain", zap.String("path", domainPrefix+name))
+		d.validators[name] = v
+		d.handlers[name] = h
+	}
+	handlers := make(map[string]http.Handler, len(d.handlers))
+	for name, h := range d.handlers {
+		handlers[name] = h
+	}
+	d.mux.Set(handlers)
+}
+
+// flushDecisionCaches drops the cached decisions of all domains.
+func (d *policyDomains) flushDecisionCaches() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, v := range d.validators {
+		v.FlushDecisionCache()
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ *     env_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"UNIK_ADDR":         ":8443,[::]:8443",
+		"UNIK_CERT":         "/env/tls.crt",
+		"UNIK_METRICS_ADDR": ":9091",
+		"UNIK_DEBUG":        "true",
+	}
+	lookup := func(name string) (string, bool) {
+		v, found := env[name]
+		return v, found
+	}
+
+	var (
+		fs          = flag.NewFlagSet("test", flag.ContinueOnError)
+		addrs       addrList
+		cert        = fs.String("cert", "/etc/certs/tls.crt", "")
+		key         = fs.String("key", "/etc/certs/tls.key", "")
+		metricsAddr = fs.String("metrics-addr", ":8080", "")
+		debug       = fs.Bool("debug", false, "")
+	)
+	fs.Var(&addrs, "addr", "")
+	require.NoError(t, fs.Parse([]string{"-metrics-addr=:9092"}))
+	require.NoError(t, applyEnv(fs, lookup))
+
+	assert.Equal(t, addrList{":8443", "[::]:8443"}, addrs)
+	assert.Equal(t, "/env/tls.crt", *cert)
+	assert.Equal(t, "/etc/certs/tls.key", *key, "defaults apply without flag and variable")
+	assert.Equal(t, ":9092", *metricsAddr, "flags take precedence over the environment")
+	assert.True(t, *debug)
+
+	env["UNIK_DEBUG"] = "maybe"
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("debug", false, "")
+	assert.ErrorContains(t, applyEnv(fs, lookup), "UNIK_DEBUG")
+}
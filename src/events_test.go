@@ -0,0 +1,49 @@
+/*
+ *     events_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDenialRecorder(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	recorder, stop := newDenialRecorder(tc, time.Minute)
+	defer stop()
+
+	ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Service", Namespace: "team-a", Name: "svc"}
+	for i := 0; i < 3; i++ {
+		recorder.Event(ref, corev1.EventTypeWarning, "AnnotationConflict", "value in use")
+	}
+
+	assert.Eventually(t, func() bool {
+		events, err := tc.CoreV1().Events("team-a").List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+		return len(events.Items) == 1 && events.Items[0].Count == 3
+	}, 5*time.Second, 50*time.Millisecond, "repeated denials are aggregated into a single event")
+}
@@ -0,0 +1,87 @@
+/*
+ *     guard.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// regressionGuard replays recently recorded reviews against configuration
+// changes and rejects changes flipping too many verdicts.
+type regressionGuard struct {
+	logger  *zap.Logger
+	records *audit.Ring
+	// options create the validators replaying the records. They must not
+	// include a decision cache or escalation, which would be shared with
+	// or distort the replay.
+	options   []validator.ValidationHandlerOption
+	threshold float64
+	// block rejects changes above threshold, which are only logged otherwise.
+	block bool
+}
+
+// check is a config.Guard. Each record is decided under both the current
+// and the next configuration, so verdicts flipping because the cluster
+// changed since the review was recorded are not counted.
+func (g *regressionGuard) check(current, next *config.Config) error {
+	records := g.records.Records()
+	if len(records) == 0 {
+		return nil
+	}
+	before, err := validator.NewValidationHandlerV1(append(slices.Clone(g.options), validator.WithLogger(zap.NewNop()), validator.WithUniqueList(current.Protected))...)
+	if err != nil {
+		return fmt.Errorf("creating validator for replay: %w", err)
+	}
+	after, err := validator.NewValidationHandlerV1(append(slices.Clone(g.options), validator.WithLogger(zap.NewNop()), validator.WithUniqueList(next.Protected))...)
+	if err != nil {
+		return fmt.Errorf("creating validator for replay: %w", err)
+	}
+
+	flips := 0
+	for _, r := range records {
+		review := admissionv1.AdmissionReview{Request: r.Request}
+		if before.Validate(context.Background(), review).Allowed != after.Validate(context.Background(), review).Allowed {
+			flips++
+		}
+	}
+	metrics.ReplayFlips.Set(float64(flips))
+
+	rate := float64(flips) / float64(len(records))
+	l := g.logger.With(zap.Int("replayed", len(records)), zap.Int("flipped", flips), zap.Float64("threshold", g.threshold))
+	switch {
+	case rate <= g.threshold:
+		l.Info("Replayed recorded reviews against new configuration")
+		return nil
+	case g.block:
+		return fmt.Errorf("%d of %d recorded reviews would flip their verdict", flips, len(records))
+	default:
+		l.Warn("New configuration flips the verdict of many recorded reviews")
+		return nil
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ *     guard_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRegressionGuard(t *testing.T) {
+	annotated := func(namespace, name string) corev1.Service {
+		return corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{validator.AnnotationNcpSnatPool: "x"},
+		}}
+	}
+	holder := annotated("a", "holder")
+	tc := testclient.NewSimpleClientset(&holder)
+
+	review, err := createReview(annotated("b", "claimant"))
+	require.NoError(t, err)
+	records := audit.NewRing(10)
+	records.Record(audit.Record{Request: review.Request})
+
+	current := &config.Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool}}}}
+	testCases := []struct {
+		desc    string
+		next    *config.Config
+		block   bool
+		allowed bool
+	}{
+		{"unchanged verdicts", &config.Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool, Immutable: true}}}}, true, true},
+		{"flipped verdicts blocked", &config.Config{Protected: validator.UniqueList{"b": {{Key: validator.AnnotationNcpSnatPool}}}}, true, false},
+		{"flipped verdicts reported", &config.Config{Protected: validator.UniqueList{"b": {{Key: validator.AnnotationNcpSnatPool}}}}, false, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			g := &regressionGuard{
+				logger:    zaptest.NewLogger(t),
+				records:   records,
+				options:   []validator.ValidationHandlerOption{validator.WithClientset(tc)},
+				threshold: 0.5,
+				block:     tC.block,
+			}
+			err := g.check(current, tC.next)
+			if tC.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, "1 of 1 recorded reviews would flip their verdict")
+			}
+		})
+	}
+}
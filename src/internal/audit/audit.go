@@ -0,0 +1,102 @@
+/*
+ *     audit.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package audit records samples of admission reviews for debugging rare
+// decisions in production without recording all traffic.
+package audit
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Record is a sampled admission review.
+type Record struct {
+	Time     time.Time                      `json:"time"`
+	Request  *admissionv1.AdmissionRequest  `json:"request"`
+	Response *admissionv1.AdmissionResponse `json:"response"`
+}
+
+// Sink stores records. Sinks handle their own failures, as recording must
+// not affect the decision.
+type Sink interface {
+	Record(Record)
+}
+
+type tee []Sink
+
+// Tee records to all of sinks.
+func Tee(sinks ...Sink) Sink {
+	return tee(sinks)
+}
+
+func (t tee) Record(r Record) {
+	for _, s := range t {
+		s.Record(r)
+	}
+}
+
+type loggerSink struct {
+	logger *zap.Logger
+}
+
+// LoggerSink logs records at info level to logger.
+func LoggerSink(logger *zap.Logger) Sink {
+	return &loggerSink{logger: logger}
+}
+
+func (s *loggerSink) Record(r Record) {
+	s.logger.Info("Sampled admission review",
+		zap.String("uid", string(r.Request.UID)),
+		zap.Bool("allowed", r.Response.Allowed),
+		zap.Any("record", r))
+}
+
+// Sampler decides which reviews are recorded.
+type Sampler struct {
+	// Fraction of all reviews to record, between 0 and 1.
+	Fraction float64
+	// Denials are always recorded if set.
+	Denials bool
+}
+
+// Validate checks the settings of s.
+func (s Sampler) Validate() error {
+	if s.Fraction < 0 || s.Fraction > 1 {
+		return errors.New("fraction must be between 0 and 1")
+	}
+	return nil
+}
+
+// Enabled reports whether s records any reviews.
+func (s Sampler) Enabled() bool {
+	return s.Fraction > 0 || s.Denials
+}
+
+// Sample reports whether the review answered with resp is to be recorded.
+func (s Sampler) Sample(resp *admissionv1.AdmissionResponse) bool {
+	if s.Denials && !resp.Allowed {
+		return true
+	}
+	return s.Fraction > 0 && rand.Float64() < s.Fraction
+}
@@ -0,0 +1,100 @@
+/*
+ *     audit_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSampler(t *testing.T) {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+	denied := &admissionv1.AdmissionResponse{Allowed: false}
+
+	assert.False(t, Sampler{}.Enabled())
+	assert.False(t, Sampler{}.Sample(denied))
+	assert.True(t, Sampler{Denials: true}.Sample(denied))
+	assert.False(t, Sampler{Denials: true}.Sample(allowed))
+	assert.True(t, Sampler{Fraction: 1}.Sample(allowed))
+	assert.Error(t, Sampler{Fraction: 1.5}.Validate())
+}
+
+func TestRedact(t *testing.T) {
+	req := &admissionv1.AdmissionRequest{
+		UserInfo: authenticationv1.UserInfo{
+			Username: "jane",
+			Extra: map[string]authenticationv1.ExtraValue{
+				"credential-id":                         {"secret"},
+				"authentication.kubernetes.io/pod-name": {"runner-1"},
+			},
+		},
+		Object: runtime.RawExtension{Raw: []byte(`{
+			"metadata": {
+				"name": "test",
+				"annotations": {
+					"ncp/snat_pool": "a",
+					"kubectl.kubernetes.io/last-applied-configuration": "{}"
+				},
+				"managedFields": [{"manager": "kubectl"}]
+			}
+		}`)},
+	}
+
+	redacted := Redact(req)
+	assert.Equal(t, "jane", redacted.UserInfo.Username)
+	assert.Equal(t, authenticationv1.ExtraValue{Redacted}, redacted.UserInfo.Extra["credential-id"])
+	assert.Equal(t, authenticationv1.ExtraValue{"secret"}, req.UserInfo.Extra["credential-id"], "original is unchanged")
+	assert.Equal(t, authenticationv1.ExtraValue{Redacted}, redacted.UserInfo.Extra["authentication.kubernetes.io/pod-name"])
+	assert.Empty(t, redacted.OldObject.Raw)
+
+	kept := Redact(req, "authentication.kubernetes.io/pod-name")
+	assert.Equal(t, authenticationv1.ExtraValue{"runner-1"}, kept.UserInfo.Extra["authentication.kubernetes.io/pod-name"])
+	assert.Equal(t, authenticationv1.ExtraValue{Redacted}, kept.UserInfo.Extra["credential-id"])
+
+	var obj struct {
+		Metadata map[string]any `json:"metadata"`
+	}
+	require.NoError(t, json.Unmarshal(redacted.Object.Raw, &obj))
+	assert.NotContains(t, obj.Metadata, "managedFields")
+	assert.Equal(t, map[string]any{
+		"ncp/snat_pool": "a",
+		"kubectl.kubernetes.io/last-applied-configuration": Redacted,
+	}, obj.Metadata["annotations"])
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(2)
+	assert.Empty(t, r.Records())
+
+	for _, uid := range []string{"1", "2", "3"} {
+		r.Record(Record{Request: &admissionv1.AdmissionRequest{UID: types.UID(uid)}})
+	}
+	records := r.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, types.UID("2"), records[0].Request.UID, "oldest record first")
+	assert.Equal(t, types.UID("3"), records[1].Request.UID)
+}
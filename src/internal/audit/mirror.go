@@ -0,0 +1,112 @@
+/*
+ *     mirror.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Mirror is a Sink sending records to another deployment of the webhook
+// running in shadow mode, for example a new release which is to replace
+// this one. Records are queued and sent by Run, so mirroring never delays
+// a review. Records arriving while the queue is full are dropped.
+type Mirror struct {
+	logger    *zap.Logger
+	client    *http.Client
+	url       string
+	tokenFile string
+	queue     chan Record
+}
+
+// NewMirror creates a mirror posting records to url with client, queueing
+// up to size records. If tokenFile is not empty, the token it holds is
+// sent as bearer token. It is read for every record, as service account
+// tokens are rotated.
+func NewMirror(logger *zap.Logger, client *http.Client, url, tokenFile string, size int) *Mirror {
+	return &Mirror{
+		logger:    logger,
+		client:    client,
+		url:       url,
+		tokenFile: tokenFile,
+		queue:     make(chan Record, size),
+	}
+}
+
+func (m *Mirror) Record(r Record) {
+	select {
+	case m.queue <- r:
+	default:
+		metrics.MirroredReviews.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run sends queued records until ctx is done.
+func (m *Mirror) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-m.queue:
+			if err := m.send(ctx, r); err != nil {
+				metrics.MirroredReviews.WithLabelValues("failed").Inc()
+				m.logger.Debug("Failed to mirror review", zap.String("uid", string(r.Request.UID)), zap.Error(err))
+				continue
+			}
+			metrics.MirroredReviews.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+func (m *Mirror) send(ctx context.Context, r Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.tokenFile != "" {
+		token, err := os.ReadFile(m.tokenFile)
+		if err != nil {
+			return fmt.Errorf("reading token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
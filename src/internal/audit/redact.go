@@ -0,0 +1,79 @@
+/*
+ *     redact.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"slices"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Redacted replaces values which must not end up in records.
+const Redacted = "REDACTED"
+
+// redactedAnnotations hold copies of whole objects, which would defeat
+// any other redaction.
+var redactedAnnotations = []string{"kubectl.kubernetes.io/last-applied-configuration"}
+
+// Redact returns a copy of req without the credentials of the requesting
+// user and without the managed fields and object copies of its objects.
+// The extra fields of the user named by keep are kept, for example to
+// attribute the request.
+func Redact(req *admissionv1.AdmissionRequest, keep ...string) *admissionv1.AdmissionRequest {
+	redacted := req.DeepCopy()
+	// Extra may carry credential IDs and similar details of the authenticator.
+	for key := range redacted.UserInfo.Extra {
+		if !slices.Contains(keep, key) {
+			redacted.UserInfo.Extra[key] = []string{Redacted}
+		}
+	}
+	redacted.Object = redactObject(redacted.Object)
+	redacted.OldObject = redactObject(redacted.OldObject)
+	return redacted
+}
+
+// redactObject removes the managed fields and redacts object copies in the
+// annotations of obj. Objects which cannot be decoded are dropped entirely.
+func redactObject(obj runtime.RawExtension) runtime.RawExtension {
+	if len(obj.Raw) == 0 {
+		return obj
+	}
+	var o map[string]any
+	if err := json.Unmarshal(obj.Raw, &o); err != nil {
+		return runtime.RawExtension{}
+	}
+	if metadata, ok := o["metadata"].(map[string]any); ok {
+		delete(metadata, "managedFields")
+		if annotations, ok := metadata["annotations"].(map[string]any); ok {
+			for _, key := range redactedAnnotations {
+				if _, ok := annotations[key]; ok {
+					annotations[key] = Redacted
+				}
+			}
+		}
+	}
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return runtime.RawExtension{}
+	}
+	return runtime.RawExtension{Raw: raw}
+}
@@ -0,0 +1,57 @@
+/*
+ *     ring.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package audit
+
+import "sync"
+
+// Ring is a Sink keeping the most recent records in memory, for example
+// to replay them against a new configuration.
+type Ring struct {
+	lock    sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewRing creates a ring holding up to size records.
+func NewRing(size int) *Ring {
+	return &Ring{records: make([]Record, size)}
+}
+
+func (r *Ring) Record(rec Record) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.records) == 0 {
+		return
+	}
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % len(r.records)
+	r.full = r.full || r.next == 0
+}
+
+// Records returns the records held, oldest first.
+func (r *Ring) Records() []Record {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]Record(nil), r.records[:r.next]...)
+	}
+	return append(append([]Record(nil), r.records[r.next:]...), r.records[:r.next]...)
+}
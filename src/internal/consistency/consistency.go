@@ -0,0 +1,245 @@
+/*
+ *     consistency.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package consistency periodically compares a sample of the services known
+// to an informer with live reads from the apiserver. The decision cache
+// relies on the informer to invalidate its entries, so missed watch events
+// would otherwise silently keep stale decisions alive.
+package consistency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+)
+
+// Result describes a single check.
+type Result struct {
+	Sampled int `json:"sampled"`
+	// Divergent lists the services, as namespace/name, whose annotations
+	// differ between the informer and the apiserver.
+	Divergent []string `json:"divergent"`
+}
+
+// Ratio returns the fraction of sampled services which diverged.
+func (r Result) Ratio() float64 {
+	if r.Sampled == 0 {
+		return 0
+	}
+	return float64(len(r.Divergent)) / float64(r.Sampled)
+}
+
+type Checker struct {
+	clientset kubernetes.Interface
+	store     cache.Store
+	logger    *zap.Logger
+	clock     clock.WithTicker
+	interval  time.Duration
+	sample    int
+	threshold float64
+	reindex   func()
+}
+
+type CheckerOption func(*Checker) error
+
+func WithLogger(logger *zap.Logger) CheckerOption {
+	return func(c *Checker) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		c.logger = logger
+		return nil
+	}
+}
+
+func WithClientset(clientset kubernetes.Interface) CheckerOption {
+	return func(c *Checker) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		c.clientset = clientset
+		return nil
+	}
+}
+
+// WithStore sets the store of the informer to check, usually
+// SharedIndexInformer.GetStore of the services informer.
+func WithStore(store cache.Store) CheckerOption {
+	return func(c *Checker) error {
+		if store == nil {
+			return errors.New("store is nil")
+		}
+		c.store = store
+		return nil
+	}
+}
+
+// WithInterval sets the time between two checks.
+func WithInterval(interval time.Duration) CheckerOption {
+	return func(c *Checker) error {
+		if interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		c.interval = interval
+		return nil
+	}
+}
+
+// WithSampleSize sets the number of services compared per check.
+func WithSampleSize(n int) CheckerOption {
+	return func(c *Checker) error {
+		if n <= 0 {
+			return errors.New("sample size must be positive")
+		}
+		c.sample = n
+		return nil
+	}
+}
+
+// WithReindex calls reindex whenever the fraction of divergent services in
+// a check exceeds threshold, which must be between 0 and 1.
+func WithReindex(threshold float64, reindex func()) CheckerOption {
+	return func(c *Checker) error {
+		if reindex == nil {
+			return errors.New("reindex is nil")
+		}
+		if threshold < 0 || threshold > 1 {
+			return fmt.Errorf("threshold %v is not between 0 and 1", threshold)
+		}
+		c.threshold = threshold
+		c.reindex = reindex
+		return nil
+	}
+}
+
+// WithClock sets the clock triggering checks. Defaults to the real clock.
+func WithClock(c clock.WithTicker) CheckerOption {
+	return func(ch *Checker) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		ch.clock = c
+		return nil
+	}
+}
+
+func NewChecker(options ...CheckerOption) (*Checker, error) {
+	c := &Checker{
+		logger:   zap.NewNop(),
+		clock:    clock.RealClock{},
+		interval: 10 * time.Minute,
+		sample:   20,
+	}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	if c.clientset == nil {
+		return nil, errors.New("clientset is required")
+	}
+	if c.store == nil {
+		return nil, errors.New("store is required")
+	}
+	return c, nil
+}
+
+// Run checks every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		c.runOnce(ctx)
+	}
+}
+
+func (c *Checker) runOnce(ctx context.Context) {
+	result, err := c.Check(ctx)
+	if err != nil {
+		c.logger.Error("Consistency check failed", zap.Error(err))
+		return
+	}
+	if len(result.Divergent) == 0 {
+		c.logger.Debug("Consistency check passed", zap.Int("sampled", result.Sampled))
+		return
+	}
+	c.logger.Warn("Informer diverged from apiserver", zap.Int("sampled", result.Sampled), zap.Strings("divergent", result.Divergent))
+	if c.reindex != nil && result.Ratio() > c.threshold {
+		c.logger.Warn("Divergence above threshold, reindexing", zap.Float64("ratio", result.Ratio()), zap.Float64("threshold", c.threshold))
+		metrics.Reindexes.WithLabelValues("consistency").Inc()
+		c.reindex()
+	}
+}
+
+// Check compares a random sample of the services in the store with the
+// apiserver. Services missing from the apiserver and services whose
+// annotations differ count as divergent. A service changed while being
+// checked may be reported once, as the informer lags behind slightly.
+func (c *Checker) Check(ctx context.Context) (Result, error) {
+	keys := c.store.ListKeys()
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	keys = keys[:min(len(keys), c.sample)]
+
+	var result Result
+	for _, key := range keys {
+		obj, exists, err := c.store.GetByKey(key)
+		if err != nil {
+			return result, fmt.Errorf("reading %s from store: %w", key, err)
+		}
+		cached, ok := obj.(*corev1.Service)
+		if !exists || !ok {
+			continue
+		}
+
+		live, err := c.clientset.CoreV1().Services(cached.Namespace).Get(ctx, cached.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			live = nil
+		case err != nil:
+			return result, fmt.Errorf("reading %s: %w", key, err)
+		}
+
+		result.Sampled++
+		if live == nil || !equality.Semantic.DeepEqual(cached.Annotations, live.Annotations) {
+			result.Divergent = append(result.Divergent, key)
+			metrics.IndexChecks.WithLabelValues("divergent").Inc()
+		} else {
+			metrics.IndexChecks.WithLabelValues("consistent").Inc()
+		}
+	}
+	metrics.IndexDivergence.Set(result.Ratio())
+	return result, nil
+}
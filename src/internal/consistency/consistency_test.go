@@ -0,0 +1,90 @@
+/*
+ *     consistency_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package consistency
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func service(namespace, name, value string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   namespace,
+		Name:        name,
+		Annotations: map[string]string{"example.com/a": value},
+	}}
+}
+
+func TestCheck(t *testing.T) {
+	tc := testclient.NewSimpleClientset(service("a", "same", "x"), service("a", "changed", "new"))
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	require.NoError(t, store.Add(service("a", "same", "x")))
+	require.NoError(t, store.Add(service("a", "changed", "old")))
+	require.NoError(t, store.Add(service("a", "deleted", "x")))
+
+	reindexed := 0
+	c, err := NewChecker(
+		WithLogger(zaptest.NewLogger(t)),
+		WithClientset(tc),
+		WithStore(store),
+		WithReindex(0.5, func() { reindexed++ }))
+	require.NoError(t, err)
+
+	result, err := c.Check(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Sampled)
+	assert.ElementsMatch(t, []string{"a/changed", "a/deleted"}, result.Divergent)
+
+	c.runOnce(context.Background())
+	assert.Equal(t, 1, reindexed, "divergence above threshold triggers a reindex")
+
+	require.NoError(t, store.Update(service("a", "changed", "new")))
+	require.NoError(t, store.Delete(service("a", "deleted", "x")))
+	c.runOnce(context.Background())
+	assert.Equal(t, 1, reindexed)
+}
+
+func TestOptions(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	testCases := []struct {
+		desc    string
+		options []CheckerOption
+	}{
+		{"no clientset", []CheckerOption{WithStore(store)}},
+		{"no store", []CheckerOption{WithClientset(tc)}},
+		{"invalid sample size", []CheckerOption{WithClientset(tc), WithStore(store), WithSampleSize(0)}},
+		{"invalid threshold", []CheckerOption{WithClientset(tc), WithStore(store), WithReindex(2, func() {})}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, err := NewChecker(tC.options...)
+			assert.Error(t, err)
+		})
+	}
+}
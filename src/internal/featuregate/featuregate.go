@@ -0,0 +1,171 @@
+/*
+ *     featuregate.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package featuregate lets experimental behavior ship disabled by default
+// and be enabled per deployment with -feature-gates, for example
+// "-feature-gates=SomeFeature=true,OtherFeature=false".
+package featuregate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Feature names a behavior which can be enabled or disabled.
+type Feature string
+
+// Stage is the maturity of a feature.
+type Stage string
+
+const (
+	// Alpha features are disabled by default and may change or be removed.
+	Alpha Stage = "ALPHA"
+	// Beta features are enabled by default, but can still be disabled.
+	Beta Stage = "BETA"
+	// GA features are always enabled. Their gate is kept for a while, so
+	// deployments enabling them explicitly keep working.
+	GA Stage = "GA"
+)
+
+// Spec describes a feature.
+type Spec struct {
+	Default bool
+	Stage   Stage
+}
+
+const (
+	// IndexFastPath looks up holders of values in the informer cache and
+	// confirms apparent conflicts with the apiserver before denying.
+	IndexFastPath Feature = "IndexFastPath"
+)
+
+// features are the features of the controller. Features graduating to GA
+// keep their entry until their gate is removed.
+var features = map[Feature]Spec{
+	IndexFastPath: {Stage: Alpha},
+}
+
+// Default holds the gates of the controller's features.
+var Default = New(features)
+
+// Gates tracks which of a set of known features are enabled. It
+// implements flag.Value.
+type Gates struct {
+	known map[Feature]Spec
+
+	lock    sync.RWMutex
+	enabled map[Feature]bool
+}
+
+// New returns gates for the known features, all at their default.
+func New(known map[Feature]Spec) *Gates {
+	return &Gates{known: known, enabled: make(map[Feature]bool)}
+}
+
+// Set enables and disables the features given as a comma separated list
+// of feature=bool pairs. Unknown features and disabling GA features are
+// errors, in which case no gate is changed.
+func (g *Gates) Set(value string) error {
+	changes := make(map[Feature]bool)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("missing bool value for %s", pair)
+		}
+		f := Feature(strings.TrimSpace(name))
+		spec, known := g.known[f]
+		if !known {
+			return fmt.Errorf("unknown feature gate %s", f)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("invalid value of %s=%s: %w", f, v, err)
+		}
+		if spec.Stage == GA && !enabled {
+			return fmt.Errorf("feature gate %s is GA and cannot be disabled", f)
+		}
+		changes[f] = enabled
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for f, enabled := range changes {
+		g.enabled[f] = enabled
+	}
+	return nil
+}
+
+// String returns the explicitly set gates in the form accepted by Set.
+func (g *Gates) String() string {
+	if g == nil {
+		return ""
+	}
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	pairs := make([]string, 0, len(g.enabled))
+	for f, enabled := range g.enabled {
+		pairs = append(pairs, fmt.Sprintf("%s=%t", f, enabled))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Enabled returns whether f is enabled. Unknown features are disabled.
+func (g *Gates) Enabled(f Feature) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if enabled, set := g.enabled[f]; set {
+		return enabled
+	}
+	return g.known[f].Default
+}
+
+// Features returns the state of all known features.
+func (g *Gates) Features() map[Feature]bool {
+	states := make(map[Feature]bool, len(g.known))
+	for f := range g.known {
+		states[f] = g.Enabled(f)
+	}
+	return states
+}
+
+// Stage returns the stage of f.
+func (g *Gates) Stage(f Feature) Stage {
+	return g.known[f].Stage
+}
+
+// Usage describes the known features for the help text of a flag.
+func (g *Gates) Usage() string {
+	if len(g.known) == 0 {
+		return "no feature gates are currently known"
+	}
+	lines := make([]string, 0, len(g.known))
+	for f, spec := range g.known {
+		lines = append(lines, fmt.Sprintf("%s=true|false (%s - default=%t)", f, spec.Stage, spec.Default))
+	}
+	sort.Strings(lines)
+	return "known feature gates:\n" + strings.Join(lines, "\n")
+}
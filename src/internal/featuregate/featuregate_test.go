@@ -0,0 +1,69 @@
+/*
+ *     featuregate_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package featuregate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGates(t *testing.T) {
+	known := map[Feature]Spec{
+		"Alpha": {Stage: Alpha},
+		"Beta":  {Default: true, Stage: Beta},
+		"GA":    {Default: true, Stage: GA},
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		want    map[Feature]bool
+		wantErr string
+	}{
+		{"defaults", "", map[Feature]bool{"Alpha": false, "Beta": true, "GA": true}, ""},
+		{"toggled", "Alpha=true, Beta=false,GA=true", map[Feature]bool{"Alpha": true, "Beta": false, "GA": true}, ""},
+		{"unknown", "Alpha=true,Gamma=true", nil, "unknown feature gate Gamma"},
+		{"missing value", "Alpha", nil, "missing bool value for Alpha"},
+		{"invalid value", "Alpha=yes", nil, "invalid value of Alpha=yes"},
+		{"GA disabled", "GA=false", nil, "GA is GA and cannot be disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := New(known)
+			err := g.Set(tt.value)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.False(t, g.Enabled("Alpha"), "a failed Set changes no gate")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, g.Features())
+		})
+	}
+
+	g := New(known)
+	require.NoError(t, g.Set("Beta=false"))
+	require.NoError(t, g.Set("Alpha=true"))
+	assert.Equal(t, "Alpha=true,Beta=false", g.String(), "later values add to earlier ones")
+	assert.False(t, g.Enabled("Gamma"), "unknown features are disabled")
+	assert.Equal(t, "known feature gates:\nAlpha=true|false (ALPHA - default=false)\nBeta=true|false (BETA - default=true)\nGA=true|false (GA - default=true)", g.Usage())
+}
@@ -0,0 +1,158 @@
+/*
+ *     auth.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrUnauthenticated is returned by an Authorizer if the request does
+// not carry valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authorizer decides whether the caller of r may perform the action
+// described by attrs.
+type Authorizer interface {
+	Authorize(r *http.Request, attrs authorizationv1.ResourceAttributes) (bool, error)
+}
+
+// SubjectAccessReviewAuthorizer authenticates callers by their bearer token
+// using a TokenReview and asks the apiserver via a SubjectAccessReview whether
+// the resulting user may perform the requested action. This way, access to
+// the endpoints is governed by plain Kubernetes RBAC.
+type SubjectAccessReviewAuthorizer struct {
+	clientset kubernetes.Interface
+}
+
+func NewSubjectAccessReviewAuthorizer(clientset kubernetes.Interface) *SubjectAccessReviewAuthorizer {
+	return &SubjectAccessReviewAuthorizer{clientset: clientset}
+}
+
+func (a *SubjectAccessReviewAuthorizer) Authorize(r *http.Request, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return false, ErrUnauthenticated
+	}
+
+	tr, err := a.clientset.AuthenticationV1().TokenReviews().Create(r.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("reviewing token: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		return false, ErrUnauthenticated
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(tr.Status.User.Extra))
+	for k, v := range tr.Status.User.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	sar, err := a.clientset.AuthorizationV1().SubjectAccessReviews().Create(r.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &attrs,
+			User:               tr.Status.User.Username,
+			Groups:             tr.Status.User.Groups,
+			UID:                tr.Status.User.UID,
+			Extra:              extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("reviewing access: %w", err)
+	}
+	return sar.Status.Allowed, nil
+}
+
+// AdminGroup is the API group of the virtual resources used to authorize
+// access to the introspection and admin endpoints.
+const AdminGroup = "unik.io"
+
+// VirtualResource maps an endpoint to RBAC attributes in AdminGroup, so
+// access can be granted with ordinary Roles and ClusterRoles. Endpoints
+// exposing data of a namespace are checked in that namespace, which lets
+// namespace admins use them for their own namespaces only.
+type VirtualResource struct {
+	Resource string
+	Verb     string
+}
+
+var (
+	// ResourceOwners guards /owner. It is checked in the namespace given by
+	// the request, or cluster scoped without one.
+	ResourceOwners = VirtualResource{Resource: "owners", Verb: "get"}
+	// ResourceReindex guards /-/reindex. It is cluster scoped.
+	ResourceReindex = VirtualResource{Resource: "reindex", Verb: "create"}
+	// ResourceSimulations guards /simulate. It is cluster scoped.
+	ResourceSimulations = VirtualResource{Resource: "simulations", Verb: "create"}
+	// ResourceMetrics guards /metrics/namespaces/. It is checked in the
+	// namespace the metrics are requested for.
+	ResourceMetrics = VirtualResource{Resource: "metrics", Verb: "get"}
+	// ResourceConfig guards reading /admin/config. It is cluster scoped.
+	ResourceConfig = VirtualResource{Resource: "config", Verb: "get"}
+	// ResourceConfigUpdate guards changing /admin/config. It is cluster scoped.
+	ResourceConfigUpdate = VirtualResource{Resource: "config", Verb: "update"}
+	// ResourceShadowReviews guards mirroring reviews to /shadow. It is
+	// cluster scoped and granted to the deployment mirroring its traffic.
+	ResourceShadowReviews = VirtualResource{Resource: "shadowreviews", Verb: "create"}
+	// ResourceShadowReport guards reading the comparison report of /shadow.
+	// It is cluster scoped.
+	ResourceShadowReport = VirtualResource{Resource: "shadowreviews", Verb: "get"}
+	// ResourceShadowReset guards resetting the comparison report of
+	// /shadow. It is cluster scoped.
+	ResourceShadowReset = VirtualResource{Resource: "shadowreviews", Verb: "delete"}
+)
+
+// Attributes returns the attributes of v in namespace. An empty namespace
+// denotes a cluster scoped check.
+func (v VirtualResource) Attributes(namespace string) authorizationv1.ResourceAttributes {
+	return authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Group:     AdminGroup,
+		Verb:      v.Verb,
+		Resource:  v.Resource,
+	}
+}
+
+// authorize writes an appropriate error response and returns false if the
+// caller of r may not access resource in namespace.
+func authorize(w http.ResponseWriter, r *http.Request, authz Authorizer, resource VirtualResource, namespace string) bool {
+	allowed, err := authz.Authorize(r, resource.Attributes(namespace))
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return false
+	case err != nil:
+		http.Error(w, "failed to authorize request: "+err.Error(), http.StatusInternalServerError)
+		return false
+	case !allowed:
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,124 @@
+/*
+ *     backpressure.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/internal/slo"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// OverloadResponse selects how reviews are answered while Backpressure
+// has no slot left for them.
+type OverloadResponse string
+
+const (
+	// OverloadThrottle answers 429 with Retry-After, so the apiserver
+	// retries the review later instead of waiting for it to time out.
+	// It suits webhooks with failurePolicy Fail.
+	OverloadThrottle OverloadResponse = "throttle"
+	// OverloadAdmit admits the review with a warning, which is what the
+	// apiserver would do on a timeout with failurePolicy Ignore anyway,
+	// just without the wait.
+	OverloadAdmit OverloadResponse = "admit"
+)
+
+// ParseOverloadResponse parses the value of a command line flag.
+func ParseOverloadResponse(s string) (OverloadResponse, error) {
+	switch r := OverloadResponse(s); r {
+	case OverloadThrottle, OverloadAdmit:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown overload response %q", s)
+	}
+}
+
+// Backpressure limits the number of reviews served concurrently to limit.
+// A review waits up to wait for a slot and is answered according to
+// overload otherwise. Both answers carry a valid AdmissionReview, so the
+// apiserver can tell them from a broken webhook.
+func Backpressure(limit int, wait time.Duration, overload OverloadResponse, retryAfter time.Duration) Middleware {
+	slots := make(chan struct{}, limit)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				timer := time.NewTimer(wait)
+				defer timer.Stop()
+				select {
+				case slots <- struct{}{}:
+				case <-timer.C:
+					metrics.OverloadedRequests.WithLabelValues(string(overload)).Inc()
+					overloaded(w, r, overload, retryAfter)
+					return
+				case <-r.Context().Done():
+					return
+				}
+			}
+			defer func() { <-slots }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// overloaded answers the review in r without validating it.
+func overloaded(w http.ResponseWriter, r *http.Request, overload OverloadResponse, retryAfter time.Duration) {
+	reportOutcome(r.Context(), slo.OutcomeOverloaded)
+	var review struct {
+		Request *struct {
+			UID types.UID `json:"uid"`
+		} `json:"request"`
+	}
+	if r.Body != nil {
+		if content, err := io.ReadAll(r.Body); err == nil {
+			json.Unmarshal(content, &review)
+		}
+	}
+	if review.Request == nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(response.RetryAfterSeconds(retryAfter))))
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	status := http.StatusOK
+	resp := response.Allowed(review.Request.UID, response.ReasonOverloaded, "unik: the webhook is overloaded, annotations were not checked")
+	if overload == OverloadThrottle {
+		resp = response.Throttled(review.Request.UID, retryAfter)
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int(resp.Result.Details.RetryAfterSeconds)))
+	}
+	data, err := json.Marshal(response.Review(resp))
+	if err != nil {
+		http.Error(w, "failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,89 @@
+/*
+ *     codec.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Codec encodes the AdmissionReviews sent back to the apiserver.
+// The returned bytes are only valid until release is called.
+type Codec interface {
+	Marshal(v any) (data []byte, release func(), err error)
+}
+
+// NewCodec returns the codec registered under name, which is either
+// "std" for encoding/json or "jsoniter".
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "", "std":
+		return StdCodec(), nil
+	case "jsoniter":
+		return JSONIterCodec(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+type stdCodec struct {
+	buffers sync.Pool
+}
+
+// StdCodec encodes using encoding/json with pooled buffers. It is the default.
+func StdCodec() Codec {
+	return &stdCodec{buffers: sync.Pool{New: func() any { return new(bytes.Buffer) }}}
+}
+
+func (c *stdCodec) Marshal(v any) ([]byte, func(), error) {
+	buf := c.buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	release := func() { c.buffers.Put(buf) }
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return buf.Bytes(), release, nil
+}
+
+type jsoniterCodec struct {
+	api jsoniter.API
+}
+
+// JSONIterCodec encodes using json-iterator, which is considerably faster
+// than encoding/json at high admission rates. Its streams are pooled.
+func JSONIterCodec() Codec {
+	return &jsoniterCodec{api: jsoniter.ConfigCompatibleWithStandardLibrary}
+}
+
+func (c *jsoniterCodec) Marshal(v any) ([]byte, func(), error) {
+	stream := c.api.BorrowStream(nil)
+	release := func() { c.api.ReturnStream(stream) }
+	stream.WriteVal(v)
+	if stream.Error != nil {
+		err := stream.Error
+		release()
+		return nil, nil, err
+	}
+	return stream.Buffer(), release, nil
+}
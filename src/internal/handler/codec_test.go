@@ -0,0 +1,61 @@
+/*
+ *     codec_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCodecs(t *testing.T) {
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Response: &admissionv1.AdmissionResponse{
+			UID:      "uid",
+			Allowed:  false,
+			Result:   &metav1.Status{Message: `already has "value"`},
+			Warnings: []string{"<warning>"},
+		},
+	}
+	expected, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	for _, name := range []string{"std", "jsoniter"} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := NewCodec(name)
+			require.NoError(t, err)
+			// Encode twice to exercise the pooled buffers.
+			for i := 0; i < 2; i++ {
+				data, release, err := codec.Marshal(review)
+				require.NoError(t, err)
+				assert.JSONEq(t, string(expected), string(data))
+				release()
+			}
+		})
+	}
+
+	_, err = NewCodec("gob")
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+/*
+ *     config.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/unik-k8s/admission-controller/pkg/config"
+)
+
+// ConfigHandler answers GET /admin/config with the configuration in
+// effect, as returned by current. PUT /admin/config replaces the runtime
+// overrides by the configuration in the body using override and answers
+// with the resulting configuration; an empty list of annotations removes a
+// scope. Rejected overrides are answered with 422 and leave the
+// configuration unchanged. Without override, PUT is refused, as there is
+// nowhere to store overrides for all replicas. Access is guarded by
+// ResourceConfig and ResourceConfigUpdate respectively.
+func ConfigHandler(current func() *config.Config, override func(context.Context, *config.Config) error, authz Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if !authorize(w, r, authz, ResourceConfig, "") {
+				return
+			}
+		case http.MethodPut:
+			if !authorize(w, r, authz, ResourceConfigUpdate, "") {
+				return
+			}
+			if override == nil {
+				http.Error(w, "overrides are disabled, as they would only apply to this replica; set -config-overrides-configmap to store them for all replicas", http.StatusMethodNotAllowed)
+				return
+			}
+			var c config.Config
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&c); err != nil {
+				http.Error(w, "invalid configuration: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := override(r.Context(), &c); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(current())
+	})
+}
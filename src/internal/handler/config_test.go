@@ -0,0 +1,115 @@
+/*
+ *     config_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+// verbAuthorizer allows the given verbs only.
+type verbAuthorizer []string
+
+func (a verbAuthorizer) Authorize(r *http.Request, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	if r.Header.Get("Authorization") == "" {
+		return false, ErrUnauthenticated
+	}
+	for _, verb := range a {
+		if verb == attrs.Verb {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestConfigHandler(t *testing.T) {
+	effective := &config.Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}}
+	current := func() *config.Config { return effective }
+	override := func(_ context.Context, c *config.Config) error {
+		if _, found := c.Protected["invalid"]; found {
+			return errors.New("invalid configuration")
+		}
+		effective = config.Merge(effective, c)
+		return nil
+	}
+
+	testCases := []struct {
+		desc   string
+		method string
+		body   string
+		verbs  verbAuthorizer
+		token  bool
+		status int
+	}{
+		{desc: "get", method: http.MethodGet, verbs: verbAuthorizer{"get"}, token: true, status: http.StatusOK},
+		{desc: "unauthenticated", method: http.MethodGet, status: http.StatusUnauthorized},
+		{desc: "put without update", method: http.MethodPut, body: `{}`, verbs: verbAuthorizer{"get"}, token: true, status: http.StatusForbidden},
+		{desc: "malformed", method: http.MethodPut, body: `{"protected":`, verbs: verbAuthorizer{"update"}, token: true, status: http.StatusBadRequest},
+		{desc: "unknown field", method: http.MethodPut, body: `{"protect":{}}`, verbs: verbAuthorizer{"update"}, token: true, status: http.StatusBadRequest},
+		{desc: "rejected", method: http.MethodPut, body: `{"protected":{"invalid":[{"key":"b"}]}}`, verbs: verbAuthorizer{"update"}, token: true, status: http.StatusUnprocessableEntity},
+		{desc: "method", method: http.MethodPost, body: `{}`, verbs: verbAuthorizer{"update"}, token: true, status: http.StatusMethodNotAllowed},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tC.method, "/admin/config", strings.NewReader(tC.body))
+			if tC.token {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			rec := httptest.NewRecorder()
+			ConfigHandler(current, override, tC.verbs).ServeHTTP(rec, req)
+			assert.Equal(t, tC.status, rec.Code)
+		})
+	}
+
+	t.Run("put without overrides", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/admin/config", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		ConfigHandler(current, nil, verbAuthorizer{"update"}).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Contains(t, rec.Body.String(), "only apply to this replica")
+	})
+
+	t.Run("put", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/admin/config", strings.NewReader(`{"protected":{"team":[{"key":"b"}]}}`))
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		ConfigHandler(current, override, verbAuthorizer{"update"}).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var got config.Config
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+		assert.Equal(t, validator.UniqueList{
+			validator.ClusterScope: {{Key: "a"}},
+			"team":                 {{Key: "b"}},
+		}, got.Protected, "the resulting configuration is returned")
+	})
+}
@@ -0,0 +1,60 @@
+/*
+ *     domains.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Domains dispatches requests below prefix to the webhook of the policy
+// domain named by the next path segment, for example /validate/dns to the
+// domain "dns". Each domain is registered as a webhook of its own in the
+// ValidatingWebhookConfiguration, so it has its own failurePolicy and
+// timeout. Requests for unknown domains are answered with 404.
+type Domains struct {
+	prefix   string
+	handlers atomic.Pointer[map[string]http.Handler]
+}
+
+// NewDomains creates a dispatcher for requests below prefix, which must end
+// with a slash.
+func NewDomains(prefix string) *Domains {
+	d := &Domains{prefix: prefix}
+	d.handlers.Store(&map[string]http.Handler{})
+	return d
+}
+
+// Set replaces the handlers of all domains. Requests already being served
+// finish with the previous handlers.
+func (d *Domains) Set(handlers map[string]http.Handler) {
+	d.handlers.Store(&handlers)
+}
+
+func (d *Domains) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, d.prefix)
+	h, ok := (*d.handlers.Load())[name]
+	if !ok || name == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,63 @@
+/*
+ *     domains_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomains(t *testing.T) {
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(name)) })
+	}
+	d := NewDomains("/validate/")
+	d.Set(map[string]http.Handler{"dns": named("dns"), "network": named("network")})
+
+	testCases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/validate/dns", http.StatusOK, "dns"},
+		{"/validate/network", http.StatusOK, "network"},
+		{"/validate/other", http.StatusNotFound, ""},
+		{"/validate/", http.StatusNotFound, ""},
+		{"/validate/dns/more", http.StatusNotFound, ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			d.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tC.path, nil))
+			assert.Equal(t, tC.status, rec.Code)
+			if tC.body != "" {
+				assert.Equal(t, tC.body, rec.Body.String())
+			}
+		})
+	}
+
+	d.Set(map[string]http.Handler{"network": named("network")})
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate/dns", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "removed domain")
+}
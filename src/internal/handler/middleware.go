@@ -0,0 +1,265 @@
+/*
+ *     middleware.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// RequestIDHeader carries the ID of a request, both inbound and outbound.
+const RequestIDHeader = "X-Request-Id"
+
+// Middleware wraps a handler with cross-cutting behavior.
+type Middleware func(http.Handler) http.Handler
+
+// Chain is an ordered list of middlewares. The first one is the outermost.
+type Chain []Middleware
+
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain(middlewares)
+}
+
+// Append returns a new chain with middlewares added to the inner end of c.
+// c itself is not modified, so a common chain can be shared by several servers.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	chain := make(Chain, 0, len(c)+len(middlewares))
+	chain = append(chain, c...)
+	return append(chain, middlewares...)
+}
+
+// Then wraps h with all middlewares of the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c) - 1; i >= 0; i-- {
+		h = c[i](h)
+	}
+	return h
+}
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID set by the RequestID middleware.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID ensures every request has an ID. An ID sent by the client in
+// RequestIDHeader is kept. The ID is echoed in the response.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDHeader)
+			if id == "" {
+				b := make([]byte, 8)
+				rand.Read(b)
+				id = hex.EncodeToString(b)
+			}
+			w.Header().Set(RequestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming responses working through the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Logging logs every request at debug level.
+func Logging(logger *zap.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			logger.Debug("Handled request",
+				zap.String("request_id", RequestIDFromContext(r.Context())),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.Int("status", rec.status),
+				zap.Duration("duration", time.Since(start)))
+		})
+	}
+}
+
+// Recovery turns panics of the wrapped handler into 500 responses, so a
+// single malformed request cannot tear down the connection unanswered.
+func Recovery(logger *zap.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if p := recover(); p != nil {
+					if p == http.ErrAbortHandler {
+						panic(p)
+					}
+					logger.Error("Recovered from panic",
+						zap.String("request_id", RequestIDFromContext(r.Context())),
+						zap.String("path", r.URL.Path),
+						zap.Any("panic", p))
+					http.Error(w, "internal server error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Metrics observes the latency of requests in metrics.HTTPRequestDuration,
+// labelled with server.
+func Metrics(server string) Middleware {
+	obs := metrics.HTTPRequestDuration.MustCurryWith(prometheus.Labels{"server": server})
+	return func(next http.Handler) http.Handler {
+		return promhttp.InstrumentHandlerDuration(obs, next)
+	}
+}
+
+// MaxBytes limits the size of request bodies to n bytes.
+func MaxBytes(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAccess guards handlers of cluster scoped endpoints with resource.
+func RequireAccess(authz Authorizer, resource VirtualResource) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !authorize(w, r, authz, resource, "") {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit rejects requests exceeding limiter with 429.
+func RateLimit(limiter *rate.Limiter) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.Allow() {
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AllowHosts rejects requests whose Host header or TLS server name (SNI)
+// is not in hosts with 421 Misdirected Request. This protects against
+// requests reaching the webhook through unexpected routes. Ports are ignored
+// and names are compared case-insensitively. An empty SNI, as sent by
+// clients connecting by IP address, is accepted.
+func AllowHosts(hosts []string) Middleware {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[strings.ToLower(h)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if !allowed[strings.ToLower(host)] {
+				http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+				return
+			}
+			if r.TLS != nil && r.TLS.ServerName != "" && !allowed[strings.ToLower(r.TLS.ServerName)] {
+				http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// InFlight tracks the number of requests currently being served, which is
+// needed to tell drained from cut off requests during shutdown.
+type InFlight struct {
+	n     atomic.Int64
+	gauge prometheus.Gauge
+}
+
+// NewInFlight creates a tracker exporting its count for server in
+// metrics.InFlightRequests.
+func NewInFlight(server string) *InFlight {
+	return &InFlight{gauge: metrics.InFlightRequests.WithLabelValues(server)}
+}
+
+// Count returns the number of requests currently in flight.
+func (f *InFlight) Count() int64 {
+	return f.n.Load()
+}
+
+// Middleware counts the requests passing through it.
+func (f *InFlight) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f.gauge.Inc()
+			f.n.Add(1)
+			defer func() {
+				f.n.Add(-1)
+				f.gauge.Dec()
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ *     middleware_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	base := NewChain(mark("a"), mark("b"))
+	extended := base.Append(mark("c"))
+
+	extended.Then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+	assert.Len(t, base, 2)
+}
+
+func TestRecoveryAndRequestID(t *testing.T) {
+	var seen string
+	h := NewChain(RequestID(), Recovery(zaptest.NewLogger(t))).Then(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestIDFromContext(r.Context())
+			panic("boom")
+		}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "abc", seen)
+	assert.Equal(t, "abc", rec.Header().Get(RequestIDHeader))
+}
+
+func TestAllowHosts(t *testing.T) {
+	h := AllowHosts([]string{"unik.unik-system.svc", "Unik.unik-system.svc.cluster.local"})(
+		http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	testCases := []struct {
+		desc       string
+		host       string
+		serverName string
+		expected   int
+	}{
+		{"service name", "unik.unik-system.svc", "", http.StatusOK},
+		{"with port and other case", "UNIK.unik-system.svc.cluster.local:443", "unik.unik-system.svc", http.StatusOK},
+		{"unexpected host", "localhost:9090", "", http.StatusMisdirectedRequest},
+		{"unexpected SNI", "unik.unik-system.svc", "evil.example.com", http.StatusMisdirectedRequest},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/validate", nil)
+			req.Host = tC.host
+			if tC.serverName != "" {
+				req.TLS = &tls.ConnectionState{ServerName: tC.serverName}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tC.expected, rec.Code)
+		})
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		overload OverloadResponse
+		status   int
+		allowed  bool
+	}{
+		{"throttle", OverloadThrottle, http.StatusTooManyRequests, false},
+		{"admit", OverloadAdmit, http.StatusOK, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			entered, release := make(chan struct{}), make(chan struct{})
+			h := Backpressure(1, 0, tC.overload, 1500*time.Millisecond)(
+				http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+					entered <- struct{}{}
+					<-release
+				}))
+
+			done := make(chan struct{})
+			go func() {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", nil))
+				close(done)
+			}()
+			<-entered
+
+			body := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1"}}`
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(body)))
+			close(release)
+			<-done
+
+			assert.Equal(t, tC.status, rec.Code)
+			var review admissionv1.AdmissionReview
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+			assert.Equal(t, "1", string(review.Response.UID))
+			assert.Equal(t, tC.allowed, review.Response.Allowed)
+			if !tC.allowed {
+				assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
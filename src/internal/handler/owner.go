@@ -0,0 +1,87 @@
+/*
+ *     owner.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/unik-k8s/admission-controller/pkg/api"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+)
+
+// OwnerHandler answers GET /owner?annotation=<key>&value=<value> with the
+// service currently holding value. For annotations protected per namespace,
+// the namespace parameter selects the scope. Callers must have access to
+// ResourceOwners in that namespace, or cluster wide without one, before
+// anything is looked up. An owner in a namespace the caller has no access
+// to is not disclosed; the value is only reported as in use with 409
+// Conflict, so callers allocating values do not take it for a free one.
+func OwnerHandler(lookup validator.OwnerLookup, authz Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		annotation, value, namespace := query.Get("annotation"), query.Get("value"), query.Get("namespace")
+		if annotation == "" {
+			http.Error(w, "missing parameter annotation", http.StatusBadRequest)
+			return
+		}
+		if !authorize(w, r, authz, ResourceOwners, namespace) {
+			return
+		}
+
+		owner, err := lookup.LookupOwner(r.Context(), namespace, annotation, value)
+		switch {
+		case errors.Is(err, validator.ErrNotProtected):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, "failed to look up owner: "+err.Error(), http.StatusInternalServerError)
+			return
+		case owner == nil:
+			http.Error(w, "value is not in use", http.StatusNotFound)
+			return
+		}
+		if namespace != "" && owner.Namespace != namespace {
+			// Cluster scoped annotations may be held outside of the
+			// namespace the caller was authorized for.
+			allowed, err := authz.Authorize(r, ResourceOwners.Attributes(owner.Namespace))
+			if err != nil || !allowed {
+				http.Error(w, "value is in use in a namespace you may not access", http.StatusConflict)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.OwnerResponse{
+			Annotation:        annotation,
+			Value:             value,
+			Namespace:         owner.Namespace,
+			Name:              owner.Name,
+			UID:               owner.UID,
+			CreationTimestamp: owner.CreationTimestamp,
+		})
+	})
+}
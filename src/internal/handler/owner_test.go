@@ -0,0 +1,136 @@
+/*
+ *     owner_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type lookupFunc func(ctx context.Context, namespace, annotation, value string) (*corev1.Service, error)
+
+func (f lookupFunc) LookupOwner(ctx context.Context, namespace, annotation, value string) (*corev1.Service, error) {
+	return f(ctx, namespace, annotation, value)
+}
+
+// namespaceAuthorizer allows access to the given namespaces only and
+// records the attributes it was asked for.
+type namespaceAuthorizer struct {
+	namespaces []string
+	asked      []authorizationv1.ResourceAttributes
+}
+
+func (a *namespaceAuthorizer) Authorize(r *http.Request, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	if r.Header.Get("Authorization") == "" {
+		return false, ErrUnauthenticated
+	}
+	a.asked = append(a.asked, attrs)
+	for _, ns := range a.namespaces {
+		if ns == attrs.Namespace {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestOwnerHandler(t *testing.T) {
+	var looked int
+	lookup := lookupFunc(func(_ context.Context, _, annotation, value string) (*corev1.Service, error) {
+		looked++
+		switch {
+		case annotation != validator.AnnotationNcpSnatPool:
+			return nil, validator.ErrNotProtected
+		case value == "pool-a":
+			return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "owner"}}, nil
+		}
+		return nil, nil
+	})
+
+	testCases := []struct {
+		desc   string
+		query  string
+		token  bool
+		status int
+	}{
+		{desc: "owner visible", query: "annotation=ncp/snat_pool&value=pool-a&namespace=team-a", token: true, status: http.StatusOK},
+		{desc: "unused value", query: "annotation=ncp/snat_pool&value=pool-b&namespace=team-a", token: true, status: http.StatusNotFound},
+		{desc: "unprotected annotation", query: "annotation=other&value=pool-a&namespace=team-a", token: true, status: http.StatusNotFound},
+		{desc: "missing annotation", query: "value=pool-a", token: true, status: http.StatusBadRequest},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			authz := &namespaceAuthorizer{namespaces: []string{"team-a"}}
+			req := httptest.NewRequest(http.MethodGet, "/owner?"+tC.query, nil)
+			if tC.token {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			rec := httptest.NewRecorder()
+			OwnerHandler(lookup, authz).ServeHTTP(rec, req)
+			assert.Equal(t, tC.status, rec.Code)
+		})
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		looked = 0
+		for _, query := range []string{"annotation=ncp/snat_pool&value=pool-a", "annotation=ncp/snat_pool&value=pool-b&namespace=team-a"} {
+			req := httptest.NewRequest(http.MethodGet, "/owner?"+query, nil)
+			rec := httptest.NewRecorder()
+			OwnerHandler(lookup, &namespaceAuthorizer{namespaces: []string{"team-a"}}).ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, query)
+		}
+		assert.Zero(t, looked, "unauthenticated requests must not reach the lookup")
+	})
+
+	t.Run("cluster scope", func(t *testing.T) {
+		looked = 0
+		authz := &namespaceAuthorizer{namespaces: []string{"team-a"}}
+		req := httptest.NewRequest(http.MethodGet, "/owner?annotation=ncp/snat_pool&value=pool-a", nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		OwnerHandler(lookup, authz).ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, []authorizationv1.ResourceAttributes{ResourceOwners.Attributes("")}, authz.asked)
+		assert.Zero(t, looked, "forbidden requests must not reach the lookup")
+	})
+
+	t.Run("other namespace", func(t *testing.T) {
+		authz := &namespaceAuthorizer{namespaces: []string{"team-b"}}
+		query := func(value string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/owner?annotation=ncp/snat_pool&namespace=team-b&value="+value, nil)
+			req.Header.Set("Authorization", "Bearer token")
+			rec := httptest.NewRecorder()
+			OwnerHandler(lookup, authz).ServeHTTP(rec, req)
+			return rec
+		}
+		held, unused := query("pool-a"), query("pool-b")
+		assert.Equal(t, http.StatusConflict, held.Code, "values held in other namespaces are in use")
+		assert.NotContains(t, held.Body.String(), "team-a", "the owner is not disclosed")
+		assert.Equal(t, http.StatusNotFound, unused.Code)
+		assert.Contains(t, authz.asked, ResourceOwners.Attributes("team-a"))
+	})
+}
@@ -0,0 +1,74 @@
+/*
+ *     reindex.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/unik-k8s/admission-controller/internal/scanner"
+)
+
+// Rescanner runs a full scan on demand.
+type Rescanner interface {
+	Rescan(ctx context.Context, progress func(scanner.Progress)) (*scanner.Report, error)
+}
+
+// ReindexEvent is one line of the newline delimited JSON stream written by
+// ReindexHandler. Exactly one of its fields is set.
+type ReindexEvent struct {
+	Progress *scanner.Progress `json:"progress,omitempty"`
+	Report   *scanner.Report   `json:"report,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// ReindexHandler answers POST /-/reindex by forcing a full rescan. Progress
+// is streamed to the client as it happens. The handler is meant to be
+// wrapped with RequireAccess for ResourceReindex and RateLimit.
+func ReindexHandler(rescanner Rescanner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusAccepted)
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+
+		write := func(ev ReindexEvent) {
+			enc.Encode(ev)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		report, err := rescanner.Rescan(r.Context(), func(p scanner.Progress) {
+			write(ReindexEvent{Progress: &p})
+		})
+		if err != nil {
+			write(ReindexEvent{Error: err.Error()})
+			return
+		}
+		write(ReindexEvent{Report: report})
+	})
+}
@@ -0,0 +1,200 @@
+/*
+ *     requesthandler.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/slo"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// TraceHeader requests the decision trace for a single review if set on
+// the request, for example by a proxy or when replaying a review by hand.
+const TraceHeader = "X-Unik-Trace"
+
+type requestHandlerConfig struct {
+	codec     Codec
+	checks    *zap.Logger
+	sampler   audit.Sampler
+	sink      audit.Sink
+	recorder  audit.Sink
+	trace     bool
+	keepExtra []string
+}
+
+type RequestHandlerOption func(*requestHandlerConfig) error
+
+// WithCodec sets the codec used to encode responses. Defaults to StdCodec.
+func WithCodec(codec Codec) RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		if codec == nil {
+			return errors.New("codec is nil")
+		}
+		c.codec = codec
+		return nil
+	}
+}
+
+// WithResponseValidation validates every response with response.Validate
+// before it is sent and logs violations to logger. It is meant for debug mode.
+func WithResponseValidation(logger *zap.Logger) RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		c.checks = logger
+		return nil
+	}
+}
+
+// WithSampling records the reviews selected by sampler to sink, redacted
+// with audit.Redact.
+func WithSampling(sampler audit.Sampler, sink audit.Sink) RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		if sink == nil {
+			return errors.New("sink is nil")
+		}
+		if err := sampler.Validate(); err != nil {
+			return err
+		}
+		c.sampler = sampler
+		c.sink = sink
+		return nil
+	}
+}
+
+// WithRecording records every review to sink, redacted with audit.Redact,
+// for example to an audit.Ring.
+func WithRecording(sink audit.Sink) RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		if sink == nil {
+			return errors.New("sink is nil")
+		}
+		c.recorder = sink
+		return nil
+	}
+}
+
+// WithAttributionExtra keeps the extra fields of the requesting user named
+// by keys in sampled and recorded reviews, which are redacted otherwise.
+func WithAttributionExtra(keys []string) RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		c.keepExtra = keys
+		return nil
+	}
+}
+
+// WithDecisionTrace keeps the decision trace of every response in its audit
+// annotations. Otherwise it is only kept for requests carrying TraceHeader.
+// It is meant for debug mode.
+func WithDecisionTrace() RequestHandlerOption {
+	return func(c *requestHandlerConfig) error {
+		c.trace = true
+		return nil
+	}
+}
+
+func AdmissionReviewRequesthandler(validator validator.ValidationHandlerV1, options ...RequestHandlerOption) (http.Handler, error) {
+	cfg := &requestHandlerConfig{codec: StdCodec()}
+	for _, option := range options {
+		if err := option(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch {
+		case r.Body == nil:
+			http.Error(w, "no body", http.StatusBadRequest)
+			return
+		case r.Header.Get("Content-Type") != "application/json":
+			http.Error(w, "wrong content type", http.StatusBadRequest)
+		}
+
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reviewed, err := validator.ValidateBytes(r.Context(), content)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if reviewed.Response != nil && reviewed.Response.AuditAnnotations[response.AuditAnnotationReason] == string(response.ReasonError) {
+			if r.Context().Err() != nil {
+				reportOutcome(r.Context(), slo.OutcomeTimeout)
+			} else {
+				reportOutcome(r.Context(), slo.OutcomeError)
+			}
+		}
+
+		if cfg.checks != nil {
+			if err := response.Validate(reviewed); err != nil {
+				cfg.checks.Error("Response violates AdmissionReview schema", zap.Error(err))
+			}
+		}
+
+		if cfg.sink != nil && cfg.sampler.Sample(reviewed.Response) {
+			sample(cfg.sink, content, reviewed.Response, cfg.keepExtra)
+		}
+
+		if !cfg.trace && r.Header.Get(TraceHeader) == "" && reviewed.Response != nil {
+			delete(reviewed.Response.AuditAnnotations, response.AuditAnnotationTrace)
+		}
+
+		if cfg.recorder != nil && reviewed.Response != nil {
+			sample(cfg.recorder, content, reviewed.Response, cfg.keepExtra)
+		}
+
+		data, release, err := cfg.codec.Marshal(reviewed)
+		if err != nil {
+			http.Error(w, "failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer release()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	}), nil
+}
+
+// sample records the request in content along with resp, keeping the extra
+// fields of the user named by keepExtra. The request is only decoded again
+// here, so unsampled reviews do not pay for it.
+func sample(sink audit.Sink, content []byte, resp *admissionv1.AdmissionResponse, keepExtra []string) {
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(content, &review); err != nil || review.Request == nil {
+		return
+	}
+	sink.Record(audit.Record{Time: time.Now(), Request: audit.Redact(review.Request, keepExtra...), Response: resp})
+}
@@ -0,0 +1,118 @@
+/*
+ *     requesthandler_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// denyingValidator denies every request.
+type denyingValidator struct{}
+
+func (denyingValidator) ValidateBytes(context.Context, []byte) (*admissionv1.AdmissionReview, error) {
+	return &admissionv1.AdmissionReview{Response: &admissionv1.AdmissionResponse{UID: "1", Allowed: false}}, nil
+}
+
+func (denyingValidator) Validate(context.Context, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{UID: "1", Allowed: false}
+}
+
+type recordingSink []audit.Record
+
+func (s *recordingSink) Record(r audit.Record) { *s = append(*s, r) }
+
+func TestSampling(t *testing.T) {
+	var sink recordingSink
+	h, err := AdmissionReviewRequesthandler(denyingValidator{}, WithSampling(audit.Sampler{Denials: true}, &sink))
+	require.NoError(t, err)
+
+	body := []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"1","userInfo":{"extra":{"token":["x"]}}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Len(t, sink, 1)
+	assert.Equal(t, "1", string(sink[0].Request.UID))
+	assert.Equal(t, []string{audit.Redacted}, []string(sink[0].Request.UserInfo.Extra["token"]))
+	assert.False(t, sink[0].Response.Allowed)
+
+	_, err = AdmissionReviewRequesthandler(denyingValidator{}, WithSampling(audit.Sampler{Fraction: 2}, &sink))
+	assert.Error(t, err)
+}
+
+// tracingValidator allows every request with a decision trace.
+type tracingValidator struct{}
+
+func (tracingValidator) ValidateBytes(context.Context, []byte) (*admissionv1.AdmissionReview, error) {
+	return &admissionv1.AdmissionReview{Response: &admissionv1.AdmissionResponse{
+		UID:              "1",
+		Allowed:          true,
+		AuditAnnotations: map[string]string{response.AuditAnnotationReason: "r", response.AuditAnnotationTrace: "source=api"},
+	}}, nil
+}
+
+func (v tracingValidator) Validate(ctx context.Context, _ admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	review, _ := v.ValidateBytes(ctx, nil)
+	return review.Response
+}
+
+func TestDecisionTrace(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		options []RequestHandlerOption
+		header  bool
+		traced  bool
+	}{
+		{"stripped by default", nil, false, false},
+		{"requested by header", nil, true, true},
+		{"always", []RequestHandlerOption{WithDecisionTrace()}, false, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			h, err := AdmissionReviewRequesthandler(tracingValidator{}, tC.options...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader([]byte(`{}`)))
+			req.Header.Set("Content-Type", "application/json")
+			if tC.header {
+				req.Header.Set(TraceHeader, "1")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			var review admissionv1.AdmissionReview
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+			_, traced := review.Response.AuditAnnotations[response.AuditAnnotationTrace]
+			assert.Equal(t, tC.traced, traced)
+			assert.Equal(t, "r", review.Response.AuditAnnotations[response.AuditAnnotationReason])
+		})
+	}
+}
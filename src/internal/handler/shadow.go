@@ -0,0 +1,83 @@
+/*
+ *     shadow.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/shadow"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// ShadowHandler serves /shadow for a deployment running in shadow mode.
+// POST decides the audit.Record in the body, as mirrored by an
+// audit.Mirror, again with validator and compares the verdicts with
+// comparator. The decision is not returned, as it answers no apiserver.
+// GET answers with the shadow.Report, with 200 if its gate passed and 412
+// Precondition Failed otherwise, so rollout tooling can gate the cutover
+// on the status alone. DELETE resets the report. Access is guarded by
+// ResourceShadowReviews, ResourceShadowReport and ResourceShadowReset
+// respectively.
+func ShadowHandler(validator validator.ValidationHandlerV1, comparator *shadow.Comparator, authz Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if !authorize(w, r, authz, ResourceShadowReviews, "") {
+				return
+			}
+			var rec audit.Record
+			if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+				http.Error(w, "invalid record: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if rec.Request == nil || rec.Response == nil {
+				http.Error(w, "record lacks request or response", http.StatusBadRequest)
+				return
+			}
+			comparator.Compare(rec, validator.Validate(r.Context(), admissionv1.AdmissionReview{Request: rec.Request}))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		case http.MethodGet:
+			if !authorize(w, r, authz, ResourceShadowReport, "") {
+				return
+			}
+		case http.MethodDelete:
+			if !authorize(w, r, authz, ResourceShadowReset, "") {
+				return
+			}
+			comparator.Reset()
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := comparator.Report()
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Passed {
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
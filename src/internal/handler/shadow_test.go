@@ -0,0 +1,102 @@
+/*
+ *     shadow_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/shadow"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestShadowHandler(t *testing.T) {
+	comparator, err := shadow.NewComparator(shadow.Gate{MinSamples: 2, MaxDisagreement: 0.5}, 10)
+	require.NoError(t, err)
+	srv := httptest.NewServer(ShadowHandler(denyingValidator{}, comparator, verbAuthorizer{"create", "get", "delete"}))
+	defer srv.Close()
+
+	report := func() (int, shadow.Report) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var r shadow.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+		return resp.StatusCode, r
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("mirror\n"), 0o600))
+	mirror := audit.NewMirror(zap.NewNop(), srv.Client(), srv.URL, tokenFile, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mirror.Run(ctx)
+
+	mirror.Record(audit.Record{
+		Request:  &admissionv1.AdmissionRequest{UID: "1", Operation: admissionv1.Create, Namespace: "a", Name: "web"},
+		Response: response.Denied("1", response.ReasonConflict, "taken"),
+	})
+	require.Eventually(t, func() bool { _, r := report(); return r.Compared == 1 }, time.Second, 10*time.Millisecond)
+	status, r := report()
+	assert.Equal(t, http.StatusPreconditionFailed, status, "too few samples")
+	assert.False(t, r.Passed)
+
+	mirror.Record(audit.Record{
+		Request:  &admissionv1.AdmissionRequest{UID: "2", Operation: admissionv1.Update, Namespace: "a", Name: "db"},
+		Response: response.Allowed("2", response.ReasonUnique),
+	})
+	require.Eventually(t, func() bool { _, r := report(); return r.Compared == 2 }, time.Second, 10*time.Millisecond)
+	status, r = report()
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, r.Passed)
+	assert.Equal(t, 1, r.Disagreed)
+	require.Len(t, r.Disagreements, 1)
+	assert.Equal(t, "db", r.Disagreements[0].Name)
+	assert.Equal(t, shadow.Verdict{Allowed: true, Reason: response.ReasonUnique}, r.Disagreements[0].Mirrored)
+	assert.False(t, r.Disagreements[0].Shadow.Allowed)
+	assert.Equal(t, map[string]int{"annotation-conflict->": 1, "annotation-unique->": 1}, r.ReasonChanges)
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, r = report()
+	assert.Zero(t, r.Compared)
+
+	resp, err = http.Post(srv.URL, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "mirrored reviews require credentials")
+}
@@ -0,0 +1,73 @@
+/*
+ *     simulate.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/unik-k8s/admission-controller/pkg/api"
+)
+
+// maxSimulateBody limits the size of simulation requests.
+const maxSimulateBody = 4 << 20
+
+// ErrInvalidSimulation is returned by a Simulator if the request cannot be
+// simulated, for example because the candidate configuration is invalid.
+var ErrInvalidSimulation = errors.New("invalid simulation")
+
+// Simulator decides on objects under a candidate configuration without
+// changing the live configuration or the cluster.
+type Simulator interface {
+	Simulate(ctx context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error)
+}
+
+// SimulateHandler answers POST /simulate with the decisions the candidate
+// configuration of the request would produce for its objects. The handler
+// is meant to be wrapped with RequireAccess for ResourceSimulations.
+func SimulateHandler(sim Simulator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req api.SimulateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSimulateBody)).Decode(&req); err != nil {
+			http.Error(w, "failed to decode request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		decisions, err := sim.Simulate(r.Context(), req)
+		switch {
+		case errors.Is(err, ErrInvalidSimulation):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			http.Error(w, "failed to simulate: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(api.SimulateResponse{Decisions: decisions})
+	})
+}
@@ -0,0 +1,77 @@
+/*
+ *     simulate_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/api"
+)
+
+type simulateFunc func(ctx context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error)
+
+func (f simulateFunc) Simulate(ctx context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error) {
+	return f(ctx, req)
+}
+
+func TestSimulateHandler(t *testing.T) {
+	sim := simulateFunc(func(_ context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error) {
+		if req.Domain == "unknown" {
+			return nil, fmt.Errorf("%w: unknown domain", ErrInvalidSimulation)
+		}
+		decisions := make([]api.SimulatedDecision, len(req.Objects))
+		for i, obj := range req.Objects {
+			decisions[i] = api.SimulatedDecision{Namespace: obj.Namespace, Name: obj.Name, Allowed: true}
+		}
+		return decisions, nil
+	})
+
+	testCases := []struct {
+		desc   string
+		method string
+		body   string
+		status int
+	}{
+		{"simulation", http.MethodPost, `{"objects":[{"metadata":{"namespace":"a","name":"svc"}}]}`, http.StatusOK},
+		{"invalid simulation", http.MethodPost, `{"domain":"unknown"}`, http.StatusUnprocessableEntity},
+		{"malformed body", http.MethodPost, `{`, http.StatusBadRequest},
+		{"wrong method", http.MethodGet, "", http.StatusMethodNotAllowed},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			SimulateHandler(sim).ServeHTTP(rec, httptest.NewRequest(tC.method, "/simulate", strings.NewReader(tC.body)))
+			assert.Equal(t, tC.status, rec.Code)
+			if rec.Code == http.StatusOK {
+				var resp api.SimulateResponse
+				require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+				assert.Equal(t, []api.SimulatedDecision{{Namespace: "a", Name: "svc", Allowed: true}}, resp.Decisions)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ *     slo.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/slo"
+)
+
+type outcomeKey struct{}
+
+// SLO counts every review with tracker. Reviews answered with a decision
+// are classified by their latency; handlers report all other outcomes with
+// reportOutcome. Reviews abandoned by the apiserver count as timeouts and
+// reviews answered with an error status as errors.
+func SLO(tracker *slo.Tracker) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			var outcome slo.Outcome
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), outcomeKey{}, &outcome)))
+			switch {
+			case outcome != "":
+			case r.Context().Err() != nil:
+				outcome = slo.OutcomeTimeout
+			case rec.status == 0 || rec.status == http.StatusOK:
+				outcome = tracker.Classify(time.Since(start))
+			default:
+				outcome = slo.OutcomeError
+			}
+			tracker.Observe(outcome)
+		})
+	}
+}
+
+// reportOutcome sets the outcome of the review served with ctx for the SLO
+// middleware, if there is one.
+func reportOutcome(ctx context.Context, outcome slo.Outcome) {
+	if p, ok := ctx.Value(outcomeKey{}).(*slo.Outcome); ok {
+		*p = outcome
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ *     slo_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/internal/slo"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func sloReviews(t *testing.T, outcome slo.Outcome) float64 {
+	var m dto.Metric
+	require.NoError(t, metrics.SLOReviews.WithLabelValues(string(outcome)).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestSLO(t *testing.T) {
+	tracker, err := slo.NewTracker(testingclock.NewFakeClock(time.Now()), 0.999, time.Hour, []time.Duration{time.Hour})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+		outcome slo.Outcome
+	}{
+		{"decision", func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("{}")) }, slo.OutcomeGood},
+		{"error status", func(w http.ResponseWriter, _ *http.Request) { http.Error(w, "failed", http.StatusBadRequest) }, slo.OutcomeError},
+		{"reported", func(w http.ResponseWriter, r *http.Request) {
+			overloaded(w, r, OverloadAdmit, time.Second)
+		}, slo.OutcomeOverloaded},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			before := sloReviews(t, tC.outcome)
+			SLO(tracker)(tC.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", nil))
+			assert.Equal(t, before+1, sloReviews(t, tC.outcome))
+		})
+	}
+
+	t.Run("abandoned", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		before := sloReviews(t, slo.OutcomeTimeout)
+		SLO(tracker)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { cancel() })).
+			ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/validate", nil).WithContext(ctx))
+		assert.Equal(t, before+1, sloReviews(t, slo.OutcomeTimeout))
+	})
+}
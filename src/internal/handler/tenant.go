@@ -0,0 +1,71 @@
+/*
+ *     tenant.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NamespaceMetricsPrefix is the path NamespaceMetricsHandler is served under.
+const NamespaceMetricsPrefix = "/metrics/namespaces/"
+
+// NamespaceMetricsHandler answers GET /metrics/namespaces/<namespace> with
+// the metrics of metrics.TenantRegistry belonging to namespace in the
+// Prometheus exposition format, so tenants can build their own dashboards
+// without access to the metrics of the whole cluster. Access is guarded by
+// ResourceMetrics in the requested namespace.
+func NamespaceMetricsHandler(authz Authorizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		namespace := strings.TrimPrefix(r.URL.Path, NamespaceMetricsPrefix)
+		if msgs := validation.IsDNS1123Label(namespace); len(msgs) > 0 {
+			http.Error(w, "invalid namespace: "+strings.Join(msgs, "; "), http.StatusBadRequest)
+			return
+		}
+
+		if !authorize(w, r, authz, ResourceMetrics, namespace) {
+			return
+		}
+
+		families, err := metrics.GatherNamespace(namespace)
+		if err != nil {
+			http.Error(w, "failed to gather metrics: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		format := expfmt.Negotiate(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		enc := expfmt.NewEncoder(w, format)
+		for _, family := range families {
+			if err := enc.Encode(family); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+/*
+ *     tenant_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+)
+
+func TestNamespaceMetricsHandler(t *testing.T) {
+	metrics.NamespaceDecisions.WithLabelValues("team-a", "default", "annotation-unique").Inc()
+	metrics.NamespaceDecisions.WithLabelValues("team-b", "default", "annotation-conflict").Inc()
+
+	testCases := []struct {
+		desc   string
+		path   string
+		token  bool
+		status int
+	}{
+		{"own namespace", "team-a", true, http.StatusOK},
+		{"other namespace", "team-b", true, http.StatusForbidden},
+		{"unauthenticated", "team-a", false, http.StatusUnauthorized},
+		{"invalid namespace", "team-a/extra", true, http.StatusBadRequest},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			authz := &namespaceAuthorizer{namespaces: []string{"team-a"}}
+			req := httptest.NewRequest(http.MethodGet, NamespaceMetricsPrefix+tC.path, nil)
+			if tC.token {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			rec := httptest.NewRecorder()
+			NamespaceMetricsHandler(authz).ServeHTTP(rec, req)
+			assert.Equal(t, tC.status, rec.Code)
+			if rec.Code == http.StatusOK {
+				assert.Contains(t, rec.Body.String(), `unik_namespace_decisions_total{domain="default",namespace="team-a",reason="annotation-unique"} 1`)
+				assert.NotContains(t, rec.Body.String(), "team-b")
+			}
+		})
+	}
+}
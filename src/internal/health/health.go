@@ -0,0 +1,148 @@
+/*
+ *     health.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package health aggregates the health of the subsystems of the admission
+// controller into its readiness.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Criticality tells how the failure of a subsystem affects readiness.
+type Criticality int
+
+const (
+	// Critical subsystems make the controller unready when they fail,
+	// as it cannot decide requests correctly without them.
+	Critical Criticality = iota
+	// Degrading subsystems are reported, but the controller stays ready,
+	// as it keeps deciding requests, if with reduced guarantees.
+	Degrading
+)
+
+func (c Criticality) String() string {
+	if c == Critical {
+		return "critical"
+	}
+	return "degrading"
+}
+
+// Check reports the health of a subsystem by returning nil if it is healthy.
+type Check func(ctx context.Context) error
+
+type check struct {
+	name        string
+	criticality Criticality
+	check       Check
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name        string
+	Criticality Criticality
+	Err         error
+}
+
+// Checker holds the checks of all subsystems.
+type Checker struct {
+	lock    sync.Mutex
+	checks  []check
+	timeout time.Duration
+}
+
+// NewChecker creates a checker giving each check at most timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers fn as the check of the subsystem called name.
+func (c *Checker) Add(name string, criticality Criticality, fn Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checks = append(c.checks, check{name: name, criticality: criticality, check: fn})
+}
+
+// Check runs all checks and returns their results ordered by name, and
+// whether the controller is ready, which it is unless a critical check failed.
+func (c *Checker) Check(ctx context.Context) ([]Result, bool) {
+	c.lock.Lock()
+	checks := append([]check(nil), c.checks...)
+	c.lock.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, ch := range checks {
+		wg.Add(1)
+		go func(i int, ch check) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+			results[i] = Result{Name: ch.name, Criticality: ch.criticality, Err: ch.check(ctx)}
+		}(i, ch)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	ready := true
+	for _, r := range results {
+		if r.Err != nil && r.Criticality == Critical {
+			ready = false
+		}
+	}
+	return results, ready
+}
+
+// Handler serves readiness in the format of the Kubernetes apiserver:
+// "ok" or 503 by default, and the result of every check with the query
+// parameter verbose.
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results, ready := c.Check(r.Context())
+
+		var b strings.Builder
+		if _, verbose := r.URL.Query()["verbose"]; verbose {
+			for _, res := range results {
+				if res.Err == nil {
+					fmt.Fprintf(&b, "[+]%s ok\n", res.Name)
+				} else {
+					fmt.Fprintf(&b, "[-]%s failed (%s): %s\n", res.Name, res.Criticality, res.Err)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			b.WriteString("readyz check failed\n")
+		} else if b.Len() > 0 {
+			b.WriteString("readyz check passed\n")
+		} else {
+			b.WriteString("ok")
+		}
+		w.Write([]byte(b.String()))
+	})
+}
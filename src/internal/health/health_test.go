@@ -0,0 +1,105 @@
+/*
+ *     health_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func healthy(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("broken") }
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		checks map[string]Criticality
+		failed string
+		query  string
+		status int
+		body   string
+	}{
+		{
+			desc:   "all healthy",
+			checks: map[string]Criticality{"tls": Critical, "config": Degrading},
+			status: http.StatusOK,
+			body:   "ok",
+		},
+		{
+			desc:   "degrading failure stays ready",
+			checks: map[string]Criticality{"tls": Critical, "config": Degrading},
+			failed: "config",
+			query:  "?verbose",
+			status: http.StatusOK,
+			body:   "[-]config failed (degrading): broken\n[+]tls ok\nreadyz check passed\n",
+		},
+		{
+			desc:   "critical failure",
+			checks: map[string]Criticality{"tls": Critical, "config": Degrading},
+			failed: "tls",
+			status: http.StatusServiceUnavailable,
+			body:   "readyz check failed\n",
+		},
+		{
+			desc:   "critical failure, verbose",
+			checks: map[string]Criticality{"tls": Critical},
+			failed: "tls",
+			query:  "?verbose=1",
+			status: http.StatusServiceUnavailable,
+			body:   "[-]tls failed (critical): broken\nreadyz check failed\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c := NewChecker(time.Second)
+			for name, criticality := range tC.checks {
+				check := healthy
+				if name == tC.failed {
+					check = failing
+				}
+				c.Add(name, criticality, check)
+			}
+
+			rec := httptest.NewRecorder()
+			c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz"+tC.query, nil))
+			assert.Equal(t, tC.status, rec.Code)
+			assert.Equal(t, tC.body, rec.Body.String())
+		})
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	c := NewChecker(10 * time.Millisecond)
+	c.Add("slow", Critical, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	results, ready := c.Check(context.Background())
+	assert.False(t, ready)
+	assert.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+}
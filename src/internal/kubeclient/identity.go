@@ -0,0 +1,67 @@
+/*
+ *     identity.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package kubeclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TokenFile is the service account token mounted into pods.
+const TokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// InClusterUsername returns the username the pod authenticates as with
+// the token in TokenFile.
+func InClusterUsername() (string, error) {
+	token, err := os.ReadFile(TokenFile)
+	if err != nil {
+		return "", err
+	}
+	return TokenUsername(strings.TrimSpace(string(token)))
+}
+
+// TokenUsername returns the subject of the service account token, which
+// is the username the apiserver authenticates it as, for example
+// "system:serviceaccount:unik:unik-admission-controller". The token is
+// not verified; it is only used to recognize requests of the pod itself.
+func TokenUsername(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("decoding token payload: %w", err)
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("decoding token claims: %w", err)
+	}
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Subject, nil
+}
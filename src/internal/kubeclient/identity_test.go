@@ -0,0 +1,47 @@
+/*
+ *     identity_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package kubeclient
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenUsername(t *testing.T) {
+	jwt := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2lnbmF0dXJl"
+	}
+
+	username, err := TokenUsername(jwt(`{"iss":"kubernetes/serviceaccount","sub":"system:serviceaccount:unik:unik-admission-controller"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "system:serviceaccount:unik:unik-admission-controller", username)
+
+	for name, token := range map[string]string{
+		"no jwt":     "opaque",
+		"no subject": jwt(`{"iss":"kubernetes/serviceaccount"}`),
+		"no json":    jwt("subject"),
+		"no base64":  "a.!!.c",
+	} {
+		_, err := TokenUsername(token)
+		assert.Error(t, err, name)
+	}
+}
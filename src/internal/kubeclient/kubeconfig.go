@@ -0,0 +1,49 @@
+/*
+ *     kubeconfig.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package kubeclient
+
+import (
+	"errors"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Kubeconfig returns a Loader for running outside of a cluster, for example
+// while developing. It loads the credentials of the given context from the
+// kubeconfig file at path, where an empty context selects the current one.
+// Without a path, the files listed in KUBECONFIG are used. If neither a
+// path, a context nor KUBECONFIG are given, the in-cluster credentials are
+// used, falling back to ~/.kube/config outside of a cluster.
+func Kubeconfig(path, context string) Loader {
+	return func() (*rest.Config, error) {
+		if path == "" && context == "" && os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" {
+			cfg, err := rest.InClusterConfig()
+			if !errors.Is(err, rest.ErrNotInCluster) {
+				return cfg, err
+			}
+		}
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = path
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	}
+}
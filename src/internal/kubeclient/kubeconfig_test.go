@@ -0,0 +1,76 @@
+/*
+ *     kubeconfig_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package kubeclient
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+users:
+- name: admin
+  user:
+    token: secret
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+current-context: dev
+`
+
+func TestKubeconfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(testKubeconfig), 0o600))
+
+	cfg, err := Kubeconfig(path, "")()
+	require.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com:6443", cfg.Host, "current context")
+	assert.Equal(t, "secret", cfg.BearerToken)
+
+	cfg, err = Kubeconfig(path, "prod")()
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com:6443", cfg.Host)
+
+	_, err = Kubeconfig(path, "staging")()
+	assert.Error(t, err, "unknown context")
+
+	t.Setenv("KUBECONFIG", path)
+	cfg, err = Kubeconfig("", "prod")()
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com:6443", cfg.Host, "KUBECONFIG is honored")
+}
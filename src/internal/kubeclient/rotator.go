@@ -0,0 +1,219 @@
+/*
+ *     rotator.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package kubeclient keeps the connection to the apiserver working across
+// rotations of the service account token and the cluster CA.
+package kubeclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
+)
+
+// Loader returns the current credentials, usually rest.InClusterConfig.
+type Loader func() (*rest.Config, error)
+
+// Rotator is an http.RoundTripper for clientsets created from Config. It
+// counts consecutive authentication failures and TLS verification errors.
+// Past a threshold, it loads the credentials again and replaces its
+// transport, so all clientsets and the watches of their informers recover
+// without a restart. Informers reconnect on their own once the
+// credentials work again.
+type Rotator struct {
+	logger      *zap.Logger
+	load        Loader
+	clock       clock.PassiveClock
+	threshold   int32
+	minInterval time.Duration
+
+	host      string
+	transport atomic.Pointer[http.RoundTripper]
+	failures  atomic.Int32
+
+	// lock serializes rebuilds.
+	lock    sync.Mutex
+	rebuilt time.Time
+}
+
+type RotatorOption func(*Rotator) error
+
+func WithLogger(logger *zap.Logger) RotatorOption {
+	return func(r *Rotator) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		r.logger = logger
+		return nil
+	}
+}
+
+// WithLoader sets the source of the credentials. Defaults to
+// rest.InClusterConfig.
+func WithLoader(load Loader) RotatorOption {
+	return func(r *Rotator) error {
+		if load == nil {
+			return errors.New("loader is nil")
+		}
+		r.load = load
+		return nil
+	}
+}
+
+// WithThreshold sets the number of consecutive failures after which the
+// credentials are loaded again.
+func WithThreshold(n int) RotatorOption {
+	return func(r *Rotator) error {
+		if n <= 0 {
+			return errors.New("threshold must be positive")
+		}
+		r.threshold = int32(n)
+		return nil
+	}
+}
+
+// WithMinInterval sets the minimum time between two rebuilds, so that
+// credentials which are broken for good do not cause a rebuild loop.
+func WithMinInterval(d time.Duration) RotatorOption {
+	return func(r *Rotator) error {
+		if d < 0 {
+			return errors.New("minimum interval must not be negative")
+		}
+		r.minInterval = d
+		return nil
+	}
+}
+
+// WithClock sets the source of the current time. Defaults to the real clock.
+func WithClock(c clock.PassiveClock) RotatorOption {
+	return func(r *Rotator) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		r.clock = c
+		return nil
+	}
+}
+
+func NewRotator(options ...RotatorOption) (*Rotator, error) {
+	r := &Rotator{
+		logger:      zap.NewNop(),
+		load:        rest.InClusterConfig,
+		clock:       clock.RealClock{},
+		threshold:   5,
+		minInterval: 30 * time.Second,
+	}
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	cfg, err := r.load()
+	if err != nil {
+		return nil, fmt.Errorf("loading credentials: %w", err)
+	}
+	transport, err := rest.TransportFor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating transport: %w", err)
+	}
+	r.host = cfg.Host
+	r.transport.Store(&transport)
+	r.rebuilt = r.clock.Now()
+	return r, nil
+}
+
+// Config returns a configuration for clientsets using r as transport.
+func (r *Rotator) Config() *rest.Config {
+	return &rest.Config{Host: r.host, Transport: r}
+}
+
+func (r *Rotator) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := (*r.transport.Load()).RoundTrip(req)
+	if failed(resp, err) {
+		if r.failures.Add(1) >= r.threshold {
+			r.rebuild()
+		}
+	} else if err == nil {
+		r.failures.Store(0)
+	}
+	return resp, err
+}
+
+// failed reports whether a round trip failed because of the credentials.
+// 403 is not counted, as it usually means RBAC denied a request the
+// credentials were accepted for.
+func failed(resp *http.Response, err error) bool {
+	if err != nil {
+		var (
+			verification *tls.CertificateVerificationError
+			unknown      x509.UnknownAuthorityError
+		)
+		return errors.As(err, &verification) || errors.As(err, &unknown)
+	}
+	return resp.StatusCode == http.StatusUnauthorized
+}
+
+func (r *Rotator) rebuild() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+	if r.failures.Load() < r.threshold || now.Sub(r.rebuilt) < r.minInterval {
+		return
+	}
+	r.rebuilt = now
+
+	cfg, err := r.load()
+	if err == nil {
+		var transport http.RoundTripper
+		if transport, err = rest.TransportFor(cfg); err == nil {
+			old := r.transport.Swap(&transport)
+			utilnet.CloseIdleConnectionsFor(*old)
+		}
+	}
+	if err != nil {
+		metrics.ClientRebuilds.WithLabelValues("failure").Inc()
+		r.logger.Error("Failed to reload apiserver credentials", zap.Error(err))
+		return
+	}
+	r.failures.Store(0)
+	metrics.ClientRebuilds.WithLabelValues("success").Inc()
+	r.logger.Warn("Reloaded apiserver credentials after repeated authentication failures")
+}
+
+// Healthy returns an error while requests keep failing authentication.
+// It can be used as health.Check.
+func (r *Rotator) Healthy(context.Context) error {
+	if n := r.failures.Load(); n >= r.threshold {
+		return fmt.Errorf("%d consecutive authentication failures", n)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+ *     rotator_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package kubeclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestRotator(t *testing.T) {
+	valid := "old"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"kind":"ServiceList","apiVersion":"v1","items":[]}`))
+	}))
+	defer srv.Close()
+
+	token := "old"
+	loads := 0
+	clk := testingclock.NewFakeClock(time.Now())
+	r, err := NewRotator(
+		WithLogger(zaptest.NewLogger(t)),
+		WithLoader(func() (*rest.Config, error) {
+			loads++
+			return &rest.Config{Host: srv.URL, BearerToken: token}, nil
+		}),
+		WithThreshold(2),
+		WithMinInterval(time.Minute),
+		WithClock(clk))
+	require.NoError(t, err)
+	clientset, err := kubernetes.NewForConfig(r.Config())
+	require.NoError(t, err)
+
+	list := func() error {
+		_, err := clientset.CoreV1().Services("").List(context.Background(), metav1.ListOptions{})
+		return err
+	}
+	require.NoError(t, list())
+
+	// The token is rotated, but the rotator does not know yet.
+	valid = "new"
+	assert.Error(t, list())
+	assert.Error(t, list())
+	assert.Error(t, r.Healthy(context.Background()))
+	assert.Equal(t, 1, loads, "rebuilds are limited by the minimum interval")
+
+	token = "new"
+	clk.Step(time.Minute)
+	assert.Error(t, list(), "the failing request is not retried")
+	assert.Equal(t, 2, loads)
+	assert.NoError(t, list())
+	assert.NoError(t, r.Healthy(context.Background()))
+}
@@ -0,0 +1,334 @@
+/*
+ *     metrics.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package metrics holds the Prometheus collectors of the admission controller.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "unik"
+
+var (
+	// Registry holds all collectors of the admission controller.
+	Registry = prometheus.NewRegistry()
+
+	// Decisions counts the decisions of the validator by policy domain and
+	// reason, as given in the audit annotations of the response.
+	Decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decisions_total",
+		Help:      "Number of admission decisions by policy domain and reason.",
+	}, []string{"domain", "reason"})
+
+	// SLOReviews counts reviews by their outcome for the SLO of the
+	// admission path (good, slow, error, timeout, overloaded).
+	SLOReviews = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slo_reviews_total",
+		Help:      "Number of reviews by outcome for the SLO of the admission path.",
+	}, []string{"outcome"})
+
+	// DegradedDecisions counts admitted requests which were answered while
+	// the validator knowingly operated degraded, by reason.
+	DegradedDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "degraded_decisions_total",
+		Help:      "Number of requests admitted while operating in degraded mode.",
+	}, []string{"reason"})
+
+	// HTTPRequestDuration observes the latency of HTTP requests per server.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by server, status code and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server", "code", "method"})
+
+	// InFlightRequests is the number of requests currently being served.
+	InFlightRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests currently being served by server.",
+	}, []string{"server"})
+
+	// ShutdownRequests counts the requests in flight at shutdown by whether
+	// they were drained or cut off after the grace period.
+	ShutdownRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shutdown_requests_total",
+		Help:      "Number of requests in flight during shutdown by outcome (drained, cut_off).",
+	}, []string{"server", "outcome"})
+
+	// OverloadedRequests counts reviews answered without validation because
+	// all validation slots were busy, by response (throttle, admit).
+	OverloadedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "overloaded_requests_total",
+		Help:      "Number of reviews answered without validation while overloaded by response.",
+	}, []string{"response"})
+
+	// ClientRebuilds counts reloads of the apiserver credentials by result
+	// (success, failure).
+	ClientRebuilds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_rebuilds_total",
+		Help:      "Number of reloads of the apiserver credentials after authentication failures by result.",
+	}, []string{"result"})
+
+	// TLSReloads counts reloads of the serving certificate and client CA by
+	// result (success, failure).
+	TLSReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tls_reloads_total",
+		Help:      "Number of reloads of the serving certificate and client CA by result.",
+	}, []string{"result"})
+
+	// DecisionCacheRequests counts lookups in the decision cache by result (hit, miss).
+	DecisionCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decision_cache_requests_total",
+		Help:      "Number of decision cache lookups by result.",
+	}, []string{"result"})
+
+	// UnsupportedRequests counts requests for resources other than services
+	// by resource and the action taken (warn, allow, deny). They hint at
+	// webhook rules matching more than they should.
+	UnsupportedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unsupported_requests_total",
+		Help:      "Number of requests for unsupported resources by resource and action.",
+	}, []string{"resource", "action"})
+
+	// TerminatingNamespaceRequests counts services created in terminating
+	// namespaces which were admitted without looking up holders.
+	TerminatingNamespaceRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "terminating_namespace_requests_total",
+		Help:      "Number of services created in terminating namespaces admitted without validation.",
+	})
+
+	// SelfRequests counts requests made by the controller itself, which are
+	// admitted without validation, by resource.
+	SelfRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "self_requests_total",
+		Help:      "Number of requests made by the controller itself by resource.",
+	}, []string{"resource"})
+
+	// ValueFilterLookups counts lookups in the per-annotation value filters
+	// by result (unused, hit, false_positive). A false positive is a hit
+	// the precise lookup found no object for.
+	ValueFilterLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "value_filter_lookups_total",
+		Help:      "Number of value filter lookups by annotation and result.",
+	}, []string{"annotation", "result"})
+
+	// ValueFilterFalsePositiveRate is the false positive rate of the value
+	// filter of each annotation, estimated from the share of bits set.
+	ValueFilterFalsePositiveRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "value_filter_estimated_false_positive_rate",
+		Help:      "Estimated false positive rate of the value filter by annotation.",
+	}, []string{"annotation"})
+
+	// Claims counts claims exchanged with other replicas by result
+	// (published, failed, received).
+	Claims = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "claims_total",
+		Help:      "Number of claims exchanged with other replicas by result.",
+	}, []string{"result"})
+
+	// ProbeRounds counts consistency probes by result (agree, disagree, error).
+	ProbeRounds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consistency_probe_rounds_total",
+		Help:      "Number of consistency probes by result.",
+	}, []string{"result"})
+
+	// ProbeDisagreeingReplicas is the number of other replicas which answered
+	// the canary review of the last consistency probe differently.
+	ProbeDisagreeingReplicas = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consistency_probe_disagreeing_replicas",
+		Help:      "Number of replicas disagreeing with this one in the last consistency probe.",
+	})
+
+	// ConfigReloads counts configuration reloads by result (success, failure).
+	ConfigReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of configuration reloads by result.",
+	}, []string{"result"})
+
+	// ReplayFlips is the number of recorded reviews whose verdict flipped
+	// when replayed against the last configuration change.
+	ReplayFlips = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_replay_flips",
+		Help:      "Number of recorded reviews whose verdict flipped when replayed against the last configuration change.",
+	})
+
+	// MirroredReviews counts reviews mirrored to a shadow deployment by
+	// result (sent, dropped, failed).
+	MirroredReviews = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mirrored_reviews_total",
+		Help:      "Number of reviews mirrored to a shadow deployment by result.",
+	}, []string{"result"})
+
+	// ShadowComparisons counts mirrored reviews decided again in shadow mode
+	// by result (agreed, disagreed).
+	ShadowComparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shadow_comparisons_total",
+		Help:      "Number of mirrored reviews decided again in shadow mode by result.",
+	}, []string{"result"})
+
+	// ProtectedAnnotations is the number of protected annotations over all scopes.
+	ProtectedAnnotations = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "protected_annotations",
+		Help:      "Number of protected annotations in the configuration in effect.",
+	})
+
+	// ConflictingWebhooks is 1 for each webhook of another configuration
+	// found at startup to validate services on the same operations. They
+	// cause requests to be denied twice.
+	ConflictingWebhooks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "conflicting_webhooks",
+		Help:      "Webhooks of other configurations validating services on the same operations, by configuration, webhook and whether they look like another release of the controller.",
+	}, []string{"configuration", "webhook", "duplicate"})
+
+	// WebhookTimeoutSkew is by how many seconds the internal deadlines of a
+	// review exceed the timeoutSeconds of the webhook. Positive values mean
+	// slow reviews are answered by the failurePolicy instead.
+	WebhookTimeoutSkew = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_timeout_skew_seconds",
+		Help:      "Seconds the internal deadlines of a review exceed the timeoutSeconds of the webhook by configuration and webhook.",
+	}, []string{"configuration", "webhook"})
+
+	// PoolValues is the number of used and free values of annotation pools
+	// as observed by the last scan.
+	PoolValues = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_values",
+		Help:      "Number of values of annotation pools by annotation, scope and state (used, free).",
+	}, []string{"annotation", "scope", "state"})
+
+	// IndexConfirmations counts holders found in the informer cache which
+	// were confirmed with the apiserver before denying, by result
+	// (confirmed, stale).
+	IndexConfirmations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "index_confirmations_total",
+		Help:      "Number of holders found in the informer cache and confirmed with the apiserver before denying, by result.",
+	}, []string{"result"})
+
+	// IndexChecks counts services compared between the informer and the
+	// apiserver by result (consistent, divergent).
+	IndexChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "index_checks_total",
+		Help:      "Number of services compared between the informer and the apiserver by result.",
+	}, []string{"result"})
+
+	// IndexDivergence is the fraction of divergent services in the last
+	// consistency check.
+	IndexDivergence = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "index_divergence_ratio",
+		Help:      "Fraction of sampled services which diverged from the apiserver in the last consistency check.",
+	})
+
+	// Reindexes counts reindexes by trigger.
+	Reindexes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reindexes_total",
+		Help:      "Number of reindexes by trigger.",
+	}, []string{"trigger"})
+
+	// StateUpdates counts attempts to publish the UnikControllerState by
+	// result (updated, unchanged, error).
+	StateUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "state_updates_total",
+		Help:      "Number of attempts to publish the controller state by result.",
+	}, []string{"result"})
+
+	// FeatureEnabled is 1 for enabled feature gates and 0 for disabled ones.
+	FeatureEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "feature_enabled",
+		Help:      "Whether a feature gate is enabled (1) or disabled (0) by name and stage.",
+	}, []string{"name", "stage"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Decisions,
+		SLOReviews,
+		DegradedDecisions,
+		HTTPRequestDuration,
+		InFlightRequests,
+		ShutdownRequests,
+		OverloadedRequests,
+		DecisionCacheRequests,
+		UnsupportedRequests,
+		SelfRequests,
+		TerminatingNamespaceRequests,
+		ValueFilterLookups,
+		ValueFilterFalsePositiveRate,
+		Claims,
+		ProbeRounds,
+		ProbeDisagreeingReplicas,
+		ClientRebuilds,
+		TLSReloads,
+		ConfigReloads,
+		ReplayFlips,
+		MirroredReviews,
+		ShadowComparisons,
+		ProtectedAnnotations,
+		PoolValues,
+		ConflictingWebhooks,
+		WebhookTimeoutSkew,
+		IndexConfirmations,
+		IndexChecks,
+		IndexDivergence,
+		Reindexes,
+		FeatureEnabled,
+		StateUpdates,
+	)
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
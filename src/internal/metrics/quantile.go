@@ -0,0 +1,89 @@
+/*
+ *     quantile.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// RequestLatency estimates the q-quantile of the latency of the requests
+// observed for server in HTTPRequestDuration so far, over all codes and
+// methods. Like histogram_quantile, it returns the upper bound of the
+// bucket the quantile falls into, or the largest bound if it lies beyond
+// all buckets. The result is false if no request was observed.
+func RequestLatency(server string, q float64) (time.Duration, bool) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		HTTPRequestDuration.Collect(ch)
+		close(ch)
+	}()
+
+	var total uint64
+	buckets := make(map[float64]uint64)
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil || !hasLabel(&metric, "server", server) {
+			continue
+		}
+		h := metric.GetHistogram()
+		total += h.GetSampleCount()
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+
+	bounds := make([]float64, 0, len(buckets))
+	for bound := range buckets {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+	rank := uint64(math.Ceil(q * float64(total)))
+	for _, bound := range bounds {
+		if buckets[bound] >= rank && !math.IsInf(bound, 1) {
+			return time.Duration(bound * float64(time.Second)), true
+		}
+	}
+	// Client libraries omit the +Inf bucket, so the quantile lies beyond
+	// the largest bound here.
+	var largest float64
+	for _, bound := range bounds {
+		if !math.IsInf(bound, 1) {
+			largest = bound
+		}
+	}
+	return time.Duration(largest * float64(time.Second)), true
+}
+
+func hasLabel(m *dto.Metric, name, value string) bool {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name && l.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
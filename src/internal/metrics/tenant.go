@@ -0,0 +1,66 @@
+/*
+ *     tenant.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	// TenantRegistry holds the collectors labelled by namespace. They are
+	// not exposed with the other collectors, as the number of namespaces is
+	// unbounded, but served to tenants filtered by namespace instead.
+	TenantRegistry = prometheus.NewRegistry()
+
+	// NamespaceDecisions counts the decisions of the validator by namespace
+	// of the reviewed object, policy domain and reason.
+	NamespaceDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "namespace_decisions_total",
+		Help:      "Number of admission decisions by namespace, policy domain and reason.",
+	}, []string{"namespace", "domain", "reason"})
+)
+
+func init() {
+	TenantRegistry.MustRegister(NamespaceDecisions)
+}
+
+// GatherNamespace returns the metrics of TenantRegistry belonging to namespace.
+func GatherNamespace(namespace string) ([]*dto.MetricFamily, error) {
+	families, err := TenantRegistry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	filtered := families[:0]
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		for _, metric := range family.Metric {
+			if hasLabel(metric, "namespace", namespace) {
+				metrics = append(metrics, metric)
+			}
+		}
+		if len(metrics) > 0 {
+			family.Metric = metrics
+			filtered = append(filtered, family)
+		}
+	}
+	return filtered, nil
+}
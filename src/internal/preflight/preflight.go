@@ -0,0 +1,109 @@
+/*
+ *     preflight.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package preflight verifies that the webhook has been granted all
+// permissions it needs, so missing RBAC rules show up as a failing
+// readiness check instead of as errors ignored at runtime.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Permission is an action the webhook needs to be allowed to perform.
+type Permission authorizationv1.ResourceAttributes
+
+// String describes p like "update configmaps/unik-report in namespace unik".
+func (p Permission) String() string {
+	var b strings.Builder
+	b.WriteString(p.Verb + " " + p.Resource)
+	if p.Group != "" {
+		b.WriteString("." + p.Group)
+	}
+	if p.Subresource != "" {
+		b.WriteString("/" + p.Subresource)
+	}
+	if p.Name != "" {
+		b.WriteString("/" + p.Name)
+	}
+	if p.Namespace != "" {
+		b.WriteString(" in namespace " + p.Namespace)
+	}
+	return b.String()
+}
+
+// Preflight checks a fixed set of permissions of the webhook's own service
+// account using SelfSubjectAccessReviews.
+type Preflight struct {
+	clientset kubernetes.Interface
+	required  []Permission
+	// passed is set once all permissions were granted, so that readiness
+	// probes do not keep creating reviews afterwards.
+	passed atomic.Bool
+}
+
+func New(clientset kubernetes.Interface, required ...Permission) *Preflight {
+	return &Preflight{clientset: clientset, required: required}
+}
+
+// Missing returns the required permissions which are not granted.
+func (p *Preflight) Missing(ctx context.Context) ([]Permission, error) {
+	var missing []Permission
+	for _, perm := range p.required {
+		attrs := authorizationv1.ResourceAttributes(perm)
+		review, err := p.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attrs},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("reviewing access to %s: %w", perm, err)
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, perm)
+		}
+	}
+	return missing, nil
+}
+
+// Healthy returns an error naming all missing permissions. Once all were
+// granted, the result is kept. It can be used as health.Check.
+func (p *Preflight) Healthy(ctx context.Context) error {
+	if p.passed.Load() {
+		return nil
+	}
+	missing, err := p.Missing(ctx)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		names := make([]string, len(missing))
+		for i, perm := range missing {
+			names[i] = perm.String()
+		}
+		return fmt.Errorf("missing permissions: %s", strings.Join(names, ", "))
+	}
+	p.passed.Store(true)
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+ *     preflight_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package preflight
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestPreflight(t *testing.T) {
+	granted := map[string]bool{"list": true}
+	tc := testclient.NewSimpleClientset()
+	tc.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = granted[review.Spec.ResourceAttributes.Verb]
+		return true, review, nil
+	})
+
+	p := New(tc,
+		Permission{Verb: "list", Resource: "services"},
+		Permission{Verb: "update", Resource: "configmaps", Namespace: "unik", Name: "unik-report"})
+
+	missing, err := p.Missing(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Permission{{Verb: "update", Resource: "configmaps", Namespace: "unik", Name: "unik-report"}}, missing)
+	assert.EqualError(t, p.Healthy(context.Background()), "missing permissions: update configmaps/unik-report in namespace unik")
+
+	granted["update"] = true
+	assert.NoError(t, p.Healthy(context.Background()))
+	granted["update"] = false
+	assert.NoError(t, p.Healthy(context.Background()), "passed preflight is kept")
+}
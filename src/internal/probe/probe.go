@@ -0,0 +1,316 @@
+/*
+ *     probe.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package probe detects replicas which decide differently on the same
+// request. Each replica periodically evaluates a synthetic canary review
+// and publishes a digest of its answer in a Lease. Replicas compare their
+// digest with those of the others, so diverging configurations or caches
+// in HA deployments are noticed before they cause inconsistent decisions.
+package probe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/clock"
+)
+
+// LeaseLabel marks the Leases through which replicas exchange digests.
+const LeaseLabel = "unik.io/consistency-probe"
+
+// DigestAnnotation holds the digest of the last answer of a replica.
+const DigestAnnotation = "unik.io/probe-digest"
+
+// Canary returns the review evaluated by each replica. All replicas must
+// build the same review from the same configuration.
+type Canary func() admissionv1.AdmissionReview
+
+// Result describes a single probe.
+type Result struct {
+	Digest string `json:"digest"`
+	// Peers maps the identity of each other replica which probed recently
+	// to its digest.
+	Peers map[string]string `json:"peers"`
+	// Disagreeing lists the replicas whose digest differs, sorted.
+	Disagreeing []string `json:"disagreeing"`
+}
+
+type Prober struct {
+	clientset kubernetes.Interface
+	validator validator.ValidationHandlerV1
+	canary    Canary
+	namespace string
+	identity  string
+	logger    *zap.Logger
+	clock     clock.WithTicker
+	interval  time.Duration
+}
+
+type ProberOption func(*Prober) error
+
+func WithLogger(logger *zap.Logger) ProberOption {
+	return func(p *Prober) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		p.logger = logger
+		return nil
+	}
+}
+
+func WithClientset(clientset kubernetes.Interface) ProberOption {
+	return func(p *Prober) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		p.clientset = clientset
+		return nil
+	}
+}
+
+// WithValidator sets the validator evaluating canary, which the probe
+// runs against.
+func WithValidator(v validator.ValidationHandlerV1, canary Canary) ProberOption {
+	return func(p *Prober) error {
+		if v == nil {
+			return errors.New("validator is nil")
+		}
+		if canary == nil {
+			return errors.New("canary is nil")
+		}
+		p.validator = v
+		p.canary = canary
+		return nil
+	}
+}
+
+// WithIdentity sets the namespace of the Leases and the identity of this
+// replica, usually the name of its pod.
+func WithIdentity(namespace, identity string) ProberOption {
+	return func(p *Prober) error {
+		if namespace == "" {
+			return errors.New("namespace is empty")
+		}
+		if identity == "" {
+			return errors.New("identity is empty")
+		}
+		p.namespace = namespace
+		p.identity = identity
+		return nil
+	}
+}
+
+// WithInterval sets the time between two probes. Replicas which did not
+// probe for twice the interval are no longer compared with.
+func WithInterval(interval time.Duration) ProberOption {
+	return func(p *Prober) error {
+		if interval < time.Second {
+			return errors.New("interval must be at least one second")
+		}
+		p.interval = interval
+		return nil
+	}
+}
+
+// WithClock sets the clock triggering probes. Defaults to the real clock.
+func WithClock(c clock.WithTicker) ProberOption {
+	return func(p *Prober) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		p.clock = c
+		return nil
+	}
+}
+
+func NewProber(options ...ProberOption) (*Prober, error) {
+	p := &Prober{
+		logger:   zap.NewNop(),
+		clock:    clock.RealClock{},
+		interval: time.Minute,
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	if p.clientset == nil {
+		return nil, errors.New("clientset is required")
+	}
+	if p.validator == nil {
+		return nil, errors.New("validator is required")
+	}
+	if p.identity == "" {
+		return nil, errors.New("identity is required")
+	}
+	return p, nil
+}
+
+// Run probes every interval until ctx is done, then removes the Lease of
+// this replica.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := p.clock.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.withdraw()
+			return
+		case <-ticker.C():
+		}
+		p.runOnce(ctx)
+	}
+}
+
+func (p *Prober) runOnce(ctx context.Context) {
+	result, err := p.Probe(ctx)
+	if err != nil {
+		metrics.ProbeRounds.WithLabelValues("error").Inc()
+		p.logger.Error("Consistency probe failed", zap.Error(err))
+		return
+	}
+	metrics.ProbeDisagreeingReplicas.Set(float64(len(result.Disagreeing)))
+	if len(result.Disagreeing) == 0 {
+		metrics.ProbeRounds.WithLabelValues("agree").Inc()
+		p.logger.Debug("Replicas agree", zap.String("digest", result.Digest), zap.Int("peers", len(result.Peers)))
+		return
+	}
+	metrics.ProbeRounds.WithLabelValues("disagree").Inc()
+	p.logger.Warn("Replicas disagree on canary review", zap.String("digest", result.Digest), zap.Strings("disagreeing", result.Disagreeing))
+}
+
+// Probe evaluates the canary, publishes the digest of the answer and
+// compares it with the digests of the other replicas.
+func (p *Prober) Probe(ctx context.Context) (Result, error) {
+	result := Result{
+		Digest: Digest(p.validator.Validate(ctx, p.canary())),
+		Peers:  make(map[string]string),
+	}
+	if err := p.publish(ctx, result.Digest); err != nil {
+		return result, fmt.Errorf("publishing digest: %w", err)
+	}
+
+	leases, err := p.clientset.CoordinationV1().Leases(p.namespace).List(ctx, metav1.ListOptions{LabelSelector: LeaseLabel})
+	if err != nil {
+		return result, fmt.Errorf("listing digests: %w", err)
+	}
+	now := p.clock.Now()
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == p.identity {
+			continue
+		}
+		if expired(lease, now) {
+			continue
+		}
+		peer, digest := *lease.Spec.HolderIdentity, lease.Annotations[DigestAnnotation]
+		result.Peers[peer] = digest
+		if digest != result.Digest {
+			result.Disagreeing = append(result.Disagreeing, peer)
+		}
+	}
+	sort.Strings(result.Disagreeing)
+	return result, nil
+}
+
+// Digest identifies the decision made in resp. Only the verdict and its
+// reason are taken into account, as messages may legitimately differ,
+// for example once denials are escalated.
+func Digest(resp *admissionv1.AdmissionResponse) string {
+	var code int32
+	if resp.Result != nil {
+		code = resp.Result.Code
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%t\x00%d\x00%s", resp.Allowed, code, resp.AuditAnnotations[response.AuditAnnotationReason])
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// expired reports whether the replica holding lease stopped probing.
+func expired(lease coordinationv1.Lease, now time.Time) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (p *Prober) leaseName() string {
+	return "unik-probe-" + p.identity
+}
+
+// publish records digest in the Lease of this replica.
+func (p *Prober) publish(ctx context.Context, digest string) error {
+	leases := p.clientset.CoordinationV1().Leases(p.namespace)
+	now := metav1.NewMicroTime(p.clock.Now())
+	duration := int32(2 * p.interval / time.Second)
+
+	lease, err := leases.Get(ctx, p.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        p.leaseName(),
+				Labels:      map[string]string{LeaseLabel: strconv.FormatBool(true)},
+				Annotations: map[string]string{DigestAnnotation: digest},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &p.identity,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{FieldManager: validator.FieldManager})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[DigestAnnotation] = digest
+	lease.Spec.HolderIdentity = &p.identity
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{FieldManager: validator.FieldManager})
+	return err
+}
+
+// withdraw deletes the Lease of this replica, so it is not compared with
+// after shutting down.
+func (p *Prober) withdraw() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := p.clientset.CoordinationV1().Leases(p.namespace).Delete(ctx, p.leaseName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		p.logger.Warn("Failed to delete probe lease", zap.Error(err))
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ *     probe_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package probe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap/zaptest"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// fixed answers every review with resp.
+type fixed struct {
+	resp *admissionv1.AdmissionResponse
+}
+
+func (f *fixed) ValidateBytes(context.Context, []byte) (*admissionv1.AdmissionReview, error) {
+	return response.Review(f.resp), nil
+}
+
+func (f *fixed) Validate(context.Context, admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	return f.resp
+}
+
+func canary() admissionv1.AdmissionReview {
+	return admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{UID: "canary"}}
+}
+
+func TestProbe(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	clk := testingclock.NewFakeClock(time.Now())
+	replicas := map[string]*fixed{
+		"a": {resp: response.Allowed("", response.ReasonUnique)},
+		"b": {resp: response.Allowed("", response.ReasonUnique)},
+		"c": {resp: response.Denied("", response.ReasonConflict, "taken")},
+	}
+	probers := make(map[string]*Prober)
+	for identity, v := range replicas {
+		p, err := NewProber(
+			WithLogger(zaptest.NewLogger(t)),
+			WithClientset(tc),
+			WithValidator(v, canary),
+			WithIdentity("unik", identity),
+			WithInterval(time.Minute),
+			WithClock(clk))
+		require.NoError(t, err)
+		probers[identity] = p
+	}
+
+	ctx := context.Background()
+	for _, identity := range []string{"a", "b", "c"} {
+		_, err := probers[identity].Probe(ctx)
+		require.NoError(t, err)
+	}
+
+	result, err := probers["a"].Probe(ctx)
+	require.NoError(t, err)
+	assert.Len(t, result.Peers, 2)
+	assert.Equal(t, []string{"c"}, result.Disagreeing)
+
+	// Replicas which stopped probing are no longer compared with.
+	clk.Step(2*time.Minute + time.Second)
+	_, err = probers["b"].Probe(ctx)
+	require.NoError(t, err)
+	result, err = probers["a"].Probe(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"b": result.Digest}, result.Peers)
+	assert.Empty(t, result.Disagreeing)
+
+	probers["a"].withdraw()
+	leases, err := tc.CoordinationV1().Leases("unik").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, leases.Items, 2)
+}
+
+func TestDigest(t *testing.T) {
+	escalated := response.Denied("other", response.ReasonConflict, "taken; contact your admin")
+	assert.Equal(t, Digest(response.Denied("uid", response.ReasonConflict, "taken")), Digest(escalated))
+	assert.NotEqual(t, Digest(response.Allowed("uid", response.ReasonUnique)), Digest(response.Allowed("uid", response.ReasonNotPresent)))
+}
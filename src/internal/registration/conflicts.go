@@ -0,0 +1,98 @@
+/*
+ *     conflicts.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package registration
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Conflict is a webhook of another ValidatingWebhookConfiguration which
+// validates services on some of the operations this webhook does. Both
+// webhooks see the same requests, so a conflicting release of this
+// controller denies them twice, with diverging messages during upgrades.
+type Conflict struct {
+	Configuration string
+	Webhook       string
+	// Operations lists the operations validated by both webhooks.
+	Operations []admissionregistrationv1.OperationType
+	// Duplicate is set for webhooks named like this one, or whose name or
+	// configuration mentions unik, which most likely are another release
+	// of this controller.
+	Duplicate bool
+}
+
+// FindConflicts returns the webhooks validating services on any of
+// operations, except for the webhook named webhook in configuration. If
+// configuration is empty, all webhooks named webhook are taken for this
+// one.
+func FindConflicts(ctx context.Context, clientset kubernetes.Interface, configuration, webhook string, operations []admissionregistrationv1.OperationType) ([]Conflict, error) {
+	list, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing webhook configurations: %w", err)
+	}
+	var conflicts []Conflict
+	for _, vwc := range list.Items {
+		for _, w := range vwc.Webhooks {
+			if w.Name == webhook && (configuration == "" || vwc.Name == configuration) {
+				continue
+			}
+			var overlap []admissionregistrationv1.OperationType
+			for _, rule := range w.Rules {
+				if !matchesServices(rule.Rule) {
+					continue
+				}
+				for _, op := range operations {
+					if !slices.Contains(overlap, op) && (slices.Contains(rule.Operations, op) || slices.Contains(rule.Operations, admissionregistrationv1.OperationAll)) {
+						overlap = append(overlap, op)
+					}
+				}
+			}
+			if len(overlap) == 0 {
+				continue
+			}
+			conflicts = append(conflicts, Conflict{
+				Configuration: vwc.Name,
+				Webhook:       w.Name,
+				Operations:    overlap,
+				Duplicate:     w.Name == webhook || strings.Contains(w.Name, "unik") || strings.Contains(vwc.Name, "unik"),
+			})
+		}
+	}
+	return conflicts, nil
+}
+
+// matchesServices reports whether rule matches services of the core API group.
+func matchesServices(rule admissionregistrationv1.Rule) bool {
+	matches := func(values []string, value string) bool {
+		return slices.Contains(values, value) || slices.Contains(values, "*")
+	}
+	if rule.Scope != nil && *rule.Scope == admissionregistrationv1.ClusterScope {
+		return false
+	}
+	return matches(rule.APIGroups, "") && matches(rule.APIVersions, "v1") &&
+		(matches(rule.Resources, "services") || slices.Contains(rule.Resources, "*/*"))
+}
@@ -0,0 +1,66 @@
+/*
+ *     conflicts_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package registration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindConflicts(t *testing.T) {
+	const (
+		create = admissionregistrationv1.Create
+		update = admissionregistrationv1.Update
+	)
+	webhook := func(name string, resources []string, operations ...admissionregistrationv1.OperationType) admissionregistrationv1.ValidatingWebhook {
+		return admissionregistrationv1.ValidatingWebhook{Name: name, Rules: []admissionregistrationv1.RuleWithOperations{{
+			Operations: operations,
+			Rule:       admissionregistrationv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: resources},
+		}}}
+	}
+	configuration := func(name string, webhooks ...admissionregistrationv1.ValidatingWebhook) *admissionregistrationv1.ValidatingWebhookConfiguration {
+		return &admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: name}, Webhooks: webhooks}
+	}
+	tc := testclient.NewSimpleClientset(
+		configuration("unik-admission-controller", webhook("unik-k8s.github.com", []string{"services"}, create, update)),
+		configuration("unik-legacy", webhook("unik-k8s.github.com", []string{"services"}, admissionregistrationv1.OperationAll)),
+		configuration("policy-engine",
+			webhook("policies.example.com", []string{"*"}, update),
+			webhook("pods.example.com", []string{"pods"}, create, update)),
+		configuration("deletions", webhook("deletions.example.com", []string{"services"}, admissionregistrationv1.Delete)),
+	)
+
+	conflicts, err := FindConflicts(context.Background(), tc, "unik-admission-controller", "unik-k8s.github.com", []admissionregistrationv1.OperationType{create, update})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Conflict{
+		{Configuration: "unik-legacy", Webhook: "unik-k8s.github.com", Operations: []admissionregistrationv1.OperationType{create, update}, Duplicate: true},
+		{Configuration: "policy-engine", Webhook: "policies.example.com", Operations: []admissionregistrationv1.OperationType{update}},
+	}, conflicts)
+
+	conflicts, err = FindConflicts(context.Background(), tc, "", "unik-k8s.github.com", []admissionregistrationv1.OperationType{create, update})
+	require.NoError(t, err)
+	assert.Len(t, conflicts, 1, "without a configuration name, all webhooks of the same name are taken for this one")
+}
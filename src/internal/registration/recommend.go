@@ -0,0 +1,118 @@
+/*
+ *     recommend.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package registration
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Criticality states how important it is to enforce the protected
+// annotations compared to keeping services deployable while the webhook
+// is unavailable.
+type Criticality string
+
+const (
+	// CriticalityStrict rejects requests the webhook could not check.
+	CriticalityStrict Criticality = "strict"
+	// CriticalityBestEffort admits requests the webhook could not check.
+	CriticalityBestEffort Criticality = "best-effort"
+)
+
+// ParseCriticality parses the value of a command line flag.
+func ParseCriticality(s string) (Criticality, error) {
+	switch c := Criticality(s); c {
+	case CriticalityStrict, CriticalityBestEffort:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown criticality %q", s)
+	}
+}
+
+// maxTimeoutSeconds is the largest timeout the apiserver accepts.
+const maxTimeoutSeconds = 30
+
+// Recommendation holds the webhook settings the controller is tuned for.
+type Recommendation struct {
+	FailurePolicy  admissionregistrationv1.FailurePolicyType `json:"failurePolicy"`
+	TimeoutSeconds int32                                     `json:"timeoutSeconds"`
+}
+
+// Recommend derives the settings for services from criticality and the
+// observed latency of the webhook. The timeout leaves room for twice the
+// latency and for at least one apiserver call of the validator taking
+// apiTimeout. A latency of 0 means none was observed yet.
+func Recommend(criticality Criticality, latency, apiTimeout time.Duration) Recommendation {
+	policy := admissionregistrationv1.Fail
+	if criticality == CriticalityBestEffort {
+		policy = admissionregistrationv1.Ignore
+	}
+	timeout := max(2*latency, apiTimeout+time.Second)
+	seconds := int32(math.Ceil(timeout.Seconds()))
+	return Recommendation{FailurePolicy: policy, TimeoutSeconds: min(max(seconds, 1), maxTimeoutSeconds)}
+}
+
+// Divergences describes how webhook differs from r.
+func (r Recommendation) Divergences(webhook admissionregistrationv1.ValidatingWebhook) []string {
+	// The defaults of the apiserver apply to unset fields.
+	policy, timeout := admissionregistrationv1.Fail, timeoutOf(webhook)
+	if webhook.FailurePolicy != nil {
+		policy = *webhook.FailurePolicy
+	}
+
+	var divergences []string
+	if policy != r.FailurePolicy {
+		divergences = append(divergences, fmt.Sprintf("failurePolicy is %s instead of %s", policy, r.FailurePolicy))
+	}
+	if timeout < r.TimeoutSeconds {
+		divergences = append(divergences, fmt.Sprintf("timeoutSeconds is %d, below the recommended %d", timeout, r.TimeoutSeconds))
+	}
+	return divergences
+}
+
+// CheckAlignment logs a warning for every setting of the registered
+// webhook diverging from rec and returns them. The webhook is not changed,
+// as its failurePolicy and timeout are decisions of the operator.
+func (r *Registrar) CheckAlignment(ctx context.Context, rec Recommendation) ([]string, error) {
+	vwc, err := r.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, r.configuration, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting webhook configuration %s: %w", r.configuration, err)
+	}
+	for _, webhook := range vwc.Webhooks {
+		if webhook.Name != r.webhook {
+			continue
+		}
+		divergences := rec.Divergences(webhook)
+		for _, d := range divergences {
+			r.logger.Warn("Webhook settings diverge from recommendation",
+				zap.String("configuration", r.configuration),
+				zap.String("webhook", r.webhook),
+				zap.String("divergence", d))
+		}
+		return divergences, nil
+	}
+	return nil, fmt.Errorf("webhook %s not found in configuration %s", r.webhook, r.configuration)
+}
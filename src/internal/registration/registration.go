@@ -0,0 +1,164 @@
+/*
+ *     registration.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package registration keeps the ValidatingWebhookConfiguration of the
+// webhook in line with the protected annotations, so the apiserver only
+// calls the webhook for requests it actually validates.
+package registration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Registrar updates a webhook of an existing ValidatingWebhookConfiguration.
+// The configuration itself, including the clientConfig and CA bundle, is
+// still deployed with the webhook; only the rules and namespaceSelector are
+// computed. The objectSelector is left alone, as protected annotations
+// cannot be expressed as label selectors.
+type Registrar struct {
+	clientset     kubernetes.Interface
+	logger        *zap.Logger
+	configuration string
+	webhook       string
+
+	// failure holds the error of the last registration, if it failed.
+	failure atomic.Pointer[error]
+}
+
+type RegistrarOption func(*Registrar) error
+
+func WithLogger(logger *zap.Logger) RegistrarOption {
+	return func(r *Registrar) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		r.logger = logger
+		return nil
+	}
+}
+
+func WithClientset(clientset kubernetes.Interface) RegistrarOption {
+	return func(r *Registrar) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		r.clientset = clientset
+		return nil
+	}
+}
+
+// WithWebhook sets the name of the ValidatingWebhookConfiguration and of
+// the webhook within it to update.
+func WithWebhook(configuration, webhook string) RegistrarOption {
+	return func(r *Registrar) error {
+		if configuration == "" || webhook == "" {
+			return errors.New("configuration and webhook names must not be empty")
+		}
+		r.configuration = configuration
+		r.webhook = webhook
+		return nil
+	}
+}
+
+func NewRegistrar(options ...RegistrarOption) (*Registrar, error) {
+	r := &Registrar{logger: zap.NewNop()}
+	for _, option := range options {
+		if err := option(r); err != nil {
+			return nil, err
+		}
+	}
+	if r.clientset == nil {
+		return nil, errors.New("clientset is required")
+	}
+	if r.configuration == "" {
+		return nil, errors.New("webhook is required")
+	}
+	return r, nil
+}
+
+// Register updates the webhook to receive exactly the requests protected
+// has checks for. The configuration is only written if it changes.
+func (r *Registrar) Register(ctx context.Context, protected validator.UniqueList) error {
+	err := r.register(ctx, protected)
+	if err != nil {
+		r.failure.Store(&err)
+	} else {
+		r.failure.Store(nil)
+	}
+	return err
+}
+
+// Healthy returns the error of the last registration, if it failed.
+// It can be used as health.Check.
+func (r *Registrar) Healthy(context.Context) error {
+	if err := r.failure.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (r *Registrar) register(ctx context.Context, protected validator.UniqueList) error {
+	rules := protected.WebhookRules()
+	namespaceSelector := protected.WebhookNamespaceSelector()
+	if namespaceSelector == nil {
+		// The apiserver defaults a missing selector to the empty one.
+		namespaceSelector = &metav1.LabelSelector{}
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vwc, err := r.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, r.configuration, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("getting webhook configuration %s: %w", r.configuration, err)
+		}
+
+		for i := range vwc.Webhooks {
+			webhook := &vwc.Webhooks[i]
+			if webhook.Name != r.webhook {
+				continue
+			}
+			if equality.Semantic.DeepEqual(webhook.Rules, rules) &&
+				equality.Semantic.DeepEqual(webhook.NamespaceSelector, namespaceSelector) {
+				r.logger.Debug("Webhook is up to date", zap.String("configuration", r.configuration))
+				return nil
+			}
+			webhook.Rules = rules
+			webhook.NamespaceSelector = namespaceSelector
+			if _, err := r.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(ctx, vwc, metav1.UpdateOptions{FieldManager: validator.FieldManager}); err != nil {
+				return err
+			}
+			r.logger.Info("Updated webhook",
+				zap.String("configuration", r.configuration),
+				zap.String("webhook", r.webhook),
+				zap.Any("rules", rules),
+				zap.Any("namespaceSelector", namespaceSelector))
+			return nil
+		}
+		return fmt.Errorf("webhook %s not found in configuration %s", r.webhook, r.configuration)
+	})
+}
@@ -0,0 +1,172 @@
+/*
+ *     registration_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package registration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap/zaptest"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRegister(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		protected  validator.UniqueList
+		operations []admissionregistrationv1.OperationType
+		namespaces []string
+	}{
+		{
+			desc:       "cluster scope",
+			protected:  validator.UniqueList{validator.ClusterScope: {{Key: "a"}}},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		},
+		{
+			desc: "namespaces, create only",
+			protected: validator.UniqueList{
+				"team-b": {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Create}}},
+				"team-a": {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Create}}},
+			},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			namespaces: []string{"team-a", "team-b"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			tc := testclient.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "unik"},
+				Webhooks: []admissionregistrationv1.ValidatingWebhook{
+					{Name: "other.example.com"},
+					{Name: "unik-k8s.github.com"},
+				},
+			})
+			r, err := NewRegistrar(WithLogger(zaptest.NewLogger(t)), WithClientset(tc), WithWebhook("unik", "unik-k8s.github.com"))
+			require.NoError(t, err)
+
+			require.NoError(t, r.Register(context.Background(), tC.protected))
+
+			vwc, err := tc.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), "unik", metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Empty(t, vwc.Webhooks[0].Rules, "other webhooks are left alone")
+
+			webhook := vwc.Webhooks[1]
+			require.Len(t, webhook.Rules, 1)
+			assert.Equal(t, tC.operations, webhook.Rules[0].Operations)
+			if tC.namespaces == nil {
+				assert.Empty(t, webhook.NamespaceSelector.MatchExpressions)
+			} else {
+				assert.Equal(t, tC.namespaces, webhook.NamespaceSelector.MatchExpressions[0].Values)
+			}
+		})
+	}
+}
+
+func TestRegisterMissingWebhook(t *testing.T) {
+	tc := testclient.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "unik"},
+	})
+	r, err := NewRegistrar(WithClientset(tc), WithWebhook("unik", "unik-k8s.github.com"))
+	require.NoError(t, err)
+
+	assert.Error(t, r.Register(context.Background(), validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}))
+}
+
+func TestRecommend(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		criticality Criticality
+		latency     time.Duration
+		expected    Recommendation
+	}{
+		{"nothing observed", CriticalityStrict, 0, Recommendation{admissionregistrationv1.Fail, 6}},
+		{"slow", CriticalityBestEffort, 4 * time.Second, Recommendation{admissionregistrationv1.Ignore, 8}},
+		{"very slow", CriticalityStrict, time.Minute, Recommendation{admissionregistrationv1.Fail, 30}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expected, Recommend(tC.criticality, tC.latency, 5*time.Second))
+		})
+	}
+}
+
+func TestCheckAlignment(t *testing.T) {
+	ignore, timeout := admissionregistrationv1.Ignore, int32(5)
+	tc := testclient.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "unik"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "unik-k8s.github.com", FailurePolicy: &ignore, TimeoutSeconds: &timeout},
+		},
+	})
+	r, err := NewRegistrar(WithLogger(zaptest.NewLogger(t)), WithClientset(tc), WithWebhook("unik", "unik-k8s.github.com"))
+	require.NoError(t, err)
+
+	divergences, err := r.CheckAlignment(context.Background(), Recommendation{FailurePolicy: admissionregistrationv1.Fail, TimeoutSeconds: 6})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"failurePolicy is Ignore instead of Fail",
+		"timeoutSeconds is 5, below the recommended 6",
+	}, divergences)
+
+	divergences, err = r.CheckAlignment(context.Background(), Recommendation{FailurePolicy: admissionregistrationv1.Ignore, TimeoutSeconds: 2})
+	require.NoError(t, err)
+	assert.Empty(t, divergences)
+}
+
+func timeoutSkew(t *testing.T, webhook string) float64 {
+	var m dto.Metric
+	require.NoError(t, metrics.WebhookTimeoutSkew.WithLabelValues("unik", webhook).Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func TestCheckTimeout(t *testing.T) {
+	timeout := int32(5)
+	tc := testclient.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "unik"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "unik-k8s.github.com", TimeoutSeconds: &timeout},
+			{Name: "defaulted.unik-k8s.github.com"},
+		},
+	})
+	r, err := NewRegistrar(WithLogger(zaptest.NewLogger(t)), WithClientset(tc), WithWebhook("unik", "unik-k8s.github.com"))
+	require.NoError(t, err)
+
+	skew, err := r.CheckTimeout(context.Background(), Budget{"queue-timeout", time.Second}, Budget{"api-timeout", 5 * time.Second})
+	require.NoError(t, err)
+	assert.True(t, skew.Exceeded())
+	assert.Equal(t, "queue-timeout 1s + api-timeout 5s = 6s, timeoutSeconds is 5s", skew.String())
+	assert.Equal(t, 1.0, timeoutSkew(t, "unik-k8s.github.com"))
+
+	r, err = NewRegistrar(WithLogger(zaptest.NewLogger(t)), WithClientset(tc), WithWebhook("unik", "defaulted.unik-k8s.github.com"))
+	require.NoError(t, err)
+	skew, err = r.CheckTimeout(context.Background(), Budget{"api-timeout", 5 * time.Second})
+	require.NoError(t, err)
+	assert.False(t, skew.Exceeded(), "the apiserver defaults to 10s")
+	assert.Equal(t, -5.0, timeoutSkew(t, "defaulted.unik-k8s.github.com"))
+}
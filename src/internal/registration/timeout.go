@@ -0,0 +1,106 @@
+/*
+ *     timeout.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package registration
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultTimeoutSeconds is the timeout the apiserver applies to webhooks
+// which do not set one.
+const defaultTimeoutSeconds = 10
+
+// Budget is an internal deadline the controller may spend on a review,
+// like the time waiting for a validation slot.
+type Budget struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Skew compares the internal budgets of a review with the timeout the
+// apiserver waits for the webhook.
+type Skew struct {
+	// Timeout is the timeoutSeconds of the webhook.
+	Timeout time.Duration
+	// Budget is the sum of Budgets, which a review may take in the worst case.
+	Budget  time.Duration
+	Budgets []Budget
+}
+
+// Exceeded reports whether a review may take longer than the apiserver
+// waits for it. Slow denials are then replaced by the failurePolicy.
+func (s Skew) Exceeded() bool {
+	return s.Budget > s.Timeout
+}
+
+// String describes the budgets like "queue-timeout 1s + api-timeout 5s =
+// 6s, timeoutSeconds is 5s".
+func (s Skew) String() string {
+	parts := make([]string, len(s.Budgets))
+	for i, b := range s.Budgets {
+		parts[i] = b.Name + " " + b.Duration.String()
+	}
+	return fmt.Sprintf("%s = %s, timeoutSeconds is %s", strings.Join(parts, " + "), s.Budget, s.Timeout)
+}
+
+// timeoutOf returns the timeout the apiserver applies to webhook.
+func timeoutOf(webhook admissionregistrationv1.ValidatingWebhook) int32 {
+	if webhook.TimeoutSeconds != nil {
+		return *webhook.TimeoutSeconds
+	}
+	return defaultTimeoutSeconds
+}
+
+// CheckTimeout compares the timeoutSeconds of the registered webhook with
+// budgets. If they exceed it, a warning is logged. The difference is
+// exported as metrics.WebhookTimeoutSkew either way. Like CheckAlignment,
+// it leaves the webhook alone.
+func (r *Registrar) CheckTimeout(ctx context.Context, budgets ...Budget) (Skew, error) {
+	vwc, err := r.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, r.configuration, metav1.GetOptions{})
+	if err != nil {
+		return Skew{}, fmt.Errorf("getting webhook configuration %s: %w", r.configuration, err)
+	}
+	for _, webhook := range vwc.Webhooks {
+		if webhook.Name != r.webhook {
+			continue
+		}
+		skew := Skew{Timeout: time.Duration(timeoutOf(webhook)) * time.Second, Budgets: budgets}
+		for _, b := range budgets {
+			skew.Budget += b.Duration
+		}
+		metrics.WebhookTimeoutSkew.WithLabelValues(r.configuration, r.webhook).Set((skew.Budget - skew.Timeout).Seconds())
+		if skew.Exceeded() {
+			r.logger.Warn("Internal deadlines exceed the timeout of the webhook; slow reviews are answered by the failurePolicy instead",
+				zap.String("configuration", r.configuration),
+				zap.String("webhook", r.webhook),
+				zap.Stringer("skew", skew))
+		}
+		return skew, nil
+	}
+	return Skew{}, fmt.Errorf("webhook %s not found in configuration %s", r.webhook, r.configuration)
+}
@@ -0,0 +1,120 @@
+/*
+ *     lease.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package scanner
+
+import (
+	"sort"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Lease reserves the value of an annotation with a lease for the service
+// which held it last. Each scan observing the service renews the lease.
+type Lease struct {
+	Annotation string      `json:"annotation"`
+	Scope      string      `json:"scope"`
+	Value      string      `json:"value"`
+	Holder     string      `json:"holder"`
+	Expires    metav1.Time `json:"expires"`
+}
+
+type leaseKey struct {
+	scope, annotation, value string
+}
+
+// LookupLease returns the holder of an unexpired lease on value.
+// It implements validator.LeaseLookup. Leases are only kept in memory,
+// so they are lost when the process restarts.
+func (s *Scanner) LookupLease(scope, annotation, value string) (string, time.Time, bool) {
+	s.leaseLock.Lock()
+	defer s.leaseLock.Unlock()
+	lease, found := s.leases[leaseKey{scope, annotation, value}]
+	if !found || s.clock.Now().After(lease.Expires.Time) {
+		return "", time.Time{}, false
+	}
+	return lease.Holder, lease.Expires.Time, true
+}
+
+// pruneLeases drops the leases of annotations which no longer have one.
+func (s *Scanner) pruneLeases(protected validator.UniqueList) {
+	s.leaseLock.Lock()
+	defer s.leaseLock.Unlock()
+	for key := range s.leases {
+		if a, found := validator.Resolve(protected[key.scope], key.annotation); !found || a.Lease == nil {
+			delete(s.leases, key)
+		}
+	}
+}
+
+// renewLeases renews the leases of all values of annotation found in scope
+// and expires the leases of values whose holder is gone for too long.
+// Leases whose holder is gone are added to report.
+func (s *Scanner) renewLeases(scope string, annotation validator.ProtectedAnnotation, byValue map[string][]corev1.Service, now time.Time, report *Report) {
+	s.leaseLock.Lock()
+	defer s.leaseLock.Unlock()
+
+	expires := metav1.NewTime(now.Add(annotation.Lease.Duration))
+	for value, holders := range byValue {
+		owner := validator.Owner(holders)
+		s.leases[leaseKey{scope, annotation.Key, value}] = Lease{
+			Annotation: annotation.Key,
+			Scope:      scope,
+			Value:      value,
+			Holder:     owner.Namespace + "/" + owner.Name,
+			Expires:    expires,
+		}
+	}
+
+	for key, lease := range s.leases {
+		if key.scope != scope || key.annotation != annotation.Key {
+			continue
+		}
+		if _, held := byValue[key.value]; held {
+			continue
+		}
+		if now.After(lease.Expires.Time) {
+			delete(s.leases, key)
+			s.logger.Info("Lease expired, value returned to pool",
+				zap.String("annotation", lease.Annotation), zap.String("scope", lease.Scope),
+				zap.String("value", lease.Value), zap.String("holder", lease.Holder))
+			report.ExpiredLeases = append(report.ExpiredLeases, lease)
+			continue
+		}
+		report.Leases = append(report.Leases, lease)
+	}
+}
+
+// sortLeases orders leases by scope, annotation and value.
+func sortLeases(leases []Lease) {
+	sort.Slice(leases, func(i, j int) bool {
+		a, b := leases[i], leases[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Annotation != b.Annotation {
+			return a.Annotation < b.Annotation
+		}
+		return a.Value < b.Value
+	})
+}
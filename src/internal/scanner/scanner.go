@@ -0,0 +1,402 @@
+/*
+ *     scanner.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package scanner periodically checks existing services for values of
+// protected annotations which are not unique, for example because they were
+// created before the webhook was deployed or while it was unavailable.
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/clock"
+)
+
+// ReportKey is the key of the ConfigMap data entry holding the JSON report.
+const ReportKey = "report.json"
+
+// Conflict describes services sharing the value of a protected annotation.
+type Conflict struct {
+	Annotation string `json:"annotation"`
+	Scope      string `json:"scope"`
+	Value      string `json:"value"`
+	// Owner is the legitimate holder of the value as determined by validator.Owner.
+	Owner string `json:"owner"`
+	// Duplicates are the holders exceeding the MaxHolders of the annotation,
+	// which are all holders but the owner by default, in ownership order.
+	Duplicates []string `json:"duplicates"`
+}
+
+// Pool describes the utilization of the Pool of an annotation in a scope.
+type Pool struct {
+	Annotation string `json:"annotation"`
+	Scope      string `json:"scope"`
+	validator.PoolUsage
+}
+
+// Report summarizes the result of a scan.
+type Report struct {
+	LastScan     metav1.Time    `json:"lastScan"`
+	Violations   int            `json:"violations"`
+	ByNamespace  map[string]int `json:"byNamespace"`
+	ByAnnotation map[string]int `json:"byAnnotation"`
+	Conflicts    []Conflict     `json:"conflicts"`
+	// Leases reserve values whose holders are gone.
+	Leases []Lease `json:"leases,omitempty"`
+	// ExpiredLeases returned their values to the pool during the scan.
+	ExpiredLeases []Lease `json:"expiredLeases,omitempty"`
+	// Pools holds the utilization of all annotations with a Pool.
+	Pools []Pool `json:"pools,omitempty"`
+}
+
+type Scanner struct {
+	clientset kubernetes.Interface
+	logger    *zap.Logger
+	protected atomic.Pointer[validator.UniqueList]
+	interval  time.Duration
+	clock     clock.WithTicker
+
+	reportNamespace string
+	reportName      string
+
+	// lock serializes scans triggered by Run and Rescan.
+	lock sync.Mutex
+
+	// failed is set while the most recent scan did not complete.
+	failed atomic.Bool
+
+	leaseLock sync.Mutex
+	leases    map[leaseKey]Lease
+}
+
+// Progress is reported by Rescan after each scope has been scanned.
+type Progress struct {
+	Scope string `json:"scope"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+type ScannerOption func(*Scanner) error
+
+func WithLogger(logger *zap.Logger) ScannerOption {
+	return func(s *Scanner) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		s.logger = logger
+		return nil
+	}
+}
+
+func WithClientset(clientset kubernetes.Interface) ScannerOption {
+	return func(s *Scanner) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		s.clientset = clientset
+		return nil
+	}
+}
+
+func WithUniqueList(list validator.UniqueList) ScannerOption {
+	return func(s *Scanner) error {
+		if list == nil {
+			return errors.New("unique list is nil")
+		}
+		s.protected.Store(&list)
+		return nil
+	}
+}
+
+// WithInterval sets the time between two scans.
+func WithInterval(interval time.Duration) ScannerOption {
+	return func(s *Scanner) error {
+		if interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		s.interval = interval
+		return nil
+	}
+}
+
+// WithReportConfigMap sets the ConfigMap the report is published to.
+// If not given, reports are only logged.
+func WithReportConfigMap(namespace, name string) ScannerOption {
+	return func(s *Scanner) error {
+		if namespace == "" || name == "" {
+			return errors.New("namespace and name of the report ConfigMap must be set")
+		}
+		s.reportNamespace = namespace
+		s.reportName = name
+		return nil
+	}
+}
+
+// WithClock sets the source of the current time, which expires leases and
+// triggers scans, for example to a fake clock in tests. Defaults to the
+// real clock.
+func WithClock(c clock.WithTicker) ScannerOption {
+	return func(s *Scanner) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		s.clock = c
+		return nil
+	}
+}
+
+func NewScanner(options ...ScannerOption) (*Scanner, error) {
+	s := &Scanner{
+		logger:   zap.NewNop(),
+		interval: 5 * time.Minute,
+		clock:    clock.RealClock{},
+		leases:   make(map[leaseKey]Lease),
+	}
+	s.protected.Store(&validator.UniqueList{})
+	for _, option := range options {
+		if err := option(s); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	if s.clientset == nil {
+		return nil, errors.New("clientset is required")
+	}
+	return s, nil
+}
+
+// Run scans immediately and then every interval until ctx is done.
+func (s *Scanner) Run(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.runOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (s *Scanner) runOnce(ctx context.Context) {
+	if _, err := s.Rescan(ctx, nil); err != nil {
+		s.logger.Error("Scan failed", zap.Error(err))
+	}
+}
+
+// Rescan runs a full scan outside of the regular interval and publishes
+// its report. If progress is not nil, it is called after each scope.
+func (s *Scanner) Rescan(ctx context.Context, progress func(Progress)) (*Report, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report, err := s.scan(ctx, progress)
+	s.failed.Store(err != nil)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("Scan complete", zap.Int("violations", report.Violations))
+	if s.reportName == "" {
+		return report, nil
+	}
+	if err := s.Publish(ctx, report); err != nil {
+		return report, fmt.Errorf("publishing report: %w", err)
+	}
+	return report, nil
+}
+
+// SetUniqueList replaces the annotations checked by subsequent scans.
+func (s *Scanner) SetUniqueList(list validator.UniqueList) {
+	s.protected.Store(&list)
+}
+
+// Degraded reports whether the most recent scan failed. In that case the
+// apiserver is likely unable to list services, and neither existing
+// duplicates nor new requests can be fully checked.
+// It can be used as validator.DegradedCheck.
+func (s *Scanner) Degraded() (string, bool) {
+	return "scanner", s.failed.Load()
+}
+
+// Healthy returns an error if the most recent scan failed.
+// It can be used as health.Check.
+func (s *Scanner) Healthy(context.Context) error {
+	if s.failed.Load() {
+		return errors.New("most recent scan failed")
+	}
+	return nil
+}
+
+// Scan lists the services in every scope and reports all values of protected
+// annotations held by more than one service.
+func (s *Scanner) Scan(ctx context.Context) (*Report, error) {
+	return s.scan(ctx, nil)
+}
+
+func (s *Scanner) scan(ctx context.Context, progress func(Progress)) (*Report, error) {
+	report := &Report{
+		LastScan:     metav1.NewTime(s.clock.Now()),
+		ByNamespace:  make(map[string]int),
+		ByAnnotation: make(map[string]int),
+	}
+
+	protected := *s.protected.Load()
+	s.pruneLeases(protected)
+	scopes := protected.Scopes()
+
+	for i, sc := range scopes {
+		services, err := validator.ListScope(ctx, s.clientset, sc)
+		if err != nil {
+			return nil, err
+		}
+
+		scope := sc.String()
+		objects := make([]map[string]string, 0, len(services))
+		for _, svc := range services {
+			objects = append(objects, svc.Annotations)
+		}
+		for _, annotation := range validator.ExpandKeys(protected[scope], objects...) {
+			byValue := make(map[string][]corev1.Service)
+			for _, svc := range services {
+				if v, found := annotation.Identity(svc.Annotations); found {
+					byValue[v] = append(byValue[v], svc)
+				}
+			}
+
+			values := make([]string, 0, len(byValue))
+			for v := range byValue {
+				values = append(values, v)
+			}
+			sort.Strings(values)
+
+			if len(annotation.Pool) > 0 {
+				usage := annotation.Usage(services)
+				metrics.PoolValues.WithLabelValues(annotation.Key, scope, "used").Set(float64(usage.Used))
+				metrics.PoolValues.WithLabelValues(annotation.Key, scope, "free").Set(float64(usage.Size - usage.Used))
+				if usage.Exhausted() {
+					s.logger.Warn("Pool exhausted", zap.String("annotation", annotation.Key), zap.String("scope", scope),
+						zap.Int("size", usage.Size), zap.Strings("top_consumers", usage.TopConsumers(3)))
+				}
+				report.Pools = append(report.Pools, Pool{Annotation: annotation.Key, Scope: scope, PoolUsage: usage})
+			}
+
+			if annotation.Lease != nil {
+				s.renewLeases(scope, annotation, byValue, report.LastScan.Time, report)
+			}
+
+			for _, value := range values {
+				holders := byValue[value]
+				if len(holders) <= annotation.Limit() {
+					continue
+				}
+				if v, _ := annotation.Lookup(holders[0].Annotations); annotation.IsShared(v) {
+					continue
+				}
+				validator.SortByOwnership(holders)
+				conflict := Conflict{
+					Annotation: annotation.Key,
+					Scope:      scope,
+					Value:      value,
+					Owner:      holders[0].Namespace + "/" + holders[0].Name,
+				}
+				for _, dup := range holders[annotation.Limit():] {
+					conflict.Duplicates = append(conflict.Duplicates, dup.Namespace+"/"+dup.Name)
+					report.ByNamespace[dup.Namespace]++
+					report.ByAnnotation[annotation.Key]++
+					report.Violations++
+				}
+				report.Conflicts = append(report.Conflicts, conflict)
+			}
+		}
+		if progress != nil {
+			progress(Progress{Scope: scope, Done: i + 1, Total: len(scopes)})
+		}
+	}
+	sortConflicts(report.Conflicts)
+	sortPools(report.Pools)
+	sortLeases(report.Leases)
+	sortLeases(report.ExpiredLeases)
+	return report, nil
+}
+
+// Publish writes report to the configured ConfigMap, creating it if necessary.
+func (s *Scanner) Publish(ctx context.Context, report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("marshalling report: %w", err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.reportNamespace,
+			Name:      s.reportName,
+			Labels:    map[string]string{"app.kubernetes.io/part-of": "unik"},
+		},
+		Data: map[string]string{
+			ReportKey:    string(data),
+			"lastScan":   report.LastScan.UTC().Format(time.RFC3339),
+			"violations": fmt.Sprint(report.Violations),
+		},
+	}
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.reportNamespace)
+	_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: validator.FieldManager})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: validator.FieldManager})
+	}
+	return err
+}
+
+// sortConflicts orders conflicts by scope, annotation and value, like leases.
+func sortConflicts(conflicts []Conflict) {
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		if a.Annotation != b.Annotation {
+			return a.Annotation < b.Annotation
+		}
+		return a.Value < b.Value
+	})
+}
+
+// sortPools orders pools by scope and annotation.
+func sortPools(pools []Pool) {
+	sort.Slice(pools, func(i, j int) bool {
+		a, b := pools[i], pools[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		return a.Annotation < b.Annotation
+	})
+}
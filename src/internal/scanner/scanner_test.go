@@ -0,0 +1,308 @@
+/*
+ *     scanner_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/unik-k8s/admission-controller/internal/scenario"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var created = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func service(namespace, name string, age time.Duration, value string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created.Add(-age)),
+			Annotations:       map[string]string{validator.AnnotationNcpSnatPool: value},
+		},
+	}
+}
+
+type ScannerSuite struct {
+	suite.Suite
+}
+
+func (s *ScannerSuite) TestScan() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "owner", 2*time.Hour, "pool-a"),
+		service("b", "dup", time.Hour, "pool-a"),
+		service("c", "dup", time.Minute, "pool-a"),
+		service("a", "unique", time.Hour, "pool-b"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool}}}))
+	assert.NoError(s.T(), err)
+
+	report, err := sc.Scan(context.Background())
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), 2, report.Violations)
+	assert.Equal(s.T(), map[string]int{"b": 1, "c": 1}, report.ByNamespace)
+	assert.Equal(s.T(), map[string]int{validator.AnnotationNcpSnatPool: 2}, report.ByAnnotation)
+	assert.Len(s.T(), report.Conflicts, 1)
+	assert.Equal(s.T(), "a/owner", report.Conflicts[0].Owner)
+	assert.Equal(s.T(), []string{"b/dup", "c/dup"}, report.Conflicts[0].Duplicates)
+}
+
+func (s *ScannerSuite) TestScanOrder() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "owner", 2*time.Hour, "pool-b"),
+		service("b", "dup", time.Hour, "pool-b"),
+		service("a", "other", 2*time.Hour, "pool-a"),
+		service("c", "dup", time.Hour, "pool-a"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(report.Conflicts, 2)
+	s.Equal("pool-a", report.Conflicts[0].Value)
+	s.Equal("pool-b", report.Conflicts[1].Value)
+}
+
+func (s *ScannerSuite) TestScanShared() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "first", time.Hour, "default"),
+		service("b", "second", time.Hour, "default"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool, Shared: []string{"default"}}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Zero(report.Violations)
+	s.Empty(report.Conflicts)
+}
+
+func (s *ScannerSuite) TestScanWildcard() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "first", 2*time.Hour, "taken"),
+		service("b", "second", time.Hour, "taken"),
+		service("b", "third", time.Hour, "free"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: "ncp/*"}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Equal(1, report.Violations)
+	s.Require().Len(report.Conflicts, 1)
+	s.Equal(validator.AnnotationNcpSnatPool, report.Conflicts[0].Annotation, "conflicts name the concrete key")
+}
+
+func (s *ScannerSuite) TestScanComposite() {
+	routed := func(namespace, name string, age time.Duration, value, router string) *corev1.Service {
+		svc := service(namespace, name, age, value)
+		svc.Annotations["ncp/router"] = router
+		return svc
+	}
+	tc := testclient.NewSimpleClientset(
+		routed("a", "first", 2*time.Hour, "pool-a", "t1"),
+		routed("b", "second", time.Hour, "pool-a", "t1"),
+		routed("b", "third", time.Hour, "pool-a", "t2"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool, Composite: []string{"ncp/router"}}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Equal(1, report.Violations)
+	s.Require().Len(report.Conflicts, 1)
+	s.Equal("pool-a (ncp/router=t1)", report.Conflicts[0].Value)
+	s.Equal([]string{"b/second"}, report.Conflicts[0].Duplicates)
+}
+
+func (s *ScannerSuite) TestScanMaxHolders() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "first", 3*time.Hour, "pool-a"),
+		service("b", "second", 2*time.Hour, "pool-a"),
+		service("c", "third", time.Hour, "pool-a"),
+		service("c", "other", time.Hour, "pool-b"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool, MaxHolders: 2}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Equal(1, report.Violations)
+	s.Require().Len(report.Conflicts, 1)
+	s.Equal("a/first", report.Conflicts[0].Owner)
+	s.Equal([]string{"c/third"}, report.Conflicts[0].Duplicates, "only holders beyond the limit are duplicates")
+}
+
+func (s *ScannerSuite) TestScanScenario() {
+	namespaces := scenario.Namespaces("ns", 20)
+	tc := scenario.New(created).
+		Bulk("svc", 2000, namespaces, validator.AnnotationNcpSnatPool, scenario.Unique("v")).
+		Duplicates("dup", 30, namespaces, validator.AnnotationNcpSnatPool, "shared").
+		Duplicates("pair", 2, []string{"ns-7"}, validator.AnnotationNcpSnatPool, "v-7").
+		Clientset()
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Equal(31, report.Violations)
+	s.Require().Len(report.Conflicts, 2)
+	byValue := map[string]Conflict{report.Conflicts[0].Value: report.Conflicts[0], report.Conflicts[1].Value: report.Conflicts[1]}
+	s.Equal("ns-0/dup-0", byValue["shared"].Owner)
+	s.Len(byValue["shared"].Duplicates, 29)
+	s.Equal("ns-7/svc-7", byValue["v-7"].Owner, "bulk services are older than later duplicates")
+	s.Equal([]string{"ns-7/pair-0", "ns-7/pair-1"}, byValue["v-7"].Duplicates)
+}
+
+func (s *ScannerSuite) TestScanPools() {
+	tc := testclient.NewSimpleClientset(
+		service("a", "first", time.Hour, "pool-a"),
+		service("b", "second", time.Hour, "pool-a"),
+		service("b", "outside", time.Hour, "not-pooled"),
+	)
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool, Pool: []string{"pool-a", "pool-b"}}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Equal([]Pool{{
+		Annotation: validator.AnnotationNcpSnatPool,
+		Scope:      validator.ClusterScope,
+		PoolUsage:  validator.PoolUsage{Used: 1, Size: 2, Consumers: map[string]int{"a": 1}},
+	}}, report.Pools)
+}
+
+func (s *ScannerSuite) TestPublish() {
+	tc := testclient.NewSimpleClientset()
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{}),
+		WithReportConfigMap("unik", "unik-report"))
+	assert.NoError(s.T(), err)
+
+	for i := 0; i < 2; i++ {
+		report := &Report{LastScan: metav1.NewTime(created), Violations: i}
+		assert.NoError(s.T(), sc.Publish(context.Background(), report))
+
+		cm, err := tc.CoreV1().ConfigMaps("unik").Get(context.Background(), "unik-report", metav1.GetOptions{})
+		assert.NoError(s.T(), err)
+
+		var published Report
+		assert.NoError(s.T(), json.Unmarshal([]byte(cm.Data[ReportKey]), &published))
+		assert.Equal(s.T(), i, published.Violations)
+	}
+}
+
+func (s *ScannerSuite) TestRescanProgress() {
+	tc := testclient.NewSimpleClientset()
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(validator.UniqueList{
+			validator.ClusterScope: {{Key: validator.AnnotationNcpSnatPool}},
+			"team-a":               {{Key: "example.com/other"}},
+		}))
+	assert.NoError(s.T(), err)
+
+	var progress []Progress
+	_, err = sc.Rescan(context.Background(), func(p Progress) { progress = append(progress, p) })
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), []Progress{
+		{Scope: validator.ClusterScope, Done: 1, Total: 2},
+		{Scope: "team-a", Done: 2, Total: 2},
+	}, progress)
+}
+
+func (s *ScannerSuite) TestLeases() {
+	tc := testclient.NewSimpleClientset(service("a", "holder", time.Hour, "pool-a"))
+	clk := testingclock.NewFakeClock(time.Now())
+	sc, err := NewScanner(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithClock(clk),
+		WithUniqueList(validator.UniqueList{validator.ClusterScope: {{
+			Key:   validator.AnnotationNcpSnatPool,
+			Lease: &metav1.Duration{Duration: time.Hour},
+		}}}))
+	s.Require().NoError(err)
+
+	report, err := sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Empty(report.Leases, "leases of existing holders are not reported")
+
+	s.Require().NoError(tc.CoreV1().Services("a").Delete(context.Background(), "holder", metav1.DeleteOptions{}))
+	report, err = sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Require().Len(report.Leases, 1)
+	s.Equal("a/holder", report.Leases[0].Holder)
+
+	holder, _, found := sc.LookupLease(validator.ClusterScope, validator.AnnotationNcpSnatPool, "pool-a")
+	s.True(found)
+	s.Equal("a/holder", holder)
+
+	// Let the lease run out.
+	clk.Step(time.Hour + time.Second)
+
+	report, err = sc.Scan(context.Background())
+	s.Require().NoError(err)
+	s.Empty(report.Leases)
+	s.Require().Len(report.ExpiredLeases, 1)
+	_, _, found = sc.LookupLease(validator.ClusterScope, validator.AnnotationNcpSnatPool, "pool-a")
+	s.False(found)
+}
+
+func TestScannerSuite(t *testing.T) {
+	suite.Run(t, new(ScannerSuite))
+}
@@ -0,0 +1,194 @@
+/*
+ *     scenario.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package scenario builds cluster states for tests: many services spread
+// over namespaces, duplicate values in specific scopes, terminating
+// objects and labelled namespaces. States are deterministic, older
+// services come first, so the owner of a duplicate value is predictable.
+package scenario
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Scenario is a cluster state under construction. Its methods return the
+// Scenario, so states can be built in one expression.
+type Scenario struct {
+	now        time.Time
+	namespaces []*corev1.Namespace
+	services   []*corev1.Service
+	// age is the age of the next service added. It decreases with every
+	// service, so services added earlier are older.
+	age time.Duration
+}
+
+// New starts an empty scenario at now. The creation and deletion
+// timestamps of all objects are relative to it.
+func New(now time.Time) *Scenario {
+	return &Scenario{now: now, age: 24 * time.Hour}
+}
+
+// Namespace adds the namespace name with labels, or sets the labels of
+// it if it exists. Namespaces of services are added without labels.
+func (s *Scenario) Namespace(name string, labels map[string]string) *Scenario {
+	s.namespace(name).Labels = labels
+	return s
+}
+
+// TerminatingNamespace adds the namespace name in phase Terminating.
+func (s *Scenario) TerminatingNamespace(name string) *Scenario {
+	ns := s.namespace(name)
+	deleted := metav1.NewTime(s.now.Add(-time.Minute))
+	ns.DeletionTimestamp = &deleted
+	ns.Status.Phase = corev1.NamespaceTerminating
+	return s
+}
+
+func (s *Scenario) namespace(name string) *corev1.Namespace {
+	if i := slices.IndexFunc(s.namespaces, func(ns *corev1.Namespace) bool { return ns.Name == name }); i >= 0 {
+		return s.namespaces[i]
+	}
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(s.now.Add(-48 * time.Hour))},
+		Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+	}
+	s.namespaces = append(s.namespaces, ns)
+	return ns
+}
+
+// Service adds a service with annotations, younger than all services added
+// before.
+func (s *Scenario) Service(namespace, name string, annotations map[string]string) *Scenario {
+	s.namespace(namespace)
+	s.services = append(s.services, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              name,
+			UID:               types.UID("uid-" + namespace + "-" + name),
+			CreationTimestamp: metav1.NewTime(s.now.Add(-s.age)),
+			Annotations:       annotations,
+		},
+	})
+	s.age -= time.Second
+	return s
+}
+
+// Bulk adds count services named prefix-<i>, spread round robin over
+// namespaces. The annotation key of the i-th service is set to value(i),
+// unless it returns "".
+func (s *Scenario) Bulk(prefix string, count int, namespaces []string, key string, value func(i int) string) *Scenario {
+	for i := 0; i < count; i++ {
+		var annotations map[string]string
+		if v := value(i); v != "" {
+			annotations = map[string]string{key: v}
+		}
+		s.Service(namespaces[i%len(namespaces)], fmt.Sprintf("%s-%d", prefix, i), annotations)
+	}
+	return s
+}
+
+// Duplicates adds count services named prefix-<i> holding value of key,
+// spread round robin over namespaces. The first one is the owner.
+func (s *Scenario) Duplicates(prefix string, count int, namespaces []string, key, value string) *Scenario {
+	return s.Bulk(prefix, count, namespaces, key, func(int) string { return value })
+}
+
+// Terminating marks the service namespace/name as terminating for since.
+// A finalizer keeps it from being removed, like a stuck load balancer.
+func (s *Scenario) Terminating(namespace, name string, since time.Duration) *Scenario {
+	svc := s.lookup(namespace, name)
+	deleted := metav1.NewTime(s.now.Add(-since))
+	svc.DeletionTimestamp = &deleted
+	svc.Finalizers = append(svc.Finalizers, "service.kubernetes.io/load-balancer-cleanup")
+	return s
+}
+
+func (s *Scenario) lookup(namespace, name string) *corev1.Service {
+	i := slices.IndexFunc(s.services, func(svc *corev1.Service) bool { return svc.Namespace == namespace && svc.Name == name })
+	if i < 0 {
+		panic(fmt.Sprintf("scenario: no service %s/%s", namespace, name))
+	}
+	return s.services[i]
+}
+
+// Services returns the services of the scenario, oldest first.
+func (s *Scenario) Services() []*corev1.Service {
+	return s.services
+}
+
+// Objects returns copies of all namespaces and services.
+func (s *Scenario) Objects() []runtime.Object {
+	objects := make([]runtime.Object, 0, len(s.namespaces)+len(s.services))
+	for _, ns := range s.namespaces {
+		objects = append(objects, ns.DeepCopy())
+	}
+	for _, svc := range s.services {
+		objects = append(objects, svc.DeepCopy())
+	}
+	return objects
+}
+
+// Clientset returns a fake clientset holding the objects of the scenario.
+func (s *Scenario) Clientset() *fake.Clientset {
+	return fake.NewSimpleClientset(s.Objects()...)
+}
+
+// Informers returns an informer factory for clientset with informers for
+// services and namespaces, and a function starting them and waiting until
+// they are synced. Informers should be handed to the code under test before
+// starting them, so its event handlers see all objects.
+func Informers(clientset kubernetes.Interface) (informers.SharedInformerFactory, func(stop <-chan struct{})) {
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Core().V1().Services().Informer()
+	factory.Core().V1().Namespaces().Informer()
+	return factory, func(stop <-chan struct{}) {
+		factory.Start(stop)
+		factory.WaitForCacheSync(stop)
+	}
+}
+
+// Namespaces returns count namespace names prefix-<i>.
+func Namespaces(prefix string, count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%d", prefix, i)
+	}
+	return names
+}
+
+// Unique returns values prefix-<i>, distinct for every service.
+func Unique(prefix string) func(int) string {
+	return func(i int) string { return fmt.Sprintf("%s-%d", prefix, i) }
+}
+
+// Cycle returns values taken round robin from values, so every value is
+// shared by every len(values)-th service.
+func Cycle(values ...string) func(int) string {
+	return func(i int) string { return values[i%len(values)] }
+}
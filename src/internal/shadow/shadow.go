@@ -0,0 +1,183 @@
+/*
+ *     shadow.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package shadow compares the decisions of a deployment of the webhook
+// running in shadow mode with those of the deployment serving the
+// apiserver, for blue/green upgrades. The serving deployment mirrors its
+// reviews with an audit.Mirror; the shadow decides them again without
+// answering the apiserver and reports where it disagrees, which gates the
+// cutover to the new release.
+package shadow
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Gate decides whether a Report allows the cutover.
+type Gate struct {
+	// MinSamples is the number of reviews to compare at least.
+	MinSamples int
+	// MaxDisagreement is the fraction of reviews, between 0 and 1, whose
+	// verdict may differ.
+	MaxDisagreement float64
+}
+
+// Validate checks the settings of g.
+func (g Gate) Validate() error {
+	if g.MinSamples < 0 {
+		return errors.New("minimum number of samples must not be negative")
+	}
+	if g.MaxDisagreement < 0 || g.MaxDisagreement > 1 {
+		return errors.New("maximum disagreement must be between 0 and 1")
+	}
+	return nil
+}
+
+// Verdict is the part of a decision which is compared.
+type Verdict struct {
+	Allowed bool            `json:"allowed"`
+	Reason  response.Reason `json:"reason"`
+}
+
+func verdict(resp *admissionv1.AdmissionResponse) Verdict {
+	return Verdict{Allowed: resp.Allowed, Reason: response.Reason(resp.AuditAnnotations[response.AuditAnnotationReason])}
+}
+
+// Disagreement is a review whose verdict differs between the deployments.
+type Disagreement struct {
+	Time      time.Time `json:"time"`
+	UID       string    `json:"uid"`
+	Operation string    `json:"operation"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Mirrored  Verdict   `json:"mirrored"`
+	Shadow    Verdict   `json:"shadow"`
+}
+
+// Report summarizes the comparisons since the comparator was created or
+// last reset.
+type Report struct {
+	Since     time.Time `json:"since"`
+	Compared  int       `json:"compared"`
+	Disagreed int       `json:"disagreed"`
+	// ReasonChanges counts reviews decided for another reason by
+	// "mirrored->shadow" reasons, including those whose verdict is the same.
+	ReasonChanges map[string]int `json:"reasonChanges,omitempty"`
+	// Disagreements holds the most recent disagreements.
+	Disagreements []Disagreement `json:"disagreements,omitempty"`
+	Gate          Gate           `json:"gate"`
+	// Passed reports whether the gate allows the cutover.
+	Passed bool `json:"passed"`
+}
+
+// Comparator collects the comparisons of mirrored reviews.
+type Comparator struct {
+	gate     Gate
+	examples int
+
+	lock          sync.Mutex
+	since         time.Time
+	compared      int
+	disagreed     int
+	reasonChanges map[string]int
+	disagreements []Disagreement
+}
+
+// NewComparator creates a comparator reporting against gate, keeping up
+// to examples disagreements.
+func NewComparator(gate Gate, examples int) (*Comparator, error) {
+	if err := gate.Validate(); err != nil {
+		return nil, err
+	}
+	c := &Comparator{gate: gate, examples: examples}
+	c.Reset()
+	return c, nil
+}
+
+// Compare records the decision of the shadow on the mirrored record r and
+// reports whether both verdicts agree.
+func (c *Comparator) Compare(r audit.Record, shadow *admissionv1.AdmissionResponse) bool {
+	mirrored, decided := verdict(r.Response), verdict(shadow)
+	agreed := mirrored.Allowed == decided.Allowed
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.compared++
+	if mirrored.Reason != decided.Reason {
+		c.reasonChanges[string(mirrored.Reason)+"->"+string(decided.Reason)]++
+	}
+	if agreed {
+		metrics.ShadowComparisons.WithLabelValues("agreed").Inc()
+		return true
+	}
+	metrics.ShadowComparisons.WithLabelValues("disagreed").Inc()
+	c.disagreed++
+	if c.examples > 0 {
+		if len(c.disagreements) == c.examples {
+			c.disagreements = c.disagreements[1:]
+		}
+		c.disagreements = append(c.disagreements, Disagreement{
+			Time:      r.Time,
+			UID:       string(r.Request.UID),
+			Operation: string(r.Request.Operation),
+			Namespace: r.Request.Namespace,
+			Name:      r.Request.Name,
+			Mirrored:  mirrored,
+			Shadow:    decided,
+		})
+	}
+	return false
+}
+
+// Report returns the comparisons so far.
+func (c *Comparator) Report() Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	report := Report{
+		Since:         c.since,
+		Compared:      c.compared,
+		Disagreed:     c.disagreed,
+		ReasonChanges: maps.Clone(c.reasonChanges),
+		Disagreements: append([]Disagreement(nil), c.disagreements...),
+		Gate:          c.gate,
+	}
+	report.Passed = c.compared > 0 && c.compared >= c.gate.MinSamples &&
+		float64(c.disagreed)/float64(c.compared) <= c.gate.MaxDisagreement
+	return report
+}
+
+// Reset discards all comparisons, for example after a fix of the shadow
+// was rolled out.
+func (c *Comparator) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.since = time.Now()
+	c.compared = 0
+	c.disagreed = 0
+	c.reasonChanges = make(map[string]int)
+	c.disagreements = nil
+}
@@ -0,0 +1,199 @@
+/*
+ *     slo.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package slo tracks the availability of the admission path as seen by the
+// webhook itself and exports it as precomputed SLO metrics: the ratio of
+// good reviews and the burn rate of the error budget over several windows.
+// SLOs can be attached to these gauges without recording rules.
+package slo
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"k8s.io/utils/clock"
+)
+
+// Outcome classifies a review for the SLO.
+type Outcome string
+
+const (
+	// OutcomeGood is a review answered with a decision within the latency objective.
+	OutcomeGood Outcome = "good"
+	// OutcomeSlow is a review answered with a decision, but slower than
+	// the latency objective.
+	OutcomeSlow Outcome = "slow"
+	// OutcomeError is a review no decision could be made on, to which the
+	// apiserver applies the failurePolicy of the webhook.
+	OutcomeError Outcome = "error"
+	// OutcomeTimeout is a review abandoned by the apiserver before it was
+	// answered.
+	OutcomeTimeout Outcome = "timeout"
+	// OutcomeOverloaded is a review answered without validation because
+	// the webhook was overloaded.
+	OutcomeOverloaded Outcome = "overloaded"
+)
+
+// buckets is the number of buckets the shortest window is divided into.
+const buckets = 60
+
+type bucket struct {
+	index     int64
+	good, bad uint64
+}
+
+// Tracker counts good and bad reviews in time buckets and computes the
+// SLO metrics over its windows when scraped. It is a prometheus.Collector.
+type Tracker struct {
+	clock     clock.PassiveClock
+	objective float64
+	latency   time.Duration
+	windows   []time.Duration
+	width     time.Duration
+
+	lock    sync.Mutex
+	buckets []bucket
+
+	objectiveDesc, latencyDesc, availabilityDesc, burnRateDesc, remainingDesc *prometheus.Desc
+}
+
+// NewTracker creates a tracker for the fraction objective of reviews to be
+// answered with a decision within latency, evaluated over windows. The
+// error budget remaining is computed over the longest window.
+func NewTracker(clk clock.PassiveClock, objective float64, latency time.Duration, windows []time.Duration) (*Tracker, error) {
+	if objective <= 0 || objective >= 1 {
+		return nil, errors.New("objective must be between 0 and 1")
+	}
+	if latency <= 0 {
+		return nil, errors.New("latency objective must be positive")
+	}
+	if len(windows) == 0 {
+		return nil, errors.New("no windows")
+	}
+	shortest, longest := windows[0], windows[0]
+	for _, w := range windows {
+		if w < time.Minute {
+			return nil, errors.New("windows must be at least a minute")
+		}
+		shortest, longest = min(shortest, w), max(longest, w)
+	}
+	width := shortest / buckets
+	return &Tracker{
+		clock:     clk,
+		objective: objective,
+		latency:   latency,
+		windows:   windows,
+		width:     width,
+		buckets:   make([]bucket, int(longest/width)+1),
+
+		objectiveDesc:    prometheus.NewDesc("unik_slo_objective_ratio", "Fraction of reviews to be answered with a decision within the latency objective.", nil, nil),
+		latencyDesc:      prometheus.NewDesc("unik_slo_latency_objective_seconds", "Latency within which reviews are to be answered.", nil, nil),
+		availabilityDesc: prometheus.NewDesc("unik_slo_availability_ratio", "Fraction of reviews answered with a decision within the latency objective by window; 1 if there were none.", []string{"window"}, nil),
+		burnRateDesc:     prometheus.NewDesc("unik_slo_error_budget_burn_rate", "Rate at which the error budget is spent by window; 1 spends it exactly within the window.", []string{"window"}, nil),
+		remainingDesc:    prometheus.NewDesc("unik_slo_error_budget_remaining_ratio", "Fraction of the error budget of the longest window left; negative once it is overspent.", nil, nil),
+	}, nil
+}
+
+// Classify returns the outcome of a review answered with a decision after d.
+func (t *Tracker) Classify(d time.Duration) Outcome {
+	if d > t.latency {
+		return OutcomeSlow
+	}
+	return OutcomeGood
+}
+
+// Observe counts a review with outcome.
+func (t *Tracker) Observe(outcome Outcome) {
+	metrics.SLOReviews.WithLabelValues(string(outcome)).Inc()
+	index := t.clock.Now().UnixNano() / int64(t.width)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	b := &t.buckets[index%int64(len(t.buckets))]
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	if outcome == OutcomeGood {
+		b.good++
+	} else {
+		b.bad++
+	}
+}
+
+// counts returns the good and bad reviews within window.
+func (t *Tracker) counts(now int64, window time.Duration) (good, bad uint64) {
+	n := int64(window / t.width)
+	for index := now - n + 1; index <= now; index++ {
+		if b := t.buckets[index%int64(len(t.buckets))]; b.index == index {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return good, bad
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.objectiveDesc
+	ch <- t.latencyDesc
+	ch <- t.availabilityDesc
+	ch <- t.burnRateDesc
+	ch <- t.remainingDesc
+}
+
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(t.objectiveDesc, prometheus.GaugeValue, t.objective)
+	ch <- prometheus.MustNewConstMetric(t.latencyDesc, prometheus.GaugeValue, t.latency.Seconds())
+
+	now := t.clock.Now().UnixNano() / int64(t.width)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var longest time.Duration
+	var burnRate float64
+	for _, w := range t.windows {
+		good, bad := t.counts(now, w)
+		availability, rate := 1.0, 0.0
+		if total := good + bad; total > 0 {
+			availability = float64(good) / float64(total)
+			rate = (1 - availability) / (1 - t.objective)
+		}
+		window := formatWindow(w)
+		ch <- prometheus.MustNewConstMetric(t.availabilityDesc, prometheus.GaugeValue, availability, window)
+		ch <- prometheus.MustNewConstMetric(t.burnRateDesc, prometheus.GaugeValue, rate, window)
+		if w > longest {
+			longest, burnRate = w, rate
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(t.remainingDesc, prometheus.GaugeValue, 1-burnRate)
+}
+
+// formatWindow renders w like Prometheus durations, for example "5m" or "6h".
+func formatWindow(w time.Duration) string {
+	s := w.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
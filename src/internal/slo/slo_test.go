@@ -0,0 +1,101 @@
+/*
+ *     slo_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// gather returns the values of the gauges of t by name and window.
+func gather(t *testing.T, tracker *Tracker) map[string]float64 {
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(tracker))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range m.GetLabel() {
+				name += "/" + label.GetValue()
+			}
+			values[name] = m.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestTracker(t *testing.T) {
+	clk := testingclock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker, err := NewTracker(clk, 0.99, time.Second, []time.Duration{5 * time.Minute, time.Hour})
+	require.NoError(t, err)
+
+	values := gather(t, tracker)
+	assert.Equal(t, 1.0, values["unik_slo_availability_ratio/5m"], "no reviews are no failures")
+	assert.Equal(t, 1.0, values["unik_slo_error_budget_remaining_ratio"])
+
+	assert.Equal(t, OutcomeGood, tracker.Classify(500*time.Millisecond))
+	assert.Equal(t, OutcomeSlow, tracker.Classify(2*time.Second))
+	for i := 0; i < 98; i++ {
+		tracker.Observe(OutcomeGood)
+	}
+	tracker.Observe(OutcomeError)
+	tracker.Observe(OutcomeTimeout)
+
+	values = gather(t, tracker)
+	assert.Equal(t, 0.99, values["unik_slo_objective_ratio"])
+	assert.Equal(t, 1.0, values["unik_slo_latency_objective_seconds"])
+	assert.InDelta(t, 0.98, values["unik_slo_availability_ratio/5m"], 1e-9)
+	assert.InDelta(t, 2, values["unik_slo_error_budget_burn_rate/5m"], 1e-9)
+	assert.InDelta(t, 2, values["unik_slo_error_budget_burn_rate/1h"], 1e-9)
+	assert.InDelta(t, -1, values["unik_slo_error_budget_remaining_ratio"], 1e-9)
+
+	clk.Step(10 * time.Minute)
+	tracker.Observe(OutcomeGood)
+	values = gather(t, tracker)
+	assert.Equal(t, 1.0, values["unik_slo_availability_ratio/5m"], "failures left the short window")
+	assert.InDelta(t, 99.0/101, values["unik_slo_availability_ratio/1h"], 1e-9)
+
+	clk.Step(2 * time.Hour)
+	values = gather(t, tracker)
+	assert.Equal(t, 1.0, values["unik_slo_availability_ratio/1h"], "buckets are reused")
+
+	_, err = NewTracker(clk, 1, time.Second, []time.Duration{time.Hour})
+	assert.Error(t, err)
+	_, err = NewTracker(clk, 0.99, time.Second, []time.Duration{time.Second})
+	assert.Error(t, err)
+}
+
+func TestFormatWindow(t *testing.T) {
+	for w, want := range map[time.Duration]string{
+		5 * time.Minute:           "5m",
+		90 * time.Minute:          "1h30m",
+		24 * time.Hour:            "24h",
+		time.Minute + time.Second: "1m1s",
+	} {
+		assert.Equal(t, want, formatWindow(w))
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ *     state.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package state publishes the state of the controller in the singleton
+// UnikControllerState: its version, the hash of its configuration, its
+// feature gates and the health of its subsystems. Admins and GitOps tools
+// can then reason about the controller declaratively instead of scraping
+// its endpoints. Only the replica holding a Lease publishes the state.
+package state
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/api/v1alpha1"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/clock"
+)
+
+// LeaseName is the name of the Lease electing the publishing replica.
+const LeaseName = "unik-controller-state"
+
+// Collect returns the current state of this replica. Leader and
+// LastUpdateTime are set by the publisher.
+type Collect func(ctx context.Context) v1alpha1.UnikControllerStateStatus
+
+type Publisher struct {
+	clientset kubernetes.Interface
+	client    dynamic.Interface
+	collect   Collect
+	namespace string
+	identity  string
+	logger    *zap.Logger
+	clock     clock.WithTicker
+	interval  time.Duration
+}
+
+type PublisherOption func(*Publisher) error
+
+func WithLogger(logger *zap.Logger) PublisherOption {
+	return func(p *Publisher) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		p.logger = logger
+		return nil
+	}
+}
+
+// WithClients sets the clientset used for the Lease and the dynamic
+// client used for the UnikControllerState.
+func WithClients(clientset kubernetes.Interface, client dynamic.Interface) PublisherOption {
+	return func(p *Publisher) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		if client == nil {
+			return errors.New("dynamic client is nil")
+		}
+		p.clientset = clientset
+		p.client = client
+		return nil
+	}
+}
+
+// WithCollector sets the function returning the state to publish.
+func WithCollector(collect Collect) PublisherOption {
+	return func(p *Publisher) error {
+		if collect == nil {
+			return errors.New("collector is nil")
+		}
+		p.collect = collect
+		return nil
+	}
+}
+
+// WithIdentity sets the namespace of the Lease and the identity of this
+// replica, usually the name of its pod.
+func WithIdentity(namespace, identity string) PublisherOption {
+	return func(p *Publisher) error {
+		if namespace == "" {
+			return errors.New("namespace is empty")
+		}
+		if identity == "" {
+			return errors.New("identity is empty")
+		}
+		p.namespace = namespace
+		p.identity = identity
+		return nil
+	}
+}
+
+// WithInterval sets how often the leader collects and publishes the
+// state. Defaults to one minute.
+func WithInterval(interval time.Duration) PublisherOption {
+	return func(p *Publisher) error {
+		if interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		p.interval = interval
+		return nil
+	}
+}
+
+// WithClock sets the source of the current time. Defaults to the real clock.
+func WithClock(c clock.WithTicker) PublisherOption {
+	return func(p *Publisher) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		p.clock = c
+		return nil
+	}
+}
+
+func NewPublisher(options ...PublisherOption) (*Publisher, error) {
+	p := &Publisher{logger: zap.NewNop(), clock: clock.RealClock{}, interval: time.Minute}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.clientset == nil:
+		return nil, errors.New("clients are required")
+	case p.collect == nil:
+		return nil, errors.New("collector is required")
+	case p.identity == "":
+		return nil, errors.New("identity is required")
+	}
+	return p, nil
+}
+
+// Run contends for the Lease until ctx is done and publishes the state
+// while holding it.
+func (p *Publisher) Run(ctx context.Context) {
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: p.namespace, Name: LeaseName},
+			Client:     p.clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: p.identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Name:            LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: p.lead,
+			OnStoppedLeading: func() { p.logger.Info("Stopped publishing controller state") },
+		},
+	})
+	if err != nil {
+		p.logger.Error("Failed to set up leader election, not publishing controller state", zap.Error(err))
+		return
+	}
+	// Run returns when the Lease is lost; keep contending for it.
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+}
+
+// lead publishes the state every interval until ctx is done.
+func (p *Publisher) lead(ctx context.Context) {
+	p.logger.Info("Publishing controller state", zap.String("identity", p.identity))
+	ticker := p.clock.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.Publish(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Warn("Failed to publish controller state", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Publish collects the state and writes it to the UnikControllerState,
+// which is created if missing. The state is only written if it changed,
+// so LastUpdateTime tells when it did.
+func (p *Publisher) Publish(ctx context.Context) error {
+	status := p.collect(ctx)
+	status.Leader = p.identity
+
+	resource := p.client.Resource(v1alpha1.UnikControllerStates)
+	obj, err := resource.Get(ctx, v1alpha1.StateName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj, err = p.create(ctx)
+	}
+	if err != nil {
+		metrics.StateUpdates.WithLabelValues("error").Inc()
+		return err
+	}
+	var current v1alpha1.UnikControllerState
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &current); err != nil {
+		return err
+	}
+	status.LastUpdateTime = current.Status.LastUpdateTime
+	if equality.Semantic.DeepEqual(current.Status, status) {
+		metrics.StateUpdates.WithLabelValues("unchanged").Inc()
+		return nil
+	}
+	status.LastUpdateTime = metav1.NewTime(p.clock.Now())
+	current.Status = status
+	updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&current)
+	if err != nil {
+		return err
+	}
+	if _, err := resource.UpdateStatus(ctx, &unstructured.Unstructured{Object: updated}, metav1.UpdateOptions{}); err != nil {
+		metrics.StateUpdates.WithLabelValues("error").Inc()
+		return err
+	}
+	metrics.StateUpdates.WithLabelValues("updated").Inc()
+	return nil
+}
+
+func (p *Publisher) create(ctx context.Context) (*unstructured.Unstructured, error) {
+	state := &v1alpha1.UnikControllerState{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "UnikControllerState"},
+		ObjectMeta: metav1.ObjectMeta{Name: v1alpha1.StateName},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(state)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Info("Creating controller state", zap.String("name", v1alpha1.StateName))
+	return p.client.Resource(v1alpha1.UnikControllerStates).Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+}
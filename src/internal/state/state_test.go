@@ -0,0 +1,92 @@
+/*
+ *     state_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func TestPublish(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	client := dynamicfake.NewSimpleDynamicClient(scheme)
+	clk := testingclock.NewFakeClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	status := v1alpha1.UnikControllerStateStatus{
+		Version:      "v1.2.3",
+		ConfigHash:   "abc",
+		FeatureGates: map[string]bool{"IndexFastPath": false},
+		Ready:        true,
+		Subsystems:   []v1alpha1.SubsystemHealth{{Name: "kubernetes-api", Critical: true, Healthy: true}},
+	}
+	p, err := NewPublisher(
+		WithClients(fake.NewSimpleClientset(), client),
+		WithCollector(func(context.Context) v1alpha1.UnikControllerStateStatus { return *status.DeepCopy() }),
+		WithIdentity("unik", "unik-0"),
+		WithClock(clk))
+	require.NoError(t, err)
+
+	get := func() v1alpha1.UnikControllerStateStatus {
+		obj, err := client.Resource(v1alpha1.UnikControllerStates).Get(context.Background(), v1alpha1.StateName, metav1.GetOptions{})
+		require.NoError(t, err)
+		var state v1alpha1.UnikControllerState
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &state))
+		return state.Status
+	}
+
+	require.NoError(t, p.Publish(context.Background()), "creating the state")
+	published := get()
+	assert.Equal(t, "unik-0", published.Leader)
+	assert.Equal(t, "abc", published.ConfigHash)
+	assert.Equal(t, clk.Now().Unix(), published.LastUpdateTime.Unix())
+
+	clk.Step(time.Minute)
+	require.NoError(t, p.Publish(context.Background()), "publishing the unchanged state")
+	assert.Equal(t, published.LastUpdateTime.Unix(), get().LastUpdateTime.Unix(), "unchanged state must not be updated")
+
+	status.Ready = false
+	status.Subsystems[0].Healthy = false
+	status.Subsystems[0].Message = "connection refused"
+	require.NoError(t, p.Publish(context.Background()), "publishing the changed state")
+	published = get()
+	assert.False(t, published.Ready)
+	assert.Equal(t, "connection refused", published.Subsystems[0].Message)
+	assert.Equal(t, clk.Now().Unix(), published.LastUpdateTime.Unix())
+}
+
+func TestNewPublisher(t *testing.T) {
+	_, err := NewPublisher(WithIdentity("unik", "unik-0"))
+	assert.Error(t, err, "clients are required")
+	_, err = NewPublisher(WithInterval(0))
+	assert.Error(t, err)
+	_, err = NewPublisher(WithIdentity("", "unik-0"))
+	assert.Error(t, err)
+}
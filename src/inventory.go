@@ -0,0 +1,184 @@
+/*
+ *     inventory.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// inventoryEntry describes the use of an annotation key.
+type inventoryEntry struct {
+	Key string `json:"key"`
+	// Objects is the number of services carrying the annotation.
+	Objects int `json:"objects"`
+	// Values is the number of distinct values.
+	Values int `json:"values"`
+	// Shared is the number of values carried by more than one service.
+	// Protecting the annotation would deny further use of those values.
+	Shared int `json:"shared"`
+	// Similar lists other keys in use which differ only in case or
+	// separators, which are likely typos.
+	Similar []string `json:"similar,omitempty"`
+}
+
+// runInventory implements the "inventory" command. It returns the exit
+// code of the process.
+func runInventory(args []string) int {
+	fs := flag.NewFlagSet("inventory", flag.ExitOnError)
+	namespace := fs.String("namespace", "", "namespace to inventory; all namespaces if empty")
+	prefix := fs.String("prefix", "", "only report annotation keys starting with prefix, for example \"ncp/\"")
+	output := fs.String("o", "text", "output format, text or json")
+	var kubeconfig kubeconfigFlags
+	kubeconfig.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s inventory [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 || (*output != "text" && *output != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	restConfig, err := kubeconfig.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+		return 1
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+		return 1
+	}
+
+	entries, err := inventory(context.Background(), clientset, *namespace, *prefix)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "taking inventory: %s\n", err)
+		return 1
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(entries)
+	} else {
+		err = printInventory(os.Stdout, entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// inventory counts the annotations starting with prefix of all services in
+// namespace, or in all namespaces if it is empty. The entries are ordered
+// by decreasing number of objects.
+func inventory(ctx context.Context, clientset kubernetes.Interface, namespace, prefix string) ([]inventoryEntry, error) {
+	var namespaces []string
+	if namespace != "" {
+		namespaces = append(namespaces, namespace)
+	}
+	services, err := validator.ListScope(ctx, clientset, validator.Cluster, namespaces...)
+	if err != nil {
+		return nil, err
+	}
+	return countAnnotations(services, prefix), nil
+}
+
+// countAnnotations builds the inventory of the annotations of services.
+func countAnnotations(services []corev1.Service, prefix string) []inventoryEntry {
+	values := make(map[string]map[string]int)
+	for _, svc := range services {
+		for key, value := range svc.Annotations {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if values[key] == nil {
+				values[key] = make(map[string]int)
+			}
+			values[key][value]++
+		}
+	}
+
+	similar := make(map[string][]string, len(values))
+	for key := range values {
+		similar[normalizeKey(key)] = append(similar[normalizeKey(key)], key)
+	}
+
+	entries := make([]inventoryEntry, 0, len(values))
+	for key, counts := range values {
+		e := inventoryEntry{Key: key, Values: len(counts)}
+		for _, n := range counts {
+			e.Objects += n
+			if n > 1 {
+				e.Shared++
+			}
+		}
+		for _, other := range similar[normalizeKey(key)] {
+			if other != key {
+				e.Similar = append(e.Similar, other)
+			}
+		}
+		sort.Strings(e.Similar)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Objects != entries[j].Objects {
+			return entries[i].Objects > entries[j].Objects
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// normalizeKey maps keys which differ only in case or in the separators
+// "-", "_" and "." to the same string.
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', '.':
+			return -1
+		}
+		return r
+	}, strings.ToLower(key))
+}
+
+// printInventory writes entries as a table to out.
+func printInventory(out io.Writer, entries []inventoryEntry) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tOBJECTS\tVALUES\tSHARED\tSIMILAR")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", e.Key, e.Objects, e.Values, e.Shared, strings.Join(e.Similar, ","))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,63 @@
+/*
+ *     inventory_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInventory(t *testing.T) {
+	svc := func(namespace, name string, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: annotations}}
+	}
+	clientset := testclient.NewSimpleClientset(
+		svc("team-a", "a", map[string]string{"ncp/snat_pool": "pool-a", "team": "a"}),
+		svc("team-a", "b", map[string]string{"ncp/snat_pool": "pool-b", "team": "a"}),
+		svc("team-b", "c", map[string]string{"ncp/snat_pool": "pool-b", "ncp/snat-pool": "pool-c"}),
+	)
+
+	entries, err := inventory(context.Background(), clientset, "", "ncp/")
+	require.NoError(t, err)
+	assert.Equal(t, []inventoryEntry{
+		{Key: "ncp/snat_pool", Objects: 3, Values: 2, Shared: 1, Similar: []string{"ncp/snat-pool"}},
+		{Key: "ncp/snat-pool", Objects: 1, Values: 1, Similar: []string{"ncp/snat_pool"}},
+	}, entries)
+
+	entries, err = inventory(context.Background(), clientset, "team-a", "")
+	require.NoError(t, err)
+	assert.Equal(t, []inventoryEntry{
+		{Key: "ncp/snat_pool", Objects: 2, Values: 2},
+		{Key: "team", Objects: 2, Values: 1, Shared: 1},
+	}, entries)
+
+	var out strings.Builder
+	require.NoError(t, printInventory(&out, entries))
+	assert.Equal(t, "KEY            OBJECTS  VALUES  SHARED  SIMILAR\n"+
+		"ncp/snat_pool  2        2       0       \n"+
+		"team           2        1       1       \n", out.String())
+}
@@ -0,0 +1,49 @@
+/*
+ *     kubeconfig.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+
+	"github.com/unik-k8s/admission-controller/internal/kubeclient"
+	"k8s.io/client-go/rest"
+)
+
+// kubeconfigFlags select the credentials for the apiserver. In a cluster,
+// they are usually left empty to use the service account of the pod.
+type kubeconfigFlags struct {
+	path    string
+	context string
+}
+
+func (k *kubeconfigFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&k.path, "kubeconfig", "", "path to a kubeconfig file for running outside of a cluster; defaults to KUBECONFIG, or the in-cluster credentials if that is unset too, falling back to ~/.kube/config")
+	fs.StringVar(&k.context, "kube-context", "", "context of the kubeconfig file to use; defaults to its current context")
+}
+
+// loader returns the source of the credentials selected by the flags.
+func (k *kubeconfigFlags) loader() kubeclient.Loader {
+	return kubeclient.Kubeconfig(k.path, k.context)
+}
+
+// config loads the credentials selected by the flags.
+func (k *kubeconfigFlags) config() (*rest.Config, error) {
+	return k.loader()()
+}
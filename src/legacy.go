@@ -0,0 +1,101 @@
+/*
+ *     legacy.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// serviceResource is the only resource the legacy binary validated.
+var serviceResource = metav1.GroupVersionResource{Version: "v1", Resource: "services"}
+
+// legacyDecision is the part of a decision of the legacy binary which is
+// compared with the migrated configuration.
+type legacyDecision struct {
+	Allowed bool
+	Message string
+}
+
+// decideLegacy decides req the way the single-annotation binary configured
+// with p does, given the services existing at the time. It is a port of
+// its handler, so a migration is verified against the legacy behavior
+// rather than against the configuration generated from it:
+//
+//   - only AnnotationNcpSnatPool is checked, always against the services of
+//     all namespaces;
+//   - the service a request is for is recognized by namespace and name, not
+//     by UID, so a service recreated while its predecessor is terminating
+//     does not conflict with it;
+//   - values reserved by leases are not known, as they are kept by the
+//     scanner of the running binary.
+func decideLegacy(p policyFlags, req *admissionv1.AdmissionRequest, services []corev1.Service, now time.Time) (legacyDecision, error) {
+	if req.Resource != serviceResource {
+		return legacyDecision{Allowed: true}, nil
+	}
+	if len(req.Object.Raw) == 0 {
+		if req.Operation == admissionv1.Delete || req.Operation == admissionv1.Connect {
+			return legacyDecision{Allowed: true}, nil
+		}
+		return legacyDecision{}, errors.New("request carries no object")
+	}
+	svc := corev1.Service{}
+	if err := json.Unmarshal(req.Object.Raw, &svc); err != nil {
+		return legacyDecision{}, fmt.Errorf("decoding object: %w", err)
+	}
+	value, present := svc.Annotations[validator.AnnotationNcpSnatPool]
+
+	if p.immutable && req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		old := corev1.Service{}
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return legacyDecision{}, fmt.Errorf("decoding old object: %w", err)
+		}
+		if previous, found := old.Annotations[validator.AnnotationNcpSnatPool]; found && (!present || previous != value) {
+			return legacyDecision{Message: fmt.Sprintf("annotation %q is immutable", validator.AnnotationNcpSnatPool)}, nil
+		}
+	}
+
+	if !present {
+		if validator.RequirementAction(p.require) == validator.RequirementDeny && svc.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			return legacyDecision{Message: fmt.Sprintf("annotation %q is required", validator.AnnotationNcpSnatPool)}, nil
+		}
+		return legacyDecision{Allowed: true}, nil
+	}
+
+	for _, service := range services {
+		if service.Namespace == req.Namespace && service.Name == req.Name {
+			continue
+		}
+		if deleted := service.DeletionTimestamp; p.releaseTerminatingAfter > 0 && deleted != nil && now.Sub(deleted.Time) > p.releaseTerminatingAfter {
+			continue
+		}
+		if held, found := service.Annotations[validator.AnnotationNcpSnatPool]; found && held == value {
+			return legacyDecision{Message: fmt.Sprintf("Service %s/%s already has the same value for annotation %q: %q", service.Namespace, service.Name, validator.AnnotationNcpSnatPool, value)}, nil
+		}
+	}
+	return legacyDecision{Allowed: true}, nil
+}
@@ -21,9 +21,47 @@
 
 package main
 
-import "github.com/magefile/mage/sh"
+import (
+	"fmt"
+
+	"github.com/magefile/mage/sh"
+)
 
 // Build builds the binary
-func Build() {
-	sh.RunV("go", "build", ".")
+func Build() error {
+	v, err := currentVersion()
+	if err != nil {
+		return err
+	}
+	return sh.RunV("go", "build", "-trimpath", "-ldflags", v.ldflags(), ".")
+}
+
+// buildVersion is the version metadata embedded into binaries and images.
+type buildVersion struct {
+	version string
+	commit  string
+	// epoch is the commit time in seconds since the epoch, used as
+	// SOURCE_DATE_EPOCH for reproducible images.
+	epoch string
+}
+
+func currentVersion() (buildVersion, error) {
+	var (
+		v   buildVersion
+		err error
+	)
+	if v.version, err = sh.Output("git", "describe", "--tags", "--always", "--dirty"); err != nil {
+		return v, fmt.Errorf("determining version: %w", err)
+	}
+	if v.commit, err = sh.Output("git", "rev-parse", "HEAD"); err != nil {
+		return v, fmt.Errorf("determining commit: %w", err)
+	}
+	if v.epoch, err = sh.Output("git", "log", "-1", "--format=%ct"); err != nil {
+		return v, fmt.Errorf("determining commit time: %w", err)
+	}
+	return v, nil
+}
+
+func (v buildVersion) ldflags() string {
+	return fmt.Sprintf("-s -w -buildid= -X main.version=%s -X main.commit=%s", v.version, v.commit)
 }
@@ -22,18 +22,73 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/sh"
 )
 
+const (
+	image = "ghcr.io/mwmahlberg/unik-admission-controller"
+	// platforms are the platforms multi-arch images are built for.
+	platforms = "linux/amd64,linux/arm64"
+)
+
 type Docker mg.Namespace
 
 // Build builds the docker image
-func (d Docker) Build() {
-	sh.RunV("docker", "build", "-t", "ghcr.io/mwmahlberg/unik-admission-controller:latest", ".")
+func (d Docker) Build() error {
+	v, err := currentVersion()
+	if err != nil {
+		return err
+	}
+	return sh.RunV("docker", append([]string{"build"}, buildArgs(v, "latest")...)...)
 }
 
 func (d Docker) Push() {
 	mg.Deps(Docker.Build)
-	sh.RunV("docker", "push", "ghcr.io/mwmahlberg/unik-admission-controller:latest")
+	sh.RunV("docker", "push", image+":latest")
+}
+
+// Multiarch builds the image for all platforms into dist/image.tar, an OCI
+// archive including an SBOM and provenance. Requires docker buildx.
+func (d Docker) Multiarch() error {
+	v, err := currentVersion()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll("dist", 0o755); err != nil {
+		return err
+	}
+	args := append([]string{"buildx", "build", "--platform", platforms, "--sbom=true", "--provenance=true",
+		"--output", "type=oci,dest=" + filepath.Join("dist", "image.tar")}, buildArgs(v, v.version)...)
+	return sh.RunWithV(map[string]string{"SOURCE_DATE_EPOCH": v.epoch}, "docker", args...)
+}
+
+// PushMultiarch builds the image for all platforms and pushes it, tagged
+// with the version and latest, along with an SBOM and provenance.
+// Requires docker buildx.
+func (d Docker) PushMultiarch() error {
+	v, err := currentVersion()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"buildx", "build", "--platform", platforms, "--sbom=true", "--provenance=true",
+		"--push", "-t", image + ":latest"}, buildArgs(v, v.version)...)
+	return sh.RunWithV(map[string]string{"SOURCE_DATE_EPOCH": v.epoch}, "docker", args...)
+}
+
+// buildArgs returns the arguments shared by all image builds, tagging the
+// image with tag.
+func buildArgs(v buildVersion, tag string) []string {
+	return []string{
+		"-t", image + ":" + tag,
+		"--build-arg", "VERSION=" + v.version,
+		"--build-arg", "COMMIT=" + v.commit,
+		"--build-arg", "SOURCE_DATE_EPOCH=" + v.epoch,
+		"--label", "org.opencontainers.image.version=" + v.version,
+		"--label", "org.opencontainers.image.revision=" + v.commit,
+		".",
+	}
 }
@@ -0,0 +1,74 @@
+/* 
+ *     generate.go is part of github.com/unik-k8s/admission-controller.
+ *  
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *  
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *  
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *  
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *  
+ */
+
+//go:build mage
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+const (
+	controllerGen = "sigs.k8s.io/controller-tools/cmd/controller-gen@v0.13.0"
+	// apiTypes holds the Go types of all custom resources.
+	apiTypes = "./api/..."
+	// crdDir receives the generated CustomResourceDefinitions.
+	crdDir = "../kustomize/base/crds"
+)
+
+type Generate mg.Namespace
+
+// All generates DeepCopy implementations and CRDs from the API types
+func (g Generate) All() {
+	mg.SerialDeps(Generate.Deepcopy, Generate.CRDs)
+}
+
+// Deepcopy generates the DeepCopy implementations of the API types
+func (g Generate) Deepcopy() error {
+	if err := requireAPITypes(); err != nil {
+		return err
+	}
+	return sh.RunV("go", "run", controllerGen, "object", "paths="+apiTypes)
+}
+
+// CRDs generates the CustomResourceDefinitions of the API types
+func (g Generate) CRDs() error {
+	if err := requireAPITypes(); err != nil {
+		return err
+	}
+	return sh.RunV("go", "run", controllerGen, "crd", "paths="+apiTypes, "output:crd:artifacts:config="+crdDir)
+}
+
+// Check fails if the generated artifacts are not in sync with the API types
+func (g Generate) Check() error {
+	mg.SerialDeps(Generate.All)
+	return sh.RunV("git", "diff", "--exit-code", "--", "api", crdDir)
+}
+
+func requireAPITypes() error {
+	if _, err := os.Stat("api"); err != nil {
+		return errors.New("no API types found in api/")
+	}
+	return nil
+}
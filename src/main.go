@@ -21,28 +21,158 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	zaplogfmt "github.com/jsternberg/zap-logfmt"
-	"github.com/unik-k8s/admission-controller/handler"
-	"github.com/unik-k8s/admission-controller/validator"
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/internal/consistency"
+	"github.com/unik-k8s/admission-controller/internal/featuregate"
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/internal/health"
+	"github.com/unik-k8s/admission-controller/internal/kubeclient"
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/internal/preflight"
+	"github.com/unik-k8s/admission-controller/internal/probe"
+	"github.com/unik-k8s/admission-controller/internal/registration"
+	"github.com/unik-k8s/admission-controller/internal/scanner"
+	"github.com/unik-k8s/admission-controller/internal/shadow"
+	"github.com/unik-k8s/admission-controller/internal/slo"
+	"github.com/unik-k8s/admission-controller/internal/state"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
 )
 
 var (
-	debug    bool = false
-	addr     string
-	certFile string
-	keyFile  string
+	debug bool = false
+	addrs addrList
+
+	metricsAddr     string
+	adminAddr       string
+	allowedHosts    string
+	maxRequestBytes int64
+	certFile        string
+	keyFile         string
+
+	policy     policyFlags
+	kubeconfig kubeconfigFlags
+
+	protectedAnnotations string
+
+	configFile         string
+	configMapNamespace string
+	configMapName      string
+	configSecretName   string
+	overridesConfigMap string
+	discoverConfigs    bool
+	policies           bool
+
+	scanInterval    time.Duration
+	reportNamespace string
+	reportName      string
+	reindexInterval time.Duration
+
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+
+	enableHTTP2     bool
+	http2MaxStreams uint32
+	maxIdleConns    int
+
+	clientCAFile     string
+	clientCAGrace    time.Duration
+	clientCAInterval time.Duration
+
+	shutdownGracePeriod time.Duration
+
+	decisionCacheTTL time.Duration
+	jsonCodec        string
+	watchNamespaces  bool
+	namespaceModes   bool
+	namespaceModeTTL time.Duration
+
+	valueFilterCapacity int
+	valueFilterFPRate   float64
+
+	maxConcurrentReviews int
+	queueTimeout         time.Duration
+	overloadResponse     string
+	retryAfter           time.Duration
+
+	sloObjective float64
+	sloLatency   time.Duration
+	sloWindows   string
+
+	replayRecords   int
+	replayThreshold float64
+	replayBlock     bool
+
+	mirrorTo              string
+	mirrorCA              string
+	mirrorQueue           int
+	shadowMode            bool
+	shadowMinSamples      int
+	shadowMaxDisagreement float64
+
+	consistencyInterval  time.Duration
+	consistencySample    int
+	consistencyThreshold float64
+	probeInterval        time.Duration
+	probeNamespace       string
+
+	stateInterval  time.Duration
+	stateNamespace string
+
+	escalationThreshold int
+	escalationWindow    time.Duration
+
+	denialEvents      bool
+	denialEventWindow time.Duration
+
+	apiTimeout time.Duration
+
+	webhookConfiguration string
+	webhookName          string
+	criticality          string
+	checkConflicts       bool
+
+	sampleFraction float64
+	sampleDenials  bool
+
+	attributionExtra string
+	attributeDenials bool
+
+	warnings             string
+	unsupportedResources string
+	terminatingAction    string
+	postProcessors       string
+	messagesFile         string
+	denialTemplateFile   string
+	selfUsername         string
+	exemptNamespaces     string
 
 	clientset kubernetes.Interface
 )
@@ -50,14 +180,118 @@ var (
 func init() {
 
 	flag.BoolVar(&debug, "debug", false, "enable debug mode")
-	flag.StringVar(&addr, "addr", ":9090", "address to listen on")
+	flag.Var(featuregate.Default, "feature-gates", "comma separated list of feature=bool pairs enabling or disabling experimental behavior; "+featuregate.Default.Usage())
+	flag.Var(&addrs, "addr", "address to listen on; may be given multiple times, for example for IPv4 and IPv6 (default :9090)")
+	flag.StringVar(&allowedHosts, "allowed-hosts", "", "comma separated list of host names the webhook may be called by, checked against the Host header and SNI; empty allows all, for example for port-forwarding")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "address to serve metrics on; empty disables the metrics server")
+	flag.StringVar(&adminAddr, "admin-addr", "", "address to serve the admin API on, which allows to inspect and override the configuration of this replica at runtime; empty disables the admin server")
+	flag.Int64Var(&maxRequestBytes, "max-request-bytes", 3<<20, "maximum size of request bodies accepted by the webhook")
 	flag.StringVar(&certFile, "cert", "/etc/certs/tls.crt", "path to TLS certificate")
 	flag.StringVar(&keyFile, "key", "/etc/certs/tls.key", "path to TLS key")
+	policy.register(flag.CommandLine)
+	kubeconfig.register(flag.CommandLine)
+	flag.StringVar(&protectedAnnotations, "protected-annotations", "", "comma separated list of further annotation keys whose values must be unique across the cluster")
+	flag.DurationVar(&scanInterval, "scan-interval", 5*time.Minute, "interval between scans for duplicate values in existing services; 0 disables scanning")
+	flag.StringVar(&reportNamespace, "report-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the ConfigMap the scan report is published to")
+	flag.StringVar(&configFile, "config", "", "path to a YAML or JSON file holding the protected annotations, which override the flags, and TLS and server settings, which apply unless given as flags")
+	flag.StringVar(&configMapNamespace, "config-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the ConfigMap given by -config-configmap and the Secret given by -config-secret")
+	flag.StringVar(&configMapName, "config-configmap", "", "name of a ConfigMap holding the protected annotations under key \""+config.ConfigMapKey+"\"; it overrides the flags and is reloaded whenever it changes")
+	flag.StringVar(&overridesConfigMap, "config-overrides-configmap", "", "name of the ConfigMap in -config-namespace storing the overrides made with PUT /admin/config, so that they reach all replicas and survive restarts; without it, the configuration can only be read via the admin API")
+	flag.StringVar(&configSecretName, "config-secret", "", "name of a Secret holding the protected annotations under key \""+config.ConfigMapKey+"\", like -config-configmap; it overrides the flags and the ConfigMap and is reloaded whenever it changes")
+	flag.BoolVar(&discoverConfigs, "discover-configs", false, "merge the annotations listed by ConfigMaps labelled "+config.DiscoveryLabel+"=true into the protected annotations of their namespaces; the central ConfigMap, Secret and policies override them")
+	flag.BoolVar(&policies, "policies", false, "merge the annotations declared by UniqueAnnotationPolicy resources into the protected annotations; scopes they declare override the flags and the ConfigMap")
+	flag.StringVar(&reportName, "report-name", "unik-report", "name of the ConfigMap the scan report is published to; empty disables publishing")
+	flag.DurationVar(&reindexInterval, "reindex-interval", time.Minute, "minimum time between two reindexes triggered via /-/reindex")
+	flag.DurationVar(&readHeaderTimeout, "read-header-timeout", 5*time.Second, "maximum time to read request headers")
+	flag.DurationVar(&readTimeout, "read-timeout", 10*time.Second, "maximum time to read a request including its body")
+	flag.DurationVar(&writeTimeout, "write-timeout", 30*time.Second, "maximum time from the end of reading the request headers to the end of writing the response")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 90*time.Second, "maximum time to keep an idle keep-alive connection open")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", 64<<10, "maximum size of request headers")
+	flag.BoolVar(&enableHTTP2, "http2", true, "serve the webhook via HTTP/2 if the client supports it")
+	flag.Func("http2-max-streams", "maximum number of concurrent HTTP/2 streams per connection (default 250)", func(v string) error {
+		n, err := strconv.ParseUint(v, 10, 32)
+		http2MaxStreams = uint32(n)
+		return err
+	})
+	flag.DurationVar(&decisionCacheTTL, "decision-cache-ttl", 0, "time to cache decisions for identical requests; 0 disables the cache")
+	flag.BoolVar(&namespaceModes, "namespace-modes", false, "let namespaces select with the annotation "+validator.ModeAnnotation+" whether requests are enforced (\"enforce\"), only warned about (\"warn\") or not validated (\"off\")")
+	flag.DurationVar(&namespaceModeTTL, "namespace-mode-ttl", 30*time.Second, "time the mode of a namespace is cached unless -watch-namespaces is given; 0 looks it up for every request")
+	flag.BoolVar(&watchNamespaces, "watch-namespaces", false, "cache namespaces to resolve scopes given as label selectors and namespace modes without a call to the apiserver per request; otherwise their labels are looked up on demand")
+	flag.IntVar(&valueFilterCapacity, "value-filter-capacity", 0, "number of values per protected annotation the value filters are sized for; values the filters rule out are admitted without listing services, values just admitted by another replica are missed until the informer sees them; 0 disables the filters")
+	flag.Float64Var(&valueFilterFPRate, "value-filter-fp-rate", 0.01, "target false positive rate of the value filters")
+	flag.IntVar(&maxConcurrentReviews, "max-concurrent-reviews", 0, "maximum number of reviews validated concurrently; 0 disables the limit")
+	flag.DurationVar(&queueTimeout, "queue-timeout", time.Second, "maximum time a review waits for one of -max-concurrent-reviews before it is answered according to -overload-response")
+	flag.StringVar(&overloadResponse, "overload-response", string(handler.OverloadThrottle), "answer to reviews exceeding -max-concurrent-reviews; \"throttle\" responds 429 with Retry-After and suits failurePolicy Fail, \"admit\" admits with a warning and suits failurePolicy Ignore")
+	flag.DurationVar(&retryAfter, "retry-after", time.Second, "time after which the apiserver is asked to retry throttled reviews")
+	flag.Float64Var(&sloObjective, "slo-objective", 0.999, "fraction of reviews to be answered with a decision within -slo-latency, exported with availability and error budget burn rate as SLO metrics; 0 disables the SLO metrics")
+	flag.DurationVar(&sloLatency, "slo-latency", time.Second, "latency within which reviews count as good for -slo-objective")
+	flag.StringVar(&sloWindows, "slo-windows", "5m,30m,1h,6h,24h,72h", "comma separated list of windows the availability and error budget burn rate are exported for; the error budget remaining refers to the longest")
+	flag.IntVar(&replayRecords, "replay-records", 0, "number of recent reviews replayed against configuration changes to find verdicts the change would flip; 0 disables the replay")
+	flag.Float64Var(&replayThreshold, "replay-threshold", 0.05, "fraction of replayed reviews whose verdict may flip before a configuration change is reported")
+	flag.BoolVar(&replayBlock, "replay-block", false, "reject configuration changes flipping more than -replay-threshold of the replayed reviews instead of only logging them")
+	flag.StringVar(&mirrorTo, "mirror-to", "", "URL of the /shadow endpoint of another deployment running with -shadow, for example a new release, which every review is mirrored to for comparison; empty disables mirroring")
+	flag.StringVar(&mirrorCA, "mirror-ca", "", "CA certificate used to verify the deployment given by -mirror-to; system roots if empty")
+	flag.IntVar(&mirrorQueue, "mirror-queue", 1000, "number of reviews queued for mirroring; reviews arriving while the queue is full are not mirrored")
+	flag.BoolVar(&shadowMode, "shadow", false, "run in shadow mode: decide the reviews mirrored to /shadow by another deployment and report where the decisions differ, to gate the cutover of a blue/green upgrade")
+	flag.IntVar(&shadowMinSamples, "shadow-min-samples", 1000, "number of mirrored reviews to compare at least before the shadow report allows the cutover")
+	flag.Float64Var(&shadowMaxDisagreement, "shadow-max-disagreement", 0, "fraction of mirrored reviews whose verdict may differ for the shadow report to allow the cutover")
+	flag.DurationVar(&consistencyInterval, "consistency-check-interval", 10*time.Minute, "interval between comparisons of the services known to the decision cache with the apiserver; 0 disables the check")
+	flag.IntVar(&consistencySample, "consistency-check-sample", 20, "number of services compared per consistency check")
+	flag.Float64Var(&consistencyThreshold, "consistency-check-threshold", 0.1, "fraction of divergent services above which the decision cache is flushed")
+	flag.DurationVar(&probeInterval, "consistency-probe-interval", 0, "interval in which replicas evaluate a canary review and compare their answers via Leases, to detect diverging configurations or caches; 0 disables the probe")
+	flag.StringVar(&probeNamespace, "consistency-probe-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the Leases of the consistency probe")
+	flag.DurationVar(&stateInterval, "controller-state-interval", 0, "interval in which the replica holding a Lease publishes version, configuration hash, feature gates and health of the controller in the UnikControllerState \"unik\"; 0 disables publishing")
+	flag.StringVar(&stateNamespace, "controller-state-namespace", os.Getenv("POD_NAMESPACE"), "namespace of the Lease electing the replica publishing the controller state")
+	flag.DurationVar(&apiTimeout, "api-timeout", 5*time.Second, "maximum time for each apiserver call made while validating; together with -queue-timeout, keep below the timeoutSeconds of the webhook, which is checked with -webhook-configuration")
+	flag.IntVar(&escalationThreshold, "escalation-threshold", 3, "number of identical denials of a user within -escalation-window after which denials include guidance; 0 disables escalation")
+	flag.DurationVar(&escalationWindow, "escalation-window", 10*time.Minute, "window in which identical denials are counted for escalation")
+	flag.BoolVar(&denialEvents, "denial-events", false, "record a Warning Event on the object for every denial")
+	flag.DurationVar(&denialEventWindow, "denial-event-window", 10*time.Minute, "window in which similar denial Events for the same object are aggregated into one")
+	flag.StringVar(&webhookConfiguration, "webhook-configuration", "", "name of the ValidatingWebhookConfiguration whose rules and namespaceSelector are kept in line with the protected annotations; empty disables self-registration")
+	flag.StringVar(&webhookName, "webhook-name", "unik-k8s.github.com", "name of the webhook within -webhook-configuration")
+	flag.BoolVar(&checkConflicts, "check-webhook-conflicts", true, "warn at startup about webhooks of other configurations validating services, such as an old release of the controller")
+	flag.StringVar(&criticality, "criticality", string(registration.CriticalityStrict), "importance of enforcing the protected annotations, which determines the recommended failurePolicy of the webhook; \"strict\" recommends Fail, \"best-effort\" recommends Ignore")
+	flag.StringVar(&terminatingAction, "terminating-namespaces", string(validator.TerminatingValidate), "decision on services created in terminating namespaces, which the apiserver rejects anyway; \"validate\" checks them like any other and \"warn\" admits them with a warning without looking up holders, saving load during large namespace cleanups")
+	flag.StringVar(&unsupportedResources, "unsupported-resources", string(validator.UnsupportedWarn), "decision on requests for resources other than services; \"warn\" admits them with a warning, \"allow\" admits them silently and \"deny\" rejects them to expose misconfigured webhook rules")
+	flag.StringVar(&postProcessors, "post-processors", "", "comma separated list of registered post-processors applied to every response in the given order, for example to add audit annotations or ticket links")
+	flag.StringVar(&exemptNamespaces, "exempt-namespaces", strings.Join(validator.DefaultExemptNamespaces, ","), "comma separated list of namespaces whose requests are admitted without validation")
+	flag.StringVar(&selfUsername, "self-username", "", "username the controller authenticates as; its own writes are admitted without validation to prevent admission loops; defaults to the subject of the service account token of the pod")
+	flag.StringVar(&messagesFile, "messages", "", "YAML or JSON file mapping message keys to texts replacing the default denial and warning texts, for example to translate them")
+	flag.StringVar(&denialTemplateFile, "denial-template", "", "file holding a Go text/template rendering denial messages from .Message, .Reason, .Namespace, .Name, .Annotation, .Value and .ConflictingService, for example to link runbooks; invalid templates are logged and the default messages kept")
+	flag.StringVar(&warnings, "warnings", string(validator.WarningsFull), "warnings attached to allowed responses; one of \"none\", \"errors-only\" or \"full\"")
+	flag.Float64Var(&sampleFraction, "sample-fraction", 0, "fraction of admission reviews between 0 and 1 logged in full, redacted, for debugging")
+	flag.BoolVar(&sampleDenials, "sample-denials", false, "log all denied admission reviews in full, redacted, for debugging")
+	flag.StringVar(&attributionExtra, "attribution-extra", strings.Join(validator.DefaultAttributionExtra, ","), "comma separated list of extra fields of the requesting user which are logged and kept in sampled reviews, for example the original user recorded by an impersonating proxy")
+	flag.BoolVar(&attributeDenials, "attribute-denials", false, "name the requesting user and the fields of -attribution-extra in denial messages")
+	flag.StringVar(&jsonCodec, "json-codec", "std", "JSON codec used to encode responses; one of \"std\" or \"jsoniter\"")
+	flag.DurationVar(&shutdownGracePeriod, "shutdown-grace-period", 5*time.Second, "time to wait for in-flight requests to complete on shutdown; keep below terminationGracePeriodSeconds")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", 0, "maximum number of idle connections kept open by the webhook; 0 means unlimited")
+	flag.StringVar(&clientCAFile, "client-ca", "", "PEM file holding the CA clients of the webhook must present a certificate of; it is reloaded with the serving certificate while serving; empty disables client verification")
+	flag.DurationVar(&clientCAGrace, "client-ca-grace", 5*time.Minute, "time connections authenticated under a replaced client CA are kept open")
+	flag.DurationVar(&clientCAInterval, "client-ca-reload-interval", 30*time.Second, "interval the client CA and serving certificate are checked for changes")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [serve] [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEach flag can also be set by an environment variable, for example %s for -metrics-addr.\n", envName("metrics-addr"))
+	}
 
 }
 
 func main() {
-	flag.Parse()
+	os.Exit(execute(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// serve runs the webhook with the flags parsed into flag.CommandLine until
+// it is shut down. It returns the exit code of the process, so that all
+// deferred cleanups have run before it exits.
+func serve() int {
+	// Flags given on the command line take precedence over the environment,
+	// which takes precedence over the -config file.
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
 
 	// Setup logging
 	var cfg zapcore.EncoderConfig
@@ -75,61 +309,685 @@ func main() {
 		panic("logger is nil")
 	}
 
-	// Setup clientset
+	// The -config file provides defaults for the flags not given on the command line.
+	var fromFile *fileConfig
+	if configFile != "" {
+		var err error
+		if fromFile, err = loadConfigFile(configFile); err != nil {
+			exitInvalid(logger.With(zap.String("file", configFile)), "Invalid configuration file", err)
+		}
+		if err := fromFile.apply(flag.CommandLine); err != nil {
+			logger.Fatal("Invalid configuration file", zap.String("file", configFile), zap.Error(err))
+		}
+	}
+
+	// Setup clientset. Its credentials are reloaded when the service
+	// account token or the cluster CA are rotated.
 	var setupError error
-	config, setupError := rest.InClusterConfig()
+	rotator, setupError := kubeclient.NewRotator(
+		kubeclient.WithLogger(logger.Named("kubeclient")),
+		kubeclient.WithLoader(kubeconfig.loader()))
 
 	if setupError != nil {
-		panic(setupError.Error())
+		logger.Fatal("Failed to connect to the apiserver; use -kubeconfig outside of a cluster", zap.Error(setupError))
 	}
 
-	clientset, setupError = kubernetes.NewForConfig(config)
+	clientset, setupError = kubernetes.NewForConfig(rotator.Config())
 	if setupError != nil {
 		panic(setupError.Error())
 	}
 
-	logger.Info("Starting unik admission controller")
-	defer logger.Info("Exiting unik admission controller")
+	logger.Info("Starting unik admission controller", zap.String("version", version), zap.String("commit", commit))
+	// Deferred calls run in reverse order, so the log is synced last.
 	defer logger.Sync()
+	defer logger.Info("Exiting unik admission controller")
+	for f, enabled := range featuregate.Default.Features() {
+		stage := featuregate.Default.Stage(f)
+		value := 0.0
+		if enabled {
+			value = 1
+			if stage != featuregate.GA {
+				logger.Info("Enabled feature gate", zap.String("feature", string(f)), zap.String("stage", string(stage)))
+			}
+		}
+		metrics.FeatureEnabled.WithLabelValues(string(f), string(stage)).Set(value)
+	}
+
+	// Cross-cutting behavior shared by all servers.
+	chain := func(server string) handler.Chain {
+		hl := logger.Named("http").With(zap.String("server", server))
+		return handler.NewChain(
+			handler.RequestID(),
+			handler.Logging(hl),
+			handler.Metrics(server),
+			handler.Recovery(hl),
+		)
+	}
 
 	mux := http.NewServeMux()
 
 	hl := logger.Named("handler").With(zap.String("handler", "validate"))
 
-	validator, err := validator.NewValidationHandlerV1(validator.WithLogger(hl), validator.WithClientset(clientset))
+	if policy.lease > 0 && scanInterval <= 0 {
+		logger.Fatal("-lease requires -scan-interval to be positive")
+	}
+	snatPool, err := policy.annotation()
 	if err != nil {
-		logger.Fatal("Failed to create validation handler", zap.Error(err))
+		logger.Fatal("Invalid policy", zap.Error(err))
+	}
+
+	// The flags form the base configuration, which later sources may override.
+	managerOpts := []config.ManagerOption{
+		config.WithLogger(logger.Named("config")),
+		config.WithSource(config.Static("flags", &config.Config{
+			Protected: validator.UniqueList{validator.ClusterScope: append([]validator.ProtectedAnnotation{snatPool}, protectedList()...)},
+		})),
+	}
+	if fromFile != nil {
+		managerOpts = append(managerOpts, config.WithSource(config.Static("file:"+configFile, &fromFile.Config)))
+	}
+	// ConfigMaps published by the teams come before the central sources, so
+	// those take precedence for a namespace.
+	if discoverConfigs {
+		managerOpts = append(managerOpts, config.WithSource(config.Discovered(clientset, logger.Named("discovery"))))
+	}
+	if configMapName != "" {
+		if configMapNamespace == "" {
+			logger.Fatal("-config-configmap requires -config-namespace")
+		}
+		managerOpts = append(managerOpts, config.WithSource(config.ConfigMap(clientset, configMapNamespace, configMapName)))
+	}
+	if configSecretName != "" {
+		if configMapNamespace == "" {
+			logger.Fatal("-config-secret requires -config-namespace")
+		}
+		managerOpts = append(managerOpts, config.WithSource(config.Secret(clientset, configMapNamespace, configSecretName)))
+	}
+	if policies {
+		dynamicClient, err := dynamic.NewForConfig(rotator.Config())
+		if err != nil {
+			logger.Fatal("Failed to create dynamic client", zap.Error(err))
+		}
+		managerOpts = append(managerOpts, config.WithSource(config.Policies(dynamicClient)))
+	}
+	// Overrides made via the admin API take precedence over all other sources.
+	var overrides *config.OverrideConfigMapSource
+	if adminAddr != "" && overridesConfigMap != "" {
+		if configMapNamespace == "" {
+			logger.Fatal("-config-overrides-configmap requires -config-namespace")
+		}
+		overrides = config.OverrideConfigMap(clientset, configMapNamespace, overridesConfigMap)
+		managerOpts = append(managerOpts, config.WithSource(overrides))
+	}
+	var recorded *audit.Ring
+	if replayRecords > 0 {
+		recorded = audit.NewRing(replayRecords)
+		guard := &regressionGuard{
+			logger:  logger.Named("replay"),
+			records: recorded,
+			options: []validator.ValidationHandlerOption{
+				validator.WithClientset(clientset),
+				validator.WithAPITimeout(apiTimeout),
+			},
+			threshold: replayThreshold,
+			block:     replayBlock,
+		}
+		managerOpts = append(managerOpts, config.WithGuard(guard.check))
+	}
+	configManager, err := config.NewManager(managerOpts...)
+	if err != nil {
+		logger.Fatal("Failed to create configuration manager", zap.Error(err))
+	}
+	if err := configManager.Reload(context.Background()); err != nil {
+		var p *config.Problem
+		if errors.As(err, &p) {
+			exitInvalid(logger, "Invalid configuration", err)
+		}
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+	protected := configManager.Current().Protected
+
+	// Readiness aggregates the health of all subsystems.
+	checker := health.NewChecker(apiTimeout)
+	checker.Add("tls", health.Critical, certificateHealth(certFile, keyFile))
+	checker.Add("config", health.Degrading, configManager.Healthy)
+	checker.Add("apiserver", health.Degrading, rotator.Healthy)
+
+	// Missing permissions are reported right away and keep the webhook
+	// unready until they are granted.
+	pf := preflight.New(clientset, requiredPermissions()...)
+	if err := pf.Healthy(context.Background()); err != nil {
+		logger.Error("Preflight failed", zap.Error(err))
+	}
+	checker.Add("preflight", health.Critical, pf.Healthy)
+
+	if checkConflicts {
+		conflictCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		warnConflictingWebhooks(conflictCtx, logger.Named("registration"), clientset, protected)
+		cancel()
+	}
+
+	crit, err := registration.ParseCriticality(criticality)
+	if err != nil {
+		logger.Fatal("Invalid value for -criticality", zap.Error(err))
+	}
+	// recommend derives the webhook settings the controller is tuned for
+	// from the latencies observed so far.
+	recommend := func() registration.Recommendation {
+		latency, _ := metrics.RequestLatency("webhook", 0.99)
+		return registration.Recommend(crit, latency, apiTimeout)
+	}
+
+	if webhookConfiguration != "" {
+		registrar, err := registration.NewRegistrar(
+			registration.WithLogger(logger.Named("registration")),
+			registration.WithClientset(clientset),
+			registration.WithWebhook(webhookConfiguration, webhookName),
+		)
+		if err != nil {
+			logger.Fatal("Failed to create registrar", zap.Error(err))
+		}
+		if err := registrar.Register(context.Background(), protected); err != nil {
+			logger.Fatal("Failed to register webhook", zap.Error(err))
+		}
+		checker.Add("registration", health.Degrading, registrar.Healthy)
+		// checkTimeout warns if a review may take longer than the apiserver
+		// waits for it, which turns slow denials into the failurePolicy.
+		checkTimeout := func() {
+			budgets := []registration.Budget{{Name: "api-timeout", Duration: apiTimeout}}
+			if maxConcurrentReviews > 0 {
+				budgets = append([]registration.Budget{{Name: "queue-timeout", Duration: queueTimeout}}, budgets...)
+			}
+			if _, err := registrar.CheckTimeout(context.Background(), budgets...); err != nil {
+				logger.Error("Failed to check webhook timeout", zap.Error(err))
+			}
+		}
+		// Each policy domain has a webhook of its own, named after it.
+		registerDomains := func(c *config.Config) {
+			for name, list := range c.Domains {
+				r, err := registration.NewRegistrar(
+					registration.WithLogger(logger.Named("registration").With(zap.String("domain", name))),
+					registration.WithClientset(clientset),
+					registration.WithWebhook(webhookConfiguration, name+"."+webhookName),
+				)
+				if err == nil {
+					err = r.Register(context.Background(), list)
+				}
+				if err != nil {
+					logger.Error("Failed to register webhook of policy domain", zap.String("domain", name), zap.Error(err))
+				}
+			}
+		}
+		go func() {
+			ticker := time.NewTicker(10 * time.Minute)
+			defer ticker.Stop()
+			for range ticker.C {
+				rec := recommend()
+				logger.Info("Recommended webhook settings", zap.Any("recommendation", rec))
+				if _, err := registrar.CheckAlignment(context.Background(), rec); err != nil {
+					logger.Error("Failed to check webhook settings", zap.Error(err))
+				}
+				checkTimeout()
+			}
+		}()
+		if _, err := registrar.CheckAlignment(context.Background(), recommend()); err != nil {
+			logger.Error("Failed to check webhook settings", zap.Error(err))
+		}
+		checkTimeout()
+		registerDomains(configManager.Current())
+		configManager.Subscribe(func(c *config.Config) {
+			if err := registrar.Register(context.Background(), c.Protected); err != nil {
+				logger.Error("Failed to register webhook", zap.Error(err))
+			}
+			registerDomains(c)
+		})
+	} else {
+		// Without self-registration, the webhook configuration is maintained
+		// by hand and needs to send the requests the protected annotations
+		// are checked on.
+		logRules := func(c *config.Config) {
+			logger.Info("Webhook rules required by configuration",
+				zap.Any("recommendation", recommend()),
+				zap.Any("rules", c.Protected.WebhookRules()),
+				zap.Any("namespaceSelector", c.Protected.WebhookNamespaceSelector()))
+		}
+		logRules(configManager.Current())
+		configManager.Subscribe(logRules)
 	}
 
-	mux.Handle("/validate", handler.AdmissionReviewRequesthandler(validator))
 	ctx, cancel := context.WithCancel(context.Background())
+	authz := handler.NewSubjectAccessReviewAuthorizer(clientset)
 
-	srv := &http.Server{
-		Addr:        addr,
-		Handler:     mux,
-		BaseContext: func(_ net.Listener) context.Context { return ctx },
+	verbosity, err := validator.ParseWarningVerbosity(warnings)
+	if err != nil {
+		logger.Fatal("Invalid value for -warnings", zap.Error(err))
+	}
+	unsupported, err := validator.ParseUnsupportedAction(unsupportedResources)
+	if err != nil {
+		logger.Fatal("Invalid value for -unsupported-resources", zap.Error(err))
+	}
+	terminating, err := validator.ParseTerminatingAction(terminatingAction)
+	if err != nil {
+		logger.Fatal("Invalid value for -terminating-namespaces", zap.Error(err))
 	}
-	srv.RegisterOnShutdown(func() { logger.Info("HTTP server shutdown complete") })
-	srv.RegisterOnShutdown(cancel)
 
-	go func() {
-		logger.Info("Starting HTTP server", zap.String("addr", addr), zap.String("protocol", "http"))
-		if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil {
-			logger.Fatal("Failed to start HTTP server", zap.Error(err))
+	validatorOpts := []validator.ValidationHandlerOption{
+		validator.WithLogger(hl),
+		validator.WithWarningVerbosity(verbosity),
+		validator.WithUnsupportedAction(unsupported),
+		validator.WithTerminatingAction(terminating),
+		validator.WithClientset(clientset),
+		validator.WithUniqueList(protected),
+		validator.WithAPITimeout(apiTimeout),
+		validator.WithAttribution(splitList(attributionExtra), attributeDenials),
+	}
+	if names := splitList(postProcessors); len(names) > 0 {
+		validatorOpts = append(validatorOpts, validator.WithRegisteredPostProcessors(names...))
+	}
+	var catalogs []validator.ValidationHandlerOption
+	if fromFile != nil && len(fromFile.Messages) > 0 {
+		catalogs = append(catalogs, validator.WithMessageCatalog(fromFile.Messages))
+	}
+	if messagesFile != "" {
+		catalog, err := loadCatalog(messagesFile)
+		if err != nil {
+			exitInvalid(logger.With(zap.String("file", messagesFile)), "Invalid message catalog", err)
 		}
-	}()
+		catalogs = append(catalogs, validator.WithMessageCatalog(catalog))
+	}
+	validatorOpts = append(validatorOpts, catalogs...)
+	if t := denialTemplate(logger, fromFile); t != nil {
+		validatorOpts = append(validatorOpts, validator.WithDenialTemplate(t))
+	}
+	exempt := validator.WithExemptNamespaces(splitList(exemptNamespaces)...)
+	validatorOpts = append(validatorOpts, exempt)
+	if probeInterval > 0 && slices.Contains(splitList(exemptNamespaces), probeNamespace) {
+		logger.Warn("The consistency probe validates its canary in an exempt namespace and can not detect diverging replicas", zap.String("namespace", probeNamespace))
+	}
+	if selfUsername == "" {
+		if selfUsername, err = kubeclient.InClusterUsername(); err != nil {
+			logger.Warn("Failed to determine own username, writes of the controller are validated like any other", zap.Error(err))
+		}
+	}
+	if selfUsername != "" {
+		logger.Info("Admitting own writes without validation", zap.String("username", selfUsername), zap.String("field_manager", validator.FieldManager))
+		validatorOpts = append(validatorOpts, validator.WithSelf(selfUsername))
+	}
+
+	if scanInterval > 0 {
+		opts := []scanner.ScannerOption{
+			scanner.WithLogger(logger.Named("scanner")),
+			scanner.WithClientset(clientset),
+			scanner.WithUniqueList(protected),
+			scanner.WithInterval(scanInterval),
+		}
+		if reportName != "" && reportNamespace != "" {
+			opts = append(opts, scanner.WithReportConfigMap(reportNamespace, reportName))
+		}
+		sc, err := scanner.NewScanner(opts...)
+		if err != nil {
+			logger.Fatal("Failed to create scanner", zap.Error(err))
+		}
+		configManager.Subscribe(func(c *config.Config) { sc.SetUniqueList(c.Protected) })
+		checker.Add("scanner", health.Degrading, sc.Healthy)
+		go sc.Run(ctx)
+		mux.Handle("/-/reindex", handler.NewChain(
+			handler.RequireAccess(authz, handler.ResourceReindex),
+			handler.RateLimit(rate.NewLimiter(rate.Every(reindexInterval), 1)),
+		).Then(handler.ReindexHandler(sc)))
+		validatorOpts = append(validatorOpts, validator.WithDegradedCheck(sc.Degraded), validator.WithLeaseLookup(sc))
+	}
+
+	if escalationThreshold > 0 {
+		validatorOpts = append(validatorOpts, validator.WithDenialEscalation(escalationThreshold, escalationWindow))
+	}
+
+	// Simulations only share the options affecting single decisions.
+	sim := &simulator{
+		clientset: clientset,
+		options: append([]validator.ValidationHandlerOption{
+			validator.WithWarningVerbosity(verbosity),
+			validator.WithUnsupportedAction(unsupported),
+			exempt,
+		}, catalogs...),
+	}
+
+	stopEvents := func() {}
+	if denialEvents {
+		var recorder record.EventRecorder
+		recorder, stopEvents = newDenialRecorder(clientset, denialEventWindow)
+		validatorOpts = append(validatorOpts, validator.WithDenialEvents(recorder))
+	}
+
+	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+	indexFastPath := featuregate.Default.Enabled(featuregate.IndexFastPath)
+	if decisionCacheTTL > 0 || valueFilterCapacity > 0 || indexFastPath {
+		informer := informerFactory.Core().V1().Services().Informer()
+		// Failing watches are retried by the informer, but must not go unnoticed.
+		informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			logger.Warn("Watch of services failed", zap.Error(err))
+		})
+		if decisionCacheTTL > 0 {
+			validatorOpts = append(validatorOpts, validator.WithDecisionCache(decisionCacheTTL, informer))
+		}
+		if valueFilterCapacity > 0 {
+			validatorOpts = append(validatorOpts, validator.WithValueFilters(informer, valueFilterCapacity, valueFilterFPRate))
+		}
+		if indexFastPath {
+			validatorOpts = append(validatorOpts, validator.WithIndexFastPath(informer))
+		}
+		checker.Add("informers/services", health.Degrading, func(context.Context) error {
+			if !informer.HasSynced() {
+				return errors.New("not synced")
+			}
+			return nil
+		})
+	}
+	if watchNamespaces {
+		informer := informerFactory.Core().V1().Namespaces().Informer()
+		informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			logger.Warn("Watch of namespaces failed", zap.Error(err))
+		})
+		validatorOpts = append(validatorOpts, validator.WithNamespaceInformer(informer))
+		checker.Add("informers/namespaces", health.Degrading, func(context.Context) error {
+			if !informer.HasSynced() {
+				return errors.New("not synced")
+			}
+			return nil
+		})
+	}
+
+	if namespaceModes {
+		validatorOpts = append(validatorOpts, validator.WithNamespaceModes(namespaceModeTTL))
+	}
+
+	validator, err := validator.NewValidationHandlerV1(validatorOpts...)
+	if err != nil {
+		logger.Fatal("Failed to create validation handler", zap.Error(err))
+	}
+	configManager.Subscribe(func(c *config.Config) { validator.SetUniqueList(c.Protected) })
+
+	codec, err := handler.NewCodec(jsonCodec)
+	if err != nil {
+		logger.Fatal("Invalid value for -json-codec", zap.Error(err))
+	}
+	handlerOpts := []handler.RequestHandlerOption{handler.WithCodec(codec), handler.WithAttributionExtra(splitList(attributionExtra))}
+	if debug {
+		handlerOpts = append(handlerOpts, handler.WithResponseValidation(hl), handler.WithDecisionTrace())
+	}
+	if sampler := (audit.Sampler{Fraction: sampleFraction, Denials: sampleDenials}); sampler.Enabled() {
+		handlerOpts = append(handlerOpts, handler.WithSampling(sampler, audit.LoggerSink(logger.Named("audit"))))
+	}
+	// Only reviews of the default domain are replayed against its configuration.
+	validateOpts := slices.Clone(handlerOpts)
+	var recorders []audit.Sink
+	if recorded != nil {
+		recorders = append(recorders, recorded)
+	}
+	if mirrorTo != "" {
+		client, err := adminClient(mirrorCA, false)
+		if err != nil {
+			logger.Fatal("Invalid value for -mirror-ca", zap.Error(err))
+		}
+		client.Timeout = apiTimeout
+		mirror := audit.NewMirror(logger.Named("mirror"), client, mirrorTo, serviceAccountTokenFile, mirrorQueue)
+		go mirror.Run(ctx)
+		logger.Info("Mirroring reviews", zap.String("url", mirrorTo))
+		recorders = append(recorders, mirror)
+	}
+	if len(recorders) > 0 {
+		validateOpts = append(validateOpts, handler.WithRecording(audit.Tee(recorders...)))
+	}
+	validateHandler, err := handler.AdmissionReviewRequesthandler(validator, validateOpts...)
+	if err != nil {
+		logger.Fatal("Failed to create request handler", zap.Error(err))
+	}
+	reviews := handler.NewChain()
+	if sloObjective > 0 {
+		var windows []time.Duration
+		for _, w := range splitList(sloWindows) {
+			d, err := time.ParseDuration(w)
+			if err != nil {
+				logger.Fatal("Invalid value for -slo-windows", zap.Error(err))
+			}
+			windows = append(windows, d)
+		}
+		tracker, err := slo.NewTracker(clock.RealClock{}, sloObjective, sloLatency, windows)
+		if err != nil {
+			logger.Fatal("Invalid SLO", zap.Error(err))
+		}
+		metrics.Registry.MustRegister(tracker)
+		reviews = reviews.Append(handler.SLO(tracker))
+	}
+	if maxConcurrentReviews > 0 {
+		overload, err := handler.ParseOverloadResponse(overloadResponse)
+		if err != nil {
+			logger.Fatal("Invalid value for -overload-response", zap.Error(err))
+		}
+		reviews = reviews.Append(handler.Backpressure(maxConcurrentReviews, queueTimeout, overload, retryAfter))
+	}
+	mux.Handle("/validate", reviews.Then(validateHandler))
+
+	domains := newPolicyDomains(logger.Named("handler").With(zap.String("handler", "validate")), validatorOpts, handlerOpts)
+	domains.update(configManager.Current())
+	configManager.Subscribe(domains.update)
+	mux.Handle(domainPrefix, reviews.Then(domains.mux))
+
+	if decisionCacheTTL > 0 && consistencyInterval > 0 {
+		cc, err := consistency.NewChecker(
+			consistency.WithLogger(logger.Named("consistency")),
+			consistency.WithClientset(clientset),
+			consistency.WithStore(informerFactory.Core().V1().Services().Informer().GetStore()),
+			consistency.WithInterval(consistencyInterval),
+			consistency.WithSampleSize(consistencySample),
+			consistency.WithReindex(consistencyThreshold, func() {
+				validator.FlushDecisionCache()
+				domains.flushDecisionCaches()
+			}))
+		if err != nil {
+			logger.Fatal("Failed to create consistency checker", zap.Error(err))
+		}
+		go cc.Run(ctx)
+	}
+
+	if probeInterval > 0 {
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.Fatal("Failed to determine identity for consistency probe", zap.Error(err))
+		}
+		prober, err := probe.NewProber(
+			probe.WithLogger(logger.Named("probe")),
+			probe.WithClientset(clientset),
+			probe.WithValidator(validator, probeCanary(configManager.Current, probeNamespace)),
+			probe.WithIdentity(probeNamespace, identity),
+			probe.WithInterval(probeInterval))
+		if err != nil {
+			logger.Fatal("Failed to create consistency probe", zap.Error(err))
+		}
+		go prober.Run(ctx)
+	}
+
+	if stateInterval > 0 {
+		identity, err := os.Hostname()
+		if err != nil {
+			logger.Fatal("Failed to determine identity for controller state", zap.Error(err))
+		}
+		dynamicClient, err := dynamic.NewForConfig(rotator.Config())
+		if err != nil {
+			logger.Fatal("Failed to create dynamic client", zap.Error(err))
+		}
+		publisher, err := state.NewPublisher(
+			state.WithLogger(logger.Named("state")),
+			state.WithClients(clientset, dynamicClient),
+			state.WithCollector(collectState(checker, configManager.Current)),
+			state.WithIdentity(stateNamespace, identity),
+			state.WithInterval(stateInterval))
+		if err != nil {
+			logger.Fatal("Failed to create controller state publisher", zap.Error(err))
+		}
+		go publisher.Run(ctx)
+	}
+
+	informerFactory.Start(ctx.Done())
+	go configManager.Run(ctx)
+	mux.Handle("/owner", handler.NewChain(
+		handler.RateLimit(rate.NewLimiter(rate.Every(time.Second), 5)),
+	).Then(handler.OwnerHandler(validator, authz)))
+	mux.Handle(handler.NamespaceMetricsPrefix, handler.NamespaceMetricsHandler(authz))
+	mux.Handle("/simulate", handler.NewChain(
+		handler.RequireAccess(authz, handler.ResourceSimulations),
+		handler.RateLimit(rate.NewLimiter(rate.Every(time.Second), 5)),
+	).Then(handler.SimulateHandler(sim)))
+	mux.Handle("/readyz", checker.Handler())
+	if shadowMode {
+		comparator, err := shadow.NewComparator(shadow.Gate{MinSamples: shadowMinSamples, MaxDisagreement: shadowMaxDisagreement}, 50)
+		if err != nil {
+			logger.Fatal("Invalid shadow report gate", zap.Error(err))
+		}
+		mux.Handle("/shadow", handler.ShadowHandler(validator, comparator, authz))
+		if webhookConfiguration != "" {
+			logger.Warn("Running in shadow mode with self-registration, which competes with the deployment mirroring its reviews", zap.String("configuration", webhookConfiguration))
+		}
+	}
+
+	if metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		metricsMux.Handle("/readyz", checker.Handler())
+		metricsSrv := newServer(metricsAddr, chain("metrics").Then(metricsMux))
+		go func() {
+			logger.Info("Starting metrics server", zap.String("addr", metricsAddr), zap.String("protocol", "http"))
+			if err := metricsSrv.ListenAndServe(); err != nil {
+				logger.Fatal("Failed to start metrics server", zap.Error(err))
+			}
+		}()
+	}
+
+	if adminAddr != "" {
+		adminMux := http.NewServeMux()
+		var override func(context.Context, *config.Config) error
+		if overrides != nil {
+			override = func(ctx context.Context, c *config.Config) error {
+				return configManager.Override(ctx, overrides, c)
+			}
+		}
+		adminMux.Handle("/admin/config", handler.ConfigHandler(configManager.Current, override, authz))
+		adminSrv := newServer(adminAddr, chain("admin").Append(handler.MaxBytes(maxRequestBytes)).Then(adminMux))
+		go func() {
+			logger.Info("Starting admin server", zap.String("addr", adminAddr), zap.String("protocol", "https"))
+			if err := adminSrv.ListenAndServeTLS(certFile, keyFile); err != nil {
+				logger.Fatal("Failed to start admin server", zap.Error(err))
+			}
+		}()
+	}
+
+	if len(addrs) == 0 {
+		addrs = addrList{":9090"}
+	}
+
+	// All listeners are opened before serving any of them, so that the
+	// webhook either serves on all addresses or fails to start.
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			logger.Fatal("Failed to listen", zap.String("addr", addr), zap.Error(err))
+		}
+		listeners = append(listeners, ln)
+	}
+
+	inFlight := handler.NewInFlight("webhook")
+	webhookChain := chain("webhook").Append(inFlight.Middleware(), handler.MaxBytes(maxRequestBytes))
+	if hosts := splitList(allowedHosts); len(hosts) > 0 {
+		webhookChain = webhookChain.Append(handler.AllowHosts(hosts))
+	}
+	webhook := webhookChain.Then(mux)
+	var ca *clientCA
+	if clientCAFile != "" {
+		if ca, err = newClientCA(logger.Named("tls"), certFile, keyFile, clientCAFile, clientCAGrace); err != nil {
+			logger.Fatal("Failed to load client CA", zap.String("file", clientCAFile), zap.Error(err))
+		}
+		go ca.Watch(ctx, clientCAInterval)
+	}
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, ln := range listeners {
+		srv := newServer(ln.Addr().String(), webhook)
+		srv.BaseContext = func(_ net.Listener) context.Context { return ctx }
+		if err := configureHTTP2(srv); err != nil {
+			logger.Fatal("Failed to configure HTTP server", zap.Error(err))
+		}
+		if ca != nil {
+			ca.configure(srv)
+		}
+		srv.RegisterOnShutdown(func() { logger.Info("HTTP server shutdown initiated", zap.String("addr", srv.Addr)) })
+		servers = append(servers, srv)
+
+		go func(ln net.Listener) {
+			logger.Info("Starting HTTP server", zap.String("addr", srv.Addr), zap.String("protocol", "http"))
+			if err := srv.ServeTLS(ln, certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start HTTP server", zap.String("addr", srv.Addr), zap.Error(err))
+			}
+		}(ln)
+	}
+
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	s := <-sigs
 	logger.Info("Shutting down", zap.String("signal", s.String()))
 
-	gracefuleCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelShutdown()
+	// The base context is only cancelled once draining is over, as cancelling
+	// it earlier would abort the very requests we try to drain.
+	defer cancel()
+
+	drained := drain(logger, servers, inFlight, shutdownGracePeriod)
+	stopEvents()
+	if !drained {
+		return 1
+	}
+	logger.Info("HTTP server shutdown complete")
+	return 0
+}
+
+// drain shuts all servers down in parallel, waiting up to grace for
+// in-flight requests to complete, and reports how many were drained and how
+// many had to be cut off. It returns false if any request was cut off.
+func drain(logger *zap.Logger, servers []*http.Server, inFlight *handler.InFlight, grace time.Duration) bool {
+	pending := inFlight.Count()
+	logger.Info("Draining in-flight requests", zap.Int64("in_flight", pending), zap.Duration("grace_period", grace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
 
-	if err := srv.Shutdown(gracefuleCtx); err != nil {
-		logger.Error("Failed to shutdown HTTP server gracefully", zap.Error(err))
-		defer os.Exit(1)
-		return
+	start := time.Now()
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}(i, srv)
+	}
+	wg.Wait()
+	err := errors.Join(errs...)
+
+	var cutOff int64
+	if err != nil {
+		cutOff = inFlight.Count()
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+	drained := max(pending-cutOff, 0)
+
+	metrics.ShutdownRequests.WithLabelValues("webhook", "drained").Add(float64(drained))
+	metrics.ShutdownRequests.WithLabelValues("webhook", "cut_off").Add(float64(cutOff))
+
+	if err != nil {
+		logger.Error("Failed to drain HTTP servers within grace period",
+			zap.Error(err), zap.Int64("drained", drained), zap.Int64("cut_off", cutOff), zap.Duration("elapsed", time.Since(start)))
+		return false
 	}
-	defer os.Exit(0)
+	logger.Info("Drained HTTP servers", zap.Int64("drained", drained), zap.Duration("elapsed", time.Since(start)))
+	return true
 }
@@ -0,0 +1,166 @@
+/*
+ *     manifests.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/unik-k8s/admission-controller/internal/preflight"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// runManifests implements the "manifests" commands. It returns the exit
+// code of the process.
+func runManifests(args []string) int {
+	fs := flag.NewFlagSet("manifests", flag.ExitOnError)
+	name := fs.String("name", "unik-admission-controller", "name of the ServiceAccount and prefix of the generated roles")
+	namespace := fs.String("namespace", os.Getenv("POD_NAMESPACE"), "namespace of the ServiceAccount")
+	// The flags of the webhook select the enabled features, so the
+	// arguments and environment of its Deployment can be passed along unchanged.
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s manifests [flags] rbac\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if err := applyEnv(fs, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if fs.NArg() != 1 || fs.Arg(0) != "rbac" {
+		fs.Usage()
+		return 2
+	}
+	if *namespace == "" {
+		fmt.Fprintln(os.Stderr, "-namespace is required")
+		return 2
+	}
+	// The report is published next to the webhook unless told otherwise.
+	if reportNamespace == "" {
+		reportNamespace = *namespace
+	}
+	if err := writeManifests(os.Stdout, rbacManifests(requiredPermissions(), *name, *namespace)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// rbacManifests returns the ClusterRole, Roles and bindings granting the
+// ServiceAccount name in namespace exactly the permissions in required.
+// Cluster wide permissions go into a ClusterRole, namespaced ones into a
+// Role per namespace.
+func rbacManifests(required []preflight.Permission, name, namespace string) []interface{} {
+	byNamespace := make(map[string][]preflight.Permission)
+	for _, p := range required {
+		byNamespace[p.Namespace] = append(byNamespace[p.Namespace], p)
+	}
+	namespaces := make([]string, 0, len(byNamespace))
+	for ns := range byNamespace {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace}}
+	var manifests []interface{}
+	for _, ns := range namespaces {
+		rules := policyRules(byNamespace[ns])
+		if ns == "" {
+			manifests = append(manifests,
+				&rbacv1.ClusterRole{
+					TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Rules:      rules,
+				},
+				&rbacv1.ClusterRoleBinding{
+					TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+					ObjectMeta: metav1.ObjectMeta{Name: name},
+					Subjects:   subjects,
+					RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+				})
+			continue
+		}
+		manifests = append(manifests,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+				Rules:      rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+				Subjects:   subjects,
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+			})
+	}
+	return manifests
+}
+
+// policyRules merges permissions on the same resource, restricted to the
+// same name, into one rule. Rules keep the order of their first permission.
+func policyRules(permissions []preflight.Permission) []rbacv1.PolicyRule {
+	type target struct{ group, resource, name string }
+	var (
+		order []target
+		verbs = make(map[target][]string)
+	)
+	for _, p := range permissions {
+		resource := p.Resource
+		if p.Subresource != "" {
+			resource += "/" + p.Subresource
+		}
+		t := target{p.Group, resource, p.Name}
+		if _, found := verbs[t]; !found {
+			order = append(order, t)
+		}
+		verbs[t] = append(verbs[t], p.Verb)
+	}
+
+	rules := make([]rbacv1.PolicyRule, 0, len(order))
+	for _, t := range order {
+		rule := rbacv1.PolicyRule{APIGroups: []string{t.group}, Resources: []string{t.resource}, Verbs: verbs[t]}
+		if t.name != "" {
+			rule.ResourceNames = []string{t.name}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// writeManifests writes manifests to out as a YAML stream.
+func writeManifests(out io.Writer, manifests []interface{}) error {
+	for _, m := range manifests {
+		data, err := yaml.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("marshalling manifest: %w", err)
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+ *     manifests_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/preflight"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestRBACManifests(t *testing.T) {
+	manifests := rbacManifests([]preflight.Permission{
+		{Verb: "get", Resource: "services"},
+		{Verb: "list", Resource: "services"},
+		{Verb: "update", Group: "unik.io", Resource: "uniqueannotationpolicies", Subresource: "status"},
+		{Verb: "update", Resource: "configmaps", Namespace: "unik", Name: "unik-report"},
+		{Verb: "create", Resource: "configmaps", Namespace: "unik"},
+	}, "unik", "unik")
+	require.Len(t, manifests, 4)
+
+	clusterRole := manifests[0].(*rbacv1.ClusterRole)
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{"unik.io"}, Resources: []string{"uniqueannotationpolicies/status"}, Verbs: []string{"update"}},
+	}, clusterRole.Rules)
+
+	role := manifests[2].(*rbacv1.Role)
+	assert.Equal(t, "unik", role.Namespace)
+	assert.Equal(t, []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{"unik-report"}, Verbs: []string{"update"}},
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"create"}},
+	}, role.Rules)
+
+	binding := manifests[3].(*rbacv1.RoleBinding)
+	assert.Equal(t, "Role", binding.RoleRef.Kind)
+	assert.Equal(t, []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "unik", Namespace: "unik"}}, binding.Subjects)
+
+	var out bytes.Buffer
+	require.NoError(t, writeManifests(&out, manifests))
+	assert.Contains(t, out.String(), "kind: ClusterRoleBinding\n")
+	assert.Equal(t, 4, bytes.Count(out.Bytes(), []byte("---\n")))
+}
@@ -0,0 +1,398 @@
+/*
+ *     migrate.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// runMigrate implements the "migrate" commands. It returns the exit code
+// of the process.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	file := fs.String("f", "", "manifest of the Deployment running the legacy binary; \"-\" reads from stdin")
+	namespace := fs.String("namespace", os.Getenv("POD_NAMESPACE"), "namespace of the Deployment running the legacy binary, if not read from -f")
+	deployment := fs.String("deployment", "unik-admission-controller", "name of the Deployment running the legacy binary, if not read from -f")
+	container := fs.String("container", "unik-admission-controller", "name of the container running the legacy binary")
+	configMap := fs.String("configmap", "unik-admission-controller-config", "name of the ConfigMap holding the migrated configuration")
+	verify := fs.Bool("verify", true, "verify the migrated configuration decides all existing services and the reviews given by -records like the legacy binary; requires cluster access")
+	records := fs.String("records", "", "file of recorded reviews to replay when verifying, one JSON record per line as logged with -sample-fraction or -sample-denials or mirrored with -mirror-to")
+	var kubeconfig kubeconfigFlags
+	kubeconfig.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s migrate [flags] legacy\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || fs.Arg(0) != "legacy" {
+		fs.Usage()
+		return 2
+	}
+	if *records != "" && !*verify {
+		fmt.Fprintln(os.Stderr, "-records requires -verify")
+		return 2
+	}
+
+	var clientset kubernetes.Interface
+	if *file == "" || *verify {
+		restConfig, err := kubeconfig.config()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+			return 1
+		}
+		if clientset, err = kubernetes.NewForConfig(restConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+			return 1
+		}
+	}
+
+	var (
+		d   *appsv1.Deployment
+		err error
+	)
+	switch *file {
+	case "":
+		d, err = clientset.AppsV1().Deployments(*namespace).Get(context.Background(), *deployment, metav1.GetOptions{})
+	case "-":
+		d, err = decodeDeployment(os.Stdin)
+	default:
+		var f *os.File
+		if f, err = os.Open(*file); err == nil {
+			d, err = decodeDeployment(f)
+			f.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reading deployment: %s\n", err)
+		return 1
+	}
+
+	args, err = containerArgs(d, *container)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	p, remaining, err := legacyFlags(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	migrated, err := legacyConfig(p)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *verify {
+		var recorded []audit.Record
+		if *records != "" {
+			f, err := os.Open(*records)
+			if err == nil {
+				recorded, err = readRecords(f)
+				f.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "reading records: %s\n", err)
+				return 1
+			}
+		}
+		if p.lease > 0 {
+			fmt.Fprintln(os.Stderr, "values reserved by leases are not known to the legacy binary outside of its scanner and are not verified")
+		}
+		differences, err := verifyMigration(context.Background(), clientset, p, migrated, recorded, os.Stderr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "verifying configuration: %s\n", err)
+			return 1
+		}
+		if differences > 0 {
+			fmt.Fprintf(os.Stderr, "%d reviews are decided differently\n", differences)
+			return 1
+		}
+	}
+
+	manifests, err := migratedManifests(d, *container, remaining, *configMap, migrated)
+	if err == nil {
+		err = writeManifests(os.Stdout, manifests)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "generate the RBAC manifests for the migrated deployment with: %s manifests -config-configmap=%s %s rbac\n", os.Args[0], *configMap, strings.Join(remaining, " "))
+	return 0
+}
+
+// decodeDeployment reads a Deployment manifest in YAML or JSON.
+func decodeDeployment(r io.Reader) (*appsv1.Deployment, error) {
+	d := &appsv1.Deployment{}
+	if err := utilyaml.NewYAMLOrJSONDecoder(r, 4096).Decode(d); err != nil {
+		return nil, err
+	}
+	if d.Kind != "Deployment" {
+		return nil, fmt.Errorf("expected a Deployment, got %q", d.Kind)
+	}
+	return d, nil
+}
+
+// containerArgs returns the arguments of the container called name in d.
+// A Deployment with a single container does not need to match name.
+func containerArgs(d *appsv1.Deployment, name string) ([]string, error) {
+	containers := d.Spec.Template.Spec.Containers
+	for _, c := range containers {
+		if c.Name == name || len(containers) == 1 {
+			return append(c.Command[min(len(c.Command), 1):], c.Args...), nil
+		}
+	}
+	return nil, fmt.Errorf("container %q not found in deployment %s/%s", name, d.Namespace, d.Name)
+}
+
+// legacyFlags parses the policy flags among args. The other arguments are
+// returned unchanged.
+func legacyFlags(args []string) (policyFlags, []string, error) {
+	var p policyFlags
+	fs := flag.NewFlagSet("legacy", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	p.register(fs)
+
+	var policy, remaining []string
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		f := fs.Lookup(name)
+		if f == nil || !strings.HasPrefix(args[i], "-") {
+			remaining = append(remaining, args[i])
+			continue
+		}
+		policy = append(policy, args[i])
+		if _, isBool := f.Value.(interface{ IsBoolFlag() bool }); !hasValue && !isBool && i+1 < len(args) {
+			i++
+			policy = append(policy, args[i])
+		}
+	}
+	if err := fs.Parse(policy); err != nil {
+		return p, nil, err
+	}
+	return p, remaining, nil
+}
+
+// legacyConfig translates the policy flags p into a configuration.
+func legacyConfig(p policyFlags) (*config.Config, error) {
+	annotation, err := p.annotation()
+	if err != nil {
+		return nil, err
+	}
+	c := &config.Config{Protected: validator.UniqueList{validator.ClusterScope: {annotation}}}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// verifyMigration decides all existing services as if they were created
+// anew, and replays recorded, once like the legacy binary configured with
+// p and once with migrated, reporting the reviews decided differently to
+// out. Both decide against the services existing now. A recorded creation
+// is replayed as the creation of the service now existing under its name,
+// if any, as that service holds its values since.
+func verifyMigration(ctx context.Context, clientset kubernetes.Interface, p policyFlags, migrated *config.Config, recorded []audit.Record, out io.Writer) (int, error) {
+	after, err := validator.NewValidationHandlerV1(validator.WithLogger(zap.NewNop()), validator.WithClientset(clientset), validator.WithUniqueList(migrated.Protected))
+	if err != nil {
+		return 0, err
+	}
+	services, err := validator.ListScope(ctx, clientset, validator.Cluster)
+	if err != nil {
+		return 0, err
+	}
+	live := make(map[types.NamespacedName]types.UID, len(services))
+	for _, svc := range services {
+		if svc.DeletionTimestamp == nil {
+			live[types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}] = svc.UID
+		}
+	}
+
+	reviews := make([]admissionv1.AdmissionReview, 0, len(services)+len(recorded))
+	for _, svc := range services {
+		review, err := createReview(svc)
+		if err != nil {
+			return 0, err
+		}
+		reviews = append(reviews, review)
+	}
+	for _, r := range recorded {
+		review, err := replayReview(r, live)
+		if err != nil {
+			return 0, fmt.Errorf("replaying review %s: %w", r.Request.UID, err)
+		}
+		reviews = append(reviews, review)
+	}
+
+	now := time.Now()
+	differences := 0
+	for _, review := range reviews {
+		b, err := decideLegacy(p, review.Request, services, now)
+		if err != nil {
+			return differences, fmt.Errorf("deciding review %s like the legacy binary: %w", review.Request.UID, err)
+		}
+		a := after.Validate(ctx, review)
+		if b.Allowed != a.Allowed {
+			differences++
+			var message string
+			if a.Result != nil {
+				message = a.Result.Message
+			}
+			fmt.Fprintf(out, "%s %s/%s: legacy allowed=%t %q, migrated allowed=%t %q\n", review.Request.Operation, review.Request.Namespace, review.Request.Name, b.Allowed, b.Message, a.Allowed, message)
+		}
+	}
+	return differences, nil
+}
+
+// createReview returns a review for the creation of svc.
+func createReview(svc corev1.Service) (admissionv1.AdmissionReview, error) {
+	svc.APIVersion, svc.Kind = "v1", "Service"
+	raw, err := json.Marshal(svc)
+	if err != nil {
+		return admissionv1.AdmissionReview{}, err
+	}
+	return admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		UID:       types.UID("migrate-" + string(svc.UID)),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+		Name:      svc.Name,
+		Namespace: svc.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}, nil
+}
+
+// replayReview returns a review for the request of r. A creation of a
+// service existing in live gets the UID of that service, which the
+// request could not carry yet.
+func replayReview(r audit.Record, live map[types.NamespacedName]types.UID) (admissionv1.AdmissionReview, error) {
+	req := r.Request.DeepCopy()
+	uid, found := live[types.NamespacedName{Namespace: req.Namespace, Name: req.Name}]
+	if req.Operation == admissionv1.Create && req.Resource == serviceResource && found && len(req.Object.Raw) > 0 {
+		svc := corev1.Service{}
+		if err := json.Unmarshal(req.Object.Raw, &svc); err != nil {
+			return admissionv1.AdmissionReview{}, fmt.Errorf("decoding object: %w", err)
+		}
+		if svc.UID == "" {
+			svc.UID = uid
+			raw, err := json.Marshal(svc)
+			if err != nil {
+				return admissionv1.AdmissionReview{}, err
+			}
+			req.Object.Raw = raw
+		}
+	}
+	return admissionv1.AdmissionReview{Request: req}, nil
+}
+
+// readRecords reads the recorded reviews in r, either audit.Records as
+// mirrored to a shadow deployment or the log lines of sampled reviews
+// carrying them. Lines without a request are skipped.
+func readRecords(r io.Reader) ([]audit.Record, error) {
+	var records []audit.Record
+	decoder := json.NewDecoder(r)
+	for {
+		var line struct {
+			audit.Record
+			// Logged is the record of a log line of a sampled review.
+			Logged *audit.Record `json:"record"`
+		}
+		err := decoder.Decode(&line)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := line.Record
+		if line.Logged != nil {
+			record = *line.Logged
+		}
+		if record.Request != nil {
+			records = append(records, record)
+		}
+	}
+}
+
+// migratedManifests returns a ConfigMap called configMap holding migrated
+// and a copy of d whose container runs with remaining, the arguments left
+// after removing the policy flags, and reads its configuration from the
+// ConfigMap instead.
+func migratedManifests(d *appsv1.Deployment, container string, remaining []string, configMap string, migrated *config.Config) ([]interface{}, error) {
+	data, err := yaml.Marshal(migrated)
+	if err != nil {
+		return nil, err
+	}
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: configMap, Namespace: d.Namespace, Labels: d.Labels},
+		Data:       map[string]string{config.ConfigMapKey: string(data)},
+	}
+
+	migratedDeployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        d.Name,
+			Namespace:   d.Namespace,
+			Labels:      d.Labels,
+			Annotations: d.Annotations,
+		},
+		Spec: *d.Spec.DeepCopy(),
+	}
+	delete(migratedDeployment.Annotations, corev1.LastAppliedConfigAnnotation)
+	args := append(append([]string(nil), remaining...), "-config-configmap="+configMap)
+	if d.Namespace != "" {
+		args = append(args, "-config-namespace="+d.Namespace)
+	}
+	containers := migratedDeployment.Spec.Template.Spec.Containers
+	for i := range containers {
+		if containers[i].Name == container || len(containers) == 1 {
+			containers[i].Command = containers[i].Command[:min(len(containers[i].Command), 1)]
+			containers[i].Args = args
+			break
+		}
+	}
+
+	return []interface{}{cm, migratedDeployment}, nil
+}
@@ -0,0 +1,216 @@
+/*
+ *     migrate_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/audit"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+)
+
+const legacyDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unik-admission-controller
+spec:
+  template:
+    spec:
+      containers:
+        - name: unik-admission-controller
+          args:
+            - '-addr=:8443'
+            - '-immutable'
+            - '-require'
+            - 'warn'
+            - '-pool=a,b'
+            - '-cert=/etc/webhook/certs/tls.crt'
+`
+
+func TestLegacyConfig(t *testing.T) {
+	d, err := decodeDeployment(strings.NewReader(legacyDeployment))
+	require.NoError(t, err)
+	args, err := containerArgs(d, "unik-admission-controller")
+	require.NoError(t, err)
+
+	p, remaining, err := legacyFlags(args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-addr=:8443", "-cert=/etc/webhook/certs/tls.crt"}, remaining)
+	c, err := legacyConfig(p)
+	require.NoError(t, err)
+
+	require.Len(t, c.Protected[validator.ClusterScope], 1)
+	annotation := c.Protected[validator.ClusterScope][0]
+	assert.Equal(t, validator.AnnotationNcpSnatPool, annotation.Key)
+	assert.True(t, annotation.Immutable)
+	assert.Equal(t, validator.RequirementWarn, annotation.Required.Action)
+	assert.Equal(t, []string{"a", "b"}, annotation.Pool)
+
+	manifests, err := migratedManifests(d, "unik-admission-controller", remaining, "unik-config", c)
+	require.NoError(t, err)
+	require.Len(t, manifests, 2)
+	cm := manifests[0].(*corev1.ConfigMap)
+	assert.Equal(t, "unik-config", cm.Name)
+	loaded := &config.Config{}
+	require.NoError(t, yaml.Unmarshal([]byte(cm.Data[config.ConfigMapKey]), loaded))
+	assert.Equal(t, c, loaded)
+	migrated := manifests[1].(*appsv1.Deployment)
+	assert.Equal(t, []string{"-addr=:8443", "-cert=/etc/webhook/certs/tls.crt", "-config-configmap=unik-config"}, migrated.Spec.Template.Spec.Containers[0].Args)
+	assert.Equal(t, []string{"-addr=:8443", "-immutable", "-require", "warn", "-pool=a,b", "-cert=/etc/webhook/certs/tls.crt"}, d.Spec.Template.Spec.Containers[0].Args, "the legacy deployment is left alone")
+}
+
+func TestLegacyConfigInvalid(t *testing.T) {
+	p, _, err := legacyFlags([]string{"-require=sometimes"})
+	require.NoError(t, err)
+	_, err = legacyConfig(p)
+	assert.Error(t, err)
+}
+
+func TestVerifyMigration(t *testing.T) {
+	svc := func(name, value string) *corev1.Service {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        name,
+			UID:         types.UID("uid-" + name),
+			Annotations: map[string]string{validator.AnnotationNcpSnatPool: value},
+		}}
+	}
+	tc := testclient.NewSimpleClientset(svc("a", "x"), svc("b", "x"), svc("c", "y"))
+	record := func(op admissionv1.Operation, obj, old *corev1.Service) audit.Record {
+		raw := func(s *corev1.Service) runtime.RawExtension {
+			if s == nil {
+				return runtime.RawExtension{}
+			}
+			data, err := json.Marshal(s)
+			require.NoError(t, err)
+			return runtime.RawExtension{Raw: data}
+		}
+		return audit.Record{Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("recorded-" + obj.Name),
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+			Namespace: obj.Namespace,
+			Name:      obj.Name,
+			Operation: op,
+			Object:    raw(obj),
+			OldObject: raw(old),
+		}}
+	}
+	created := svc("c", "y")
+	created.UID = ""
+	recorded := []audit.Record{
+		record(admissionv1.Create, created, nil),
+		record(admissionv1.Create, svc("d", "y"), nil),
+		record(admissionv1.Update, svc("c", "z"), svc("c", "y")),
+	}
+
+	p, _, err := legacyFlags([]string{"-immutable"})
+	require.NoError(t, err)
+	migrated, err := legacyConfig(p)
+	require.NoError(t, err)
+
+	differences, err := verifyMigration(context.Background(), tc, p, migrated, recorded, io.Discard)
+	require.NoError(t, err)
+	assert.Zero(t, differences)
+
+	var out strings.Builder
+	mutable, err := legacyConfig(policyFlags{})
+	require.NoError(t, err)
+	differences, err = verifyMigration(context.Background(), tc, p, mutable, recorded, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 1, differences, "the legacy binary denies changing the value")
+	assert.Contains(t, out.String(), "UPDATE default/c: legacy allowed=false")
+}
+
+func TestDecideLegacy(t *testing.T) {
+	now := time.Now()
+	deleted := metav1.NewTime(now.Add(-time.Hour))
+	services := []corev1.Service{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web", UID: "old", DeletionTimestamp: &deleted, Annotations: map[string]string{validator.AnnotationNcpSnatPool: "x"}}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "db", Annotations: map[string]string{validator.AnnotationNcpSnatPool: "y"}}},
+	}
+	request := func(namespace, name, value string, serviceType corev1.ServiceType) *admissionv1.AdmissionRequest {
+		svc := corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Spec: corev1.ServiceSpec{Type: serviceType}}
+		if value != "" {
+			svc.Annotations = map[string]string{validator.AnnotationNcpSnatPool: value}
+		}
+		raw, err := json.Marshal(svc)
+		require.NoError(t, err)
+		return &admissionv1.AdmissionRequest{
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+			Namespace: namespace,
+			Name:      name,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}
+	}
+	testCases := []struct {
+		desc    string
+		flags   policyFlags
+		req     *admissionv1.AdmissionRequest
+		allowed bool
+	}{
+		{"unique", policyFlags{}, request("team-c", "web", "z", ""), true},
+		{"held in another namespace", policyFlags{}, request("team-c", "web", "y", ""), false},
+		{"self by name", policyFlags{}, request("team-a", "web", "x", ""), true},
+		{"held by terminating service", policyFlags{}, request("team-c", "web", "x", ""), false},
+		{"released terminating service", policyFlags{releaseTerminatingAfter: time.Minute}, request("team-c", "web", "x", ""), true},
+		{"required", policyFlags{require: "deny"}, request("team-c", "lb", "", corev1.ServiceTypeLoadBalancer), false},
+		{"required with warning", policyFlags{require: "warn"}, request("team-c", "lb", "", corev1.ServiceTypeLoadBalancer), true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			decision, err := decideLegacy(tC.flags, tC.req, services, now)
+			require.NoError(t, err)
+			assert.Equal(t, tC.allowed, decision.Allowed, decision.Message)
+		})
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	input := `{"time":"2024-01-01T00:00:00Z","request":{"uid":"mirrored"},"response":{"uid":"mirrored","allowed":true}}
+{"level":"info","msg":"Sampled admission review","uid":"sampled","record":{"request":{"uid":"sampled"}}}
+{"level":"info","msg":"Configuration changed"}
+`
+	records, err := readRecords(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, types.UID("mirrored"), records[0].Request.UID)
+	assert.Equal(t, types.UID("sampled"), records[1].Request.UID)
+
+	_, err = readRecords(strings.NewReader("{"))
+	assert.Error(t, err)
+}
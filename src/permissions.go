@@ -0,0 +1,117 @@
+/*
+ *     permissions.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"github.com/unik-k8s/admission-controller/internal/featuregate"
+	"github.com/unik-k8s/admission-controller/internal/preflight"
+)
+
+// requiredPermissions returns the permissions needed by the features enabled
+// on the command line. It mirrors kustomize/base/rbac.yaml.
+func requiredPermissions() []preflight.Permission {
+	required := []preflight.Permission{
+		{Verb: "get", Resource: "services"},
+		{Verb: "list", Resource: "services"},
+		// The admin endpoints authorize their callers.
+		{Verb: "create", Group: "authentication.k8s.io", Resource: "tokenreviews"},
+		{Verb: "create", Group: "authorization.k8s.io", Resource: "subjectaccessreviews"},
+	}
+	if decisionCacheTTL > 0 || valueFilterCapacity > 0 || featuregate.Default.Enabled(featuregate.IndexFastPath) {
+		required = append(required, preflight.Permission{Verb: "watch", Resource: "services"})
+	}
+	if namespaceModes {
+		required = append(required, preflight.Permission{Verb: "get", Resource: "namespaces"})
+	}
+	if watchNamespaces {
+		required = append(required,
+			preflight.Permission{Verb: "list", Resource: "namespaces"},
+			preflight.Permission{Verb: "watch", Resource: "namespaces"})
+	}
+	if configMapName != "" {
+		required = append(required,
+			preflight.Permission{Verb: "get", Resource: "configmaps", Namespace: configMapNamespace, Name: configMapName},
+			preflight.Permission{Verb: "list", Resource: "configmaps", Namespace: configMapNamespace, Name: configMapName},
+			preflight.Permission{Verb: "watch", Resource: "configmaps", Namespace: configMapNamespace, Name: configMapName})
+	}
+	if discoverConfigs {
+		required = append(required,
+			preflight.Permission{Verb: "list", Resource: "configmaps"},
+			preflight.Permission{Verb: "watch", Resource: "configmaps"})
+	}
+	if configSecretName != "" {
+		required = append(required,
+			preflight.Permission{Verb: "get", Resource: "secrets", Namespace: configMapNamespace, Name: configSecretName},
+			preflight.Permission{Verb: "list", Resource: "secrets", Namespace: configMapNamespace, Name: configSecretName},
+			preflight.Permission{Verb: "watch", Resource: "secrets", Namespace: configMapNamespace, Name: configSecretName})
+	}
+	if adminAddr != "" && overridesConfigMap != "" {
+		required = append(required,
+			preflight.Permission{Verb: "get", Resource: "configmaps", Namespace: configMapNamespace, Name: overridesConfigMap},
+			preflight.Permission{Verb: "list", Resource: "configmaps", Namespace: configMapNamespace, Name: overridesConfigMap},
+			preflight.Permission{Verb: "watch", Resource: "configmaps", Namespace: configMapNamespace, Name: overridesConfigMap},
+			preflight.Permission{Verb: "update", Resource: "configmaps", Namespace: configMapNamespace, Name: overridesConfigMap},
+			// Names cannot be restricted for create.
+			preflight.Permission{Verb: "create", Resource: "configmaps", Namespace: configMapNamespace})
+	}
+	if policies {
+		required = append(required,
+			preflight.Permission{Verb: "list", Group: "unik.io", Resource: "uniqueannotationpolicies"},
+			preflight.Permission{Verb: "watch", Group: "unik.io", Resource: "uniqueannotationpolicies"},
+			preflight.Permission{Verb: "update", Group: "unik.io", Resource: "uniqueannotationpolicies", Subresource: "status"})
+	}
+	if denialEvents {
+		required = append(required,
+			preflight.Permission{Verb: "create", Resource: "events"},
+			preflight.Permission{Verb: "patch", Resource: "events"})
+	}
+	if scanInterval > 0 && reportName != "" && reportNamespace != "" {
+		required = append(required,
+			preflight.Permission{Verb: "update", Resource: "configmaps", Namespace: reportNamespace, Name: reportName},
+			// Names cannot be restricted for create.
+			preflight.Permission{Verb: "create", Resource: "configmaps", Namespace: reportNamespace})
+	}
+	if probeInterval > 0 {
+		for _, verb := range []string{"get", "list", "create", "update", "delete"} {
+			required = append(required, preflight.Permission{Verb: verb, Group: "coordination.k8s.io", Resource: "leases", Namespace: probeNamespace})
+		}
+	}
+	if stateInterval > 0 {
+		for _, verb := range []string{"get", "create", "update"} {
+			required = append(required, preflight.Permission{Verb: verb, Group: "coordination.k8s.io", Resource: "leases", Namespace: stateNamespace})
+		}
+		required = append(required,
+			preflight.Permission{Verb: "get", Group: "unik.io", Resource: "unikcontrollerstates"},
+			preflight.Permission{Verb: "create", Group: "unik.io", Resource: "unikcontrollerstates"},
+			preflight.Permission{Verb: "update", Group: "unik.io", Resource: "unikcontrollerstates", Subresource: "status"})
+	}
+	if checkConflicts {
+		required = append(required, preflight.Permission{Verb: "list", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations"})
+	}
+	if mirrorTo != "" {
+		required = append(required, preflight.Permission{Verb: "create", Group: "unik.io", Resource: "shadowreviews"})
+	}
+	if webhookConfiguration != "" {
+		required = append(required,
+			preflight.Permission{Verb: "get", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Name: webhookConfiguration},
+			preflight.Permission{Verb: "update", Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations", Name: webhookConfiguration})
+	}
+	return required
+}
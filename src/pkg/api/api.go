@@ -0,0 +1,67 @@
+/*
+ *     api.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package api holds the types exchanged with the introspection endpoints
+// of the webhook. They are shared by the handlers serving the endpoints and
+// by pkg/client, so both sides agree on the wire format.
+package api
+
+import (
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SimulateRequest is the body of POST /simulate.
+type SimulateRequest struct {
+	// Config is the candidate configuration.
+	Config config.Config `json:"config"`
+	// Domain selects the policy domain of Config to simulate. By default,
+	// the annotations of Config.Protected are used.
+	Domain string `json:"domain,omitempty"`
+	// Objects are reviewed in order. Admitted objects are visible to the
+	// reviews of the objects after them.
+	Objects []corev1.Service `json:"objects"`
+}
+
+// SimulatedDecision is the decision on one of the objects of a SimulateRequest.
+type SimulatedDecision struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Allowed   bool     `json:"allowed"`
+	Reason    string   `json:"reason"`
+	Message   string   `json:"message,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// SimulateResponse is the response of POST /simulate.
+type SimulateResponse struct {
+	Decisions []SimulatedDecision `json:"decisions"`
+}
+
+// OwnerResponse is the response of GET /owner.
+type OwnerResponse struct {
+	Annotation        string      `json:"annotation"`
+	Value             string      `json:"value"`
+	Namespace         string      `json:"namespace"`
+	Name              string      `json:"name"`
+	UID               types.UID   `json:"uid"`
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+}
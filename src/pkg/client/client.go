@@ -0,0 +1,323 @@
+/*
+ *     client.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package client calls the introspection and admin endpoints of the
+// webhook. Requests are authenticated with a bearer token, which the
+// webhook reviews and authorizes like the apiserver would, so callers need
+// the RBAC permissions on the virtual resources of the endpoints.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/pkg/api"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type (
+	// SimulateRequest is the body of Decisions.
+	SimulateRequest = api.SimulateRequest
+	// Decision is the decision on one object of a SimulateRequest.
+	Decision = api.SimulatedDecision
+	// Owner describes the service holding a value.
+	Owner = api.OwnerResponse
+)
+
+var (
+	// ErrNoAdminURL is returned by the methods calling the admin server if
+	// the client was created without WithAdminURL.
+	ErrNoAdminURL = errors.New("no admin URL given")
+	// ErrPoolExhausted is returned by Allocate if all values of the pool
+	// are in use.
+	ErrPoolExhausted = errors.New("all values of the pool are in use")
+)
+
+// StatusError is returned if the webhook answers with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound reports whether err is a StatusError for 404 Not Found.
+func IsNotFound(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusNotFound
+}
+
+// IsConflict reports whether err is a StatusError for 409 Conflict.
+func IsConflict(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.StatusCode == http.StatusConflict
+}
+
+// Client calls the endpoints of one webhook deployment.
+type Client struct {
+	webhook *url.URL
+	admin   *url.URL
+	http    *http.Client
+	token   func() (string, error)
+}
+
+type ClientOption func(*Client) error
+
+// WithHTTPClient sets the client used for requests, for example to trust
+// the CA of the webhook. Defaults to http.DefaultClient.
+func WithHTTPClient(c *http.Client) ClientOption {
+	return func(cl *Client) error {
+		if c == nil {
+			return errors.New("http client is nil")
+		}
+		cl.http = c
+		return nil
+	}
+}
+
+// WithAdminURL sets the base URL of the admin server given by -admin-addr,
+// needed for Policies, SetPolicies, Explain and Allocate.
+func WithAdminURL(raw string) ClientOption {
+	return func(c *Client) error {
+		u, err := parseBaseURL(raw)
+		if err != nil {
+			return fmt.Errorf("admin URL: %w", err)
+		}
+		c.admin = u
+		return nil
+	}
+}
+
+// WithToken authenticates requests with token.
+func WithToken(token string) ClientOption {
+	return func(c *Client) error {
+		if token == "" {
+			return errors.New("token is empty")
+		}
+		c.token = func() (string, error) { return token, nil }
+		return nil
+	}
+}
+
+// WithTokenFile authenticates requests with the token in the file at path,
+// which is read for every request, so rotated service account tokens are
+// picked up.
+func WithTokenFile(path string) ClientOption {
+	return func(c *Client) error {
+		if path == "" {
+			return errors.New("token file is empty")
+		}
+		c.token = func() (string, error) {
+			token, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("reading token: %w", err)
+			}
+			return strings.TrimSpace(string(token)), nil
+		}
+		return nil
+	}
+}
+
+// New returns a client for the webhook server at webhookURL, for example
+// "https://unik-admission-controller.unik.svc".
+func New(webhookURL string, options ...ClientOption) (*Client, error) {
+	u, err := parseBaseURL(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("webhook URL: %w", err)
+	}
+	c := &Client{webhook: u, http: http.DefaultClient}
+	for _, option := range options {
+		if err := option(c); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func parseBaseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute http or https URL", raw)
+	}
+	return u, nil
+}
+
+// Policies returns the configuration currently in effect.
+func (c *Client) Policies(ctx context.Context) (*config.Config, error) {
+	if c.admin == nil {
+		return nil, ErrNoAdminURL
+	}
+	var current config.Config
+	if err := c.do(ctx, http.MethodGet, c.admin, "/admin/config", nil, nil, &current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// SetPolicies overrides the configuration with next at runtime and returns
+// the resulting configuration. The webhook stores overrides in the ConfigMap
+// given by -config-overrides-configmap, from which all replicas pick them
+// up; without it, overrides are refused.
+func (c *Client) SetPolicies(ctx context.Context, next *config.Config) (*config.Config, error) {
+	if c.admin == nil {
+		return nil, ErrNoAdminURL
+	}
+	var current config.Config
+	if err := c.do(ctx, http.MethodPut, c.admin, "/admin/config", nil, next, &current); err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// Decisions returns the decisions the candidate configuration of req
+// produces for its objects, without changing the configuration or the
+// cluster.
+func (c *Client) Decisions(ctx context.Context, req SimulateRequest) ([]Decision, error) {
+	var resp api.SimulateResponse
+	if err := c.do(ctx, http.MethodPost, c.webhook, "/simulate", nil, req, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Decisions, nil
+}
+
+// Explain returns the decision the configuration currently in effect
+// makes for svc, including the reason and message of a denial.
+func (c *Client) Explain(ctx context.Context, svc corev1.Service) (*Decision, error) {
+	current, err := c.Policies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	decisions, err := c.Decisions(ctx, SimulateRequest{Config: *current, Objects: []corev1.Service{svc}})
+	if err != nil {
+		return nil, err
+	}
+	if len(decisions) != 1 {
+		return nil, fmt.Errorf("expected 1 decision, got %d", len(decisions))
+	}
+	return &decisions[0], nil
+}
+
+// Owner returns the service holding value of annotation. For annotations
+// protected per namespace, namespace selects the scope. A value not in
+// use is reported as an error satisfying IsNotFound, a value held in a
+// namespace the caller may not access as one satisfying IsConflict.
+func (c *Client) Owner(ctx context.Context, namespace, annotation, value string) (*Owner, error) {
+	query := url.Values{"annotation": {annotation}, "value": {value}}
+	if namespace != "" {
+		query.Set("namespace", namespace)
+	}
+	var owner Owner
+	if err := c.do(ctx, http.MethodGet, c.webhook, "/owner", query, nil, &owner); err != nil {
+		return nil, err
+	}
+	return &owner, nil
+}
+
+// Allocate returns the first value of the pool of annotation which is not
+// in use, including values held in namespaces the caller may not access.
+// The value is not reserved; a service claiming it can still be denied if
+// another one claimed it first.
+func (c *Client) Allocate(ctx context.Context, namespace, annotation string) (string, error) {
+	current, err := c.Policies(ctx)
+	if err != nil {
+		return "", err
+	}
+	var pool []string
+	for _, a := range current.Protected.ProtectedInNamespace(namespace) {
+		if a.Key == annotation {
+			pool = a.Pool
+			break
+		}
+	}
+	if len(pool) == 0 {
+		return "", fmt.Errorf("annotation %q has no pool in namespace %q", annotation, namespace)
+	}
+	for _, value := range pool {
+		_, err := c.Owner(ctx, namespace, annotation, value)
+		switch {
+		case IsNotFound(err):
+			return value, nil
+		case IsConflict(err):
+			// Owners in namespaces the caller may not see still hold the value.
+			continue
+		case err != nil:
+			return "", err
+		}
+	}
+	return "", ErrPoolExhausted
+}
+
+// do sends a request with the JSON encoding of body, if not nil, to path
+// below base and decodes the response into out.
+func (c *Client) do(ctx context.Context, method string, base *url.URL, path string, query url.Values, body, out any) error {
+	u := base.JoinPath(path)
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != nil {
+		token, err := c.token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+ *     client_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/pkg/api"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// tokenAuthorizer allows all requests carrying the bearer token "secret".
+type tokenAuthorizer struct{}
+
+func (tokenAuthorizer) Authorize(r *http.Request, _ authorizationv1.ResourceAttributes) (bool, error) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		return false, handler.ErrUnauthenticated
+	}
+	return true, nil
+}
+
+// holders maps values of any annotation to the service holding them.
+type holders map[string]*corev1.Service
+
+func (h holders) LookupOwner(_ context.Context, _, _, value string) (*corev1.Service, error) {
+	return h[value], nil
+}
+
+// namespaceAuthorizer allows reading the configuration, and looking up
+// owners in namespace only.
+type namespaceAuthorizer struct {
+	namespace string
+}
+
+func (a namespaceAuthorizer) Authorize(_ *http.Request, attrs authorizationv1.ResourceAttributes) (bool, error) {
+	return attrs.Resource != handler.ResourceOwners.Resource || attrs.Namespace == a.namespace, nil
+}
+
+type simulator struct{}
+
+func (simulator) Simulate(_ context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error) {
+	decisions := make([]api.SimulatedDecision, len(req.Objects))
+	for i, svc := range req.Objects {
+		_, protected := req.Config.Protected[svc.Namespace]
+		decisions[i] = api.SimulatedDecision{Namespace: svc.Namespace, Name: svc.Name, Allowed: !protected}
+	}
+	return decisions, nil
+}
+
+func TestClient(t *testing.T) {
+	current := &config.Config{Protected: validator.UniqueList{"team-a": {{Key: "example.com/ip", Pool: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}}}}}
+	admin := httptest.NewServer(handler.ConfigHandler(func() *config.Config { return current }, func(_ context.Context, c *config.Config) error {
+		current = c
+		return nil
+	}, tokenAuthorizer{}))
+	defer admin.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/owner", handler.OwnerHandler(holders{
+		"10.0.0.1": {ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "web"}},
+	}, tokenAuthorizer{}))
+	mux.Handle("/simulate", handler.SimulateHandler(simulator{}))
+	webhook := httptest.NewServer(mux)
+	defer webhook.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+	c, err := New(webhook.URL, WithAdminURL(admin.URL), WithTokenFile(tokenFile))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	policies, err := c.Policies(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, current, policies)
+
+	owner, err := c.Owner(ctx, "team-a", "example.com/ip", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "web", owner.Name)
+	_, err = c.Owner(ctx, "team-a", "example.com/ip", "10.0.0.2")
+	assert.True(t, IsNotFound(err))
+
+	value, err := c.Allocate(ctx, "team-a", "example.com/ip")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", value)
+	_, err = c.Allocate(ctx, "team-b", "example.com/ip")
+	assert.ErrorContains(t, err, "has no pool")
+
+	decision, err := c.Explain(ctx, corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "db"}})
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+
+	next := &config.Config{Protected: validator.UniqueList{"team-b": {{Key: "example.com/ip"}}}}
+	policies, err = c.SetPolicies(ctx, next)
+	require.NoError(t, err)
+	assert.Equal(t, next.Protected, policies.Protected)
+
+	unauthenticated, err := New(webhook.URL, WithAdminURL(admin.URL))
+	require.NoError(t, err)
+	_, err = unauthenticated.Policies(ctx)
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusUnauthorized, se.StatusCode)
+
+	withoutAdmin, err := New(webhook.URL)
+	require.NoError(t, err)
+	_, err = withoutAdmin.Explain(ctx, corev1.Service{})
+	assert.ErrorIs(t, err, ErrNoAdminURL)
+
+	_, err = New("unik.svc")
+	assert.Error(t, err)
+}
+
+func TestAllocateHiddenOwner(t *testing.T) {
+	current := &config.Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "example.com/ip", Pool: []string{"10.0.0.1", "10.0.0.2"}}}}}
+	admin := httptest.NewServer(handler.ConfigHandler(func() *config.Config { return current }, nil, namespaceAuthorizer{"team-a"}))
+	defer admin.Close()
+	webhook := httptest.NewServer(handler.OwnerHandler(holders{
+		"10.0.0.1": {ObjectMeta: metav1.ObjectMeta{Namespace: "team-b", Name: "web"}},
+	}, namespaceAuthorizer{"team-a"}))
+	defer webhook.Close()
+
+	c, err := New(webhook.URL, WithAdminURL(admin.URL))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.Owner(ctx, "team-a", "example.com/ip", "10.0.0.1")
+	assert.True(t, IsConflict(err), "the owner in team-b is not disclosed")
+
+	value, err := c.Allocate(ctx, "team-a", "example.com/ip")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", value, "values held in hidden namespaces are in use")
+}
@@ -0,0 +1,361 @@
+/*
+ *     config.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package config loads the configuration of the admission controller from
+// several sources, merges and validates it, and notifies subscribers about
+// changes, so that components can be reconfigured without a restart.
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultDomain names the policy domain formed by Config.Protected in logs
+// and metrics.
+const DefaultDomain = "default"
+
+// Config is the part of the configuration which can change at runtime.
+type Config struct {
+	// Protected lists the protected annotations per scope.
+	Protected validator.UniqueList `json:"protected,omitempty"`
+
+	// Domains are independent sets of protected annotations by name, for
+	// example for network and DNS annotations owned by different teams.
+	// Each domain is served on its own path and validated by its own
+	// handler, so a change to one domain does not affect the others.
+	Domains map[string]validator.UniqueList `json:"domains,omitempty"`
+}
+
+// Hash returns the hex encoded SHA-256 of c, which is equal for equal
+// configurations.
+func (c *Config) Hash() string {
+	// Maps are encoded with sorted keys, and a Config always encodes.
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Merge combines configs in order of increasing precedence. A scope set by
+// a config replaces the same scope of all configs before it; an empty list
+// of annotations removes the scope. Domains are merged the same way, scope
+// by scope, and removed once they have no scopes left. nil configs are skipped.
+func Merge(configs ...*Config) *Config {
+	merged := &Config{Protected: validator.UniqueList{}}
+	for _, c := range configs {
+		if c == nil {
+			continue
+		}
+		mergeList(merged.Protected, c.Protected)
+		for name, list := range c.Domains {
+			if merged.Domains == nil {
+				merged.Domains = make(map[string]validator.UniqueList)
+			}
+			if merged.Domains[name] == nil {
+				merged.Domains[name] = validator.UniqueList{}
+			}
+			mergeList(merged.Domains[name], list)
+			if len(merged.Domains[name]) == 0 {
+				delete(merged.Domains, name)
+			}
+		}
+	}
+	return merged
+}
+
+// mergeList merges the scopes of src into dst.
+func mergeList(dst, src validator.UniqueList) {
+	for scope, annotations := range src {
+		if len(annotations) == 0 {
+			delete(dst, scope)
+			continue
+		}
+		dst[scope] = annotations
+	}
+}
+
+// Validate checks c for errors and returns all of them.
+func (c *Config) Validate() error {
+	errs := validateList(c.Protected)
+	for name, list := range c.Domains {
+		if name == DefaultDomain {
+			errs = append(errs, &Problem{Domain: name, Message: "reserved for the protected annotations outside of domains"})
+		} else if msgs := validation.IsDNS1123Label(name); len(msgs) > 0 {
+			errs = append(errs, &Problem{Domain: name, Message: "invalid name: " + strings.Join(msgs, ", ")})
+		}
+		for _, err := range validateList(list) {
+			err.(*Problem).Domain = name
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Problem is an error in a configuration, located by the domain, scope and
+// annotation it occurs in, where applicable.
+type Problem struct {
+	Domain     string `json:"domain,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	Annotation string `json:"annotation,omitempty"`
+	Message    string `json:"message"`
+}
+
+func (p *Problem) Error() string {
+	var b strings.Builder
+	if p.Domain != "" {
+		fmt.Fprintf(&b, "domain %q: ", p.Domain)
+	}
+	if p.Scope != "" {
+		fmt.Fprintf(&b, "scope %q: ", p.Scope)
+	}
+	if p.Annotation != "" {
+		fmt.Fprintf(&b, "annotation %q: ", p.Annotation)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Warnings returns suspicious entries of c which are valid, but likely
+// typos. Keys are matched case-sensitively, so a key differing from the
+// annotation in use only in case silently protects nothing. Annotations
+// protected both cluster-wide and in a namespace are reported as well, as
+// the cluster scope already makes their values unique in every namespace.
+func (c *Config) Warnings() []string {
+	warnings := listWarnings(c.Protected)
+	for name, list := range c.Domains {
+		for _, w := range listWarnings(list) {
+			warnings = append(warnings, fmt.Sprintf("domain %q: %s", name, w))
+		}
+	}
+	sort.Strings(warnings)
+	return warnings
+}
+
+// listWarnings returns the suspicious entries of the protected annotations of a domain.
+func listWarnings(list validator.UniqueList) []string {
+	var warnings []string
+	for scope, annotations := range list {
+		lower := make(map[string]string, len(annotations))
+		for _, a := range annotations {
+			prefix, name, found := strings.Cut(a.Key, "/")
+			if !found {
+				prefix, name = "", prefix
+			}
+			if prefix != strings.ToLower(prefix) {
+				warnings = append(warnings, fmt.Sprintf("scope %q: annotation %q: prefix contains uppercase letters", scope, a.Key))
+			}
+			if name != strings.ToLower(name) {
+				warnings = append(warnings, fmt.Sprintf("scope %q: annotation %q: name contains uppercase letters", scope, a.Key))
+			}
+			if other, found := lower[strings.ToLower(a.Key)]; found && other != a.Key {
+				warnings = append(warnings, fmt.Sprintf("scope %q: annotations %q and %q only differ in case", scope, other, a.Key))
+			}
+			lower[strings.ToLower(a.Key)] = a.Key
+			if scope != validator.ClusterScope && slices.ContainsFunc(list[validator.ClusterScope], func(c validator.ProtectedAnnotation) bool { return c.Key == a.Key }) {
+				warnings = append(warnings, fmt.Sprintf("scope %q: annotation %q: also protected cluster-wide", scope, a.Key))
+			}
+		}
+	}
+	return warnings
+}
+
+// validateList checks the protected annotations of a domain.
+func validateList(list validator.UniqueList) []error {
+	var errs []error
+	problem := func(scope, annotation, format string, args ...any) {
+		errs = append(errs, &Problem{Scope: scope, Annotation: annotation, Message: fmt.Sprintf(format, args...)})
+	}
+	for scope, annotations := range list {
+		switch {
+		case scope == "":
+			problem("", "", "empty scope; use %q for cluster scope", validator.ClusterScope)
+		default:
+			switch validator.ParseScope(scope).(type) {
+			case validator.NamespaceScope:
+				if msgs := validation.IsDNS1123Label(scope); len(msgs) > 0 {
+					problem(scope, "", "invalid namespace: %s", strings.Join(msgs, ", "))
+				}
+			case validator.GlobScope, validator.RegexpScope, validator.SelectorScope:
+				if err := validator.ValidateScope(scope); err != nil {
+					problem(scope, "", "invalid pattern: %s", err)
+				}
+			}
+		}
+		seen := make(map[string]bool, len(annotations))
+		for i, a := range annotations {
+			switch {
+			case a.Key == "":
+				problem(scope, "", "annotation %d: empty key", i)
+			case seen[a.Key]:
+				problem(scope, a.Key, "declared more than once")
+			case a.Key == validator.Wildcard:
+				problem(scope, a.Key, "wildcard must follow a prefix")
+			default:
+				// The apiserver validates annotation keys the same way. An
+				// invalid key could never match and would not protect anything.
+				// A wildcard stands for the rest of a valid key.
+				key := a.Key
+				if a.IsWildcard() {
+					key = strings.TrimSuffix(key, validator.Wildcard) + "x"
+				}
+				if msgs := validation.IsQualifiedName(strings.ToLower(key)); len(msgs) > 0 {
+					problem(scope, a.Key, "invalid key: %s", strings.Join(msgs, ", "))
+				}
+			}
+			if a.IsWildcard() && a.Required != nil {
+				problem(scope, a.Key, "wildcard keys can not be required")
+			}
+			for _, key := range a.Composite {
+				if msgs := validation.IsQualifiedName(strings.ToLower(key)); len(msgs) > 0 {
+					problem(scope, a.Key, "invalid composite key %q: %s", key, strings.Join(msgs, ", "))
+				}
+			}
+			components := a.Components()
+			slices.Sort(components)
+			if len(slices.Compact(components)) != len(a.Composite)+1 {
+				problem(scope, a.Key, "composite contains duplicate keys")
+			}
+			if len(a.Composite) > 0 {
+				switch {
+				case a.IsWildcard():
+					problem(scope, a.Key, "wildcard keys can not be composite")
+				case len(a.Pool) > 0:
+					problem(scope, a.Key, "composite keys can not have a pool")
+				case a.Lease != nil:
+					problem(scope, a.Key, "composite keys can not have a lease")
+				}
+			}
+			seen[a.Key] = true
+			if a.ReleaseTerminatingAfter != nil && a.ReleaseTerminatingAfter.Duration <= 0 {
+				problem(scope, a.Key, "releaseTerminatingAfter must be positive")
+			}
+			if a.Lease != nil && a.Lease.Duration <= 0 {
+				problem(scope, a.Key, "lease must be positive")
+			}
+			pool := slices.Clone(a.Pool)
+			slices.Sort(pool)
+			if len(slices.Compact(pool)) != len(a.Pool) {
+				problem(scope, a.Key, "pool contains duplicate values")
+			}
+			shared := slices.Clone(a.Shared)
+			slices.Sort(shared)
+			if len(slices.Compact(shared)) != len(a.Shared) {
+				problem(scope, a.Key, "shared contains duplicate values")
+			}
+			if a.IsShared("") && a.EmptyValues != validator.EmptyValue {
+				problem(scope, a.Key, "empty values can only be shared if emptyValues is %q", validator.EmptyValue)
+			}
+			if a.MaxHolders < 0 {
+				problem(scope, a.Key, "maxHolders must not be negative")
+			}
+			if a.MaxHolders > 1 && a.Lease != nil {
+				problem(scope, a.Key, "values shared by several holders can not be leased")
+			}
+			if a.PoolWarningThreshold < 0 || a.PoolWarningThreshold > 100 {
+				problem(scope, a.Key, "poolWarningThreshold must be a percentage")
+			}
+			if len(a.Namespaces) > 0 && scope != validator.ClusterScope {
+				problem(scope, a.Key, "namespaces are only supported in cluster scope")
+			}
+			for _, namespace := range a.Namespaces {
+				for _, msg := range validation.IsDNS1123Label(namespace) {
+					problem(scope, a.Key, "namespace %q: %s", namespace, msg)
+				}
+			}
+			switch a.EmptyValues {
+			case "", validator.EmptyAbsent, validator.EmptyValue:
+			default:
+				problem(scope, a.Key, "invalid treatment of empty values %q", a.EmptyValues)
+			}
+			operations := a.Operations
+			if a.Required != nil {
+				operations = append(append([]admissionv1.Operation(nil), operations...), a.Required.Operations...)
+			}
+			groups := make(map[string]bool, len(a.OperationsIn))
+			for _, o := range a.OperationsIn {
+				switch {
+				case o.Namespaces == "":
+					problem(scope, a.Key, "operationsIn: empty namespaces; use %q for all namespaces", validator.ClusterScope)
+				case groups[o.Namespaces]:
+					problem(scope, a.Key, "operationsIn: duplicate namespaces %q", o.Namespaces)
+				case len(o.Operations) == 0:
+					problem(scope, a.Key, "operationsIn: no operations for namespaces %q", o.Namespaces)
+				default:
+					if _, ok := validator.ParseScope(o.Namespaces).(validator.NamespaceScope); ok {
+						if msgs := validation.IsDNS1123Label(o.Namespaces); len(msgs) > 0 {
+							problem(scope, a.Key, "operationsIn: invalid namespace %q: %s", o.Namespaces, strings.Join(msgs, ", "))
+						}
+					} else if err := validator.ValidateScope(o.Namespaces); err != nil {
+						problem(scope, a.Key, "operationsIn: invalid pattern %q: %s", o.Namespaces, err)
+					}
+				}
+				groups[o.Namespaces] = true
+				operations = append(append([]admissionv1.Operation(nil), operations...), o.Operations...)
+			}
+			for _, op := range operations {
+				if op != admissionv1.Create && op != admissionv1.Update {
+					problem(scope, a.Key, "unsupported operation %q", op)
+				}
+			}
+			if a.Required != nil {
+				switch a.Required.Action {
+				case "", validator.RequirementDeny, validator.RequirementWarn:
+				default:
+					problem(scope, a.Key, "invalid requirement action %q", a.Required.Action)
+				}
+			}
+			if a.Distinct != nil {
+				if a.IsWildcard() {
+					problem(scope, a.Key, "wildcard keys can not be distinct")
+				}
+				if len(a.Distinct.Keys) == 0 {
+					problem(scope, a.Key, "distinct lists no keys")
+				}
+				for _, key := range a.Distinct.Keys {
+					if key == a.Key {
+						problem(scope, a.Key, "distinct contains the annotation itself")
+					} else if msgs := validation.IsQualifiedName(strings.ToLower(key)); len(msgs) > 0 {
+						problem(scope, a.Key, "invalid distinct key %q: %s", key, strings.Join(msgs, ", "))
+					}
+				}
+				keys := slices.Clone(a.Distinct.Keys)
+				slices.Sort(keys)
+				if len(slices.Compact(keys)) != len(a.Distinct.Keys) {
+					problem(scope, a.Key, "distinct contains duplicate keys")
+				}
+				switch a.Distinct.Action {
+				case "", validator.RequirementDeny, validator.RequirementWarn:
+				default:
+					problem(scope, a.Key, "invalid distinct action %q", a.Distinct.Action)
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,330 @@
+/*
+ *     config_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMerge(t *testing.T) {
+	base := &Config{Protected: validator.UniqueList{
+		validator.ClusterScope: {{Key: "a"}},
+		"team":                 {{Key: "b"}},
+	}}
+	override := &Config{Protected: validator.UniqueList{
+		validator.ClusterScope: {{Key: "a", Immutable: true}},
+		"team":                 {},
+		"other":                {{Key: "c"}},
+	}}
+
+	assert.Equal(t, validator.UniqueList{
+		validator.ClusterScope: {{Key: "a", Immutable: true}},
+		"other":                {{Key: "c"}},
+	}, Merge(base, nil, override).Protected)
+}
+
+func TestMergeDomains(t *testing.T) {
+	base := &Config{Domains: map[string]validator.UniqueList{
+		"dns":     {validator.ClusterScope: {{Key: "a"}}},
+		"network": {validator.ClusterScope: {{Key: "b"}}},
+	}}
+	override := &Config{Domains: map[string]validator.UniqueList{
+		"dns":     {"team": {{Key: "c"}}},
+		"network": {validator.ClusterScope: {}},
+	}}
+
+	assert.Equal(t, map[string]validator.UniqueList{
+		"dns": {validator.ClusterScope: {{Key: "a"}}, "team": {{Key: "c"}}},
+	}, Merge(base, override).Domains)
+	assert.Nil(t, Merge(&Config{}).Domains)
+}
+
+func TestValidateDomains(t *testing.T) {
+	valid := validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}
+	testCases := []struct {
+		desc    string
+		domains map[string]validator.UniqueList
+		valid   bool
+	}{
+		{"valid", map[string]validator.UniqueList{"dns": valid}, true},
+		{"reserved name", map[string]validator.UniqueList{DefaultDomain: valid}, false},
+		{"invalid name", map[string]validator.UniqueList{"DNS/a": valid}, false},
+		{"invalid annotation", map[string]validator.UniqueList{"dns": {"team": {{Key: ""}}}}, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := (&Config{Domains: tC.domains}).Validate()
+			if tC.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		protected validator.UniqueList
+		valid     bool
+	}{
+		{"valid", validator.UniqueList{validator.ClusterScope: {{Key: "a", Required: &validator.Requirement{Action: validator.RequirementWarn}}}}, true},
+		{"default action", validator.UniqueList{validator.ClusterScope: {{Key: "a", Required: &validator.Requirement{}}}}, true},
+		{"empty scope", validator.UniqueList{"": {{Key: "a"}}}, false},
+		{"invalid namespace", validator.UniqueList{"Team_A": {{Key: "a"}}}, false},
+		{"glob", validator.UniqueList{"team-*": {{Key: "a"}}}, true},
+		{"invalid glob", validator.UniqueList{"team-[": {{Key: "a"}}}, false},
+		{"regexp", validator.UniqueList{"^prod-.*$": {{Key: "a"}}}, true},
+		{"invalid regexp", validator.UniqueList{"^prod-(": {{Key: "a"}}}, false},
+		{"selector", validator.UniqueList{"env in (prod,staging)": {{Key: "a"}}}, true},
+		{"invalid selector", validator.UniqueList{"env in (prod": {{Key: "a"}}}, false},
+		{"shared", validator.UniqueList{"team": {{Key: "a", Shared: []string{"default"}}}}, true},
+		{"duplicate shared", validator.UniqueList{"team": {{Key: "a", Shared: []string{"default", "default"}}}}, false},
+		{"shared empty value", validator.UniqueList{"team": {{Key: "a", Shared: []string{""}}}}, false},
+		{"empty key", validator.UniqueList{"team": {{Key: ""}}}, false},
+		{"duplicate key", validator.UniqueList{"team": {{Key: "a"}, {Key: "a", Immutable: true}}}, false},
+		{"create only", validator.UniqueList{"team": {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Create}}}}, true},
+		{"unsupported operation", validator.UniqueList{"team": {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Delete}}}}, false},
+		{"unsupported requirement operation", validator.UniqueList{"team": {{Key: "a", Required: &validator.Requirement{Operations: []admissionv1.Operation{admissionv1.Connect}}}}}, false},
+		{"operations in namespaces", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Namespaces: "env=dev", Operations: []admissionv1.Operation{admissionv1.Create}}, {Namespaces: "sandbox", Operations: []admissionv1.Operation{admissionv1.Create}}}}}}, true},
+		{"operations in no namespaces", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Operations: []admissionv1.Operation{admissionv1.Create}}}}}}, false},
+		{"no operations in namespaces", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Namespaces: "dev-*"}}}}}, false},
+		{"unsupported operation in namespaces", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Namespaces: "dev-*", Operations: []admissionv1.Operation{admissionv1.Delete}}}}}}, false},
+		{"duplicate namespaces of operations", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Namespaces: "dev-*", Operations: []admissionv1.Operation{admissionv1.Create}}, {Namespaces: "dev-*", Operations: []admissionv1.Operation{admissionv1.Create}}}}}}, false},
+		{"invalid namespaces of operations", validator.UniqueList{validator.ClusterScope: {{Key: "a", OperationsIn: []validator.NamespaceOperations{{Namespaces: "env in (dev", Operations: []admissionv1.Operation{admissionv1.Create}}}}}}, false},
+		{"locality hint", validator.UniqueList{validator.ClusterScope: {{Key: "a", Namespaces: []string{"team-a"}}}}, true},
+		{"locality hint in namespace scope", validator.UniqueList{"team": {{Key: "a", Namespaces: []string{"team-a"}}}}, false},
+		{"invalid locality hint", validator.UniqueList{validator.ClusterScope: {{Key: "a", Namespaces: []string{"Team_A"}}}}, false},
+		{"qualified key", validator.UniqueList{"team": {{Key: "example.com/ip"}}}, true},
+		{"key with space", validator.UniqueList{"team": {{Key: "example.com/ ip"}}}, false},
+		{"key with empty prefix", validator.UniqueList{"team": {{Key: "/ip"}}}, false},
+		{"wildcard key", validator.UniqueList{"team": {{Key: "example.com/*"}}}, true},
+		{"bare wildcard key", validator.UniqueList{"team": {{Key: "*"}}}, false},
+		{"wildcard within key", validator.UniqueList{"team": {{Key: "example.com/*/ip"}}}, false},
+		{"required wildcard key", validator.UniqueList{"team": {{Key: "example.com/*", Required: &validator.Requirement{}}}}, false},
+		{"composite key", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Composite: []string{"ncp/router"}}}}, true},
+		{"invalid composite key", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Composite: []string{"ncp/ router"}}}}, false},
+		{"duplicate composite key", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Composite: []string{"ncp/router", "ncp/snat_pool"}}}}, false},
+		{"max holders", validator.UniqueList{"team": {{Key: "a", MaxHolders: 3}}}, true},
+		{"negative max holders", validator.UniqueList{"team": {{Key: "a", MaxHolders: -1}}}, false},
+		{"leased max holders", validator.UniqueList{"team": {{Key: "a", MaxHolders: 3, Lease: &metav1.Duration{Duration: time.Hour}}}}, false},
+		{"composite key with pool", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Composite: []string{"ncp/router"}, Pool: []string{"a"}}}}, false},
+		{"empty values", validator.UniqueList{"team": {{Key: "a", EmptyValues: validator.EmptyValue}}}, true},
+		{"invalid empty values", validator.UniqueList{"team": {{Key: "a", EmptyValues: "ignore"}}}, false},
+		{"invalid action", validator.UniqueList{"team": {{Key: "a", Required: &validator.Requirement{Action: "ignore"}}}}, false},
+		{"distinct", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{Keys: []string{"ncp/ingress_pool"}, Action: validator.RequirementWarn}}}}, true},
+		{"distinct without keys", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{}}}}, false},
+		{"distinct from itself", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{Keys: []string{"ncp/snat_pool"}}}}}, false},
+		{"duplicate distinct key", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{Keys: []string{"ncp/router", "ncp/router"}}}}}, false},
+		{"invalid distinct key", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{Keys: []string{"ncp/ router"}}}}}, false},
+		{"invalid distinct action", validator.UniqueList{"team": {{Key: "ncp/snat_pool", Distinct: &validator.Distinction{Keys: []string{"ncp/router"}, Action: "ignore"}}}}, false},
+		{"distinct wildcard key", validator.UniqueList{"team": {{Key: "ncp/*", Distinct: &validator.Distinction{Keys: []string{"ncp/router"}}}}}, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := (&Config{Protected: tC.protected}).Validate()
+			if tC.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := &Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "a"}}, "team": {{Key: "b"}}}}
+	b := &Config{Protected: validator.UniqueList{"team": {{Key: "b"}}, validator.ClusterScope: {{Key: "a"}}}}
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.Len(t, a.Hash(), 64)
+	b.Protected["team"][0].Immutable = true
+	assert.NotEqual(t, a.Hash(), b.Hash())
+}
+
+func TestWarnings(t *testing.T) {
+	c := &Config{
+		Protected: validator.UniqueList{
+			validator.ClusterScope: {{Key: "Example.com/ip"}, {Key: "example.com/IP"}, {Key: "example.com/ip"}},
+			"team":                 {{Key: "example.com/ip"}},
+		},
+		Domains: map[string]validator.UniqueList{"dns": {"team": {{Key: "example.com/host"}}}},
+	}
+	assert.NoError(t, c.Validate())
+	assert.Equal(t, []string{
+		`scope "*": annotation "Example.com/ip": prefix contains uppercase letters`,
+		`scope "*": annotation "example.com/IP": name contains uppercase letters`,
+		`scope "*": annotations "Example.com/ip" and "example.com/IP" only differ in case`,
+		`scope "*": annotations "example.com/IP" and "example.com/ip" only differ in case`,
+		`scope "team": annotation "example.com/ip": also protected cluster-wide`,
+	}, c.Warnings())
+}
+
+func TestProblems(t *testing.T) {
+	c := &Config{
+		Protected: validator.UniqueList{"team": {{Key: "a"}, {Key: "a"}}},
+		Domains:   map[string]validator.UniqueList{"dns": {"team": {{Key: "b", EmptyValues: "ignore"}}}},
+	}
+	var problems []*Problem
+	for _, err := range c.Validate().(interface{ Unwrap() []error }).Unwrap() {
+		var p *Problem
+		require.ErrorAs(t, err, &p)
+		problems = append(problems, p)
+	}
+	require.Len(t, problems, 2)
+	assert.Equal(t, &Problem{Scope: "team", Annotation: "a", Message: "declared more than once"}, problems[0])
+	assert.Equal(t, &Problem{Domain: "dns", Scope: "team", Annotation: "b", Message: `invalid treatment of empty values "ignore"`}, problems[1])
+	assert.EqualError(t, problems[1], `domain "dns": scope "team": annotation "b": invalid treatment of empty values "ignore"`)
+}
+
+// fakeSource is a watchable source whose configuration can be changed by tests.
+type fakeSource struct {
+	config  *Config
+	err     error
+	changes chan struct{}
+}
+
+func (f *fakeSource) Name() string { return "fake" }
+
+func (f *fakeSource) Load(context.Context) (*Config, error) { return f.config, f.err }
+
+func (f *fakeSource) Watch(ctx context.Context, changed func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.changes:
+			changed()
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	_, err := NewManager()
+	assert.Error(t, err, "manager without sources")
+
+	flags := Static("flags", &Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}})
+	source := &fakeSource{changes: make(chan struct{})}
+	m, err := NewManager(WithSource(flags), WithSource(source))
+	require.NoError(t, err)
+
+	var notified []*Config
+	m.Subscribe(func(c *Config) { notified = append(notified, c) })
+
+	require.NoError(t, m.Reload(context.Background()))
+	require.Len(t, notified, 1)
+	assert.Equal(t, validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}, m.Current().Protected)
+
+	// Unchanged configurations are not announced.
+	require.NoError(t, m.Reload(context.Background()))
+	assert.Len(t, notified, 1)
+
+	// Failing sources and invalid results keep the current configuration.
+	source.err = errors.New("unavailable")
+	assert.Error(t, m.Reload(context.Background()))
+	source.err = nil
+	source.config = &Config{Protected: validator.UniqueList{"team": {{Key: ""}}}}
+	assert.Error(t, m.Reload(context.Background()))
+	assert.Len(t, notified, 1)
+	assert.Equal(t, validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}, m.Current().Protected)
+	assert.Error(t, m.Healthy(context.Background()), "failed reload is reported")
+
+	// Changes detected by watchers are applied by Run.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	source.config = &Config{Protected: validator.UniqueList{"team": {{Key: "b"}}}}
+	source.changes <- struct{}{}
+	cancel()
+	<-done
+
+	require.Len(t, notified, 2)
+	assert.Equal(t, validator.UniqueList{
+		validator.ClusterScope: {{Key: "a"}},
+		"team":                 {{Key: "b"}},
+	}, notified[1].Protected)
+	assert.NoError(t, m.Healthy(context.Background()))
+}
+
+func TestManagerGuard(t *testing.T) {
+	source := &fakeSource{config: &Config{Protected: validator.UniqueList{"team": {{Key: "a"}}}}}
+	var guarded []*Config
+	m, err := NewManager(WithSource(source), WithGuard(func(current, next *Config) error {
+		guarded = append(guarded, next)
+		if _, found := next.Protected["other"]; found {
+			return errors.New("too broad")
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+
+	require.NoError(t, m.Reload(context.Background()))
+	assert.Empty(t, guarded, "the first configuration is not guarded")
+
+	source.config = &Config{Protected: validator.UniqueList{"other": {{Key: "a"}}}}
+	assert.ErrorContains(t, m.Reload(context.Background()), "too broad")
+	assert.Equal(t, validator.UniqueList{"team": {{Key: "a"}}}, m.Current().Protected)
+	assert.Len(t, guarded, 1)
+}
+
+func TestOverride(t *testing.T) {
+	flags := Static("flags", &Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}})
+	runtime := Runtime("runtime")
+	m, err := NewManager(WithSource(flags), WithSource(runtime))
+	require.NoError(t, err)
+	require.NoError(t, m.Reload(context.Background()))
+
+	require.NoError(t, m.Override(context.Background(), runtime, &Config{Protected: validator.UniqueList{"team": {{Key: "b"}}}}))
+	assert.Equal(t, validator.UniqueList{
+		validator.ClusterScope: {{Key: "a"}},
+		"team":                 {{Key: "b"}},
+	}, m.Current().Protected)
+
+	// Invalid overrides are rejected and leave the previous one in place.
+	assert.Error(t, m.Override(context.Background(), runtime, &Config{Protected: validator.UniqueList{"team": {{Key: ""}}}}))
+	assert.Equal(t, validator.UniqueList{"team": {{Key: "b"}}}, mustLoad(t, runtime).Protected)
+	assert.Len(t, m.Current().Protected, 2)
+	assert.NoError(t, m.Healthy(context.Background()), "rejected override is not reported")
+
+	// Empty lists remove scopes of other sources, nil removes the override.
+	require.NoError(t, m.Override(context.Background(), runtime, &Config{Protected: validator.UniqueList{validator.ClusterScope: {}}}))
+	assert.Empty(t, m.Current().Protected)
+	require.NoError(t, m.Override(context.Background(), runtime, nil))
+	assert.Equal(t, validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}, m.Current().Protected)
+}
+
+func mustLoad(t *testing.T, s Source) *Config {
+	t.Helper()
+	c, err := s.Load(context.Background())
+	require.NoError(t, err)
+	return c
+}
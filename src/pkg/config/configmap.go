@@ -0,0 +1,165 @@
+/*
+ *     configmap.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigMapKey is the key of the configuration in the data of a ConfigMap.
+const ConfigMapKey = "config.yaml"
+
+// configMapResync is the interval in which a ConfigMap source reports a
+// possible change even without events, so that failed loads are retried.
+const configMapResync = time.Minute
+
+type configMapSource struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// ConfigMap returns a source reading the configuration from the YAML or
+// JSON document stored under ConfigMapKey in the named ConfigMap. Unknown
+// fields are rejected, so typos do not silently drop protection. A missing
+// ConfigMap provides no configuration, so the webhook can start before it
+// is created.
+//
+// The source is a Watcher. It watches the ConfigMap with an informer, which
+// reconnects with backoff if the watch breaks, and reports a possible change
+// at least once per minute, so failed loads are retried.
+func ConfigMap(clientset kubernetes.Interface, namespace, name string) Source {
+	return &configMapSource{clientset: clientset, namespace: namespace, name: name}
+}
+
+func (s *configMapSource) Name() string {
+	return "configmap/" + s.namespace + "/" + s.name
+}
+
+func (s *configMapSource) Load(ctx context.Context) (*Config, error) {
+	cm, err := s.clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return parseConfigMap(cm)
+}
+
+// parseConfigMap decodes the configuration stored in cm.
+func parseConfigMap(cm *corev1.ConfigMap) (*Config, error) {
+	data, found := cm.Data[ConfigMapKey]
+	if !found {
+		return nil, fmt.Errorf("key %q not found", ConfigMapKey)
+	}
+	return decode([]byte(data))
+}
+
+// decode decodes the document stored under ConfigMapKey.
+func decode(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("decoding %q: %w", ConfigMapKey, err)
+	}
+	return &c, nil
+}
+
+// OverrideConfigMapSource is a ConfigMap source whose configuration can be
+// replaced, see OverrideConfigMap.
+type OverrideConfigMapSource struct {
+	*configMapSource
+}
+
+// OverrideConfigMap returns a source like ConfigMap, whose configuration is
+// replaced by Manager.Override. Overrides are stored in the ConfigMap,
+// which is created if missing, so they reach every replica watching it and
+// survive restarts.
+func OverrideConfigMap(clientset kubernetes.Interface, namespace, name string) *OverrideConfigMapSource {
+	return &OverrideConfigMapSource{&configMapSource{clientset: clientset, namespace: namespace, name: name}}
+}
+
+// Replace stores c in the ConfigMap and returns the configuration it held.
+// A nil c is stored as an empty configuration. A configuration which could
+// not be decoded is replaced and reported as nil.
+func (s *OverrideConfigMapSource) Replace(ctx context.Context, c *Config) (*Config, error) {
+	if c == nil {
+		c = &Config{}
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.namespace)
+	var previous *Config
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			previous = nil
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name},
+				Data:       map[string]string{ConfigMapKey: string(data)},
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{FieldManager: validator.FieldManager})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		previous, _ = parseConfigMap(cm)
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[ConfigMapKey] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{FieldManager: validator.FieldManager})
+		return err
+	})
+	return previous, err
+}
+
+func (s *configMapSource) Watch(ctx context.Context, changed func()) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.clientset, configMapResync,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { changed() },
+		UpdateFunc: func(interface{}, interface{}) { changed() },
+		DeleteFunc: func(interface{}) { changed() },
+	})
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
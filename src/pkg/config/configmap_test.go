@@ -0,0 +1,108 @@
+/*
+ *     configmap_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMap(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	source := ConfigMap(tc, "unik", "unik-config")
+	assert.Equal(t, "configmap/unik/unik-config", source.Name())
+
+	c, err := source.Load(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, c, "a missing ConfigMap provides no configuration")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go source.(Watcher).Watch(ctx, func() { changes <- struct{}{} })
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unik", Name: "unik-config"},
+		Data: map[string]string{ConfigMapKey: `
+protected:
+  "*":
+  - key: example.com/ip
+    immutable: true
+`},
+	}
+	_, err = tc.CoreV1().ConfigMaps("unik").Create(ctx, cm, metav1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating the ConfigMap was not reported")
+	}
+
+	c, err = source.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &Config{Protected: validator.UniqueList{
+		validator.ClusterScope: {{Key: "example.com/ip", Immutable: true}},
+	}}, c)
+
+	cm.Data[ConfigMapKey] = "protected:\n  \"*\":\n  - key: example.com/ip\n    immutible: true\n"
+	_, err = tc.CoreV1().ConfigMaps("unik").Update(ctx, cm, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updating the ConfigMap was not reported")
+	}
+	_, err = source.Load(ctx)
+	assert.ErrorContains(t, err, "immutible", "unknown fields are rejected")
+}
+
+func TestOverrideConfigMap(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	overrides := OverrideConfigMap(tc, "unik", "unik-overrides")
+	base := Static("flags", &Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}})
+	m, err := NewManager(WithSource(base), WithSource(overrides))
+	require.NoError(t, err)
+	require.NoError(t, m.Reload(context.Background()))
+
+	require.NoError(t, m.Override(context.Background(), overrides, &Config{Protected: validator.UniqueList{"team": {{Key: "b"}}}}))
+	assert.Len(t, m.Current().Protected, 2)
+
+	// Another replica reading the ConfigMap sees the override.
+	stored, err := ConfigMap(tc, "unik", "unik-overrides").Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, validator.UniqueList{"team": {{Key: "b"}}}, stored.Protected)
+
+	// Invalid overrides are rejected before they are stored.
+	assert.Error(t, m.Override(context.Background(), overrides, &Config{Protected: validator.UniqueList{"team": {{Key: ""}}}}))
+	stored, err = ConfigMap(tc, "unik", "unik-overrides").Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, validator.UniqueList{"team": {{Key: "b"}}}, stored.Protected)
+
+	require.NoError(t, m.Override(context.Background(), overrides, nil))
+	assert.Equal(t, validator.UniqueList{validator.ClusterScope: {{Key: "a"}}}, m.Current().Protected)
+}
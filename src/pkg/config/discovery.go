@@ -0,0 +1,152 @@
+/*
+ *     discovery.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/yaml"
+)
+
+// DiscoveryLabel selects the ConfigMaps found by a Discovered source.
+const DiscoveryLabel = "unik.io/config"
+
+// discoverySelector is the label selector of a Discovered source.
+const discoverySelector = DiscoveryLabel + "=true"
+
+// NamespaceConfig is the document stored under ConfigMapKey in the
+// ConfigMaps found by a Discovered source. It lists the annotations
+// protected in the namespace of the ConfigMap.
+type NamespaceConfig struct {
+	Annotations []validator.ProtectedAnnotation `json:"annotations"`
+}
+
+type discoveredSource struct {
+	clientset kubernetes.Interface
+	logger    *zap.Logger
+}
+
+// Discovered returns a source merging the ConfigMaps labelled
+// DiscoveryLabel=true of all namespaces, so each team can publish the
+// annotations protected in its namespace. Each ConfigMap sets the scope of
+// its namespace; a deleted ConfigMap no longer sets it.
+//
+// Like policies, each ConfigMap is validated on its own. Invalid ones are
+// skipped, as are annotations already protected in the same namespace by
+// an older ConfigMap, and logged, so a broken ConfigMap does not disable
+// all others.
+//
+// The source is a Watcher. It watches the ConfigMaps with an informer and
+// reports a possible change at least once per minute.
+func Discovered(clientset kubernetes.Interface, logger *zap.Logger) Source {
+	return &discoveredSource{clientset: clientset, logger: logger}
+}
+
+func (s *discoveredSource) Name() string {
+	return "configmaps/" + discoverySelector
+}
+
+func (s *discoveredSource) Load(ctx context.Context) (*Config, error) {
+	list, err := s.clientset.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: discoverySelector})
+	if err != nil {
+		return nil, err
+	}
+	configMaps := list.Items
+	// Older ConfigMaps win conflicts.
+	sort.Slice(configMaps, func(i, j int) bool {
+		a, b := configMaps[i], configMaps[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	c := &Config{Protected: validator.UniqueList{}}
+	owners := make(map[string]string)
+	for i := range configMaps {
+		cm := &configMaps[i]
+		if err := acceptNamespaceConfig(c.Protected, owners, cm); err != nil {
+			s.logger.Warn("Skipping namespace configuration", zap.String("namespace", cm.Namespace), zap.String("configmap", cm.Name), zap.Error(err))
+		}
+	}
+	return c, nil
+}
+
+// acceptNamespaceConfig merges the annotations of cm into the scope of its
+// namespace in list, unless they are invalid or conflict with those of an
+// earlier ConfigMap. owners maps the scope and key of each merged
+// annotation to its ConfigMap.
+func acceptNamespaceConfig(list validator.UniqueList, owners map[string]string, cm *corev1.ConfigMap) error {
+	data, found := cm.Data[ConfigMapKey]
+	if !found {
+		return fmt.Errorf("key %q not found", ConfigMapKey)
+	}
+	var nc NamespaceConfig
+	if err := yaml.UnmarshalStrict([]byte(data), &nc); err != nil {
+		return fmt.Errorf("decoding %q: %w", ConfigMapKey, err)
+	}
+	if len(nc.Annotations) == 0 {
+		return errors.New("no annotations")
+	}
+	scope := cm.Namespace
+	if errs := validateList(validator.UniqueList{scope: nc.Annotations}); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	var errs []error
+	for _, a := range nc.Annotations {
+		if owner, found := owners[scope+"/"+a.Key]; found {
+			errs = append(errs, fmt.Errorf("annotation %q is already protected in namespace %q by ConfigMap %q", a.Key, scope, owner))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, a := range nc.Annotations {
+		owners[scope+"/"+a.Key] = cm.Name
+		list[scope] = append(list[scope], a)
+	}
+	return nil
+}
+
+func (s *discoveredSource) Watch(ctx context.Context, changed func()) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.clientset, configMapResync,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = discoverySelector
+		}))
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { changed() },
+		UpdateFunc: func(interface{}, interface{}) { changed() },
+		DeleteFunc: func(interface{}) { changed() },
+	})
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
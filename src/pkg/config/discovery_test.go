@@ -0,0 +1,83 @@
+/*
+ *     discovery_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscovered(t *testing.T) {
+	now := time.Now()
+	configMap := func(ns, name string, age time.Duration, labelled bool, doc string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         ns,
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+			Data: map[string]string{ConfigMapKey: doc},
+		}
+		if labelled {
+			cm.Labels = map[string]string{DiscoveryLabel: "true"}
+		}
+		return cm
+	}
+	tc := testclient.NewSimpleClientset(
+		configMap("team-a", "unik", time.Hour, true, "annotations:\n- key: example.com/ip\n"),
+		configMap("team-a", "more", time.Minute, true, "annotations:\n- key: example.com/ip\n- key: example.com/host\n"),
+		configMap("team-b", "unik", time.Hour, true, "annotations:\n- key: example.com/vip\n  maxHolders: 2\n"),
+		configMap("team-c", "unik", time.Hour, true, "annotations:\n- key: \"not a key\"\n"),
+		configMap("team-d", "unik", time.Hour, true, "protected: {}\n"),
+		configMap("team-e", "unik", time.Hour, false, "annotations:\n- key: example.com/ip\n"),
+	)
+	source := Discovered(tc, zap.NewNop())
+	assert.Equal(t, "configmaps/unik.io/config=true", source.Name())
+
+	c, err := source.Load(context.Background())
+	require.NoError(t, err, "invalid ConfigMaps are skipped")
+	assert.Equal(t, validator.UniqueList{
+		"team-a": {{Key: "example.com/ip"}},
+		"team-b": {{Key: "example.com/vip", MaxHolders: 2}},
+	}, c.Protected, "the older ConfigMap wins a conflict and unlabelled ones are ignored")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go source.(Watcher).Watch(ctx, func() { changes <- struct{}{} })
+
+	require.NoError(t, tc.CoreV1().ConfigMaps("team-b").Delete(ctx, "unik", metav1.DeleteOptions{}))
+	// The informer reports the initial ConfigMaps, then the deletion.
+	assert.Eventually(t, func() bool {
+		c, err := source.Load(ctx)
+		require.NoError(t, err)
+		_, found := c.Protected["team-b"]
+		return !found && len(changes) > 0
+	}, 5*time.Second, 10*time.Millisecond, "a deleted ConfigMap no longer sets the scope of its namespace")
+}
@@ -0,0 +1,241 @@
+/*
+ *     manager.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Subscriber is notified with the new configuration after each change.
+type Subscriber func(*Config)
+
+// Guard vets a change from current to next, which is valid, before it takes
+// effect. If it returns an error, the change is rejected like an invalid
+// configuration.
+type Guard func(current, next *Config) error
+
+// Manager loads the configuration from its sources and distributes it
+// to its subscribers.
+type Manager struct {
+	logger      *zap.Logger
+	sources     []Source
+	subscribers []Subscriber
+	guards      []Guard
+
+	// lock serializes reloads, so subscribers see changes in order.
+	lock    sync.Mutex
+	current atomic.Pointer[Config]
+	// failure holds the error of the last reload, if it failed.
+	failure atomic.Pointer[error]
+
+	// overrides serializes Override, so a failed override restores the
+	// configuration it replaced.
+	overrides sync.Mutex
+}
+
+type ManagerOption func(*Manager) error
+
+func WithLogger(logger *zap.Logger) ManagerOption {
+	return func(m *Manager) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		m.logger = logger
+		return nil
+	}
+}
+
+// WithSource adds source to the manager. Sources added later take
+// precedence over sources added earlier, as described by Merge.
+func WithSource(source Source) ManagerOption {
+	return func(m *Manager) error {
+		if source == nil {
+			return errors.New("source is nil")
+		}
+		m.sources = append(m.sources, source)
+		return nil
+	}
+}
+
+// WithGuard adds guard to the manager. Guards are not consulted for the
+// first configuration loaded.
+func WithGuard(guard Guard) ManagerOption {
+	return func(m *Manager) error {
+		if guard == nil {
+			return errors.New("guard is nil")
+		}
+		m.guards = append(m.guards, guard)
+		return nil
+	}
+}
+
+func NewManager(options ...ManagerOption) (*Manager, error) {
+	m := &Manager{logger: zap.NewNop()}
+	for _, option := range options {
+		if err := option(m); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+	if len(m.sources) == 0 {
+		return nil, errors.New("at least one source is required")
+	}
+	return m, nil
+}
+
+// Subscribe registers s to be called after every change of the configuration.
+// Subscribers are called synchronously and in the order they subscribed.
+func (m *Manager) Subscribe(s Subscriber) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.subscribers = append(m.subscribers, s)
+}
+
+// Current returns the configuration in effect, or nil before the first
+// successful Reload. It must not be modified.
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// Reload loads all sources, merges and validates the result and notifies
+// the subscribers if the configuration changed. If any source fails or the
+// result is invalid, the current configuration is kept.
+func (m *Manager) Reload(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	err := m.reload(ctx)
+	if err != nil {
+		m.failure.Store(&err)
+		metrics.ConfigReloads.WithLabelValues("failure").Inc()
+		return err
+	}
+	m.failure.Store(nil)
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+// Healthy returns the error of the last Reload, if it failed. The
+// configuration in effect before stays in effect in that case.
+// It can be used as health.Check.
+func (m *Manager) Healthy(context.Context) error {
+	if err := m.failure.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func (m *Manager) reload(ctx context.Context) error {
+	merged, err := m.candidate(ctx, nil, nil)
+	if err != nil {
+		return err
+	}
+	if merged == nil {
+		m.logger.Debug("Configuration unchanged")
+		return nil
+	}
+
+	for _, w := range merged.Warnings() {
+		m.logger.Warn("Suspicious configuration", zap.String("warning", w))
+	}
+
+	m.current.Store(merged)
+	protected := 0
+	for _, annotations := range merged.Protected {
+		protected += len(annotations)
+	}
+	for _, list := range merged.Domains {
+		for _, annotations := range list {
+			protected += len(annotations)
+		}
+	}
+	metrics.ProtectedAnnotations.Set(float64(protected))
+	m.logger.Info("Configuration changed", zap.Int("scopes", len(merged.Protected)), zap.Int("domains", len(merged.Domains)), zap.Int("protected_annotations", protected))
+
+	for _, s := range m.subscribers {
+		s(merged)
+	}
+	return nil
+}
+
+// candidate loads all sources, merges and validates the result and vets
+// the change with the guards, without applying it. If replace is set, c is
+// used instead of its configuration. It returns nil if the configuration
+// would not change.
+func (m *Manager) candidate(ctx context.Context, replace Source, c *Config) (*Config, error) {
+	configs := make([]*Config, 0, len(m.sources))
+	for _, source := range m.sources {
+		if replace != nil && source == replace {
+			configs = append(configs, c)
+			continue
+		}
+		loaded, err := source.Load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading source %q: %w", source.Name(), err)
+		}
+		configs = append(configs, loaded)
+	}
+
+	merged := Merge(configs...)
+	if err := merged.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	current := m.current.Load()
+	if reflect.DeepEqual(merged, current) {
+		return nil, nil
+	}
+	if current != nil {
+		for _, guard := range m.guards {
+			if err := guard(current, merged); err != nil {
+				return nil, fmt.Errorf("configuration change rejected: %w", err)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Run watches all sources implementing Watcher and reloads the configuration
+// on changes until ctx is done. Failed reloads are logged.
+func (m *Manager) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, source := range m.sources {
+		w, ok := source.(Watcher)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			w.Watch(ctx, func() {
+				if err := m.Reload(ctx); err != nil {
+					m.logger.Error("Failed to reload configuration", zap.String("source", name), zap.Error(err))
+				}
+			})
+		}(source.Name())
+	}
+	wg.Wait()
+}
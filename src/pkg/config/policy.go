@@ -0,0 +1,186 @@
+/*
+ *     policy.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/api/v1alpha1"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// policyResync is the interval in which a policy source reports a possible
+// change even without events, so that failed loads are retried.
+const policyResync = time.Minute
+
+type policySource struct {
+	client dynamic.Interface
+}
+
+// Policies returns a source merging the annotations of all
+// UniqueAnnotationPolicies into the protected annotations. The scopes
+// declared by policies replace the same scopes of earlier sources.
+//
+// Each policy is validated on its own, and the outcome is reported in its
+// Accepted condition. Invalid policies are skipped, as are policies
+// protecting an annotation already protected in the same scope by an older
+// policy, so a broken policy does not disable all others. A failed status
+// update fails the load, so it is retried.
+//
+// The source is a Watcher. It watches the policies with an informer and
+// reports a possible change at least once per minute.
+func Policies(client dynamic.Interface) Source {
+	return &policySource{client: client}
+}
+
+func (s *policySource) Name() string {
+	return v1alpha1.UniqueAnnotationPolicies.GroupResource().String()
+}
+
+func (s *policySource) Load(ctx context.Context) (*Config, error) {
+	list, err := s.client.Resource(v1alpha1.UniqueAnnotationPolicies).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	policies := make([]v1alpha1.UniqueAnnotationPolicy, len(list.Items))
+	for i, item := range list.Items {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &policies[i]); err != nil {
+			return nil, fmt.Errorf("decoding policy %q: %w", item.GetName(), err)
+		}
+	}
+	// Older policies win conflicts.
+	sort.Slice(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Name < b.Name
+	})
+
+	c := &Config{Protected: validator.UniqueList{}}
+	owners := make(map[string]string)
+	var errs []error
+	for i := range policies {
+		p := &policies[i]
+		condition := acceptPolicy(c.Protected, owners, p)
+		if err := s.updateStatus(ctx, p, condition); err != nil {
+			errs = append(errs, fmt.Errorf("updating status of policy %q: %w", p.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// acceptPolicy merges the annotations of p into list unless they are
+// invalid or conflict with those of an earlier policy. owners maps the
+// scope and key of each merged annotation to its policy. The returned
+// condition reports the outcome.
+func acceptPolicy(list validator.UniqueList, owners map[string]string, p *v1alpha1.UniqueAnnotationPolicy) metav1.Condition {
+	scope := validator.ClusterScope
+	if p.Spec.Namespace != "" {
+		scope = p.Spec.Namespace
+	}
+	var msgs []string
+	if p.Spec.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(p.Spec.Namespace) {
+			msgs = append(msgs, fmt.Sprintf("namespace %q: %s", p.Spec.Namespace, msg))
+		}
+	}
+	if len(p.Spec.Annotations) == 0 {
+		msgs = append(msgs, "no annotations")
+	}
+	for _, err := range validateList(validator.UniqueList{scope: p.Spec.Annotations}) {
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) > 0 {
+		return policyCondition(p, metav1.ConditionFalse, v1alpha1.ReasonInvalid, strings.Join(msgs, "; "))
+	}
+
+	for _, a := range p.Spec.Annotations {
+		if owner, found := owners[scope+"/"+a.Key]; found {
+			msgs = append(msgs, fmt.Sprintf("annotation %q is already protected in scope %q by policy %q", a.Key, scope, owner))
+		}
+	}
+	if len(msgs) > 0 {
+		return policyCondition(p, metav1.ConditionFalse, v1alpha1.ReasonConflict, strings.Join(msgs, "; "))
+	}
+
+	for _, a := range p.Spec.Annotations {
+		owners[scope+"/"+a.Key] = p.Name
+		list[scope] = append(list[scope], a)
+	}
+	return policyCondition(p, metav1.ConditionTrue, v1alpha1.ReasonValid, fmt.Sprintf("%d annotations protected in scope %q", len(p.Spec.Annotations), scope))
+}
+
+func policyCondition(p *v1alpha1.UniqueAnnotationPolicy, status metav1.ConditionStatus, reason, message string) metav1.Condition {
+	return metav1.Condition{
+		Type:               v1alpha1.ConditionAccepted,
+		Status:             status,
+		ObservedGeneration: p.Generation,
+		Reason:             reason,
+		Message:            message,
+	}
+}
+
+// updateStatus sets condition on p, writing the status only if it changed.
+func (s *policySource) updateStatus(ctx context.Context, p *v1alpha1.UniqueAnnotationPolicy, condition metav1.Condition) error {
+	current := meta.FindStatusCondition(p.Status.Conditions, condition.Type)
+	if p.Status.ObservedGeneration == p.Generation && current != nil &&
+		current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	p.Status.ObservedGeneration = p.Generation
+	meta.SetStatusCondition(&p.Status.Conditions, condition)
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Resource(v1alpha1.UniqueAnnotationPolicies).UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{})
+	return err
+}
+
+func (s *policySource) Watch(ctx context.Context, changed func()) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(s.client, policyResync)
+	informer := factory.ForResource(v1alpha1.UniqueAnnotationPolicies).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { changed() },
+		UpdateFunc: func(interface{}, interface{}) { changed() },
+		DeleteFunc: func(interface{}) { changed() },
+	})
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
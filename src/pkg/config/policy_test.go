@@ -0,0 +1,98 @@
+/*
+ *     policy_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/api/v1alpha1"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func policy(t *testing.T, name string, age time.Duration, spec v1alpha1.UniqueAnnotationPolicySpec) *unstructured.Unstructured {
+	p := &v1alpha1.UniqueAnnotationPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "UniqueAnnotationPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Generation:        1,
+			CreationTimestamp: metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Add(-age)),
+		},
+		Spec: spec,
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(p)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestPolicies(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	client := fake.NewSimpleDynamicClient(scheme,
+		policy(t, "snat", 2*time.Hour, v1alpha1.UniqueAnnotationPolicySpec{
+			Annotations: []validator.ProtectedAnnotation{{Key: "ncp/snat_pool", Immutable: true}},
+		}),
+		policy(t, "team-a", time.Hour, v1alpha1.UniqueAnnotationPolicySpec{
+			Namespace:   "team-a",
+			Annotations: []validator.ProtectedAnnotation{{Key: "example.com/ip"}},
+		}),
+		policy(t, "duplicate", time.Hour, v1alpha1.UniqueAnnotationPolicySpec{
+			Annotations: []validator.ProtectedAnnotation{{Key: "ncp/snat_pool"}},
+		}),
+		policy(t, "invalid", time.Hour, v1alpha1.UniqueAnnotationPolicySpec{
+			Annotations: []validator.ProtectedAnnotation{{Key: "not a key"}},
+		}),
+	)
+	source := Policies(client)
+	assert.Equal(t, "uniqueannotationpolicies.unik.io", source.Name())
+
+	c, err := source.Load(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &Config{Protected: validator.UniqueList{
+		validator.ClusterScope: {{Key: "ncp/snat_pool", Immutable: true}},
+		"team-a":               {{Key: "example.com/ip"}},
+	}}, c)
+
+	for name, reason := range map[string]string{
+		"snat":      v1alpha1.ReasonValid,
+		"team-a":    v1alpha1.ReasonValid,
+		"duplicate": v1alpha1.ReasonConflict,
+		"invalid":   v1alpha1.ReasonInvalid,
+	} {
+		obj, err := client.Resource(v1alpha1.UniqueAnnotationPolicies).Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		var p v1alpha1.UniqueAnnotationPolicy
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &p))
+		condition := meta.FindStatusCondition(p.Status.Conditions, v1alpha1.ConditionAccepted)
+		if assert.NotNil(t, condition, name) {
+			assert.Equal(t, reason, condition.Reason, name)
+			assert.Equal(t, reason == v1alpha1.ReasonValid, condition.Status == metav1.ConditionTrue, name)
+		}
+		assert.Equal(t, int64(1), p.Status.ObservedGeneration, name)
+	}
+}
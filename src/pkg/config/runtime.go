@@ -0,0 +1,103 @@
+/*
+ *     runtime.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Overridable is a source whose configuration can be replaced while
+// running, for example via the admin API.
+type Overridable interface {
+	Source
+	// Replace stores c as the configuration of the source and returns the
+	// configuration it replaced.
+	Replace(ctx context.Context, c *Config) (*Config, error)
+}
+
+// RuntimeSource holds a configuration set while running. It is kept in
+// memory only, so it applies to a single replica and is lost on restart;
+// use OverrideConfigMap for deployments with several replicas.
+type RuntimeSource struct {
+	name string
+
+	lock   sync.Mutex
+	config *Config
+}
+
+// Runtime returns an empty runtime source. It should be added to the
+// manager last, so its configuration takes precedence over all others.
+func Runtime(name string) *RuntimeSource {
+	return &RuntimeSource{name: name}
+}
+
+func (s *RuntimeSource) Name() string {
+	return s.name
+}
+
+func (s *RuntimeSource) Load(context.Context) (*Config, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.config, nil
+}
+
+func (s *RuntimeSource) Replace(_ context.Context, c *Config) (*Config, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous := s.config
+	s.config = c
+	return previous, nil
+}
+
+// Override replaces the configuration of source, which must be one of the
+// sources of m, by c and reloads. Like every source, c is merged scope by
+// scope, so an empty list of annotations removes a scope and a nil c
+// removes all overrides. The resulting configuration is validated before
+// c is stored, so invalid overrides never reach other replicas watching
+// source. If the reload fails nevertheless, the previous configuration of
+// source is restored and the error is returned.
+func (m *Manager) Override(ctx context.Context, source Overridable, c *Config) error {
+	m.overrides.Lock()
+	defer m.overrides.Unlock()
+
+	if _, err := m.candidate(ctx, source, c); err != nil {
+		return err
+	}
+	previous, err := source.Replace(ctx, c)
+	if err != nil {
+		return fmt.Errorf("storing override in %q: %w", source.Name(), err)
+	}
+	err = m.Reload(ctx)
+	if err == nil {
+		return nil
+	}
+	if _, err := source.Replace(ctx, previous); err != nil {
+		m.logger.Error("Failed to restore configuration after rejected override", zap.String("source", source.Name()), zap.Error(err))
+	}
+	// Reload again, so the rejected override is not reported by Healthy.
+	if err := m.Reload(ctx); err != nil {
+		m.logger.Error("Failed to reload configuration after rejected override", zap.Error(err))
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+/*
+ *     secret.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+type secretSource struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// Secret returns a source reading the configuration from the named Secret,
+// for teams keeping it alongside credentials. The document is stored under
+// ConfigMapKey and decoded like the one of a ConfigMap source, and a
+// missing Secret provides no configuration either.
+//
+// Like ConfigMap sources, the source is a Watcher reporting changes of the
+// Secret and a possible change at least once per minute.
+func Secret(clientset kubernetes.Interface, namespace, name string) Source {
+	return &secretSource{clientset: clientset, namespace: namespace, name: name}
+}
+
+func (s *secretSource) Name() string {
+	return "secret/" + s.namespace + "/" + s.name
+}
+
+func (s *secretSource) Load(ctx context.Context) (*Config, error) {
+	secret, err := s.clientset.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return parseSecret(secret)
+}
+
+// parseSecret decodes the configuration stored in secret.
+func parseSecret(secret *corev1.Secret) (*Config, error) {
+	data, found := secret.Data[ConfigMapKey]
+	if !found {
+		return nil, fmt.Errorf("key %q not found", ConfigMapKey)
+	}
+	return decode(data)
+}
+
+func (s *secretSource) Watch(ctx context.Context, changed func()) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.clientset, configMapResync,
+		informers.WithNamespace(s.namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("metadata.name", s.name).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { changed() },
+		UpdateFunc: func(interface{}, interface{}) { changed() },
+		DeleteFunc: func(interface{}) { changed() },
+	})
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
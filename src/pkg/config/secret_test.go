@@ -0,0 +1,80 @@
+/*
+ *     secret_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecret(t *testing.T) {
+	tc := testclient.NewSimpleClientset()
+	source := Secret(tc, "unik", "unik-config")
+	assert.Equal(t, "secret/unik/unik-config", source.Name())
+
+	c, err := source.Load(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, c, "a missing Secret provides no configuration")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	go source.(Watcher).Watch(ctx, func() { changes <- struct{}{} })
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "unik", Name: "unik-config"},
+		Data: map[string][]byte{
+			ConfigMapKey: []byte("protected:\n  \"*\":\n  - key: example.com/ip\n"),
+			"token":      []byte("s3cr3t"),
+		},
+	}
+	_, err = tc.CoreV1().Secrets("unik").Create(ctx, secret, metav1.CreateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("creating the Secret was not reported")
+	}
+
+	c, err = source.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &Config{Protected: validator.UniqueList{
+		validator.ClusterScope: {{Key: "example.com/ip"}},
+	}}, c, "other keys of the Secret are ignored")
+
+	delete(secret.Data, ConfigMapKey)
+	_, err = tc.CoreV1().Secrets("unik").Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updating the Secret was not reported")
+	}
+	_, err = source.Load(ctx)
+	assert.ErrorContains(t, err, "not found")
+}
@@ -0,0 +1,56 @@
+/*
+ *     source.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package config
+
+import "context"
+
+// Source provides a partial configuration.
+type Source interface {
+	// Name identifies the source in logs and errors.
+	Name() string
+	// Load returns the current configuration of the source.
+	Load(ctx context.Context) (*Config, error)
+}
+
+// Watcher is implemented by sources which can detect changes themselves.
+// Watch blocks until ctx is done and calls changed whenever the
+// configuration of the source may have changed.
+type Watcher interface {
+	Watch(ctx context.Context, changed func())
+}
+
+type staticSource struct {
+	name   string
+	config *Config
+}
+
+// Static returns a source always providing config, for example
+// the configuration derived from command line flags.
+func Static(name string, config *Config) Source {
+	return &staticSource{name: name, config: config}
+}
+
+func (s *staticSource) Name() string {
+	return s.name
+}
+
+func (s *staticSource) Load(context.Context) (*Config, error) {
+	return s.config, nil
+}
@@ -0,0 +1,145 @@
+/*
+ *     response.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package response builds AdmissionResponses. All constructors set the UID
+// of the request and record why a decision was made in the audit annotations,
+// so responses do not depend on every call site getting this right.
+package response
+
+import (
+	"net/http"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AuditAnnotationReason is the key of the audit annotation holding the
+// Reason of a decision. The apiserver prefixes it with the webhook name.
+const AuditAnnotationReason = "reason"
+
+// AuditAnnotationTrace is the key of the audit annotation holding a compact
+// trace of how a decision was made, if tracing is enabled.
+const AuditAnnotationTrace = "trace"
+
+// AuditAnnotationMode is the key of the audit annotation holding the mode
+// of the namespace a denial was turned into a warning in.
+const AuditAnnotationMode = "mode"
+
+// AuditAnnotationShared is the key of the audit annotation listing the
+// "annotation=value" pairs of an object which were not checked for
+// uniqueness, as the values are marked shared.
+const AuditAnnotationShared = "shared"
+
+// Reason classifies a decision.
+type Reason string
+
+const (
+	ReasonUnsupportedResource Reason = "unsupported-resource"
+	ReasonNotPresent          Reason = "annotation-not-present"
+	ReasonUnique              Reason = "annotation-unique"
+	ReasonShared              Reason = "value-shared"
+	ReasonConflict            Reason = "annotation-conflict"
+	ReasonLeased              Reason = "value-leased"
+	ReasonImmutable           Reason = "annotation-immutable"
+	ReasonRequired            Reason = "annotation-required"
+	ReasonDistinct            Reason = "annotation-distinct"
+	ReasonOverloaded          Reason = "overloaded"
+	ReasonSelf                Reason = "self-originated"
+	ReasonModeOff             Reason = "mode-off"
+	ReasonExempt              Reason = "namespace-exempt"
+	ReasonTerminating         Reason = "namespace-terminating"
+	ReasonError               Reason = "error"
+)
+
+// Allowed admits the request identified by uid.
+func Allowed(uid types.UID, reason Reason, warnings ...string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:              uid,
+		Allowed:          true,
+		Warnings:         warnings,
+		AuditAnnotations: map[string]string{AuditAnnotationReason: string(reason)},
+	}
+}
+
+// Denied rejects the request identified by uid, telling the user why in message.
+func Denied(uid types.UID, reason Reason, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: message,
+		},
+		AuditAnnotations: map[string]string{AuditAnnotationReason: string(reason)},
+	}
+}
+
+// Errored reports that no decision could be made on the request identified
+// by uid. The apiserver applies the failurePolicy of the webhook to it.
+func Errored(uid types.UID, err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusInternalServerError,
+			Reason:  metav1.StatusReasonInternalError,
+			Message: "unik: " + err.Error(),
+		},
+		AuditAnnotations: map[string]string{AuditAnnotationReason: string(ReasonError)},
+	}
+}
+
+// Throttled asks the apiserver to retry the request identified by uid after
+// retryAfter, as the webhook is overloaded.
+func Throttled(uid types.UID, retryAfter time.Duration) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		UID:     uid,
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusTooManyRequests,
+			Reason:  metav1.StatusReasonTooManyRequests,
+			Message: "unik: too many requests, retry later",
+			Details: &metav1.StatusDetails{RetryAfterSeconds: RetryAfterSeconds(retryAfter)},
+		},
+		AuditAnnotations: map[string]string{AuditAnnotationReason: string(ReasonOverloaded)},
+	}
+}
+
+// RetryAfterSeconds rounds d up to whole seconds, but at least one, as
+// expected by the Retry-After header.
+func RetryAfterSeconds(d time.Duration) int32 {
+	return int32(max(1, (d+time.Second-1)/time.Second))
+}
+
+// Review wraps resp into an AdmissionReview of the version the apiserver expects.
+func Review(resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionReview {
+	return &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionv1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Response: resp,
+	}
+}
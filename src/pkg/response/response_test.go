@@ -0,0 +1,78 @@
+/*
+ *     response_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package response
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func TestResponses(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		resp    *admissionv1.AdmissionResponse
+		allowed bool
+		code    int32
+		reason  Reason
+	}{
+		{"allowed", Allowed("uid", ReasonUnique, "warning"), true, 0, ReasonUnique},
+		{"denied", Denied("uid", ReasonConflict, "taken"), false, http.StatusForbidden, ReasonConflict},
+		{"errored", Errored("uid", errors.New("boom")), false, http.StatusInternalServerError, ReasonError},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, "uid", string(tC.resp.UID))
+			assert.Equal(t, tC.allowed, tC.resp.Allowed)
+			assert.Equal(t, string(tC.reason), tC.resp.AuditAnnotations[AuditAnnotationReason])
+			if !tC.allowed {
+				assert.Equal(t, tC.code, tC.resp.Result.Code)
+			}
+		})
+	}
+
+	review := Review(Allowed("uid", ReasonNotPresent))
+	assert.Equal(t, "admission.k8s.io/v1", review.APIVersion)
+	assert.Equal(t, "AdmissionReview", review.Kind)
+	assert.Nil(t, review.Request)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(Review(Denied("uid", ReasonConflict, "taken"))))
+
+	broken := Review(&admissionv1.AdmissionResponse{
+		Allowed:          false,
+		AuditAnnotations: map[string]string{"unik.io/reason": "x", "bad key": "y"},
+		Warnings:         []string{strings.Repeat("w", maxWarningLength+1)},
+	})
+	broken.APIVersion = "admission.k8s.io/v1beta1"
+	err := Validate(broken)
+	if assert.Error(t, err) {
+		for _, violation := range []string{"apiVersion", "uid", "no result message", `"unik.io/reason"`, `"bad key"`, "truncated"} {
+			assert.Contains(t, err.Error(), violation)
+		}
+	}
+
+	assert.ErrorContains(t, Validate(&admissionv1.AdmissionReview{}), "response is missing")
+}
@@ -0,0 +1,75 @@
+/*
+ *     validate.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package response
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxWarningLength is the length after which the apiserver may truncate warnings.
+const maxWarningLength = 256
+
+// Validate checks review against the rules the apiserver applies to
+// responses of admission webhooks and returns all violations.
+// It is meant to catch bugs during development and is too costly to run
+// on every response in production.
+func Validate(review *admissionv1.AdmissionReview) error {
+	var errs []error
+	if v := admissionv1.SchemeGroupVersion.String(); review.APIVersion != v {
+		errs = append(errs, fmt.Errorf("apiVersion is %q instead of %q", review.APIVersion, v))
+	}
+	if review.Kind != "AdmissionReview" {
+		errs = append(errs, fmt.Errorf("kind is %q instead of \"AdmissionReview\"", review.Kind))
+	}
+
+	resp := review.Response
+	if resp == nil {
+		return errors.Join(append(errs, errors.New("response is missing"))...)
+	}
+	if resp.UID == "" {
+		errs = append(errs, errors.New("response.uid is empty"))
+	}
+	if !resp.Allowed && (resp.Result == nil || resp.Result.Message == "") {
+		errs = append(errs, errors.New("denied response has no result message"))
+	}
+	if (resp.Patch == nil) != (resp.PatchType == nil) {
+		errs = append(errs, errors.New("response.patch and response.patchType must be set together"))
+	}
+	for key := range resp.AuditAnnotations {
+		if strings.Contains(key, "/") {
+			errs = append(errs, fmt.Errorf("audit annotation %q must not have a prefix", key))
+			continue
+		}
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Errorf("audit annotation %q: %s", key, msg))
+		}
+	}
+	for i, w := range resp.Warnings {
+		if len(w) > maxWarningLength {
+			errs = append(errs, fmt.Errorf("warning %d is longer than %d characters and may be truncated", i, maxWarningLength))
+		}
+	}
+	return errors.Join(errs...)
+}
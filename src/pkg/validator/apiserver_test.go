@@ -0,0 +1,159 @@
+/*
+ *     apiserver_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// fakeAPIServer answers service list requests like an apiserver, but can be
+// made slow or failing, which the fake clientset cannot simulate.
+type fakeAPIServer struct {
+	*httptest.Server
+	services []corev1.Service
+
+	delay    atomic.Int64
+	failures atomic.Int32
+	requests atomic.Int32
+}
+
+func newFakeAPIServer(services ...corev1.Service) *fakeAPIServer {
+	f := &fakeAPIServer{services: services}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeAPIServer) serve(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	select {
+	case <-time.After(time.Duration(f.delay.Load())):
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if f.failures.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(metav1.Status{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+			Status:   metav1.StatusFailure,
+			Code:     http.StatusInternalServerError,
+			Reason:   metav1.StatusReasonInternalError,
+			Message:  "etcdserver: request timed out",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(corev1.ServiceList{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceList"},
+		Items:    f.services,
+	})
+}
+
+func (f *fakeAPIServer) clientset() kubernetes.Interface {
+	return kubernetes.NewForConfigOrDie(&rest.Config{Host: f.URL})
+}
+
+func (s *HandlerSuite) TestAPIServerTimeout() {
+	api := newFakeAPIServer()
+	defer api.Close()
+	api.delay.Store(int64(time.Second))
+
+	informer := informers.NewSharedInformerFactory(testclient.NewSimpleClientset(), 0).Core().V1().Services().Informer()
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(api.clientset()),
+		WithAPITimeout(50*time.Millisecond), WithDecisionCache(time.Minute, informer))
+	s.Require().NoError(err)
+
+	start := time.Now()
+	response := h.Validate(context.Background(), ar)
+	s.Less(time.Since(start), time.Second, "the timeout must cut the call short")
+	s.Equal(ar.Request.UID, response.UID)
+	s.False(response.Allowed)
+	s.Require().NotNil(response.Result)
+	s.Equal(int32(http.StatusInternalServerError), response.Result.Code)
+
+	// Errors must not be cached.
+	api.delay.Store(0)
+	s.True(h.Validate(context.Background(), ar).Allowed)
+}
+
+func (s *HandlerSuite) TestAPIServerCancellation() {
+	api := newFakeAPIServer()
+	defer api.Close()
+	api.delay.Store(int64(time.Second))
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(api.clientset()))
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	response := h.Validate(ctx, ar)
+	s.Less(time.Since(start), time.Second, "abandoned requests must stop calling the apiserver")
+	s.False(response.Allowed)
+	s.Contains(response.Result.Message, context.DeadlineExceeded.Error())
+
+	requests := api.requests.Load()
+	s.False(h.Validate(ctx, ar).Allowed)
+	s.Equal(requests, api.requests.Load(), "no calls are made once the request is abandoned")
+}
+
+func (s *HandlerSuite) TestAPIServerFailure() {
+	api := newFakeAPIServer()
+	defer api.Close()
+	api.failures.Store(1)
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(api.clientset()))
+	s.Require().NoError(err)
+
+	response := h.Validate(context.Background(), ar)
+	s.Equal(ar.Request.UID, response.UID)
+	s.False(response.Allowed)
+	s.Contains(response.Result.Message, "etcdserver: request timed out")
+
+	// The apiserver retries with the same request, which must now succeed.
+	s.True(h.Validate(context.Background(), ar).Allowed)
+	s.Equal(int32(2), api.requests.Load())
+}
+
+func (s *HandlerSuite) TestAPIServerDuplicateDelivery() {
+	api := newFakeAPIServer(*poolService("other", "holder", "test"))
+	defer api.Close()
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(api.clientset()), WithDenialEscalation(2, time.Minute))
+	s.Require().NoError(err)
+
+	first := h.Validate(context.Background(), ar)
+	second := h.Validate(context.Background(), ar)
+	s.False(first.Allowed)
+	s.Equal(first, second, "duplicate deliveries must get identical answers")
+}
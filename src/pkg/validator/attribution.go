@@ -0,0 +1,133 @@
+/*
+ *     attribution.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// serviceAccountPrefix starts the names of service account users, followed
+// by the namespace and name of the account separated by a colon.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// DefaultAttributionExtra names the extra fields of the requesting user
+// surfaced by default. Service account tokens bound to a pod carry the name
+// of the pod, which identifies the CI job or controller using the account.
+var DefaultAttributionExtra = []string{"authentication.kubernetes.io/pod-name"}
+
+// Attribution identifies who initiated a request. Requests made by CI
+// pipelines and controllers run as service accounts, and proxies
+// impersonating their users usually keep the original user in extra fields,
+// so the user alone often does not tell who is responsible.
+type Attribution struct {
+	User   string
+	Groups []string
+	// ServiceAccount is "namespace/name" if the user is a service account.
+	ServiceAccount string
+	// Extra holds the selected extra fields of the user which are set.
+	Extra map[string][]string
+}
+
+// Attribute returns the attribution of a request by user, including the
+// extra fields named by keys. Other extra fields may carry credentials and
+// are never included.
+func Attribute(user authenticationv1.UserInfo, keys []string) Attribution {
+	a := Attribution{User: user.Username, Groups: user.Groups}
+	if account, found := strings.CutPrefix(user.Username, serviceAccountPrefix); found {
+		if namespace, name, found := strings.Cut(account, ":"); found && namespace != "" && name != "" {
+			a.ServiceAccount = namespace + "/" + name
+		}
+	}
+	for _, key := range keys {
+		if values, found := user.Extra[key]; found {
+			if a.Extra == nil {
+				a.Extra = make(map[string][]string)
+			}
+			a.Extra[key] = values
+		}
+	}
+	return a
+}
+
+// MarshalLogObject logs a without the groups, which are only logged when
+// needed as they are long for most users.
+func (a Attribution) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("user", a.User)
+	if a.ServiceAccount != "" {
+		enc.AddString("service_account", a.ServiceAccount)
+	}
+	for _, key := range a.keys() {
+		enc.AddString(key, strings.Join(a.Extra[key], ","))
+	}
+	return nil
+}
+
+// String describes a for users, for example in denial messages.
+func (a Attribution) String() string {
+	return "requested by " + a.details()
+}
+
+// details lists the user and extra fields of a.
+func (a Attribution) details() string {
+	var b strings.Builder
+	b.WriteString(a.User)
+	for _, key := range a.keys() {
+		fmt.Fprintf(&b, ", %s=%s", key, strings.Join(a.Extra[key], ","))
+	}
+	return b.String()
+}
+
+// keys returns the keys of the extra fields of a in order.
+func (a Attribution) keys() []string {
+	keys := make([]string, 0, len(a.Extra))
+	for key := range a.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WithAttribution selects the extra fields of the requesting user which are
+// logged along with the user, instead of DefaultAttributionExtra. If denials
+// is set, denial messages name the user and these fields, so that users of
+// shared CI accounts learn whose request was denied.
+func WithAttribution(keys []string, denials bool) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		h.attributionExtra = keys
+		h.attributeDenials = denials
+		return nil
+	}
+}
+
+// attributeDenial appends the attribution of ar to the message of resp if
+// it is a denial and denials are attributed.
+func (h *AdmitHandlerV1) attributeDenial(ar admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) {
+	if !h.attributeDenials || resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusForbidden {
+		return
+	}
+	resp.Result.Message += h.message(MessageAttribution, Attribute(ar.Request.UserInfo, h.attributionExtra).details())
+}
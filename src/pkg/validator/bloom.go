@@ -0,0 +1,270 @@
+/*
+ *     bloom.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithValueFilters keeps a bloom filter per protected annotation over the
+// values held by the services known to informer, sized for capacity values
+// at a false positive rate of fpRate. Values the filters rule out are
+// admitted without listing services; only possible hits take the precise path.
+// Values admitted by the handler are added to the filters right away and
+// kept across rebuilds until the informer has seen them, so a service not
+// yet seen by the informer still sends later requests for its value down the
+// precise path. Values admitted by other replicas are only known once their
+// watch event arrives, unless they are received by WithClaimBus.
+//
+// Filters are built from the informer cache once it has synced and are
+// rebuilt when they outgrow their capacity or too many of their values
+// have been released by deleted or changed services.
+func WithValueFilters(informer cache.SharedIndexInformer, capacity int, fpRate float64) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if informer == nil {
+			return errors.New("informer is nil")
+		}
+		if capacity <= 0 {
+			return errors.New("value filter capacity must be positive")
+		}
+		if fpRate <= 0 || fpRate >= 1 {
+			return errors.New("value filter false positive rate must be between 0 and 1")
+		}
+		h.values = &valueFilters{
+			store:    informer.GetStore(),
+			synced:   informer.HasSynced,
+			capacity: capacity,
+			fpRate:   fpRate,
+			filters:  make(map[string]*bloomFilter),
+			pending:  make(map[string]map[string]time.Time),
+		}
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { h.values.update(nil, obj) },
+			UpdateFunc: func(old, obj interface{}) { h.values.update(old, obj) },
+			DeleteFunc: func(obj interface{}) { h.values.update(obj, nil) },
+		})
+		return err
+	}
+}
+
+type filterResult int
+
+const (
+	// filterUnavailable means the filter could not be consulted.
+	filterUnavailable filterResult = iota
+	// filterUnused means the value is definitely not in use.
+	filterUnused
+	// filterHit means the value may be in use.
+	filterHit
+)
+
+// valueFilters holds the bloom filters by annotation key. Filters are
+// created on the first lookup of their annotation.
+type valueFilters struct {
+	store    cache.Store
+	synced   func() bool
+	capacity int
+	fpRate   float64
+
+	lock    sync.Mutex
+	filters map[string]*bloomFilter
+	// pending holds the claimed values by annotation key and the time they
+	// were claimed at, until the informer has seen a service holding them.
+	pending map[string]map[string]time.Time
+}
+
+// pendingClaimTTL is how long a claimed value is kept across rebuilds
+// without the informer seeing it, for example because the request was
+// rejected after being admitted.
+const pendingClaimTTL = 2 * time.Minute
+
+// lookup reports whether value of the annotation key may be in use.
+func (f *valueFilters) lookup(key, value string, now time.Time) filterResult {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.synced() {
+		return filterUnavailable
+	}
+	filter, found := f.filters[key]
+	if !found || filter.stale() {
+		filter = f.build(key, now)
+		f.filters[key] = filter
+	}
+	metrics.ValueFilterFalsePositiveRate.WithLabelValues(key).Set(filter.estimatedFalsePositiveRate())
+	if !filter.mayContain(value) {
+		metrics.ValueFilterLookups.WithLabelValues(key, "unused").Inc()
+		return filterUnused
+	}
+	metrics.ValueFilterLookups.WithLabelValues(key, "hit").Inc()
+	return filterHit
+}
+
+// build creates the filter of the annotation key from the informer cache
+// and the values claimed since.
+func (f *valueFilters) build(key string, now time.Time) *bloomFilter {
+	var values []string
+	for _, obj := range f.store.List() {
+		if svc, ok := obj.(*corev1.Service); ok {
+			if value, found := svc.Annotations[key]; found {
+				values = append(values, value)
+			}
+		}
+	}
+	for value, claimed := range f.pending[key] {
+		if now.Sub(claimed) > pendingClaimTTL {
+			delete(f.pending[key], value)
+			continue
+		}
+		values = append(values, value)
+	}
+	filter := newBloomFilter(max(f.capacity, 2*len(values)), f.fpRate)
+	for _, value := range values {
+		filter.add(value)
+	}
+	return filter
+}
+
+// update adds the values obj holds to the filters and records the values
+// released by the change from old.
+func (f *valueFilters) update(old, obj interface{}) {
+	if tombstone, ok := old.(cache.DeletedFinalStateUnknown); ok {
+		old = tombstone.Obj
+	}
+	var before, after map[string]string
+	if svc, ok := old.(*corev1.Service); ok {
+		before = svc.Annotations
+	}
+	if svc, ok := obj.(*corev1.Service); ok {
+		after = svc.Annotations
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for key, claimed := range f.pending {
+		if value, holds := after[key]; holds {
+			delete(claimed, value)
+		}
+	}
+	for key, filter := range f.filters {
+		previous, held := before[key]
+		value, holds := after[key]
+		if holds && (!held || previous != value) {
+			filter.add(value)
+		}
+		if held && (!holds || previous != value) {
+			filter.released++
+		}
+	}
+}
+
+// claim adds value to the filter of the annotation key and keeps it for
+// the filters built until the informer has seen a service holding it.
+func (f *valueFilters) claim(key, value string, now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if filter, found := f.filters[key]; found {
+		filter.add(value)
+	}
+	if f.pending[key] == nil {
+		f.pending[key] = make(map[string]time.Time)
+	}
+	f.pending[key][value] = now
+}
+
+// bloomFilter is a bloom filter over strings using double hashing.
+type bloomFilter struct {
+	bits     []uint64
+	size     uint64
+	hashes   int
+	capacity int
+	ones     int
+
+	// added and released count the values added to the filter and those
+	// which have been released since, and are a measure for its staleness.
+	added    int
+	released int
+}
+
+// newBloomFilter returns a filter holding up to capacity values at a false
+// positive rate of fpRate.
+func newBloomFilter(capacity int, fpRate float64) *bloomFilter {
+	size := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	size = max(64, (size+63)/64*64)
+	hashes := max(1, int(math.Round(float64(size)/float64(capacity)*math.Ln2)))
+	return &bloomFilter{
+		bits:     make([]uint64, size/64),
+		size:     size,
+		hashes:   hashes,
+		capacity: capacity,
+	}
+}
+
+func (b *bloomFilter) positions(value string) func(i int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	return func(i int) uint64 { return (h1 + uint64(i)*h2) % b.size }
+}
+
+func (b *bloomFilter) add(value string) {
+	pos := b.positions(value)
+	for i := 0; i < b.hashes; i++ {
+		p := pos(i)
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			b.bits[p/64] |= 1 << (p % 64)
+			b.ones++
+		}
+	}
+	b.added++
+}
+
+// mayContain reports false if value has definitely not been added.
+func (b *bloomFilter) mayContain(value string) bool {
+	pos := b.positions(value)
+	for i := 0; i < b.hashes; i++ {
+		p := pos(i)
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stale reports whether the filter has outgrown its capacity or more than
+// a quarter of its values have been released.
+func (b *bloomFilter) stale() bool {
+	return b.added > b.capacity || b.released > b.added/4
+}
+
+// estimatedFalsePositiveRate estimates the false positive rate from the
+// share of bits set.
+func (b *bloomFilter) estimatedFalsePositiveRate() float64 {
+	return math.Pow(float64(b.ones)/float64(b.size), float64(b.hashes))
+}
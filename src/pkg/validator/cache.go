@@ -0,0 +1,198 @@
+/*
+ *     cache.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithDecisionCache caches decisions for ttl, so that re-applying identical
+// manifests, as GitOps tools do in their sync loops, does not list services
+// over and over again. Entries are invalidated as soon as informer reports
+// a change to a service holding one of the values a decision depended on.
+// Denials because of a lease are not cached past the expiry of the lease.
+func WithDecisionCache(ttl time.Duration, informer cache.SharedIndexInformer) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if ttl <= 0 {
+			return errors.New("decision cache ttl must be positive")
+		}
+		if informer == nil {
+			return errors.New("informer is nil")
+		}
+		h.cache = newDecisionCache(ttl)
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { h.invalidate(obj) },
+			UpdateFunc: func(old, obj interface{}) { h.invalidate(old); h.invalidate(obj) },
+			DeleteFunc: func(obj interface{}) { h.invalidate(obj) },
+		})
+		return err
+	}
+}
+
+// invalidate drops all cached decisions depending on a protected value of obj.
+func (h *AdmitHandlerV1) invalidate(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+	for key, value := range svc.Annotations {
+		h.cache.invalidate(key + "=" + value)
+	}
+}
+
+type cacheEntry struct {
+	resp    *admissionv1.AdmissionResponse
+	expires time.Time
+	values  []string
+}
+
+// decisionCache maps decision keys to responses. Each entry is also
+// indexed by the "annotation=value" pairs it depends on.
+type decisionCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	byValue map[string]map[string]struct{}
+}
+
+func newDecisionCache(ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+		byValue: make(map[string]map[string]struct{}),
+	}
+}
+
+// get returns a copy of the response cached for key at now, carrying uid.
+func (c *decisionCache) get(key string, uid types.UID, now time.Time) (*admissionv1.AdmissionResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, found := c.entries[key]
+	if found && now.After(e.expires) {
+		c.remove(key)
+		found = false
+	}
+	if !found {
+		metrics.DecisionCacheRequests.WithLabelValues("miss").Inc()
+		return nil, false
+	}
+	metrics.DecisionCacheRequests.WithLabelValues("hit").Inc()
+	resp := e.resp.DeepCopy()
+	resp.UID = uid
+	return resp, true
+}
+
+// put caches resp for key at now until the ttl has passed, or until
+// validUntil if it is set and earlier, such as when the lease a denial
+// depends on expires.
+func (c *decisionCache) put(key string, resp *admissionv1.AdmissionResponse, values []string, now, validUntil time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expires := now.Add(c.ttl)
+	if !validUntil.IsZero() && validUntil.Before(expires) {
+		expires = validUntil
+	}
+	c.remove(key)
+	c.entries[key] = cacheEntry{resp: resp.DeepCopy(), expires: expires, values: values}
+	for _, v := range values {
+		if c.byValue[v] == nil {
+			c.byValue[v] = make(map[string]struct{})
+		}
+		c.byValue[v][key] = struct{}{}
+	}
+}
+
+func (c *decisionCache) invalidate(value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key := range c.byValue[value] {
+		c.remove(key)
+	}
+}
+
+// flush drops all entries.
+func (c *decisionCache) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.byValue = make(map[string]map[string]struct{})
+}
+
+// remove must be called with lock held.
+func (c *decisionCache) remove(key string) {
+	e, found := c.entries[key]
+	if !found {
+		return
+	}
+	delete(c.entries, key)
+	for _, v := range e.values {
+		delete(c.byValue[v], key)
+		if len(c.byValue[v]) == 0 {
+			delete(c.byValue, v)
+		}
+	}
+}
+
+// protectedValues returns the "annotation=value" pairs of svc for all
+// protected annotations it carries.
+func protectedValues(svc corev1.Service, annotations []ScopedAnnotation) []string {
+	var values []string
+	for _, a := range annotations {
+		if v, found := a.Lookup(svc.Annotations); found {
+			values = append(values, a.Key+"="+v)
+		}
+	}
+	return values
+}
+
+// decisionKey hashes everything a decision depends on apart from other
+// services: the identity of the object, the operation, its service type
+// and the values of the protected annotations before and after the change.
+func decisionKey(ar admissionv1.AdmissionReview, svc corev1.Service, old *corev1.Service, annotations []ScopedAnnotation) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", ar.Request.Kind.Kind, ar.Request.Namespace, ar.Request.Name, ar.Request.Operation, svc.Spec.Type)
+	for _, a := range annotations {
+		for _, key := range a.Components() {
+			v, found := svc.Annotations[key]
+			fmt.Fprintf(h, "%s\x00%t\x00%s\x00", key, found, v)
+			if old != nil {
+				v, found = old.Annotations[key]
+				fmt.Fprintf(h, "%t\x00%s\x00", found, v)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,112 @@
+/*
+ *     claims.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Claim announces that a replica admitted a service newly holding Value of
+// the protected annotation Key.
+type Claim struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ClaimBus carries claims between the replicas of the webhook, usually by
+// pub/sub on a store shared by all of them, such as Redis or etcd.
+//
+// Each replica learns about new holders of a value from its informer, but
+// only once the apiserver has persisted them. Until then, its cached
+// decisions and value filters still consider the value free. A ClaimBus
+// closes this window, as claims are published as soon as they are admitted.
+type ClaimBus interface {
+	// Publish announces c to all other replicas.
+	Publish(ctx context.Context, c Claim) error
+	// Subscribe registers fn to be called for each claim published by
+	// other replicas.
+	Subscribe(fn func(Claim)) error
+}
+
+// WithClaimBus publishes the claims admitted by this replica on bus and
+// drops cached decisions and negative value filter results for the claims
+// received from other replicas.
+func WithClaimBus(bus ClaimBus) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if bus == nil {
+			return errors.New("claim bus is nil")
+		}
+		h.claims = bus
+		return bus.Subscribe(h.claimed)
+	}
+}
+
+// claimed invalidates everything assuming c.Value of c.Key to be free.
+func (h *AdmitHandlerV1) claimed(c Claim) {
+	metrics.Claims.WithLabelValues("received").Inc()
+	if h.cache != nil {
+		h.cache.invalidate(c.Key + "=" + c.Value)
+	}
+	if h.values != nil {
+		h.values.claim(c.Key, c.Value, h.clock.Now())
+	}
+}
+
+// publishClaims adds the values svc newly holds to the value filters and
+// publishes them, if resp admits it. Failing to publish does not change the
+// decision, as the informers of other replicas catch up with the claim
+// eventually.
+func (h *AdmitHandlerV1) publishClaims(ctx context.Context, l *zap.Logger, ar admissionv1.AdmissionReview, svc corev1.Service, old *corev1.Service, annotations []ScopedAnnotation, resp *admissionv1.AdmissionResponse) {
+	if (h.claims == nil && h.values == nil) || !resp.Allowed || (ar.Request.DryRun != nil && *ar.Request.DryRun) {
+		return
+	}
+	for _, a := range annotations {
+		value, holds := a.Lookup(svc.Annotations)
+		if !holds || a.IsShared(value) {
+			continue
+		}
+		if old != nil {
+			if previous, held := a.Lookup(old.Annotations); held && previous == value {
+				continue
+			}
+		}
+		if h.values != nil {
+			h.values.claim(a.Key, value, h.clock.Now())
+		}
+		if h.claims == nil {
+			continue
+		}
+		pctx, cancel := h.apiContext(ctx)
+		err := h.claims.Publish(pctx, Claim{Key: a.Key, Value: value})
+		cancel()
+		if err != nil {
+			metrics.Claims.WithLabelValues("failed").Inc()
+			l.Warn("Failed to publish claim", zap.String("annotation", a.Key), zap.Error(err))
+			continue
+		}
+		metrics.Claims.WithLabelValues("published").Inc()
+	}
+}
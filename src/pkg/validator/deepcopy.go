@@ -0,0 +1,103 @@
+/*
+ *     deepcopy.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import "slices"
+
+// DeepCopyInto copies p into out, so ProtectedAnnotations can be embedded
+// in API types with generated deep copy functions.
+func (p *ProtectedAnnotation) DeepCopyInto(out *ProtectedAnnotation) {
+	*out = *p
+	if p.Required != nil {
+		out.Required = p.Required.DeepCopy()
+	}
+	out.Operations = slices.Clone(p.Operations)
+	if p.OperationsIn != nil {
+		out.OperationsIn = make([]NamespaceOperations, len(p.OperationsIn))
+		for i := range p.OperationsIn {
+			p.OperationsIn[i].DeepCopyInto(&out.OperationsIn[i])
+		}
+	}
+	if p.ReleaseTerminatingAfter != nil {
+		d := *p.ReleaseTerminatingAfter
+		out.ReleaseTerminatingAfter = &d
+	}
+	if p.Lease != nil {
+		d := *p.Lease
+		out.Lease = &d
+	}
+	out.Pool = slices.Clone(p.Pool)
+	out.Shared = slices.Clone(p.Shared)
+	out.Composite = slices.Clone(p.Composite)
+	if p.Distinct != nil {
+		out.Distinct = p.Distinct.DeepCopy()
+	}
+	out.Namespaces = slices.Clone(p.Namespaces)
+}
+
+// DeepCopy returns a copy of p sharing no memory with it.
+func (p *ProtectedAnnotation) DeepCopy() *ProtectedAnnotation {
+	if p == nil {
+		return nil
+	}
+	out := new(ProtectedAnnotation)
+	p.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies r into out.
+func (r *Requirement) DeepCopyInto(out *Requirement) {
+	*out = *r
+	out.ServiceTypes = slices.Clone(r.ServiceTypes)
+	out.Namespaces = slices.Clone(r.Namespaces)
+	out.Operations = slices.Clone(r.Operations)
+}
+
+// DeepCopy returns a copy of r sharing no memory with it.
+func (r *Requirement) DeepCopy() *Requirement {
+	if r == nil {
+		return nil
+	}
+	out := new(Requirement)
+	r.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies d into out.
+func (d *Distinction) DeepCopyInto(out *Distinction) {
+	*out = *d
+	out.Keys = slices.Clone(d.Keys)
+}
+
+// DeepCopy returns a copy of d sharing no memory with it.
+func (d *Distinction) DeepCopy() *Distinction {
+	if d == nil {
+		return nil
+	}
+	out := new(Distinction)
+	d.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto copies n into out.
+func (n *NamespaceOperations) DeepCopyInto(out *NamespaceOperations) {
+	*out = *n
+	out.Operations = slices.Clone(n.Operations)
+}
@@ -0,0 +1,67 @@
+/*
+ *     degraded.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// DegradedWarning is attached to every admitted response while the
+// validator knowingly operates in degraded mode, unless replaced by the
+// MessageDegraded text of a Catalog.
+const DegradedWarning = "unik: operating in degraded mode, uniqueness not fully guaranteed"
+
+// DegradedCheck reports whether a subsystem the validator depends on is
+// degraded. The reason is used as metric label and should be a short,
+// constant identifier of the subsystem, e.g. "scanner".
+type DegradedCheck func() (reason string, degraded bool)
+
+// WithDegradedCheck adds a check consulted for every admitted request.
+// The option can be given multiple times.
+func WithDegradedCheck(check DegradedCheck) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if check == nil {
+			return errors.New("degraded check is nil")
+		}
+		h.degradedChecks = append(h.degradedChecks, check)
+		return nil
+	}
+}
+
+// markDegraded attaches the degraded warning to resp if it admits the request
+// and any of the degraded checks fails.
+func (h *AdmitHandlerV1) markDegraded(resp *admissionv1.AdmissionResponse) {
+	if !resp.Allowed {
+		return
+	}
+	var degraded bool
+	for _, check := range h.degradedChecks {
+		if reason, failed := check(); failed {
+			metrics.DegradedDecisions.WithLabelValues(reason).Inc()
+			degraded = true
+		}
+	}
+	if degraded {
+		resp.Warnings = append(resp.Warnings, h.problem(h.message(MessageDegraded))...)
+	}
+}
@@ -0,0 +1,30 @@
+/*
+ *     doc.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package validator decides whether an AdmissionReview keeps the protected
+// annotations of a Service unique.
+//
+// The packages below pkg/ are the public surface of this module: the
+// ValidationHandlerV1 interface and its options here, the configuration
+// types in pkg/config, the response builders in pkg/response and the
+// endpoint types in pkg/api used by pkg/client. Their
+// exported API only changes incompatibly with a new major version of the
+// module. Everything below internal/ is an implementation detail of the
+// unik binary and may change with any release.
+package validator
@@ -0,0 +1,111 @@
+/*
+ *     escalation.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// denialKey identifies repeated attempts of a user to make the same claim.
+// The denial message stands in for the annotation and value.
+type denialKey struct {
+	user, namespace, message string
+}
+
+type denialWindow struct {
+	start   time.Time
+	count   int
+	lastUID types.UID
+}
+
+// denialTracker counts denials per denialKey within a fixed window.
+type denialTracker struct {
+	threshold int
+	window    time.Duration
+
+	lock    sync.Mutex
+	windows map[denialKey]*denialWindow
+}
+
+// WithDenialEscalation escalates denials once the same user was denied
+// the same request threshold times within window in the same namespace.
+// Escalated denials carry guidance on how to resolve the conflict, and a
+// single aggregated warning is logged per window instead of one per denial.
+func WithDenialEscalation(threshold int, window time.Duration) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if threshold < 1 || window <= 0 {
+			return errors.New("threshold and window must be positive")
+		}
+		h.denials = &denialTracker{threshold: threshold, window: window, windows: make(map[denialKey]*denialWindow)}
+		return nil
+	}
+}
+
+// record counts a denial for key at now and returns the number of denials
+// within the current window. Repeated deliveries of the same request, which
+// the apiserver sends with the same uid, are counted once.
+func (t *denialTracker) record(key denialKey, uid types.UID, now time.Time) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for k, w := range t.windows {
+		if now.Sub(w.start) > t.window {
+			delete(t.windows, k)
+		}
+	}
+	w, found := t.windows[key]
+	if !found {
+		w = &denialWindow{start: now}
+		t.windows[key] = w
+	}
+	if w.count > 0 && w.lastUID == uid {
+		return w.count
+	}
+	w.count++
+	w.lastUID = uid
+	return w.count
+}
+
+// escalate counts the denial in resp and, once the threshold is reached,
+// returns a copy of resp carrying guidance for the user.
+func (h *AdmitHandlerV1) escalate(l *zap.Logger, ar admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	if h.denials == nil || resp.Allowed || resp.Result == nil {
+		return resp
+	}
+	key := denialKey{user: ar.Request.UserInfo.Username, namespace: ar.Request.Namespace, message: resp.Result.Message}
+	count := h.denials.record(key, ar.Request.UID, h.clock.Now())
+	if count < h.denials.threshold {
+		return resp
+	}
+	if count == h.denials.threshold {
+		l.Warn("Repeated denials", zap.String("user", key.user), zap.Int("denials", count), zap.Duration("window", h.denials.window), zap.String("message", key.message))
+	}
+
+	escalated := resp.DeepCopy()
+	escalated.Result.Message += h.message(MessageRepeatedDenials,
+		count, h.denials.window, ar.Request.Namespace)
+	return escalated
+}
@@ -0,0 +1,84 @@
+/*
+ *     events.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// WithDenialEvents records a Warning Event on the reviewed object for every
+// denial, so users find out why their objects never appeared with kubectl
+// describe or kubectl get events. Dry-run requests do not record Events.
+//
+// Repeated denials are aggregated by the recorder: with a recorder created by
+// a record.EventBroadcaster, identical Events increase the count of a single
+// Event instead of creating new ones.
+func WithDenialEvents(recorder record.EventRecorder) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if recorder == nil {
+			return errors.New("event recorder is nil")
+		}
+		h.recorder = recorder
+		return nil
+	}
+}
+
+// recordDenial records an Event for resp if it denies the request of ar.
+func (h *AdmitHandlerV1) recordDenial(ar admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) {
+	if h.recorder == nil || resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusForbidden {
+		return
+	}
+	if ar.Request.DryRun != nil && *ar.Request.DryRun {
+		return
+	}
+	// Objects created with generateName have no name to attach the Event to.
+	if ar.Request.Name == "" {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: ar.Request.Kind.Version,
+		Kind:       ar.Request.Kind.Kind,
+		Namespace:  ar.Request.Namespace,
+		Name:       ar.Request.Name,
+	}
+	if ar.Request.Kind.Group != "" {
+		ref.APIVersion = ar.Request.Kind.Group + "/" + ar.Request.Kind.Version
+	}
+	h.recorder.Event(ref, corev1.EventTypeWarning, eventReason(response.Reason(resp.AuditAnnotations[response.AuditAnnotationReason])), resp.Result.Message)
+}
+
+// eventReason turns reason into the UpperCamelCase form used for the
+// reasons of Events, for example "AnnotationConflict".
+func eventReason(reason response.Reason) string {
+	var b strings.Builder
+	for _, word := range strings.Split(string(reason), "-") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
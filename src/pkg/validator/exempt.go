@@ -0,0 +1,47 @@
+/*
+ *     exempt.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultExemptNamespaces are the namespaces of the cluster itself, which
+// run no services claiming values of protected annotations.
+var DefaultExemptNamespaces = []string{"kube-system", "kube-public"}
+
+// WithExemptNamespaces admits all requests in the given namespaces without
+// validating them. The option can be given multiple times.
+func WithExemptNamespaces(namespaces ...string) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		for _, ns := range namespaces {
+			if msgs := validation.IsDNS1123Label(ns); len(msgs) > 0 {
+				return fmt.Errorf("invalid exempt namespace %q: %v", ns, msgs)
+			}
+			if h.exempt == nil {
+				h.exempt = make(map[string]bool)
+			}
+			h.exempt[ns] = true
+		}
+		return nil
+	}
+}
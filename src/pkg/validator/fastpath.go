@@ -0,0 +1,136 @@
+/*
+ *     fastpath.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithIndexFastPath looks up the holders of a value in the cache of
+// informer instead of listing services from the apiserver. A value no
+// cached service holds is admitted right away. An apparent conflict is
+// confirmed by getting each cached holder from the apiserver before
+// denying, so a stale cache costs at most some latency, never a false
+// denial. Services created but not yet seen by the informer are missed,
+// though.
+//
+// Until informer has synced, and for scopes selecting namespaces by label,
+// services are listed from the apiserver as usual.
+func WithIndexFastPath(informer cache.SharedIndexInformer) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if informer == nil {
+			return errors.New("informer is nil")
+		}
+		h.index = &serviceIndex{indexer: informer.GetIndexer(), synced: informer.HasSynced}
+		return nil
+	}
+}
+
+// serviceIndex answers lookups from an informer cache.
+type serviceIndex struct {
+	indexer cache.Indexer
+	synced  func() bool
+}
+
+// covers reports whether services of scope can be looked up in the index.
+func (i *serviceIndex) covers(scope Scope) bool {
+	if i == nil || !i.synced() {
+		return false
+	}
+	_, selects := scope.(SelectorScope)
+	return !selects
+}
+
+// list returns the cached services of scope like ListScope, restricted to
+// namespaces if given.
+func (i *serviceIndex) list(scope Scope, namespaces ...string) []corev1.Service {
+	var objs []interface{}
+	switch {
+	case len(namespaces) > 0:
+		for _, namespace := range namespaces {
+			if scope.Contains(namespace) {
+				inNamespace, _ := i.indexer.ByIndex(cache.NamespaceIndex, namespace)
+				objs = append(objs, inNamespace...)
+			}
+		}
+	case scope.Namespace() != metav1.NamespaceAll:
+		objs, _ = i.indexer.ByIndex(cache.NamespaceIndex, scope.Namespace())
+	default:
+		objs = i.indexer.List()
+	}
+	services := make([]corev1.Service, 0, len(objs))
+	for _, obj := range objs {
+		if svc, ok := obj.(*corev1.Service); ok && scope.Contains(svc.Namespace) {
+			services = append(services, *svc)
+		}
+	}
+	return services
+}
+
+// confirmHolders gets the holders of value found in the index from the
+// apiserver and returns those which still hold it, and how many did not.
+func (h *AdmitHandlerV1) confirmHolders(ctx context.Context, l *zap.Logger, annotation ScopedAnnotation, value string, components []string, holders []corev1.Service) ([]corev1.Service, int, error) {
+	var confirmed []corev1.Service
+	stale := 0
+	now := h.clock.Now()
+	for _, holder := range holders {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		apiCtx, cancel := h.apiContext(ctx)
+		live, err := h.clientset.CoreV1().Services(holder.Namespace).Get(apiCtx, holder.Name, metav1.GetOptions{})
+		cancel()
+		switch {
+		case apierrors.IsNotFound(err):
+			l.Info("Cached holder no longer exists", zap.String("service", holder.Namespace+"/"+holder.Name))
+		case err != nil:
+			return nil, 0, fmt.Errorf("confirming holder %s/%s: %w", holder.Namespace, holder.Name, err)
+		case !holds(annotation, live, value, components) || annotation.Released(live, now):
+			l.Info("Cached holder no longer holds the value", zap.String("service", holder.Namespace+"/"+holder.Name))
+		default:
+			metrics.IndexConfirmations.WithLabelValues("confirmed").Inc()
+			confirmed = append(confirmed, *live)
+			continue
+		}
+		metrics.IndexConfirmations.WithLabelValues("stale").Inc()
+		stale++
+	}
+	return confirmed, stale, nil
+}
+
+// holds reports whether svc holds value and the composite components of
+// the annotation.
+func holds(annotation ScopedAnnotation, svc *corev1.Service, value string, components []string) bool {
+	if v, found := annotation.Lookup(svc.Annotations); !found || v != value {
+		return false
+	}
+	values, complete := annotation.CompositeValues(svc.Annotations)
+	return complete && slices.Equal(values, components)
+}
@@ -0,0 +1,149 @@
+/*
+ *     messages.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MessageKey identifies a denial or warning text in a Catalog. Keys are
+// stable, so tooling can rely on them while the texts are changed.
+type MessageKey string
+
+const (
+	MessageUnsupportedResource MessageKey = "unsupported-resource"
+	MessageUnsupportedWarning  MessageKey = "unsupported-resource-warning"
+	MessageRequired            MessageKey = "annotation-required"
+	MessageRequiredWarning     MessageKey = "annotation-required-warning"
+	MessageDistinct            MessageKey = "annotation-distinct"
+	MessageDistinctWarning     MessageKey = "annotation-distinct-warning"
+	MessageConflict            MessageKey = "annotation-conflict"
+	MessageCompositeConflict   MessageKey = "composite-conflict"
+	MessageLimitReached        MessageKey = "limit-reached"
+	MessagePoolExhausted       MessageKey = "pool-exhausted"
+	MessagePoolNearlyExhausted MessageKey = "pool-nearly-exhausted"
+	MessageLeased              MessageKey = "value-leased"
+	MessageReleased            MessageKey = "value-released"
+	MessageImmutableRemoved    MessageKey = "immutable-removed"
+	MessageImmutableChanged    MessageKey = "immutable-changed"
+	MessageDegraded            MessageKey = "degraded"
+	MessageRepeatedDenials     MessageKey = "repeated-denials"
+	MessageAttribution         MessageKey = "attribution"
+	MessageModeWarn            MessageKey = "mode-warn"
+	MessageTerminating         MessageKey = "namespace-terminating"
+)
+
+// message is the default text of a MessageKey together with arguments of
+// the types it is formatted with, used to check replacements.
+type message struct {
+	text   string
+	sample []any
+}
+
+var messages = map[MessageKey]message{
+	MessageUnsupportedResource: {"unik: %s is not supported; the rules of the webhook configuration should only match services", []any{"apps/v1, Resource=deployments"}},
+	MessageUnsupportedWarning:  {"unik: Request does not contain a supported service", nil},
+	MessageRequired:            {"Service %s/%s must carry annotation \"%s\"", []any{"default", "web", "ncp/snat_pool"}},
+	MessageRequiredWarning:     {"unik: Service %s/%s must carry annotation \"%s\"", []any{"default", "web", "ncp/snat_pool"}},
+	MessageDistinct:            {"Service %s/%s must carry different values for annotations %s, but all are \"%s\"", []any{"default", "web", `"ncp/snat_pool", "ncp/ingress_pool"`, "pool-a"}},
+	MessageDistinctWarning:     {"unik: Service %s/%s should carry different values for annotations %s, but all are \"%s\"", []any{"default", "web", `"ncp/snat_pool", "ncp/ingress_pool"`, "pool-a"}},
+	MessageConflict:            {"Service %s/%s already has the same value for annotation \"%s\": \"%s\"", []any{"default", "web", "ncp/snat_pool", "pool-a"}},
+	MessageCompositeConflict:   {"Service %s/%s already has the same values for annotations %s: %s", []any{"default", "web", `"ncp/snat_pool", "ncp/router"`, `"pool-a", "t1"`}},
+	MessageLimitReached:        {"Value \"%s\" of annotation \"%s\" is already used by %d Services, at most %d may share it, for example Service %s/%s", []any{"pool-a", "ncp/snat_pool", 3, 3, "default", "web"}},
+	MessagePoolExhausted:       {"; all %d values of the pool are in use, top consumers: %s", []any{3, "default (2), other (1)"}},
+	MessagePoolNearlyExhausted: {"unik: %d of %d values (%d%%) of the pool of annotation \"%s\" are in use", []any{9, 10, 90, "ncp/snat_pool"}},
+	MessageLeased:              {"Value \"%s\" of annotation \"%s\" is reserved for deleted Service %s until %s", []any{"pool-a", "ncp/snat_pool", "default/web", "2023-01-01T00:00:00Z"}},
+	MessageReleased:            {"unik: Service %s/%s still has the same value for annotation \"%s\", but has been terminating since %s", []any{"default", "web", "ncp/snat_pool", "2023-01-01T00:00:00Z"}},
+	MessageImmutableRemoved:    {"Annotation \"%s\" is immutable and must not be removed", []any{"ncp/snat_pool"}},
+	MessageImmutableChanged:    {"Annotation \"%s\" is immutable and must not be changed from \"%s\" to \"%s\"", []any{"ncp/snat_pool", "pool-a", "pool-b"}},
+	MessageDegraded:            {DegradedWarning, nil},
+	MessageRepeatedDenials:     {" (denied %d times within %s; look up the current holder with GET /owner?annotation=<key>&value=<value>&namespace=%s on the unik webhook and choose a different value)", []any{5, time.Minute, "default"}},
+	MessageAttribution:         {" (requested by %s)", []any{"jane, authentication.kubernetes.io/pod-name=web-0"}},
+	MessageModeWarn:            {"unik: %s; admitted as namespace %s is in warn mode", []any{"Annotation \"ncp/snat_pool\" is immutable and must not be removed", "default"}},
+	MessageTerminating:         {"unik: Namespace %s is terminating, protected annotations were not checked", []any{"default"}},
+}
+
+// Catalog replaces the texts of denials and warnings by key, for example
+// to translate them. Texts are fmt format strings taking the same
+// arguments as the default text in the same order; explicit argument
+// indexes like %[2]s reorder or skip them. Keys not in the catalog keep
+// their default text.
+type Catalog map[MessageKey]string
+
+// DefaultCatalog returns the default texts of all messages.
+func DefaultCatalog() Catalog {
+	c := make(Catalog, len(messages))
+	for key, m := range messages {
+		c[key] = m.text
+	}
+	return c
+}
+
+// Validate checks that c only holds known keys and that every text can be
+// formatted with the arguments of its key.
+func (c Catalog) Validate() error {
+	keys := make([]MessageKey, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	var errs []error
+	for _, key := range keys {
+		m, known := messages[key]
+		if !known {
+			errs = append(errs, fmt.Errorf("message %q: unknown key", key))
+			continue
+		}
+		if formatted := fmt.Sprintf(c[key], m.sample...); strings.Contains(formatted, "%!") {
+			errs = append(errs, fmt.Errorf("message %q: text does not match the %d arguments of the message: %s", key, len(m.sample), formatted))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithMessageCatalog replaces the texts of denials and warnings by the ones
+// of c. The option can be given multiple times; later catalogs take
+// precedence.
+func WithMessageCatalog(c Catalog) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if h.catalog == nil {
+			h.catalog = make(Catalog, len(c))
+		}
+		maps.Copy(h.catalog, c)
+		return nil
+	}
+}
+
+// message formats the text of key with args.
+func (h *AdmitHandlerV1) message(key MessageKey, args ...any) string {
+	text, found := h.catalog[key]
+	if !found {
+		text = messages[key].text
+	}
+	return fmt.Sprintf(text, args...)
+}
@@ -0,0 +1,138 @@
+/*
+ *     mode.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// ModeAnnotation is the annotation of a Namespace selecting the Mode
+// requests in the namespace are validated in.
+const ModeAnnotation = "unik.io/mode"
+
+// Mode controls how denials are enforced in a namespace.
+type Mode string
+
+const (
+	// ModeEnforce denies requests violating the protected annotations.
+	// It is the default.
+	ModeEnforce Mode = "enforce"
+	// ModeWarn admits requests which would be denied, with the reason of
+	// the denial as warning.
+	ModeWarn Mode = "warn"
+	// ModeOff admits all requests without validating them.
+	ModeOff Mode = "off"
+)
+
+// ParseMode returns the mode called name.
+func ParseMode(name string) (Mode, error) {
+	switch m := Mode(name); m {
+	case ModeEnforce, ModeWarn, ModeOff:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown mode %q", name)
+}
+
+// WithNamespaceModes lets namespaces select the Mode their requests are
+// validated in with the ModeAnnotation. Without WithNamespaceInformer,
+// the mode of a namespace is cached for ttl; 0 looks it up for every
+// request.
+func WithNamespaceModes(ttl time.Duration) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if ttl < 0 {
+			return errors.New("mode cache TTL must not be negative")
+		}
+		h.modes = &modeCache{ttl: ttl, entries: make(map[string]modeEntry)}
+		return nil
+	}
+}
+
+// modeCache holds the modes of namespaces looked up in the apiserver.
+type modeCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]modeEntry
+}
+
+type modeEntry struct {
+	mode    Mode
+	expires time.Time
+}
+
+// namespaceMode returns the mode of namespace. Namespaces without or with
+// an invalid ModeAnnotation are enforced.
+func (h *AdmitHandlerV1) namespaceMode(ctx context.Context, l *zap.Logger, namespace string) (Mode, error) {
+	if h.modes == nil || namespace == "" {
+		return ModeEnforce, nil
+	}
+	cached := h.namespaces == nil && h.modes.ttl > 0
+	now := h.clock.Now()
+	if cached {
+		h.modes.lock.Lock()
+		entry, found := h.modes.entries[namespace]
+		h.modes.lock.Unlock()
+		if found && now.Before(entry.expires) {
+			return entry.mode, nil
+		}
+	}
+
+	ns, err := h.namespace(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	mode := ModeEnforce
+	if value, found := ns.Annotations[ModeAnnotation]; found {
+		if mode, err = ParseMode(value); err != nil {
+			l.Warn("Ignoring invalid mode of namespace", zap.String("mode", value))
+			mode = ModeEnforce
+		}
+	}
+	if cached {
+		h.modes.lock.Lock()
+		h.modes.entries[namespace] = modeEntry{mode: mode, expires: now.Add(h.modes.ttl)}
+		h.modes.lock.Unlock()
+	}
+	return mode, nil
+}
+
+// applyMode turns a denial in resp into a warning if mode is ModeWarn.
+// Other responses are returned as they are.
+func (h *AdmitHandlerV1) applyMode(l *zap.Logger, ar admissionv1.AdmissionReview, mode Mode, resp *admissionv1.AdmissionResponse) *admissionv1.AdmissionResponse {
+	if mode != ModeWarn || resp.Allowed || resp.Result == nil || resp.Result.Code != http.StatusForbidden {
+		return resp
+	}
+	l.Info("Admitted denied request in warn mode", zap.String("message", resp.Result.Message))
+	reason := response.Reason(resp.AuditAnnotations[response.AuditAnnotationReason])
+	warned := response.Allowed(ar.Request.UID, reason, h.problem(h.message(MessageModeWarn, resp.Result.Message, ar.Request.Namespace))...)
+	warned.AuditAnnotations[response.AuditAnnotationMode] = string(ModeWarn)
+	if trace, found := resp.AuditAnnotations[response.AuditAnnotationTrace]; found {
+		warned.AuditAnnotations[response.AuditAnnotationTrace] = trace
+	}
+	return warned
+}
@@ -0,0 +1,124 @@
+/*
+ *     namespaces.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// WithNamespaceInformer looks up the labels of namespaces, needed to decide
+// on requests in SelectorScopes, and their modes in informer instead of getting them from
+// the apiserver for each request. Cached decisions are dropped whenever the
+// labels of a namespace change, as the scopes it belongs to may change.
+func WithNamespaceInformer(informer cache.SharedIndexInformer) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if informer == nil {
+			return errors.New("informer is nil")
+		}
+		h.namespaces = corev1listers.NewNamespaceLister(informer.GetIndexer())
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, obj interface{}) {
+				o, ok1 := old.(*corev1.Namespace)
+				n, ok2 := obj.(*corev1.Namespace)
+				if ok1 && ok2 && !maps.Equal(o.Labels, n.Labels) && h.cache != nil {
+					h.cache.flush()
+				}
+			},
+		})
+		return err
+	}
+}
+
+// protectedIn returns the annotations which apply to objects in namespace,
+// looking up the labels of namespace if list has SelectorScopes.
+func (h *AdmitHandlerV1) protectedIn(ctx context.Context, list UniqueList, namespace string) ([]ScopedAnnotation, error) {
+	if namespace == "" || !list.HasSelectors() {
+		return list.ProtectedInNamespace(namespace), nil
+	}
+	namespaceLabels, err := h.namespaceLabels(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return list.ProtectedIn(namespace, namespaceLabels), nil
+}
+
+// checkedOn returns the annotations of protected which are checked on op
+// in namespace. The labels of namespace are only looked up if an annotation
+// restricts its operations in namespaces selected by labels.
+func (h *AdmitHandlerV1) checkedOn(ctx context.Context, protected []ScopedAnnotation, namespace string, op admissionv1.Operation) ([]ScopedAnnotation, error) {
+	var namespaceLabels map[string]string
+	if namespace != "" && slices.ContainsFunc(protected, func(a ScopedAnnotation) bool { return a.operationsBySelector() }) {
+		var err error
+		if namespaceLabels, err = h.namespaceLabels(ctx, namespace); err != nil {
+			return nil, err
+		}
+	}
+	var checked []ScopedAnnotation
+	for _, a := range protected {
+		if a.AppliesIn(op, namespace, namespaceLabels) {
+			checked = append(checked, a)
+		}
+	}
+	return checked, nil
+}
+
+// namespaceLabels returns the labels of namespace, which are never nil.
+func (h *AdmitHandlerV1) namespaceLabels(ctx context.Context, namespace string) (map[string]string, error) {
+	ns, err := h.namespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if ns.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return ns.Labels, nil
+}
+
+// namespace returns the Namespace called name. Namespaces not yet known
+// to the informer are looked up in the apiserver. A namespace deleted
+// meanwhile is returned empty.
+func (h *AdmitHandlerV1) namespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	if h.namespaces != nil {
+		if ns, err := h.namespaces.Get(name); err == nil {
+			return ns, nil
+		}
+	}
+	apiCtx, cancel := h.apiContext(ctx)
+	defer cancel()
+	ns, err := h.clientset.CoreV1().Namespaces().Get(apiCtx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting namespace %q: %w", name, err)
+	}
+	return ns, nil
+}
@@ -0,0 +1,109 @@
+/*
+ *     owner.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ErrNotProtected is returned when looking up the owner of a value for an
+// annotation the validator does not govern.
+var ErrNotProtected = errors.New("annotation is not protected")
+
+// OwnerLookup finds the service currently holding a value of a protected annotation.
+type OwnerLookup interface {
+	LookupOwner(ctx context.Context, namespace, annotation, value string) (*corev1.Service, error)
+}
+
+// LeaseLookup finds leases reserving values of annotations with a Lease.
+type LeaseLookup interface {
+	// LookupLease returns the namespace/name of the object holding an
+	// unexpired lease on value and the time the lease expires.
+	LookupLease(scope, annotation, value string) (holder string, expires time.Time, found bool)
+}
+
+// SortByOwnership sorts services sharing an annotation value so that the
+// legitimate owner comes first: first writer wins, so the service with the
+// oldest creationTimestamp is the owner. Ties are broken by namespace and
+// name, which makes the order deterministic.
+func SortByOwnership(services []corev1.Service) {
+	slices.SortStableFunc(services, func(a, b corev1.Service) int {
+		switch {
+		case a.CreationTimestamp.Before(&b.CreationTimestamp):
+			return -1
+		case b.CreationTimestamp.Before(&a.CreationTimestamp):
+			return 1
+		}
+		if c := strings.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// Owner returns the legitimate owner among services sharing an annotation
+// value as determined by SortByOwnership, or nil if services is empty.
+// The given slice is not modified.
+func Owner(services []corev1.Service) *corev1.Service {
+	if len(services) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(services)
+	SortByOwnership(sorted)
+	return &sorted[0]
+}
+
+// LookupOwner returns the legitimate owner of value for annotation within
+// the scope the annotation is protected in for objects in namespace, or nil
+// if the value is unused or only held by released terminating services.
+// An empty namespace only considers cluster scoped
+// annotations.
+func (h *AdmitHandlerV1) LookupOwner(ctx context.Context, namespace, annotation, value string) (*corev1.Service, error) {
+	annotations, err := h.protectedIn(ctx, h.uniqueList(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	annotations = expandKeys(annotations, map[string]string{annotation: value})
+	idx := slices.IndexFunc(annotations, func(a ScopedAnnotation) bool { return a.Key == annotation })
+	if idx < 0 {
+		return nil, ErrNotProtected
+	}
+	protected := annotations[idx]
+
+	services, err := ListScope(ctx, h.clientset, protected.Scope, protected.Locality(namespace)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var holders []corev1.Service
+	now := h.clock.Now()
+	for _, service := range services {
+		if v, found := protected.Lookup(service.Annotations); found && v == value && !protected.Released(&service, now) {
+			holders = append(holders, service)
+		}
+	}
+	return Owner(holders), nil
+}
@@ -0,0 +1,107 @@
+/*
+ *     pool.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PoolUsage describes how many values of the Pool of an annotation are in use.
+type PoolUsage struct {
+	Used int `json:"used"`
+	Size int `json:"size"`
+	// Consumers counts the pool values in use per namespace.
+	Consumers map[string]int `json:"consumers,omitempty"`
+}
+
+// Usage computes the usage of the pool of p by services. Values outside
+// of the pool are not counted. A value held by several services counts once
+// for the namespace of its owner.
+func (p ProtectedAnnotation) Usage(services []corev1.Service) PoolUsage {
+	usage := PoolUsage{Size: len(p.Pool), Consumers: make(map[string]int)}
+	holders := make(map[string][]corev1.Service)
+	for _, svc := range services {
+		if v, found := p.Lookup(svc.Annotations); found && slices.Contains(p.Pool, v) {
+			holders[v] = append(holders[v], svc)
+		}
+	}
+	for _, h := range holders {
+		usage.Used++
+		usage.Consumers[Owner(h).Namespace]++
+	}
+	return usage
+}
+
+// Exhausted reports whether all values of the pool are in use.
+func (u PoolUsage) Exhausted() bool {
+	return u.Size > 0 && u.Used >= u.Size
+}
+
+// TopConsumers returns up to n namespaces using the most pool values,
+// formatted as "namespace (count)".
+func (u PoolUsage) TopConsumers(n int) []string {
+	namespaces := make([]string, 0, len(u.Consumers))
+	for ns := range u.Consumers {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		a, b := namespaces[i], namespaces[j]
+		if u.Consumers[a] != u.Consumers[b] {
+			return u.Consumers[a] > u.Consumers[b]
+		}
+		return a < b
+	})
+	if len(namespaces) > n {
+		namespaces = namespaces[:n]
+	}
+	top := make([]string, len(namespaces))
+	for i, ns := range namespaces {
+		top[i] = fmt.Sprintf("%s (%d)", ns, u.Consumers[ns])
+	}
+	return top
+}
+
+// poolWarning returns a warning if more than the PoolWarningThreshold of the
+// pool of annotation is in use once svc, the object of ar, is admitted.
+func (h *AdmitHandlerV1) poolWarning(annotation ScopedAnnotation, services []corev1.Service, ar admissionv1.AdmissionReview, svc corev1.Service) string {
+	if len(annotation.Pool) == 0 || annotation.PoolWarningThreshold <= 0 {
+		return ""
+	}
+	admitted := make([]corev1.Service, 0, len(services)+1)
+	for _, service := range services {
+		if !isSelf(ar, svc, service) {
+			admitted = append(admitted, service)
+		}
+	}
+	svc.Namespace = ar.Request.Namespace
+	admitted = append(admitted, svc)
+
+	usage := annotation.Usage(admitted)
+	if usage.Used*100 <= annotation.PoolWarningThreshold*usage.Size {
+		return ""
+	}
+	return h.message(MessagePoolNearlyExhausted,
+		usage.Used, usage.Size, usage.Used*100/usage.Size, annotation.Key)
+}
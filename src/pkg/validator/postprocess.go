@@ -0,0 +1,114 @@
+/*
+ *     postprocess.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// PostProcessor customizes the response to ar before it is returned, for
+// example by adding audit annotations, rewriting messages or linking to a
+// ticket system. It may change everything but the decision: changes of
+// Allowed and UID are reverted.
+type PostProcessor func(ctx context.Context, ar admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse)
+
+var (
+	postProcessorsLock sync.RWMutex
+	postProcessors     = make(map[string]PostProcessor)
+)
+
+// RegisterPostProcessor makes p available by name, so it can be enabled by
+// configuration. It is meant to be called from init functions and panics
+// if name is empty or already registered, or p is nil.
+func RegisterPostProcessor(name string, p PostProcessor) {
+	postProcessorsLock.Lock()
+	defer postProcessorsLock.Unlock()
+	if name == "" || p == nil {
+		panic("validator: RegisterPostProcessor called with empty name or nil post-processor")
+	}
+	if _, found := postProcessors[name]; found {
+		panic(fmt.Sprintf("validator: post-processor %q registered twice", name))
+	}
+	postProcessors[name] = p
+}
+
+// PostProcessors returns the names of all registered post-processors in
+// alphabetical order.
+func PostProcessors() []string {
+	postProcessorsLock.RLock()
+	defer postProcessorsLock.RUnlock()
+	names := make([]string, 0, len(postProcessors))
+	for name := range postProcessors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithPostProcessor appends p to the post-processors applied to every
+// response, in the order they were added.
+func WithPostProcessor(p PostProcessor) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if p == nil {
+			return errors.New("post-processor is nil")
+		}
+		h.postProcessors = append(h.postProcessors, p)
+		return nil
+	}
+}
+
+// WithRegisteredPostProcessors appends the post-processors registered as
+// names, in the given order.
+func WithRegisteredPostProcessors(names ...string) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		for _, name := range names {
+			postProcessorsLock.RLock()
+			p, found := postProcessors[name]
+			postProcessorsLock.RUnlock()
+			if !found {
+				return fmt.Errorf("unknown post-processor %q, registered are %q", name, PostProcessors())
+			}
+			h.postProcessors = append(h.postProcessors, p)
+		}
+		return nil
+	}
+}
+
+// postProcess applies the post-processors to resp, keeping the decision.
+func (h *AdmitHandlerV1) postProcess(ctx context.Context, ar admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) {
+	if len(h.postProcessors) == 0 {
+		return
+	}
+	allowed, uid := resp.Allowed, resp.UID
+	for _, p := range h.postProcessors {
+		p(ctx, ar, resp)
+	}
+	if resp.Allowed != allowed || resp.UID != uid {
+		h.logger.Error("Post-processor changed the decision, reverting", zap.String("uid", string(ar.Request.UID)))
+		resp.Allowed, resp.UID = allowed, uid
+	}
+}
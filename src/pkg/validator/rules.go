@@ -0,0 +1,117 @@
+/*
+ *     rules.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultOperations are the operations annotations are checked on if they
+// do not restrict them.
+var defaultOperations = []admissionv1.Operation{admissionv1.Create, admissionv1.Update}
+
+// WebhookRules returns the rules of a ValidatingWebhookConfiguration which
+// send the webhook exactly the requests u has checks for in some namespace,
+// or nil if there are none.
+func (u UniqueList) WebhookRules() []admissionregistrationv1.RuleWithOperations {
+	needed := make(map[admissionv1.Operation]bool)
+	for _, annotations := range u {
+		for _, a := range annotations {
+			for _, op := range a.possibleOperations() {
+				needed[op] = true
+			}
+		}
+	}
+
+	var operations []admissionregistrationv1.OperationType
+	for _, op := range []admissionv1.Operation{admissionv1.Create, admissionv1.Update, admissionv1.Delete, admissionv1.Connect} {
+		if needed[op] {
+			operations = append(operations, admissionregistrationv1.OperationType(op))
+		}
+	}
+	if len(operations) == 0 {
+		return nil
+	}
+
+	scope := admissionregistrationv1.NamespacedScope
+	return []admissionregistrationv1.RuleWithOperations{{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{serviceRessource.Group},
+			APIVersions: []string{serviceRessource.Version},
+			Resources:   []string{serviceRessource.Resource},
+			Scope:       &scope,
+		},
+	}}
+}
+
+// possibleOperations returns the operations the annotation may be checked
+// on in any namespace. A single webhook cannot restrict operations to some
+// namespaces, so the handler admits the requests OperationsIn excludes.
+func (p ProtectedAnnotation) possibleOperations() []admissionv1.Operation {
+	var operations []admissionv1.Operation
+	for _, o := range p.OperationsIn {
+		operations = append(operations, orDefault(o.Operations)...)
+		if o.Namespaces == ClusterScope {
+			// Neither later entries nor Operations ever apply.
+			return operations
+		}
+	}
+	return append(operations, orDefault(p.Operations)...)
+}
+
+// orDefault returns operations, or defaultOperations if it is empty.
+func orDefault(operations []admissionv1.Operation) []admissionv1.Operation {
+	if len(operations) == 0 {
+		return defaultOperations
+	}
+	return operations
+}
+
+// WebhookNamespaceSelector returns the namespaceSelector of a webhook which
+// only sends requests for namespaces u protects annotations in, or nil if
+// annotations are protected cluster-wide, in namespaces matching a pattern,
+// which may be created at any time, or not at all.
+func (u UniqueList) WebhookNamespaceSelector() *metav1.LabelSelector {
+	var namespaces []string
+	for _, scope := range u.Scopes() {
+		if len(u[scope.String()]) == 0 {
+			continue
+		}
+		if scope.Namespace() == "" {
+			return nil
+		}
+		namespaces = append(namespaces, scope.Namespace())
+	}
+	if len(namespaces) == 0 {
+		return nil
+	}
+	sort.Strings(namespaces)
+	return &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+		Key:      corev1.LabelMetadataName,
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   namespaces,
+	}}}
+}
@@ -0,0 +1,307 @@
+/*
+ *     scenario_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/scenario"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap/zaptest"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// populated spreads 2000 services with distinct values over 20 namespaces.
+// Service svc-<i> lives in namespace ns-<i%20> and holds value v-<i>.
+func populated() *scenario.Scenario {
+	return scenario.New(time.Now()).
+		Bulk("svc", 2000, scenario.Namespaces("ns", 20), AnnotationNcpSnatPool, scenario.Unique("v"))
+}
+
+func (s *HandlerSuite) TestScenarios() {
+	cluster := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}
+	testCases := []struct {
+		desc      string
+		scenario  *scenario.Scenario
+		list      UniqueList
+		namespace string
+		value     string
+		reason    response.Reason
+		message   string
+		warning   string
+	}{
+		{
+			desc: "empty cluster", scenario: scenario.New(time.Now()), list: cluster,
+			namespace: "ns-0", value: "v-0", reason: response.ReasonUnique,
+		},
+		{
+			desc: "unused value among thousands", scenario: populated(), list: cluster,
+			namespace: "ns-0", value: "fresh", reason: response.ReasonUnique,
+		},
+		{
+			desc: "value held in another namespace", scenario: populated(), list: cluster,
+			namespace: "ns-0", value: "v-1337", reason: response.ReasonConflict, message: "ns-17/svc-1337",
+		},
+		{
+			desc: "value held in another namespace of a namespace scope", scenario: populated(),
+			list:      UniqueList{"ns-0": {{Key: AnnotationNcpSnatPool}}},
+			namespace: "ns-0", value: "v-1337", reason: response.ReasonUnique,
+		},
+		{
+			desc: "value held in the same namespace of a namespace scope", scenario: populated(),
+			list:      UniqueList{"ns-0": {{Key: AnnotationNcpSnatPool}}},
+			namespace: "ns-0", value: "v-1340", reason: response.ReasonConflict, message: "ns-0/svc-1340",
+		},
+		{
+			desc:      "value held in a namespace outside a pattern scope",
+			scenario:  populated(),
+			list:      UniqueList{"ns-1*": {{Key: AnnotationNcpSnatPool}}},
+			namespace: "ns-1", value: "v-2", reason: response.ReasonUnique,
+		},
+		{
+			desc:      "value held in a namespace selected by the same selector",
+			scenario:  populated().Namespace("ns-1", map[string]string{"env": "prod"}).Namespace("ns-2", map[string]string{"env": "prod"}),
+			list:      UniqueList{"env=prod": {{Key: AnnotationNcpSnatPool}}},
+			namespace: "ns-1", value: "v-2", reason: response.ReasonConflict, message: "ns-2/svc-2",
+		},
+		{
+			desc:      "value held in a namespace not selected by the selector",
+			scenario:  populated().Namespace("ns-1", map[string]string{"env": "prod"}),
+			list:      UniqueList{"env=prod": {{Key: AnnotationNcpSnatPool}}},
+			namespace: "ns-1", value: "v-2", reason: response.ReasonUnique,
+		},
+		{
+			desc:      "duplicates already present, the oldest owns the value",
+			scenario:  populated().Duplicates("dup", 50, scenario.Namespaces("ns", 20), AnnotationNcpSnatPool, "shared"),
+			list:      cluster,
+			namespace: "ns-0", value: "shared", reason: response.ReasonConflict, message: "ns-0/dup-0",
+		},
+		{
+			desc:      "holders below the limit",
+			scenario:  populated().Duplicates("dup", 3, []string{"ns-0"}, AnnotationNcpSnatPool, "shared"),
+			list:      UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, MaxHolders: 4}}},
+			namespace: "ns-1", value: "shared", reason: response.ReasonUnique,
+		},
+		{
+			desc:      "holders at the limit",
+			scenario:  populated().Duplicates("dup", 3, []string{"ns-0"}, AnnotationNcpSnatPool, "shared"),
+			list:      UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, MaxHolders: 3}}},
+			namespace: "ns-1", value: "shared", reason: response.ReasonConflict, message: "already used by 3 Services",
+		},
+		{
+			desc:      "shared values among duplicates",
+			scenario:  populated().Duplicates("dup", 100, scenario.Namespaces("ns", 20), AnnotationNcpSnatPool, "default"),
+			list:      UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Shared: []string{"default"}}}},
+			namespace: "ns-0", value: "default", reason: response.ReasonShared,
+		},
+		{
+			desc:      "terminating holder keeps its value",
+			scenario:  populated().Terminating("ns-3", "svc-3", time.Hour),
+			list:      cluster,
+			namespace: "ns-0", value: "v-3", reason: response.ReasonConflict, message: "ns-3/svc-3",
+		},
+		{
+			desc:     "terminating holder releases its value",
+			scenario: populated().Terminating("ns-3", "svc-3", time.Hour),
+			list: UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool,
+				ReleaseTerminatingAfter: &metav1.Duration{Duration: 10 * time.Minute}}}},
+			namespace: "ns-0", value: "v-3", reason: response.ReasonUnique, warning: "ns-3/svc-3",
+		},
+		{
+			desc:      "terminating and live holders",
+			scenario:  populated().Duplicates("dup", 2, []string{"ns-4"}, AnnotationNcpSnatPool, "shared").Terminating("ns-4", "dup-0", time.Hour),
+			list:      UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, ReleaseTerminatingAfter: &metav1.Duration{Duration: time.Minute}}}},
+			namespace: "ns-0", value: "shared", reason: response.ReasonConflict, message: "ns-4/dup-1",
+		},
+		{
+			desc:      "services without annotations",
+			scenario:  scenario.New(time.Now()).Bulk("bare", 2000, scenario.Namespaces("ns", 20), AnnotationNcpSnatPool, func(int) string { return "" }),
+			list:      cluster,
+			namespace: "ns-0", value: "v-0", reason: response.ReasonUnique,
+		},
+		{
+			desc:      "few values cycled over thousands of services",
+			scenario:  scenario.New(time.Now()).Bulk("svc", 2000, scenario.Namespaces("ns", 20), AnnotationNcpSnatPool, scenario.Cycle("pool-a", "pool-b")),
+			list:      cluster,
+			namespace: "ns-0", value: "pool-b", reason: response.ReasonConflict, message: "ns-1/svc-1",
+		},
+	}
+	for _, tC := range testCases {
+		for _, informed := range []bool{false, true} {
+			name := tC.desc
+			if informed {
+				name += " (informers)"
+			}
+			s.Run(name, func() {
+				tc := tC.scenario.Clientset()
+				opts := []ValidationHandlerOption{WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(tC.list)}
+				factory, start := scenario.Informers(tc)
+				if informed {
+					opts = append(opts,
+						WithNamespaceInformer(factory.Core().V1().Namespaces().Informer()),
+						WithValueFilters(factory.Core().V1().Services().Informer(), 4096, 0.01))
+				}
+				h, err := NewValidationHandlerV1(opts...)
+				s.Require().NoError(err)
+				stop := make(chan struct{})
+				defer close(stop)
+				start(stop)
+
+				raw, err := json.Marshal(poolService(tC.namespace, "claimant", tC.value))
+				s.Require().NoError(err)
+				review := createReview(raw)
+				review.Request.Namespace, review.Request.Name = tC.namespace, "claimant"
+
+				resp := h.Validate(context.Background(), review)
+				s.Equal(string(tC.reason), resp.AuditAnnotations[response.AuditAnnotationReason])
+				s.Equal(tC.reason != response.ReasonConflict, resp.Allowed)
+				if tC.message != "" {
+					s.Contains(resp.Result.Message, tC.message)
+				}
+				if tC.warning != "" {
+					s.Require().Len(resp.Warnings, 1)
+					s.Contains(resp.Warnings[0], tC.warning)
+				}
+			})
+		}
+	}
+}
+
+// TestScenarioUpdates checks updates of services within a populated cluster,
+// which must not conflict with themselves.
+func (s *HandlerSuite) TestScenarioUpdates() {
+	tc := populated().Clientset()
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Immutable: true}}}))
+	s.Require().NoError(err)
+
+	update := func(value string) admissionv1.AdmissionReview {
+		oldRaw, err := json.Marshal(poolService("ns-5", "svc-5", "v-5"))
+		s.Require().NoError(err)
+		raw, err := json.Marshal(poolService("ns-5", "svc-5", value))
+		s.Require().NoError(err)
+		review := updateReview(oldRaw, raw)
+		review.Request.Namespace, review.Request.Name = "ns-5", "svc-5"
+		return review
+	}
+	resp := h.Validate(context.Background(), update("v-5"))
+	s.True(resp.Allowed, "services do not conflict with themselves")
+	s.Equal(string(response.ReasonUnique), resp.AuditAnnotations[response.AuditAnnotationReason])
+
+	resp = h.Validate(context.Background(), update("v-6"))
+	s.False(resp.Allowed)
+	s.Equal(string(response.ReasonImmutable), resp.AuditAnnotations[response.AuditAnnotationReason])
+}
+
+func (s *HandlerSuite) TestTerminatingNamespaces() {
+	_, err := NewValidationHandlerV1(WithTerminatingAction("skip"))
+	s.Error(err)
+
+	tc := populated().TerminatingNamespace("ns-3").Clientset()
+	lists := 0
+	tc.Fake.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+	review := func(namespace string, operation admissionv1.Operation) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService(namespace, "claimant", "v-7"))
+		s.Require().NoError(err)
+		r := createReview(raw)
+		r.Request.Namespace, r.Request.Name, r.Request.Operation = namespace, "claimant", operation
+		if operation == admissionv1.Update {
+			r.Request.OldObject = r.Request.Object
+		}
+		return r
+	}
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}))
+	s.Require().NoError(err)
+	s.False(h.Validate(context.Background(), review("ns-3", admissionv1.Create)).Allowed, "terminating namespaces are validated by default")
+
+	h, err = NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}), WithTerminatingAction(TerminatingWarn))
+	s.Require().NoError(err)
+	lists = 0
+	resp := h.Validate(context.Background(), review("ns-3", admissionv1.Create))
+	s.True(resp.Allowed)
+	s.Equal(string(response.ReasonTerminating), resp.AuditAnnotations[response.AuditAnnotationReason])
+	s.Equal([]string{"unik: Namespace ns-3 is terminating, protected annotations were not checked"}, resp.Warnings)
+	s.Zero(lists, "holders are not looked up")
+
+	s.False(h.Validate(context.Background(), review("ns-3", admissionv1.Update)).Allowed, "updates are still validated")
+	s.False(h.Validate(context.Background(), review("ns-4", admissionv1.Create)).Allowed, "active namespaces are validated")
+}
+
+// TestIndexFastPath looks up holders in an informer cache which has fallen
+// behind the apiserver: svc-42 has been deleted and svc-43 changed since.
+func (s *HandlerSuite) TestIndexFastPath() {
+	_, err := NewValidationHandlerV1(WithIndexFastPath(nil))
+	s.Error(err)
+
+	cached := populated().Clientset()
+	live := populated().Clientset()
+	s.Require().NoError(live.CoreV1().Services("ns-2").Delete(context.Background(), "svc-42", metav1.DeleteOptions{}))
+	_, err = live.CoreV1().Services("ns-3").Update(context.Background(), poolService("ns-3", "svc-43", "changed"), metav1.UpdateOptions{})
+	s.Require().NoError(err)
+	calls := map[string]int{}
+	live.Fake.PrependReactor("*", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		calls[action.GetVerb()]++
+		return false, nil, nil
+	})
+
+	factory, start := scenario.Informers(cached)
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(live),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}),
+		WithIndexFastPath(factory.Core().V1().Services().Informer()))
+	s.Require().NoError(err)
+	stop := make(chan struct{})
+	defer close(stop)
+	start(stop)
+
+	validate := func(value string) *admissionv1.AdmissionResponse {
+		raw, err := json.Marshal(poolService("ns-0", "claimant", value))
+		s.Require().NoError(err)
+		review := createReview(raw)
+		review.Request.Namespace, review.Request.Name = "ns-0", "claimant"
+		return h.Validate(context.Background(), review)
+	}
+
+	resp := validate("fresh")
+	s.True(resp.Allowed)
+	s.Empty(calls, "unused values are admitted from the index")
+
+	resp = validate("v-1337")
+	s.False(resp.Allowed)
+	s.Contains(resp.Result.Message, "ns-17/svc-1337")
+	s.Equal(map[string]int{"get": 1}, calls, "conflicts are confirmed with the apiserver")
+
+	for _, value := range []string{"v-42", "v-43"} {
+		resp = validate(value)
+		s.True(resp.Allowed, "stale holder of %s", value)
+		s.Equal(string(response.ReasonUnique), resp.AuditAnnotations[response.AuditAnnotationReason])
+	}
+	s.Equal(map[string]int{"get": 3}, calls, "services are never listed")
+}
@@ -0,0 +1,219 @@
+/*
+ *     scope.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Scope determines which objects share the values of an annotation.
+type Scope interface {
+	// String returns the key of the scope in a UniqueList.
+	String() string
+	// Namespace returns the namespace the objects of the scope are listed
+	// in, or "" if they have to be listed across all namespaces.
+	Namespace() string
+	// Contains reports whether objects in namespace belong to the scope.
+	Contains(namespace string) bool
+}
+
+type clusterScope struct{}
+
+// Cluster is the scope of all objects in the cluster. Its key is ClusterScope.
+var Cluster Scope = clusterScope{}
+
+func (clusterScope) String() string         { return ClusterScope }
+func (clusterScope) Namespace() string      { return "" }
+func (clusterScope) Contains(_ string) bool { return true }
+
+// NamespaceScope is the scope of all objects in the named namespace.
+type NamespaceScope string
+
+func (n NamespaceScope) String() string                 { return string(n) }
+func (n NamespaceScope) Namespace() string              { return string(n) }
+func (n NamespaceScope) Contains(namespace string) bool { return namespace == string(n) }
+
+// GlobScope is the scope of all objects in the namespaces matching a glob
+// pattern as understood by path.Match, for example "team-*".
+type GlobScope string
+
+func (g GlobScope) String() string    { return string(g) }
+func (g GlobScope) Namespace() string { return "" }
+func (g GlobScope) Contains(namespace string) bool {
+	matched, _ := path.Match(string(g), namespace)
+	return matched
+}
+
+// RegexpScope is the scope of all objects in the namespaces matching a
+// regular expression, for example "^prod-.*$". Like any regular expression,
+// it matches substrings unless anchored.
+type RegexpScope string
+
+// regexps caches the compiled expressions of RegexpScopes.
+var regexps sync.Map
+
+func (r RegexpScope) String() string    { return string(r) }
+func (r RegexpScope) Namespace() string { return "" }
+func (r RegexpScope) Contains(namespace string) bool {
+	re, err := r.compile()
+	return err == nil && re.MatchString(namespace)
+}
+
+// compile returns the compiled expression of r.
+func (r RegexpScope) compile() (*regexp.Regexp, error) {
+	if re, found := regexps.Load(r); found {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(string(r))
+	if err != nil {
+		return nil, err
+	}
+	regexps.Store(r, re)
+	return re, nil
+}
+
+// SelectorScope is the scope of all objects in the namespaces whose labels
+// match a label selector, for example "env=prod". The labels of a namespace
+// are not known from its name, so Contains always reports false; Matches
+// decides on the labels instead.
+type SelectorScope string
+
+// selectors caches the parsed selectors of SelectorScopes.
+var selectors sync.Map
+
+func (s SelectorScope) String() string       { return string(s) }
+func (s SelectorScope) Namespace() string    { return "" }
+func (s SelectorScope) Contains(string) bool { return false }
+
+// Matches reports whether objects in a namespace labelled namespaceLabels
+// belong to s.
+func (s SelectorScope) Matches(namespaceLabels map[string]string) bool {
+	selector, err := s.parse()
+	return err == nil && selector.Matches(labels.Set(namespaceLabels))
+}
+
+// parse returns the parsed selector of s.
+func (s SelectorScope) parse() (labels.Selector, error) {
+	if selector, found := selectors.Load(s); found {
+		return selector.(labels.Selector), nil
+	}
+	selector, err := labels.Parse(string(s))
+	if err != nil {
+		return nil, err
+	}
+	selectors.Store(s, selector)
+	return selector, nil
+}
+
+// ParseScope returns the scope for a key of a UniqueList. Keys starting
+// with "^" are regular expressions, keys containing any of "=!(," are label
+// selectors and other keys containing any of "*?[" are glob patterns, except
+// for ClusterScope. All other keys name a namespace. Selectors consisting of
+// a single existence requirement, such as "env", name a namespace instead.
+func ParseScope(key string) Scope {
+	switch {
+	case key == ClusterScope:
+		return Cluster
+	case strings.HasPrefix(key, "^"):
+		return RegexpScope(key)
+	case strings.ContainsAny(key, "=!(,"):
+		return SelectorScope(key)
+	case strings.ContainsAny(key, "*?["):
+		return GlobScope(key)
+	}
+	return NamespaceScope(key)
+}
+
+// ValidateScope reports whether key is a valid pattern. Names of namespaces
+// are not checked.
+func ValidateScope(key string) error {
+	switch scope := ParseScope(key).(type) {
+	case RegexpScope:
+		_, err := scope.compile()
+		return err
+	case GlobScope:
+		_, err := path.Match(key, "")
+		return err
+	case SelectorScope:
+		_, err := scope.parse()
+		return err
+	}
+	return nil
+}
+
+// ListScope lists the services belonging to scope. If namespaces are given,
+// only services in those of them which belong to scope are listed. They are
+// ignored for SelectorScopes.
+func ListScope(ctx context.Context, clientset kubernetes.Interface, scope Scope, namespaces ...string) ([]corev1.Service, error) {
+	if selector, ok := scope.(SelectorScope); ok {
+		return listSelected(ctx, clientset, selector)
+	}
+	if len(namespaces) > 0 {
+		var services []corev1.Service
+		for _, namespace := range namespaces {
+			if !scope.Contains(namespace) {
+				continue
+			}
+			list, err := clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("listing services of scope %q in namespace %q: %w", scope, namespace, err)
+			}
+			services = append(services, list.Items...)
+		}
+		return services, nil
+	}
+	list, err := clientset.CoreV1().Services(scope.Namespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing services in scope %q: %w", scope, err)
+	}
+	services := list.Items[:0]
+	for _, svc := range list.Items {
+		if scope.Contains(svc.Namespace) {
+			services = append(services, svc)
+		}
+	}
+	return services, nil
+}
+
+// listSelected lists the services in the namespaces selected by scope.
+func listSelected(ctx context.Context, clientset kubernetes.Interface, scope SelectorScope) ([]corev1.Service, error) {
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: scope.String()})
+	if err != nil {
+		return nil, fmt.Errorf("listing namespaces of scope %q: %w", scope, err)
+	}
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	namespaces := make([]string, len(list.Items))
+	for i, ns := range list.Items {
+		namespaces[i] = ns.Name
+	}
+	return ListScope(ctx, clientset, Cluster, namespaces...)
+}
@@ -0,0 +1,73 @@
+/*
+ *     self.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"encoding/json"
+	"errors"
+
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// FieldManager is the field manager of all writes of the controller. It
+// tells them apart from requests made with the credentials of the
+// controller for other purposes, like probes.
+const FieldManager = "unik-admission-controller"
+
+// WithSelf sets the username the controller authenticates as. Requests of
+// this user made with FieldManager are admitted without validation, so
+// webhook rules matching the resources the controller writes can not
+// make it deny or wait for its own writes. Dry runs are always validated,
+// as they are used to check the webhook itself.
+func WithSelf(username string) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if username == "" {
+			return errors.New("username is empty")
+		}
+		h.self = username
+		return nil
+	}
+}
+
+// selfOriginated reports whether ar is a write of the controller itself.
+func (h *AdmitHandlerV1) selfOriginated(ar admissionv1.AdmissionReview) bool {
+	if h.self == "" || ar.Request.UserInfo.Username != h.self {
+		return false
+	}
+	if ar.Request.DryRun != nil && *ar.Request.DryRun {
+		return false
+	}
+	return fieldManager(ar.Request) == FieldManager
+}
+
+// fieldManager returns the field manager of the create, update or patch
+// options of req, if any.
+func fieldManager(req *admissionv1.AdmissionRequest) string {
+	if len(req.Options.Raw) == 0 {
+		return ""
+	}
+	var options struct {
+		FieldManager string `json:"fieldManager"`
+	}
+	if err := json.Unmarshal(req.Options.Raw, &options); err != nil {
+		return ""
+	}
+	return options.FieldManager
+}
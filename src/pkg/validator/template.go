@@ -0,0 +1,126 @@
+/*
+ *     template.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Denial holds the details of a denial a DenialTemplate is executed with.
+type Denial struct {
+	// Reason is the reason of the denial, like "annotation-conflict".
+	Reason string
+	// Namespace and Name identify the denied Service.
+	Namespace string
+	Name      string
+	// Annotation is the protected annotation the denial is about and Value
+	// its value in the request, if any.
+	Annotation string
+	Value      string
+	// ConflictingService is the namespace/name of the Service holding or
+	// reserving Value, if any.
+	ConflictingService string
+	// Message is the default message, including the text of a catalog.
+	Message string
+}
+
+// sampleDenial is used to check templates before they are used.
+var sampleDenial = Denial{
+	Reason:             string(response.ReasonConflict),
+	Namespace:          "default",
+	Name:               "web",
+	Annotation:         AnnotationNcpSnatPool,
+	Value:              "pool-a",
+	ConflictingService: "other/api",
+	Message:            `Service other/api already has the same value for annotation "ncp/snat_pool": "pool-a"`,
+}
+
+// DenialTemplate renders denial messages with text/template, so they can
+// for example link to a runbook:
+//
+//	{{.Message}}; see https://wiki.example.com/unik#{{.Reason}}
+type DenialTemplate struct {
+	t *template.Template
+}
+
+// ParseDenialTemplate parses text and checks that it can be executed with
+// a Denial.
+func ParseDenialTemplate(text string) (*DenialTemplate, error) {
+	t, err := template.New("denial").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	dt := &DenialTemplate{t: t}
+	rendered, err := dt.Render(sampleDenial)
+	if err != nil {
+		return nil, err
+	}
+	if rendered == "" {
+		return nil, errors.New("template renders an empty message")
+	}
+	return dt, nil
+}
+
+// Render executes the template with d.
+func (dt *DenialTemplate) Render(d Denial) (string, error) {
+	var b strings.Builder
+	if err := dt.t.Execute(&b, d); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// WithDenialTemplate renders the messages of denials with t. If rendering
+// fails, the default message is used.
+func WithDenialTemplate(t *DenialTemplate) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if t == nil {
+			return errors.New("denial template is nil")
+		}
+		h.denialTemplate = t
+		return nil
+	}
+}
+
+// deny returns a denial of ar with the message rendered from d, or the
+// default message of d if there is no template or it fails.
+func (h *AdmitHandlerV1) deny(l *zap.Logger, ar admissionv1.AdmissionReview, reason response.Reason, d Denial) *admissionv1.AdmissionResponse {
+	d.Reason = string(reason)
+	d.Namespace = ar.Request.Namespace
+	msg := d.Message
+	if h.denialTemplate != nil {
+		rendered, err := h.denialTemplate.Render(d)
+		switch {
+		case err != nil:
+			l.Warn("Failed to render denial template, using the default message", zap.Error(err))
+		case rendered == "":
+			l.Warn("Denial template rendered an empty message, using the default message")
+		default:
+			msg = rendered
+		}
+	}
+	return response.Denied(ar.Request.UID, reason, msg)
+}
@@ -0,0 +1,86 @@
+/*
+ *     terminating.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TerminatingAction is the decision on services created in terminating
+// namespaces. The apiserver rejects them anyway, so looking up holders
+// only adds load while large namespaces are cleaned up.
+type TerminatingAction string
+
+const (
+	// TerminatingValidate validates the request like any other. It is the
+	// default.
+	TerminatingValidate TerminatingAction = "validate"
+	// TerminatingWarn admits the request with an informational warning
+	// without looking up holders.
+	TerminatingWarn TerminatingAction = "warn"
+)
+
+// ParseTerminatingAction returns the action called name.
+func ParseTerminatingAction(name string) (TerminatingAction, error) {
+	switch a := TerminatingAction(name); a {
+	case TerminatingValidate, TerminatingWarn:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown action for terminating namespaces %q", name)
+}
+
+// WithTerminatingAction sets the decision on services created in
+// terminating namespaces. Namespaces are looked up like for SelectorScopes,
+// so WithNamespaceInformer saves a call to the apiserver per request.
+func WithTerminatingAction(a TerminatingAction) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if _, err := ParseTerminatingAction(string(a)); err != nil {
+			return err
+		}
+		h.terminating = a
+		return nil
+	}
+}
+
+// admitTerminating returns the decision on ar if it creates a service in a
+// terminating namespace and TerminatingWarn is set, and nil otherwise.
+func (h *AdmitHandlerV1) admitTerminating(ctx context.Context, l *zap.Logger, ar admissionv1.AdmissionReview) (*admissionv1.AdmissionResponse, error) {
+	if h.terminating != TerminatingWarn || ar.Request.Operation != admissionv1.Create {
+		return nil, nil
+	}
+	ns, err := h.namespace(ctx, ar.Request.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if ns.DeletionTimestamp == nil && ns.Status.Phase != corev1.NamespaceTerminating {
+		return nil, nil
+	}
+	l.Info("Admitted request", zap.String("reason", "namespace terminating"))
+	metrics.TerminatingNamespaceRequests.Inc()
+	return response.Allowed(ar.Request.UID, response.ReasonTerminating,
+		h.info(h.message(MessageTerminating, ar.Request.Namespace))...), nil
+}
@@ -0,0 +1,64 @@
+/*
+ *     trace.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+const (
+	traceSourceAPI   = "source=api"
+	traceSourceCache = "source=cache"
+)
+
+// decisionTrace records how a decision was made, so that it can be
+// explained from the response alone. It is attached to every decision as
+// the audit annotation response.AuditAnnotationTrace; the request handler
+// removes it unless tracing is enabled.
+type decisionTrace struct {
+	steps []string
+	// validUntil is when a lease the decision depends on expires, if any.
+	validUntil time.Time
+}
+
+// add records a step, for example the result of checking one annotation.
+func (t *decisionTrace) add(format string, args ...any) {
+	t.steps = append(t.steps, fmt.Sprintf(format, args...))
+}
+
+// attach sets the trace as audit annotation of resp.
+func (t *decisionTrace) attach(resp *admissionv1.AdmissionResponse) {
+	if resp.AuditAnnotations == nil {
+		resp.AuditAnnotations = make(map[string]string)
+	}
+	resp.AuditAnnotations[response.AuditAnnotationTrace] = strings.Join(append([]string{traceSourceAPI}, t.steps...), "; ")
+}
+
+// fromCache marks the trace of resp as answered from the decision cache.
+func fromCache(resp *admissionv1.AdmissionResponse) {
+	if trace, ok := resp.AuditAnnotations[response.AuditAnnotationTrace]; ok {
+		resp.AuditAnnotations[response.AuditAnnotationTrace] = strings.Replace(trace, traceSourceAPI, traceSourceCache, 1)
+	}
+}
@@ -0,0 +1,503 @@
+/*
+ *     uniquelist.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterScope is the scope key for annotations whose values
+// must be unique across all namespaces of the cluster.
+const ClusterScope = "*"
+
+// Wildcard ends the key of a ProtectedAnnotation protecting all
+// annotation keys starting with the prefix before it.
+const Wildcard = "*"
+
+// ProtectedAnnotation describes an annotation governed by the validator.
+type ProtectedAnnotation struct {
+	// Key is the annotation key, for example "ncp/snat_pool". A key ending
+	// in Wildcard, for example "ncp/*", protects every key with that
+	// prefix. The values of each of these keys must be unique on their own.
+	Key string `json:"key"`
+
+	// Immutable denies UPDATEs which change or remove the annotation
+	// once it has been set on an object.
+	Immutable bool `json:"immutable,omitempty"`
+
+	// Required, if set, demands that matching objects carry the annotation.
+	Required *Requirement `json:"required,omitempty"`
+
+	// Operations restricts all checks of the annotation to the given
+	// operations. By default, the annotation is checked on CREATE and UPDATE.
+	Operations []admissionv1.Operation `json:"operations,omitempty"`
+
+	// OperationsIn overrides Operations in groups of namespaces, for
+	// example to check the annotation on CREATE and UPDATE in "env=prod",
+	// but on CREATE only in "env=dev". The first entry matching the
+	// namespace of a request applies.
+	OperationsIn []NamespaceOperations `json:"operationsIn,omitempty"`
+
+	// ReleaseTerminatingAfter, if set, releases the value of an object which
+	// has been terminating for longer than the given duration, for example
+	// because of a stuck finalizer. New claimants of the value are admitted
+	// with a warning. By default, terminating objects keep their values.
+	ReleaseTerminatingAfter *metav1.Duration `json:"releaseTerminatingAfter,omitempty"`
+
+	// Lease, if set, keeps the value of a deleted object reserved for that
+	// object for the given duration. Only a new object with the same
+	// namespace and name may claim it until then. Leases are tracked by
+	// the scanner, which must be enabled.
+	Lease *metav1.Duration `json:"lease,omitempty"`
+
+	// Pool, if set, is the finite set of values available for the
+	// annotation. Its utilization is exported as metrics, and denials
+	// point out when the pool is exhausted.
+	Pool []string `json:"pool,omitempty"`
+
+	// Shared lists values exempt from uniqueness, for example a "default"
+	// pool used by all services without a dedicated one. Any number of
+	// objects may carry them.
+	Shared []string `json:"shared,omitempty"`
+
+	// Composite lists further annotation keys forming one uniqueness key
+	// together with Key, for example "ncp/router" for "ncp/snat_pool".
+	// Objects only conflict if they carry the same values for all of them,
+	// and objects lacking any of them are not checked. All other settings
+	// refer to Key only.
+	Composite []string `json:"composite,omitempty"`
+
+	// Distinct, if set, demands that objects carry values for the
+	// annotation which differ from those of other annotations on the same
+	// object, for example "ncp/snat_pool" and "ncp/ingress_pool". Shared
+	// values are exempt.
+	Distinct *Distinction `json:"distinct,omitempty"`
+
+	// MaxHolders, if set, lets up to the given number of objects share a
+	// value instead of a single one. Denials report how many objects hold
+	// it already.
+	MaxHolders int `json:"maxHolders,omitempty"`
+
+	// PoolWarningThreshold, if set, attaches a warning to admitted requests
+	// once more than the given percentage of the pool is in use.
+	PoolWarningThreshold int `json:"poolWarningThreshold,omitempty"`
+
+	// Namespaces, if set, is a locality hint for cluster scoped annotations:
+	// the annotation is only ever used in the given namespaces. Lookups
+	// are restricted to them and the namespace of the request instead of
+	// listing services across the whole cluster. The scanner still checks
+	// all namespaces, so values used elsewhere show up as violations.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// EmptyValues determines whether an empty value takes part in the
+	// checks. By default, it is treated as if the annotation was absent.
+	EmptyValues EmptyValueTreatment `json:"emptyValues,omitempty"`
+}
+
+// EmptyValueTreatment determines how an empty value of a protected
+// annotation is treated.
+type EmptyValueTreatment string
+
+const (
+	// EmptyAbsent treats an empty value as if the annotation was not set.
+	// Any number of objects may carry it, it does not satisfy requirements,
+	// and setting an immutable annotation from empty to a value is allowed.
+	// It is the default.
+	EmptyAbsent EmptyValueTreatment = "absent"
+	// EmptyValue treats the empty string like any other value, so only one
+	// object in the scope may carry it.
+	EmptyValue EmptyValueTreatment = "value"
+)
+
+// Lookup returns the value of the annotation in annotations and whether it
+// is set, taking EmptyValues into account.
+func (p ProtectedAnnotation) Lookup(annotations map[string]string) (string, bool) {
+	v, found := annotations[p.Key]
+	if v == "" && p.EmptyValues != EmptyValue {
+		return "", false
+	}
+	return v, found
+}
+
+// IsShared reports whether value is exempt from uniqueness.
+func (p ProtectedAnnotation) IsShared(value string) bool {
+	return slices.Contains(p.Shared, value)
+}
+
+// Limit returns the number of objects which may hold the same value.
+func (p ProtectedAnnotation) Limit() int {
+	return max(1, p.MaxHolders)
+}
+
+// Components returns the keys forming the uniqueness key of p: Key
+// followed by the Composite keys.
+func (p ProtectedAnnotation) Components() []string {
+	return append([]string{p.Key}, p.Composite...)
+}
+
+// CompositeValues returns the values of the Composite keys in annotations
+// and whether all of them are set, taking EmptyValues into account.
+func (p ProtectedAnnotation) CompositeValues(annotations map[string]string) ([]string, bool) {
+	values := make([]string, len(p.Composite))
+	for i, key := range p.Composite {
+		v, found := annotations[key]
+		if !found || v == "" && p.EmptyValues != EmptyValue {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// Identity returns the value identifying the holder of the annotation in
+// annotations and whether it takes part in the uniqueness check. It is the
+// value of Key, followed by the values of the Composite keys as in
+// "pool-a (ncp/router=t1)".
+func (p ProtectedAnnotation) Identity(annotations map[string]string) (string, bool) {
+	v, found := p.Lookup(annotations)
+	if !found || len(p.Composite) == 0 {
+		return v, found
+	}
+	values, complete := p.CompositeValues(annotations)
+	if !complete {
+		return "", false
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = p.Composite[i] + "=" + value
+	}
+	return v + " (" + strings.Join(pairs, ", ") + ")", true
+}
+
+// IsWildcard reports whether p protects all keys with a prefix.
+func (p ProtectedAnnotation) IsWildcard() bool {
+	return strings.HasSuffix(p.Key, Wildcard)
+}
+
+// Matches reports whether p protects the annotation key.
+func (p ProtectedAnnotation) Matches(key string) bool {
+	if p.IsWildcard() {
+		return strings.HasPrefix(key, strings.TrimSuffix(p.Key, Wildcard))
+	}
+	return p.Key == key
+}
+
+// Resolve returns the annotation of list protecting key, with Key set to
+// key. An annotation declared with key itself takes precedence over
+// wildcards, and of wildcards the one with the longest prefix wins.
+func Resolve(list []ProtectedAnnotation, key string) (ProtectedAnnotation, bool) {
+	var resolved ProtectedAnnotation
+	found := false
+	for _, a := range list {
+		if a.Key == key {
+			return a, true
+		}
+		if a.IsWildcard() && a.Matches(key) && (!found || len(a.Key) > len(resolved.Key)) {
+			resolved, found = a, true
+		}
+	}
+	resolved.Key = key
+	return resolved, found
+}
+
+// ExpandKeys returns list with its wildcards replaced by the annotations
+// they protect among the keys of objects, as resolved by Resolve. The
+// expanded annotations follow the others, ordered by key.
+func ExpandKeys(list []ProtectedAnnotation, objects ...map[string]string) []ProtectedAnnotation {
+	if !slices.ContainsFunc(list, ProtectedAnnotation.IsWildcard) {
+		return list
+	}
+	result := make([]ProtectedAnnotation, 0, len(list))
+	explicit := make(map[string]bool, len(list))
+	for _, a := range list {
+		if !a.IsWildcard() {
+			result = append(result, a)
+			explicit[a.Key] = true
+		}
+	}
+	var keys []string
+	for _, annotations := range objects {
+		for key := range annotations {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		if explicit[key] {
+			continue
+		}
+		if a, found := Resolve(list, key); found {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+// Released reports whether obj no longer holds its value of the annotation
+// at now, because it has been terminating for longer than ReleaseTerminatingAfter.
+func (p ProtectedAnnotation) Released(obj metav1.Object, now time.Time) bool {
+	deleted := obj.GetDeletionTimestamp()
+	if p.ReleaseTerminatingAfter == nil || deleted == nil {
+		return false
+	}
+	return now.Sub(deleted.Time) > p.ReleaseTerminatingAfter.Duration
+}
+
+// RequirementAction determines what happens when a required annotation is
+// missing or the values of distinct annotations are the same.
+type RequirementAction string
+
+const (
+	// RequirementDeny denies requests for objects lacking the annotation.
+	RequirementDeny RequirementAction = "deny"
+	// RequirementWarn admits such requests, but attaches a warning.
+	RequirementWarn RequirementAction = "warn"
+)
+
+// Distinction lists the annotations whose values must differ from the one
+// of a protected annotation on the same object. Annotations the object
+// does not carry are not compared.
+type Distinction struct {
+	// Keys are the keys of the other annotations. They need not be
+	// protected themselves.
+	Keys []string `json:"keys"`
+
+	// Action defaults to RequirementDeny.
+	Action RequirementAction `json:"action,omitempty"`
+}
+
+// Same returns the keys of d whose values in annotations equal value.
+func (d *Distinction) Same(annotations map[string]string, value string) []string {
+	var same []string
+	for _, key := range d.Keys {
+		if other, found := annotations[key]; found && other == value {
+			same = append(same, key)
+		}
+	}
+	return same
+}
+
+// NamespaceOperations are the operations a protected annotation is checked
+// on in a group of namespaces.
+type NamespaceOperations struct {
+	// Namespaces selects the namespaces like a scope key of a UniqueList,
+	// for example "dev-*" or "env=dev".
+	Namespaces string `json:"namespaces"`
+
+	// Operations are the operations the annotation is checked on in them.
+	Operations []admissionv1.Operation `json:"operations"`
+}
+
+// Matches reports whether n applies to namespace, labelled
+// namespaceLabels. Label selectors never match nil labels.
+func (n NamespaceOperations) Matches(namespace string, namespaceLabels map[string]string) bool {
+	scope := ParseScope(n.Namespaces)
+	if selector, ok := scope.(SelectorScope); ok {
+		return namespaceLabels != nil && selector.Matches(namespaceLabels)
+	}
+	return scope.Contains(namespace)
+}
+
+// Requirement selects the objects which must carry a protected annotation.
+// Empty fields match everything within the scope of the annotation.
+type Requirement struct {
+	// ServiceTypes restricts the requirement to services of the given types,
+	// for example LoadBalancer.
+	ServiceTypes []corev1.ServiceType `json:"serviceTypes,omitempty"`
+
+	// Namespaces restricts the requirement to the given namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Action defaults to RequirementDeny.
+	Action RequirementAction `json:"action,omitempty"`
+
+	// Operations restricts the requirement to the given operations, for
+	// example to CREATE only. By default, it applies to CREATE and UPDATE.
+	Operations []admissionv1.Operation `json:"operations,omitempty"`
+}
+
+// Matches reports whether the requirement applies to svc in namespace on op.
+// The namespace is passed explicitly as it is not necessarily set on
+// the object of an admission request.
+func (r *Requirement) Matches(namespace string, op admissionv1.Operation, svc corev1.Service) bool {
+	if !appliesTo(r.Operations, op) {
+		return false
+	}
+	if len(r.Namespaces) > 0 && !slices.Contains(r.Namespaces, namespace) {
+		return false
+	}
+	if len(r.ServiceTypes) > 0 && !slices.Contains(r.ServiceTypes, svc.Spec.Type) {
+		return false
+	}
+	return true
+}
+
+// AppliesTo reports whether the annotation is checked on op, regardless of
+// OperationsIn.
+func (p ProtectedAnnotation) AppliesTo(op admissionv1.Operation) bool {
+	return appliesTo(p.Operations, op)
+}
+
+// AppliesIn is like AppliesTo, but takes OperationsIn into account for
+// requests in namespace, labelled namespaceLabels.
+func (p ProtectedAnnotation) AppliesIn(op admissionv1.Operation, namespace string, namespaceLabels map[string]string) bool {
+	for _, o := range p.OperationsIn {
+		if o.Matches(namespace, namespaceLabels) {
+			return appliesTo(o.Operations, op)
+		}
+	}
+	return appliesTo(p.Operations, op)
+}
+
+// operationsBySelector reports whether an entry of OperationsIn selects
+// namespaces by their labels.
+func (p ProtectedAnnotation) operationsBySelector() bool {
+	return slices.ContainsFunc(p.OperationsIn, func(o NamespaceOperations) bool {
+		_, ok := ParseScope(o.Namespaces).(SelectorScope)
+		return ok
+	})
+}
+
+// appliesTo reports whether op is one of operations, which are all
+// operations if empty.
+func appliesTo(operations []admissionv1.Operation, op admissionv1.Operation) bool {
+	return len(operations) == 0 || slices.Contains(operations, op)
+}
+
+// UniqueList maps a scope, which is either the name of a namespace, a
+// pattern or label selector matching namespaces or ClusterScope, to the
+// annotations protected within that scope. The values of an annotation
+// protected by a pattern or selector must be unique across all matching
+// namespaces, see ParseScope.
+type UniqueList map[string][]ProtectedAnnotation
+
+// ScopedAnnotation is a ProtectedAnnotation together with the scope
+// it was declared in.
+type ScopedAnnotation struct {
+	ProtectedAnnotation
+	Scope Scope
+}
+
+// Locality returns the namespaces to look up values of the annotation in
+// for a request in namespace, or nil if the whole scope has to be listed.
+func (a ScopedAnnotation) Locality(namespace string) []string {
+	if a.Scope != Cluster || len(a.Namespaces) == 0 {
+		return nil
+	}
+	namespaces := slices.Clone(a.Namespaces)
+	if namespace != "" {
+		namespaces = append(namespaces, namespace)
+	}
+	slices.Sort(namespaces)
+	return slices.Compact(namespaces)
+}
+
+// Scopes returns the scopes of u ordered by their keys.
+func (u UniqueList) Scopes() []Scope {
+	keys := make([]string, 0, len(u))
+	for key := range u {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	scopes := make([]Scope, len(keys))
+	for i, key := range keys {
+		scopes[i] = ParseScope(key)
+	}
+	return scopes
+}
+
+// ProtectedInNamespace returns the annotations which apply to objects
+// in namespace ordered by key, so that conflicts and warnings are reported
+// in a stable order. Of annotations with the same key, the cluster scoped
+// one comes first. Annotations of SelectorScopes are not included, as they
+// depend on the labels of namespace; use ProtectedIn for them.
+func (u UniqueList) ProtectedInNamespace(namespace string) []ScopedAnnotation {
+	return u.ProtectedIn(namespace, nil)
+}
+
+// ProtectedIn is like ProtectedInNamespace, but includes the annotations of
+// the SelectorScopes matching namespaceLabels, the labels of namespace,
+// unless they are nil.
+func (u UniqueList) ProtectedIn(namespace string, namespaceLabels map[string]string) []ScopedAnnotation {
+	var result []ScopedAnnotation
+	for _, a := range u[ClusterScope] {
+		result = append(result, ScopedAnnotation{ProtectedAnnotation: a, Scope: Cluster})
+	}
+	for _, scope := range u.Scopes() {
+		if scope == Cluster {
+			continue
+		}
+		if selector, ok := scope.(SelectorScope); ok {
+			if namespaceLabels == nil || !selector.Matches(namespaceLabels) {
+				continue
+			}
+		} else if !scope.Contains(namespace) {
+			continue
+		}
+		for _, a := range u[scope.String()] {
+			result = append(result, ScopedAnnotation{ProtectedAnnotation: a, Scope: scope})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// expandKeys applies ExpandKeys to the annotations of each scope, keeping
+// the order of ProtectedIn.
+func expandKeys(annotations []ScopedAnnotation, objects ...map[string]string) []ScopedAnnotation {
+	if !slices.ContainsFunc(annotations, func(a ScopedAnnotation) bool { return a.IsWildcard() }) {
+		return annotations
+	}
+	var scopes []Scope
+	byScope := make(map[string][]ProtectedAnnotation)
+	for _, a := range annotations {
+		key := a.Scope.String()
+		if _, found := byScope[key]; !found {
+			scopes = append(scopes, a.Scope)
+		}
+		byScope[key] = append(byScope[key], a.ProtectedAnnotation)
+	}
+	var result []ScopedAnnotation
+	for _, scope := range scopes {
+		for _, a := range ExpandKeys(byScope[scope.String()], objects...) {
+			result = append(result, ScopedAnnotation{ProtectedAnnotation: a, Scope: scope})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// HasSelectors reports whether u protects annotations in a SelectorScope,
+// which requires the labels of namespaces to decide on requests.
+func (u UniqueList) HasSelectors() bool {
+	for key, annotations := range u {
+		if _, ok := ParseScope(key).(SelectorScope); ok && len(annotations) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+/*
+ *     unsupported.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import "fmt"
+
+// UnsupportedAction is the decision on requests for resources other than
+// services. Such requests only reach the webhook if the rules of its
+// configuration match more than services.
+type UnsupportedAction string
+
+const (
+	// UnsupportedWarn admits the request with an informational warning.
+	// It is the default.
+	UnsupportedWarn UnsupportedAction = "warn"
+	// UnsupportedAllow admits the request silently.
+	UnsupportedAllow UnsupportedAction = "allow"
+	// UnsupportedDeny rejects the request, which makes misconfigured
+	// webhook rules surface immediately.
+	UnsupportedDeny UnsupportedAction = "deny"
+)
+
+// ParseUnsupportedAction returns the action called name.
+func ParseUnsupportedAction(name string) (UnsupportedAction, error) {
+	switch a := UnsupportedAction(name); a {
+	case UnsupportedWarn, UnsupportedAllow, UnsupportedDeny:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown action for unsupported resources %q", name)
+}
+
+// WithUnsupportedAction sets the decision on requests for resources other
+// than services.
+func WithUnsupportedAction(a UnsupportedAction) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if _, err := ParseUnsupportedAction(string(a)); err != nil {
+			return err
+		}
+		h.unsupported = a
+		return nil
+	}
+}
@@ -0,0 +1,765 @@
+/*
+ *     validation.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/metrics"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/clock"
+)
+
+const AnnotationNcpSnatPool = "ncp/snat_pool"
+
+var (
+	runtimeScheme = runtime.NewScheme()
+	codecFactory  = serializer.NewCodecFactory(runtimeScheme)
+	deserializer  = codecFactory.UniversalDeserializer()
+)
+
+func init() {
+	// See https://github.com/kubernetes-sigs/controller-runtime/issues/1161
+	admissionv1.AddToScheme(runtimeScheme)
+
+}
+
+// ValidationHandlerV1 decides on AdmissionReviews. Calls to the apiserver
+// made while deciding are cancelled with ctx, so work stops as soon as the
+// apiserver abandons a review.
+type ValidationHandlerV1 interface {
+	ValidateBytes(ctx context.Context, data []byte) (*admissionv1.AdmissionReview, error)
+	Validate(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse
+}
+
+// AdmitHandlerV1 is a wrapper around an admission handler function.
+// Using it allows us to implement various versions of the admission API.
+// It is safe for concurrent use, so the lookups, buses and post processors
+// given to it must be, too.
+type AdmitHandlerV1 struct {
+	clientset kubernetes.Interface
+	logger    *zap.Logger
+	protected atomic.Pointer[UniqueList]
+
+	degradedChecks []DegradedCheck
+	cache          *decisionCache
+	values         *valueFilters
+	index          *serviceIndex
+	leases         LeaseLookup
+	denials        *denialTracker
+	apiTimeout     time.Duration
+	domain         string
+	clock          clock.PassiveClock
+	verbosity      WarningVerbosity
+	unsupported    UnsupportedAction
+	recorder       record.EventRecorder
+	claims         ClaimBus
+	postProcessors []PostProcessor
+
+	attributionExtra []string
+	attributeDenials bool
+	namespaces       corev1listers.NamespaceLister
+	catalog          Catalog
+	denialTemplate   *DenialTemplate
+	self             string
+	modes            *modeCache
+	exempt           map[string]bool
+	terminating      TerminatingAction
+}
+
+var serviceRessource = metav1.GroupVersionResource{Version: "v1", Resource: "services"}
+
+type ValidationHandlerOption func(*AdmitHandlerV1) error
+
+func WithLogger(logger *zap.Logger) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if logger == nil {
+			return errors.New("logger is nil")
+		}
+		h.logger = logger
+		return nil
+	}
+}
+
+func WithClientset(clientset kubernetes.Interface) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if clientset == nil {
+			return errors.New("clientset is nil")
+		}
+		h.clientset = clientset
+		return nil
+	}
+}
+
+// WithUniqueList sets the annotations protected by the handler.
+// If not given, AnnotationNcpSnatPool is protected cluster wide.
+func WithUniqueList(list UniqueList) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if list == nil {
+			return errors.New("unique list is nil")
+		}
+		h.protected.Store(&list)
+		return nil
+	}
+}
+
+// WithAPITimeout bounds each call to the apiserver made while validating.
+// It should be well below the timeoutSeconds of the webhook, so that a slow
+// apiserver leads to an error response instead of the apiserver giving up
+// on the webhook. Without it, calls are not bounded.
+func WithAPITimeout(timeout time.Duration) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if timeout <= 0 {
+			return errors.New("timeout must be positive")
+		}
+		h.apiTimeout = timeout
+		return nil
+	}
+}
+
+// WithLeaseLookup enables the Lease of protected annotations, using leases to
+// find values reserved for deleted objects.
+func WithLeaseLookup(leases LeaseLookup) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if leases == nil {
+			return errors.New("lease lookup is nil")
+		}
+		h.leases = leases
+		return nil
+	}
+}
+
+// WithDomain sets the name of the policy domain the handler validates,
+// which labels its decisions in metrics.Decisions. Defaults to "default".
+func WithDomain(name string) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if name == "" {
+			return errors.New("domain is empty")
+		}
+		h.domain = name
+		return nil
+	}
+}
+
+// WithClock sets the source of the current time, for example to a fake
+// clock in tests. Defaults to the real clock.
+func WithClock(c clock.PassiveClock) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if c == nil {
+			return errors.New("clock is nil")
+		}
+		h.clock = c
+		return nil
+	}
+}
+
+func NewValidationHandlerV1(options ...ValidationHandlerOption) (*AdmitHandlerV1, error) {
+	h := &AdmitHandlerV1{domain: "default", clock: clock.RealClock{}, verbosity: WarningsFull, unsupported: UnsupportedWarn, terminating: TerminatingValidate, attributionExtra: DefaultAttributionExtra}
+	h.protected.Store(&UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}})
+	var err error
+	for _, option := range options {
+		if err = option(h); err != nil {
+			return nil, fmt.Errorf("error while applying option: %w", err)
+		}
+	}
+
+	return h, nil
+}
+
+// SetUniqueList replaces the annotations protected by the handler.
+// Requests already being validated finish with the previous list.
+// Cached decisions are dropped, as they may depend on the previous list.
+func (h *AdmitHandlerV1) SetUniqueList(list UniqueList) {
+	h.protected.Store(&list)
+	if h.cache != nil {
+		h.cache.flush()
+	}
+}
+
+// FlushDecisionCache drops all cached decisions, for example after the
+// informer invalidating them was found to have missed changes.
+func (h *AdmitHandlerV1) FlushDecisionCache() {
+	if h.cache != nil {
+		h.cache.flush()
+	}
+}
+
+// uniqueList returns the annotations currently protected by the handler.
+func (h *AdmitHandlerV1) uniqueList() UniqueList {
+	return *h.protected.Load()
+}
+
+// ValidateBytes decides on the AdmissionReview encoded in data. An error is
+// only returned if data does not hold an AdmissionReview request. Otherwise,
+// the response always carries the UID of the request, even if validating
+// failed or panicked. If ctx is done before validating started, for
+// example while the review was queued, it is answered with an error
+// response without validating it. Reviews are validated concurrently.
+func (h *AdmitHandlerV1) ValidateBytes(ctx context.Context, data []byte) (*admissionv1.AdmissionReview, error) {
+	rto, gvk, err := deserializer.Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request object: %w", err)
+	}
+
+	if gvk.Group != admissionv1.GroupName || gvk.Version != "v1" || gvk.Kind != "AdmissionReview" {
+		return nil, fmt.Errorf("unexpected group, version or kind %s", gvk)
+	}
+	review, ok := rto.(*admissionv1.AdmissionReview)
+	if !ok || review.Request == nil {
+		return nil, errors.New("expected v1.AdmissionReview with a request")
+	}
+
+	if err := ctx.Err(); err != nil {
+		h.logger.Info("Request abandoned by client", zap.String("uid", string(review.Request.UID)), zap.Error(err))
+		return response.Review(response.Errored(review.Request.UID, err)), nil
+	}
+	return response.Review(h.validateSafely(ctx, *review)), nil
+}
+
+// validateSafely turns panics during validation into error responses and
+// ensures the response carries the UID of the request.
+func (h *AdmitHandlerV1) validateSafely(ctx context.Context, ar admissionv1.AdmissionReview) (resp *admissionv1.AdmissionResponse) {
+	defer func() {
+		if p := recover(); p != nil {
+			h.logger.Error("Recovered from panic during validation", zap.String("uid", string(ar.Request.UID)), zap.Any("panic", p), zap.Stack("stack"))
+			resp = response.Errored(ar.Request.UID, fmt.Errorf("internal error: %v", p))
+		}
+		resp.UID = ar.Request.UID
+		metrics.Decisions.WithLabelValues(h.domain, resp.AuditAnnotations[response.AuditAnnotationReason]).Inc()
+		h.recordDenial(ar, resp)
+		if ar.Request.Namespace != "" {
+			metrics.NamespaceDecisions.WithLabelValues(ar.Request.Namespace, h.domain, resp.AuditAnnotations[response.AuditAnnotationReason]).Inc()
+		}
+	}()
+	return h.Validate(ctx, ar)
+}
+
+// Validate decides on the admission request contained in ar. If ctx is
+// cancelled before a decision was made, an error response is returned.
+func (h *AdmitHandlerV1) Validate(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	resp := h.validate(ctx, ar)
+	h.markDegraded(resp)
+	h.attributeDenial(ar, resp)
+	h.postProcess(ctx, ar, resp)
+	return resp
+}
+
+// validate is the actual admission handler function.
+// Requests made by the controller itself and requests in exempt namespaces
+// are admitted right away, and requests for resources other than services
+// are handled as set with WithUnsupportedAction. Namespaces in ModeOff are
+// not checked; requests in terminating namespaces are handled as set with
+// WithTerminatingAction.
+// Otherwise, the request is answered from the decision cache if possible,
+// or decided by decide on the protected annotations checked for its
+// operation in the namespace: an annotation is admitted if no other service
+// in its scope has the same value, annotations marked as immutable must
+// keep their value on UPDATE and annotations with a Requirement must be
+// present. Finally, denials are turned into warnings in ModeWarn and
+// repeated ones are escalated with guidance for the user. Every response
+// carries the reason for it in its AuditAnnotations.
+func (h *AdmitHandlerV1) validate(ctx context.Context, ar admissionv1.AdmissionReview) *admissionv1.AdmissionResponse {
+	requester := Attribute(ar.Request.UserInfo, h.attributionExtra)
+	l := h.logger.With(
+		zap.String("namespace", ar.Request.Namespace),
+		zap.String("kind", ar.Request.Kind.Kind),
+		zap.String("name", ar.Request.Name),
+		zap.String("operation", string(ar.Request.Operation)),
+		zap.String("uid", string(ar.Request.UID)),
+		zap.Object("requester", requester))
+
+	defer l.Sync()
+
+	l.Info("Validating request", zap.Strings("groups", requester.Groups))
+
+	l.Debug("Request context",
+		zap.String("group", ar.Request.Kind.Group),
+		zap.String("version", ar.Request.Kind.Version),
+		zap.String("resource", ar.Request.Resource.String()))
+
+	if h.selfOriginated(ar) {
+		l.Info("Admitted request", zap.String("reason", "self-originated"), zap.String("resource", ar.Request.Resource.String()))
+		metrics.SelfRequests.WithLabelValues(ar.Request.Resource.String()).Inc()
+		return response.Allowed(ar.Request.UID, response.ReasonSelf)
+	}
+
+	if h.exempt[ar.Request.Namespace] {
+		l.Debug("Admitted request", zap.String("reason", "namespace exempt"))
+		return response.Allowed(ar.Request.UID, response.ReasonExempt)
+	}
+
+	if ar.Request.Resource != serviceRessource {
+		l.Warn("Request is not for a (supported) service", zap.String("group", ar.Request.Kind.Group), zap.String("version", ar.Request.Kind.Version), zap.String("kind", ar.Request.Kind.Kind), zap.String("action", string(h.unsupported)))
+		metrics.UnsupportedRequests.WithLabelValues(ar.Request.Resource.String(), string(h.unsupported)).Inc()
+		switch h.unsupported {
+		case UnsupportedDeny:
+			return response.Denied(ar.Request.UID, response.ReasonUnsupportedResource,
+				h.message(MessageUnsupportedResource, ar.Request.Resource.String()))
+		case UnsupportedAllow:
+			return response.Allowed(ar.Request.UID, response.ReasonUnsupportedResource)
+		}
+		return response.Allowed(ar.Request.UID, response.ReasonUnsupportedResource, h.info(h.message(MessageUnsupportedWarning))...)
+	}
+
+	// DELETE and CONNECT requests carry no object, and there is nothing
+	// a protected annotation could conflict with.
+	if len(ar.Request.Object.Raw) == 0 {
+		if ar.Request.Operation == admissionv1.Delete || ar.Request.Operation == admissionv1.Connect {
+			l.Info("Admitted request", zap.String("reason", "no object"))
+			return response.Allowed(ar.Request.UID, response.ReasonNotPresent)
+		}
+		l.Error("Request carries no object")
+		return response.Errored(ar.Request.UID, errors.New("request carries no object"))
+	}
+
+	svc := corev1.Service{}
+
+	// Maybe the return values should be used, but it seems redundant to me
+	// at the moment.
+	_, _, err := deserializer.Decode(ar.Request.Object.Raw, nil, &svc)
+
+	if err != nil {
+		l.Error("Failed to decode request object", zap.Error(err))
+		return response.Errored(ar.Request.UID, fmt.Errorf("decoding object: %w", err))
+	}
+
+	// The request of a service created with generateName carries no name.
+	// Validating webhooks are called after the name has been generated, so
+	// it is taken from the object; only if that has none either, the
+	// service can neither hold a value nor be excluded as its own holder.
+	if ar.Request.Name == "" {
+		l = l.With(zap.String("generate_name", svc.GenerateName), zap.String("object_name", svc.Name))
+		if svc.Name != "" {
+			request := *ar.Request
+			request.Name = svc.Name
+			ar.Request = &request
+		}
+	}
+	// The apiserver always sets the namespace of requests for services, but
+	// requests made up by other callers may only carry it in the object.
+	if ar.Request.Namespace == "" {
+		if svc.Namespace == "" {
+			l.Error("Request carries no namespace")
+			return response.Errored(ar.Request.UID, errors.New("request carries no namespace"))
+		}
+		l.Info("Request carries no namespace, using the namespace of the object", zap.String("object_namespace", svc.Namespace))
+		request := *ar.Request
+		request.Namespace = svc.Namespace
+		ar.Request = &request
+	}
+
+	mode, err := h.namespaceMode(ctx, l, ar.Request.Namespace)
+	if err != nil {
+		l.Error("Failed to determine mode of namespace", zap.Error(err))
+		return response.Errored(ar.Request.UID, err)
+	}
+	if mode == ModeOff {
+		l.Info("Admitted request", zap.String("reason", "namespace mode off"))
+		return response.Allowed(ar.Request.UID, response.ReasonModeOff)
+	}
+	if resp, err := h.admitTerminating(ctx, l, ar); err != nil {
+		l.Error("Failed to determine whether namespace is terminating", zap.Error(err))
+		return response.Errored(ar.Request.UID, err)
+	} else if resp != nil {
+		return resp
+	}
+
+	protected, err := h.protectedIn(ctx, h.uniqueList(), ar.Request.Namespace)
+	if err != nil {
+		l.Error("Failed to determine protected annotations", zap.Error(err))
+		return response.Errored(ar.Request.UID, err)
+	}
+	annotations, err := h.checkedOn(ctx, protected, ar.Request.Namespace, ar.Request.Operation)
+	if err != nil {
+		l.Error("Failed to determine checked annotations", zap.Error(err))
+		return response.Errored(ar.Request.UID, err)
+	}
+
+	var old *corev1.Service
+	if ar.Request.Operation == admissionv1.Update && len(ar.Request.OldObject.Raw) > 0 {
+		old = &corev1.Service{}
+		if _, _, err := deserializer.Decode(ar.Request.OldObject.Raw, nil, old); err != nil {
+			l.Error("Failed to decode old object", zap.Error(err))
+			return response.Errored(ar.Request.UID, fmt.Errorf("decoding old object: %w", err))
+		}
+	}
+	if old != nil {
+		annotations = expandKeys(annotations, svc.Annotations, old.Annotations)
+	} else {
+		annotations = expandKeys(annotations, svc.Annotations)
+	}
+
+	var key string
+	if h.cache != nil {
+		key = decisionKey(ar, svc, old, annotations)
+		if resp, hit := h.cache.get(key, ar.Request.UID, h.clock.Now()); hit {
+			l.Info("Answered request from decision cache", zap.Bool("allowed", resp.Allowed))
+			fromCache(resp)
+			return h.escalate(l, ar, h.applyMode(l, ar, mode, resp))
+		}
+	}
+
+	trace := &decisionTrace{}
+	resp, err := h.decide(ctx, l, ar, svc, old, annotations, trace)
+	if err != nil && ctx.Err() != nil {
+		l.Info("Request abandoned by client", zap.Error(ctx.Err()))
+		return response.Errored(ar.Request.UID, ctx.Err())
+	}
+	if err != nil {
+		l.Error("Failed to decide on request", zap.Error(err))
+		return response.Errored(ar.Request.UID, err)
+	}
+	trace.attach(resp)
+	if h.cache != nil {
+		h.cache.put(key, resp, protectedValues(svc, annotations), h.clock.Now(), trace.validUntil)
+	}
+	h.publishClaims(ctx, l, ar, svc, old, annotations, resp)
+	return h.escalate(l, ar, h.applyMode(l, ar, mode, resp))
+}
+
+// apiContext bounds calls to the apiserver by the timeout set with WithAPITimeout.
+func (h *AdmitHandlerV1) apiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.apiTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, h.apiTimeout)
+}
+
+// decide evaluates all rules for svc. old is the previous state of svc on
+// UPDATE and nil otherwise. An error is returned if the apiserver could not
+// be queried, in which case no decision can be made.
+func (h *AdmitHandlerV1) decide(ctx context.Context, l *zap.Logger, ar admissionv1.AdmissionReview, svc corev1.Service, old *corev1.Service, annotations []ScopedAnnotation, trace *decisionTrace) (*admissionv1.AdmissionResponse, error) {
+	if old != nil {
+		if denied := h.checkImmutable(l, ar, svc, *old, annotations); denied != nil {
+			return denied, nil
+		}
+	}
+
+	var warnings []string
+	for _, annotation := range annotations {
+		if annotation.Required == nil || !annotation.Required.Matches(ar.Request.Namespace, ar.Request.Operation, svc) {
+			continue
+		}
+		if _, present := annotation.Lookup(svc.Annotations); present {
+			continue
+		}
+		if annotation.Required.Action == RequirementWarn {
+			l.Info("Required annotation missing", zap.String("annotation", annotation.Key), zap.String("action", string(RequirementWarn)))
+			warnings = append(warnings, h.problem(h.message(MessageRequiredWarning, ar.Request.Namespace, displayName(ar, svc), annotation.Key))...)
+			continue
+		}
+		l.Info("Denied request", zap.String("reason", "required annotation missing"), zap.String("annotation", annotation.Key))
+		return h.deny(l, ar, response.ReasonRequired, Denial{
+			Name:       displayName(ar, svc),
+			Annotation: annotation.Key,
+			Message:    h.message(MessageRequired, ar.Request.Namespace, displayName(ar, svc), annotation.Key),
+		}), nil
+	}
+
+	for _, annotation := range annotations {
+		if annotation.Distinct == nil {
+			continue
+		}
+		value, present := annotation.Lookup(svc.Annotations)
+		if !present || annotation.IsShared(value) {
+			continue
+		}
+		same := annotation.Distinct.Same(svc.Annotations, value)
+		if len(same) == 0 {
+			continue
+		}
+		keys := quoteAll(append([]string{annotation.Key}, same...))
+		if annotation.Distinct.Action == RequirementWarn {
+			l.Info("Distinct annotations have the same value", zap.String("annotation", annotation.Key), zap.Strings("same", same), zap.String("action", string(RequirementWarn)))
+			warnings = append(warnings, h.problem(h.message(MessageDistinctWarning, ar.Request.Namespace, displayName(ar, svc), keys, value))...)
+			continue
+		}
+		l.Info("Denied request", zap.String("reason", "distinct annotations have the same value"), zap.String("annotation", annotation.Key), zap.Strings("same", same))
+		return h.deny(l, ar, response.ReasonDistinct, Denial{
+			Name:       displayName(ar, svc),
+			Annotation: annotation.Key,
+			Value:      value,
+			Message:    h.message(MessageDistinct, ar.Request.Namespace, displayName(ar, svc), keys, value),
+		}), nil
+	}
+
+	// Services are listed at most once per scope and locality hint.
+	listed := make(map[string][]corev1.Service)
+	checked := 0
+	var shared []string
+
+	for _, annotation := range annotations {
+		al := l.With(zap.String("annotation", annotation.Key), zap.Stringer("scope", annotation.Scope))
+
+		toSearch, present := annotation.Lookup(svc.Annotations)
+		if !present {
+			trace.add("%s@%s: absent", annotation.Key, annotation.Scope)
+			continue
+		}
+		components, complete := annotation.CompositeValues(svc.Annotations)
+		if !complete {
+			trace.add("%s@%s: incomplete", annotation.Key, annotation.Scope)
+			continue
+		}
+		if annotation.IsShared(toSearch) {
+			al.Info("Value is marked shared, skipping uniqueness check", zap.String("value", toSearch))
+			trace.add("%s@%s: shared", annotation.Key, annotation.Scope)
+			shared = append(shared, annotation.Key+"="+toSearch)
+			continue
+		}
+		checked++
+
+		al.Info("Found annotation, checking existing services", zap.String("value", toSearch))
+
+		now := h.clock.Now()
+
+		// Pool warnings need the full list of holders.
+		filtered := filterUnavailable
+		if h.values != nil && annotation.PoolWarningThreshold == 0 {
+			filtered = h.values.lookup(annotation.Key, toSearch, now)
+		}
+
+		locality := annotation.Locality(ar.Request.Namespace)
+		indexed := h.index.covers(annotation.Scope)
+		listKey := annotation.Scope.String() + "/" + strings.Join(locality, ",")
+		if indexed {
+			listKey = "index:" + listKey
+		}
+		services, reused := listed[listKey]
+		switch {
+		case reused || filtered == filterUnused:
+		case indexed:
+			services = h.index.list(annotation.Scope, locality...)
+			listed[listKey] = services
+		default:
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			apiCtx, cancel := h.apiContext(ctx)
+			var err error
+			services, err = ListScope(apiCtx, h.clientset, annotation.Scope, locality...)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			listed[listKey] = services
+		}
+
+		var holders, released []corev1.Service
+		for _, service := range services {
+
+			// TODO: What happens if the service changes the annotation to one that is already
+			// used by a different service?
+			if isSelf(ar, svc, service) {
+				continue
+			}
+			if serviceAnnotationValue, found := annotation.Lookup(service.Annotations); !found || serviceAnnotationValue != toSearch {
+				continue
+			}
+			if values, complete := annotation.CompositeValues(service.Annotations); !complete || !slices.Equal(values, components) {
+				continue
+			}
+			if annotation.Released(&service, now) {
+				released = append(released, service)
+				continue
+			}
+			holders = append(holders, service)
+		}
+
+		// Holders found in the index may be stale and are confirmed before
+		// denying.
+		stale := 0
+		if indexed && len(holders) >= annotation.Limit() {
+			var err error
+			if holders, stale, err = h.confirmHolders(ctx, al, annotation, toSearch, components, holders); err != nil {
+				return nil, err
+			}
+		}
+
+		if filtered == filterHit && len(holders) == 0 && len(released) == 0 && (old == nil || old.Annotations[annotation.Key] != toSearch) {
+			metrics.ValueFilterLookups.WithLabelValues(annotation.Key, "false_positive").Inc()
+		}
+
+		switch {
+		case filtered == filterUnused:
+			trace.add("%s@%s: filtered=unused", annotation.Key, annotation.Scope)
+		case indexed:
+			trace.add("%s@%s: indexed=%d reused=%t holders=%d released=%d stale=%d", annotation.Key, annotation.Scope, len(services), reused, len(holders), len(released), stale)
+		case locality != nil:
+			trace.add("%s@%s: locality=%s scanned=%d reused=%t holders=%d released=%d", annotation.Key, annotation.Scope, strings.Join(locality, ","), len(services), reused, len(holders), len(released))
+		default:
+			trace.add("%s@%s: scanned=%d reused=%t holders=%d released=%d", annotation.Key, annotation.Scope, len(services), reused, len(holders), len(released))
+		}
+
+		if len(holders) >= annotation.Limit() {
+			owner := Owner(holders)
+			al.Info("Denied request", zap.String("reason", "annotation already present"), zap.String("service", fmt.Sprintf("%s/%s", owner.Namespace, owner.Name)), zap.Int("holders", len(holders)), zap.Int("limit", annotation.Limit()))
+			var msg string
+			switch {
+			case annotation.Limit() > 1:
+				value, _ := annotation.Identity(svc.Annotations)
+				msg = h.message(MessageLimitReached, value, annotation.Key, len(holders), annotation.Limit(), owner.Namespace, owner.Name)
+			case len(annotation.Composite) > 0:
+				msg = h.message(MessageCompositeConflict, owner.Namespace, owner.Name, quoteAll(annotation.Components()), quoteAll(append([]string{toSearch}, components...)))
+			default:
+				msg = h.message(MessageConflict, owner.Namespace, owner.Name, annotation.Key, toSearch)
+			}
+			if len(annotation.Pool) > 0 {
+				if usage := annotation.Usage(services); usage.Exhausted() {
+					al.Warn("Pool exhausted", zap.Int("size", usage.Size))
+					msg += h.message(MessagePoolExhausted, usage.Size, strings.Join(usage.TopConsumers(3), ", "))
+				}
+			}
+			return h.deny(al, ar, response.ReasonConflict, Denial{
+				Name:               displayName(ar, svc),
+				Annotation:         annotation.Key,
+				Value:              toSearch,
+				ConflictingService: owner.Namespace + "/" + owner.Name,
+				Message:            msg,
+			}), nil
+		}
+
+		if annotation.Lease != nil && h.leases != nil {
+			holder, expires, leased := h.leases.LookupLease(annotation.Scope.String(), annotation.Key, toSearch)
+			if leased && holder != ar.Request.Namespace+"/"+ar.Request.Name {
+				al.Info("Denied request", zap.String("reason", "value leased"), zap.String("service", holder), zap.Time("expires", expires))
+				trace.validUntil = expires
+				return h.deny(al, ar, response.ReasonLeased, Denial{
+					Name:               displayName(ar, svc),
+					Annotation:         annotation.Key,
+					Value:              toSearch,
+					ConflictingService: holder,
+					Message:            h.message(MessageLeased, toSearch, annotation.Key, holder, expires.UTC().Format(time.RFC3339)),
+				}), nil
+			}
+		}
+
+		if warning := h.poolWarning(annotation, services, ar, svc); warning != "" {
+			al.Info("Pool nearly exhausted")
+			warnings = append(warnings, h.info(warning)...)
+		}
+
+		if previous := Owner(released); previous != nil {
+			al.Info("Released value of terminating service", zap.String("service", fmt.Sprintf("%s/%s", previous.Namespace, previous.Name)), zap.Time("deletion_timestamp", previous.DeletionTimestamp.Time))
+			warnings = append(warnings, h.info(h.message(MessageReleased,
+				previous.Namespace, previous.Name, annotation.Key, previous.DeletionTimestamp.UTC().Format(time.RFC3339)))...)
+		}
+	}
+
+	var resp *admissionv1.AdmissionResponse
+	switch {
+	case checked > 0:
+		defer l.Info("Admitted request", zap.String("reason", "annotation value unique"))
+		resp = response.Allowed(ar.Request.UID, response.ReasonUnique, warnings...)
+	case len(shared) > 0:
+		defer l.Info("Admitted request", zap.String("reason", "annotation value shared"))
+		resp = response.Allowed(ar.Request.UID, response.ReasonShared, warnings...)
+	default:
+		defer l.Info("Admitted request", zap.String("reason", "annotation not present"))
+		resp = response.Allowed(ar.Request.UID, response.ReasonNotPresent, warnings...)
+	}
+	if len(shared) > 0 {
+		resp.AuditAnnotations[response.AuditAnnotationShared] = strings.Join(shared, ",")
+	}
+	return resp, nil
+}
+
+// checkImmutable compares the protected annotations of an updated service
+// with the ones of the old object and returns a denial if an immutable
+// annotation was changed or removed. It returns nil otherwise.
+func (h *AdmitHandlerV1) checkImmutable(l *zap.Logger, ar admissionv1.AdmissionReview, svc, old corev1.Service, annotations []ScopedAnnotation) *admissionv1.AdmissionResponse {
+	for _, annotation := range annotations {
+		if !annotation.Immutable {
+			continue
+		}
+		oldValue, wasSet := annotation.Lookup(old.Annotations)
+		if !wasSet {
+			continue
+		}
+		newValue, isSet := annotation.Lookup(svc.Annotations)
+		switch {
+		case !isSet:
+			l.Info("Denied request", zap.String("reason", "immutable annotation removed"), zap.String("annotation", annotation.Key))
+			return h.deny(l, ar, response.ReasonImmutable, Denial{
+				Name:       displayName(ar, svc),
+				Annotation: annotation.Key,
+				Message:    h.message(MessageImmutableRemoved, annotation.Key),
+			})
+		case newValue != oldValue:
+			l.Info("Denied request", zap.String("reason", "immutable annotation changed"), zap.String("annotation", annotation.Key))
+			return h.deny(l, ar, response.ReasonImmutable, Denial{
+				Name:       displayName(ar, svc),
+				Annotation: annotation.Key,
+				Value:      newValue,
+				Message:    h.message(MessageImmutableChanged, annotation.Key, oldValue, newValue),
+			})
+		}
+	}
+	return nil
+}
+
+// quoteAll quotes and joins values for messages.
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + v + `"`
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// displayName names the service of ar in messages. Services created with
+// generateName have no name yet and are named after their prefix.
+func displayName(ar admissionv1.AdmissionReview, svc corev1.Service) string {
+	switch {
+	case ar.Request.Name != "":
+		return ar.Request.Name
+	case svc.Name != "":
+		return svc.Name
+	case svc.GenerateName != "":
+		return svc.GenerateName + "*"
+	}
+	return "<unnamed>"
+}
+
+// isSelf reports whether service is the object svc under review in ar.
+// Objects are compared by UID, which the apiserver assigns before calling
+// validating webhooks, and otherwise by namespace and name. Unnamed
+// objects are never the same as another.
+func isSelf(ar admissionv1.AdmissionReview, svc, service corev1.Service) bool {
+	if svc.UID != "" && service.UID != "" {
+		return svc.UID == service.UID
+	}
+	return ar.Request.Name != "" && service.Namespace == ar.Request.Namespace && service.Name == ar.Request.Name
+}
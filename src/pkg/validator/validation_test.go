@@ -0,0 +1,1941 @@
+/*
+ *     validation_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"go.uber.org/zap/zaptest"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var defaultService = []byte(
+	`{
+	"apiVersion": "v1",
+	"kind": "Service",
+	"metadata": {
+		"annotations": {
+			"ncp/snat_pool": "test"
+		},
+		"name": "test",
+		"namespace": "default"
+	}
+}`)
+
+var defaultServiceWithoutAnnotation = []byte(
+	`{
+	"apiVersion": "v1",
+	"kind": "Service",
+	"metadata": {
+		"name": "test",
+		"namespace": "default"
+	}
+}`)
+
+var ar = admissionv1.AdmissionReview{
+	Request: &admissionv1.AdmissionRequest{
+		UID: "test",
+		Kind: metav1.GroupVersionKind{
+			Group:   "",
+			Version: "v1",
+			Kind:    "Service",
+		},
+		Resource: metav1.GroupVersionResource{
+			Group:    "",
+			Version:  "v1",
+			Resource: "services",
+		},
+		Name:      "test",
+		Namespace: "default",
+		Operation: admissionv1.Create,
+		Object: runtime.RawExtension{
+			Raw: defaultService,
+		},
+	},
+}
+
+var arWithoutAnnotation = admissionv1.AdmissionReview{
+	Request: &admissionv1.AdmissionRequest{
+		UID: "test",
+		Kind: metav1.GroupVersionKind{
+			Group:   "",
+			Version: "v1",
+			Kind:    "Service",
+		},
+		Resource: metav1.GroupVersionResource{
+			Group:    "",
+			Version:  "v1",
+			Resource: "services",
+		},
+		Name:      "test",
+		Namespace: "default",
+		Operation: admissionv1.Create,
+		Object: runtime.RawExtension{
+			Raw: defaultServiceWithoutAnnotation,
+		},
+	},
+}
+
+var serviceNoAnnotation = corev1.Service{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:        "no-annotation",
+		Namespace:   "default",
+		Annotations: map[string]string{},
+	},
+}
+
+var serviceWithAnnotationOtherValue = corev1.Service{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:        "with-annotation",
+		Namespace:   "default",
+		Annotations: map[string]string{AnnotationNcpSnatPool: "other"},
+	},
+}
+
+type HandlerSuite struct {
+	suite.Suite
+}
+
+func (s *HandlerSuite) TestHandlerOld() {
+	tc := testclient.NewSimpleClientset()
+	tc.Fake.PrependReactor("list", "services",
+		func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			return true, &corev1.ServiceList{}, nil
+		})
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc))
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), h)
+	response := h.Validate(context.Background(), ar)
+	assert.NotNil(s.T(), response)
+}
+
+func emptyServiceList(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+	return true, &corev1.ServiceList{}, nil
+}
+
+func listWithService(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+	return true, &corev1.ServiceList{
+		Items: []corev1.Service{
+			serviceNoAnnotation,
+		},
+	}, nil
+}
+
+func listWithServiceAndAnnotation(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+	return true, &corev1.ServiceList{
+		Items: []corev1.Service{
+			serviceWithAnnotationOtherValue,
+		},
+	}, nil
+}
+
+func (s *HandlerSuite) TestHandlerAdmission() {
+
+	testCases := []struct {
+		desc    string
+		reactor k8stesting.ReactionFunc
+		ar      admissionv1.AdmissionReview
+	}{
+		{
+			desc:    "empty service list",
+			reactor: emptyServiceList,
+			ar:      ar,
+		},
+		{
+			desc:    "list with service, no annotation",
+			reactor: listWithService,
+			ar:      ar,
+		},
+		{
+			desc:    "list with service and annotation, different value",
+			reactor: listWithServiceAndAnnotation,
+			ar:      ar,
+		},
+		{
+			desc:    "request without annotation",
+			reactor: emptyServiceList,
+			ar:      arWithoutAnnotation,
+		},
+	}
+	for _, tC := range testCases {
+
+		s.T().Run(tC.desc, func(t *testing.T) {
+
+			tc := testclient.NewSimpleClientset()
+			tc.Fake.PrependReactor("list", "services", tC.reactor)
+
+			h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(t)), WithClientset(tc))
+			assert.NoError(t, err)
+			assert.NotNil(t, h)
+
+			response := h.Validate(context.Background(), tC.ar)
+			assert.NotNil(t, response)
+			assert.True(t, response.Allowed)
+		})
+	}
+}
+
+func updateReview(oldObject, object []byte) admissionv1.AdmissionReview {
+	review := *ar.DeepCopy()
+	review.Request.Operation = admissionv1.Update
+	review.Request.Object = runtime.RawExtension{Raw: object}
+	review.Request.OldObject = runtime.RawExtension{Raw: oldObject}
+	return review
+}
+
+var defaultServiceOtherValue = []byte(
+	`{
+	"apiVersion": "v1",
+	"kind": "Service",
+	"metadata": {
+		"annotations": {
+			"ncp/snat_pool": "other"
+		},
+		"name": "test",
+		"namespace": "default"
+	}
+}`)
+
+func (s *HandlerSuite) TestHandlerImmutable() {
+	testCases := []struct {
+		desc      string
+		immutable bool
+		ar        admissionv1.AdmissionReview
+		allowed   bool
+	}{
+		{
+			desc:      "unchanged value",
+			immutable: true,
+			ar:        updateReview(defaultService, defaultService),
+			allowed:   true,
+		},
+		{
+			desc:      "changed value",
+			immutable: true,
+			ar:        updateReview(defaultService, defaultServiceOtherValue),
+			allowed:   false,
+		},
+		{
+			desc:      "removed annotation",
+			immutable: true,
+			ar:        updateReview(defaultService, defaultServiceWithoutAnnotation),
+			allowed:   false,
+		},
+		{
+			desc:      "annotation added",
+			immutable: true,
+			ar:        updateReview(defaultServiceWithoutAnnotation, defaultService),
+			allowed:   true,
+		},
+		{
+			desc:      "changed value, not immutable",
+			immutable: false,
+			ar:        updateReview(defaultService, defaultServiceOtherValue),
+			allowed:   true,
+		},
+	}
+	for _, tC := range testCases {
+		s.T().Run(tC.desc, func(t *testing.T) {
+			tc := testclient.NewSimpleClientset()
+			tc.Fake.PrependReactor("list", "services", emptyServiceList)
+
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(t)),
+				WithClientset(tc),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Immutable: tC.immutable}}}))
+			assert.NoError(t, err)
+
+			response := h.Validate(context.Background(), tC.ar)
+			assert.NotNil(t, response)
+			assert.Equal(t, tC.allowed, response.Allowed)
+		})
+	}
+}
+
+var loadBalancerWithoutAnnotation = []byte(
+	`{
+	"apiVersion": "v1",
+	"kind": "Service",
+	"metadata": {
+		"name": "test",
+		"namespace": "default"
+	},
+	"spec": {
+		"type": "LoadBalancer"
+	}
+}`)
+
+func createReview(object []byte) admissionv1.AdmissionReview {
+	review := *ar.DeepCopy()
+	review.Request.Object = runtime.RawExtension{Raw: object}
+	return review
+}
+
+func (s *HandlerSuite) TestHandlerRequired() {
+	testCases := []struct {
+		desc        string
+		requirement *Requirement
+		ar          admissionv1.AdmissionReview
+		allowed     bool
+		warnings    int
+	}{
+		{
+			desc:        "load balancer without annotation, deny",
+			requirement: &Requirement{ServiceTypes: []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}},
+			ar:          createReview(loadBalancerWithoutAnnotation),
+			allowed:     false,
+		},
+		{
+			desc:        "load balancer without annotation, warn",
+			requirement: &Requirement{ServiceTypes: []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}, Action: RequirementWarn},
+			ar:          createReview(loadBalancerWithoutAnnotation),
+			allowed:     true,
+			warnings:    1,
+		},
+		{
+			desc:        "cluster ip without annotation",
+			requirement: &Requirement{ServiceTypes: []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}},
+			ar:          createReview(defaultServiceWithoutAnnotation),
+			allowed:     true,
+		},
+		{
+			desc:        "other namespace",
+			requirement: &Requirement{Namespaces: []string{"other"}},
+			ar:          createReview(loadBalancerWithoutAnnotation),
+			allowed:     true,
+		},
+		{
+			desc:        "operation not required",
+			requirement: &Requirement{Operations: []admissionv1.Operation{admissionv1.Create}},
+			ar:          updateReview(loadBalancerWithoutAnnotation, loadBalancerWithoutAnnotation),
+			allowed:     true,
+		},
+		{
+			desc:        "annotation present",
+			requirement: &Requirement{},
+			ar:          createReview(defaultService),
+			allowed:     true,
+		},
+	}
+	for _, tC := range testCases {
+		s.T().Run(tC.desc, func(t *testing.T) {
+			tc := testclient.NewSimpleClientset()
+			tc.Fake.PrependReactor("list", "services", emptyServiceList)
+
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(t)),
+				WithClientset(tc),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Required: tC.requirement}}}))
+			assert.NoError(t, err)
+
+			response := h.Validate(context.Background(), tC.ar)
+			assert.NotNil(t, response)
+			assert.Equal(t, tC.allowed, response.Allowed)
+			assert.Len(t, response.Warnings, tC.warnings)
+		})
+	}
+}
+
+func (s *HandlerSuite) TestHandlerDistinct() {
+	const ingressPool = "ncp/ingress_pool"
+	service := func(annotations map[string]string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: annotations}})
+		s.Require().NoError(err)
+		review := createReview(raw)
+		review.Request.Namespace, review.Request.Name = "default", "web"
+		return review
+	}
+	testCases := []struct {
+		desc        string
+		distinction *Distinction
+		annotations map[string]string
+		reason      response.Reason
+		message     string
+		warnings    int
+	}{
+		{
+			desc:        "different values",
+			distinction: &Distinction{Keys: []string{ingressPool}},
+			annotations: map[string]string{AnnotationNcpSnatPool: "pool-a", ingressPool: "pool-b"},
+			reason:      response.ReasonUnique,
+		},
+		{
+			desc:        "other annotation absent",
+			distinction: &Distinction{Keys: []string{ingressPool}},
+			annotations: map[string]string{AnnotationNcpSnatPool: "pool-a"},
+			reason:      response.ReasonUnique,
+		},
+		{
+			desc:        "same values, deny",
+			distinction: &Distinction{Keys: []string{"ncp/router", ingressPool}},
+			annotations: map[string]string{AnnotationNcpSnatPool: "pool-a", ingressPool: "pool-a"},
+			reason:      response.ReasonDistinct,
+			message:     `Service default/web must carry different values for annotations "ncp/snat_pool", "ncp/ingress_pool", but all are "pool-a"`,
+		},
+		{
+			desc:        "same values, warn",
+			distinction: &Distinction{Keys: []string{ingressPool}, Action: RequirementWarn},
+			annotations: map[string]string{AnnotationNcpSnatPool: "pool-a", ingressPool: "pool-a"},
+			reason:      response.ReasonUnique,
+			warnings:    1,
+		},
+		{
+			desc:        "same shared values",
+			distinction: &Distinction{Keys: []string{ingressPool}},
+			annotations: map[string]string{AnnotationNcpSnatPool: "default", ingressPool: "default"},
+			reason:      response.ReasonShared,
+		},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(s.T())),
+				WithClientset(testclient.NewSimpleClientset()),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Shared: []string{"default"}, Distinct: tC.distinction}}}))
+			s.Require().NoError(err)
+
+			resp := h.Validate(context.Background(), service(tC.annotations))
+			s.Equal(string(tC.reason), resp.AuditAnnotations[response.AuditAnnotationReason])
+			s.Equal(tC.reason != response.ReasonDistinct, resp.Allowed)
+			if tC.message != "" {
+				s.Equal(tC.message, resp.Result.Message)
+			}
+			s.Len(resp.Warnings, tC.warnings)
+		})
+	}
+}
+
+func (s *HandlerSuite) TestHandlerOperations() {
+	tc := testclient.NewSimpleClientset()
+	tc.Fake.PrependReactor("list", "services", emptyServiceList)
+
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{
+			Key:        AnnotationNcpSnatPool,
+			Immutable:  true,
+			Operations: []admissionv1.Operation{admissionv1.Create},
+		}}}))
+	s.NoError(err)
+
+	response := h.Validate(context.Background(), updateReview(defaultService, defaultServiceOtherValue))
+	s.True(response.Allowed, "annotation is not checked on update")
+}
+
+func (s *HandlerSuite) TestHandlerOperationsIn() {
+	tc := testclient.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev", Labels: map[string]string{"env": "dev"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}})
+	tc.Fake.PrependReactor("list", "services", emptyServiceList)
+
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{
+			Key:       AnnotationNcpSnatPool,
+			Immutable: true,
+			OperationsIn: []NamespaceOperations{
+				{Namespaces: "env=dev", Operations: []admissionv1.Operation{admissionv1.Create}},
+				{Namespaces: "sandbox-*", Operations: []admissionv1.Operation{admissionv1.Create}},
+			},
+		}}}))
+	s.NoError(err)
+
+	update := func(namespace string) admissionv1.AdmissionReview {
+		review := updateReview(defaultService, defaultServiceOtherValue)
+		review.Request.Namespace = namespace
+		return review
+	}
+	s.True(h.Validate(context.Background(), update("dev")).Allowed, "annotation is not checked on update in namespaces labelled env=dev")
+	s.True(h.Validate(context.Background(), update("sandbox-a")).Allowed, "annotation is not checked on update in namespaces matching sandbox-*")
+	s.False(h.Validate(context.Background(), update("prod")).Allowed, "annotation is checked on update in other namespaces")
+}
+
+func (s *HandlerSuite) TestWebhookRules() {
+	testCases := []struct {
+		desc       string
+		list       UniqueList
+		operations []admissionregistrationv1.OperationType
+	}{
+		{
+			desc: "empty list",
+			list: UniqueList{},
+		},
+		{
+			desc:       "default",
+			list:       UniqueList{ClusterScope: {{Key: "a"}}},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		},
+		{
+			desc:       "create only",
+			list:       UniqueList{ClusterScope: {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Create}}}},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		},
+		{
+			desc: "union of scopes",
+			list: UniqueList{
+				ClusterScope: {{Key: "a", Operations: []admissionv1.Operation{admissionv1.Update}}},
+				"default":    {{Key: "b", Operations: []admissionv1.Operation{admissionv1.Create}}},
+			},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		},
+		{
+			desc: "operations in some namespaces",
+			list: UniqueList{ClusterScope: {{Key: "a", OperationsIn: []NamespaceOperations{
+				{Namespaces: "env=dev", Operations: []admissionv1.Operation{admissionv1.Create}},
+			}}}},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create, admissionregistrationv1.Update},
+		},
+		{
+			desc: "operations in all namespaces",
+			list: UniqueList{ClusterScope: {{Key: "a", OperationsIn: []NamespaceOperations{
+				{Namespaces: ClusterScope, Operations: []admissionv1.Operation{admissionv1.Create}},
+				{Namespaces: "env=prod", Operations: []admissionv1.Operation{admissionv1.Update}},
+			}}}},
+			operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+		},
+	}
+	for _, tC := range testCases {
+		s.T().Run(tC.desc, func(t *testing.T) {
+			rules := tC.list.WebhookRules()
+			if tC.operations == nil {
+				assert.Empty(t, rules)
+				return
+			}
+			assert.Len(t, rules, 1)
+			assert.Equal(t, tC.operations, rules[0].Operations)
+			assert.Equal(t, []string{"services"}, rules[0].Resources)
+		})
+	}
+}
+
+func (s *HandlerSuite) TestOwner() {
+	older := metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Hour))
+
+	svc := func(namespace, name string, created metav1.Time) corev1.Service {
+		return corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, CreationTimestamp: created}}
+	}
+
+	testCases := []struct {
+		desc     string
+		services []corev1.Service
+		owner    string
+	}{
+		{
+			desc:     "oldest wins",
+			services: []corev1.Service{svc("a", "new", newer), svc("b", "old", older)},
+			owner:    "b/old",
+		},
+		{
+			desc:     "same timestamp, namespace breaks tie",
+			services: []corev1.Service{svc("b", "x", older), svc("a", "y", older)},
+			owner:    "a/y",
+		},
+		{
+			desc:     "same timestamp and namespace, name breaks tie",
+			services: []corev1.Service{svc("a", "y", older), svc("a", "x", older)},
+			owner:    "a/x",
+		},
+	}
+	for _, tC := range testCases {
+		s.T().Run(tC.desc, func(t *testing.T) {
+			owner := Owner(tC.services)
+			assert.NotNil(t, owner)
+			assert.Equal(t, tC.owner, owner.Namespace+"/"+owner.Name)
+		})
+	}
+	assert.Nil(s.T(), Owner(nil))
+}
+
+func (s *HandlerSuite) TestHandlerDeniesWithOwner() {
+	older := metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	tc := testclient.NewSimpleClientset()
+	tc.Fake.PrependReactor("list", "services",
+		func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			return true, &corev1.ServiceList{
+				Items: []corev1.Service{
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "second", CreationTimestamp: metav1.NewTime(older.Add(time.Minute)), Annotations: map[string]string{AnnotationNcpSnatPool: "test"}}},
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "first", CreationTimestamp: older, Annotations: map[string]string{AnnotationNcpSnatPool: "test"}}},
+				},
+			}, nil
+		})
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc))
+	assert.NoError(s.T(), err)
+
+	response := h.Validate(context.Background(), ar)
+	assert.False(s.T(), response.Allowed)
+	assert.Contains(s.T(), response.Result.Message, "default/first")
+}
+
+func (s *HandlerSuite) TestHandlerTerminating() {
+	deleted := metav1.NewTime(time.Now().Add(-time.Hour))
+	tc := testclient.NewSimpleClientset(&corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "other", Name: "stuck", DeletionTimestamp: &deleted, Finalizers: []string{"example.com/stuck"},
+		Annotations: map[string]string{AnnotationNcpSnatPool: "test"},
+	}})
+
+	testCases := []struct {
+		desc    string
+		release *metav1.Duration
+		allowed bool
+	}{
+		{"terminating services keep their value by default", nil, false},
+		{"value is kept within the release period", &metav1.Duration{Duration: 2 * time.Hour}, false},
+		{"value is released after the release period", &metav1.Duration{Duration: time.Minute}, true},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, ReleaseTerminatingAfter: tC.release}}}))
+			s.Require().NoError(err)
+
+			response := h.Validate(context.Background(), ar)
+			s.Equal(tC.allowed, response.Allowed)
+			if tC.allowed {
+				s.Require().Len(response.Warnings, 1)
+				s.Contains(response.Warnings[0], "other/stuck")
+			}
+
+			owner, err := h.LookupOwner(context.Background(), "default", AnnotationNcpSnatPool, "test")
+			s.NoError(err)
+			s.Equal(tC.allowed, owner == nil)
+		})
+	}
+}
+
+type fakeLeases map[string]string
+
+func (f fakeLeases) LookupLease(scope, annotation, value string) (string, time.Time, bool) {
+	holder, found := f[value]
+	return holder, time.Now().Add(time.Hour), found
+}
+
+func (s *HandlerSuite) TestHandlerLease() {
+	tc := testclient.NewSimpleClientset()
+	leased := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Lease: &metav1.Duration{Duration: time.Hour}}}}
+
+	testCases := []struct {
+		desc    string
+		list    UniqueList
+		holder  string
+		allowed bool
+	}{
+		{"leased to another service", leased, "other/gone", false},
+		{"leased to the same service", leased, "default/test", true},
+		{"annotation without lease", UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}, "other/gone", true},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+				WithUniqueList(tC.list), WithLeaseLookup(fakeLeases{"test": tC.holder}))
+			s.Require().NoError(err)
+
+			response := h.Validate(context.Background(), ar)
+			s.Equal(tC.allowed, response.Allowed)
+			if !tC.allowed {
+				s.Contains(response.Result.Message, tC.holder)
+			}
+		})
+	}
+}
+
+func poolService(namespace, name, value string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Annotations: map[string]string{AnnotationNcpSnatPool: value}}}
+}
+
+func (s *HandlerSuite) TestHandlerPoolExhausted() {
+	testCases := []struct {
+		desc      string
+		services  []runtime.Object
+		exhausted bool
+	}{
+		{"free values left", []runtime.Object{poolService("team-a", "a", "test")}, false},
+		{"pool exhausted", []runtime.Object{
+			poolService("team-a", "a", "test"),
+			poolService("team-b", "b", "other"),
+			poolService("team-b", "c", "third"),
+		}, true},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(testclient.NewSimpleClientset(tC.services...)),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Pool: []string{"test", "other", "third"}}}}))
+			s.Require().NoError(err)
+
+			response := h.Validate(context.Background(), ar)
+			s.False(response.Allowed)
+			if tC.exhausted {
+				s.Contains(response.Result.Message, "all 3 values of the pool are in use, top consumers: team-b (2), team-a (1)")
+			} else {
+				s.NotContains(response.Result.Message, "values of the pool")
+			}
+		})
+	}
+}
+
+func (s *HandlerSuite) TestHandlerPoolWarning() {
+	testCases := []struct {
+		desc      string
+		services  []runtime.Object
+		threshold int
+		warning   string
+	}{
+		{"below threshold", []runtime.Object{poolService("team-a", "a", "other")}, 50, ""},
+		{"above threshold", []runtime.Object{poolService("team-a", "a", "other"), poolService("team-a", "b", "third")}, 50, "3 of 4 values (75%)"},
+		{"disabled", []runtime.Object{poolService("team-a", "a", "other"), poolService("team-a", "b", "third")}, 0, ""},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(testclient.NewSimpleClientset(tC.services...)),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Pool: []string{"test", "other", "third", "fourth"}, PoolWarningThreshold: tC.threshold}}}))
+			s.Require().NoError(err)
+
+			response := h.Validate(context.Background(), ar)
+			s.True(response.Allowed)
+			if tC.warning == "" {
+				s.Empty(response.Warnings)
+			} else {
+				s.Require().Len(response.Warnings, 1)
+				s.Contains(response.Warnings[0], tC.warning)
+			}
+		})
+	}
+}
+
+func (s *HandlerSuite) TestHandlerEscalation() {
+	tc := testclient.NewSimpleClientset(poolService("other", "holder", "test"))
+	clk := testingclock.NewFakeClock(time.Now())
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithClock(clk), WithDenialEscalation(2, time.Minute))
+	s.Require().NoError(err)
+
+	review := *ar.DeepCopy()
+	review.Request.UserInfo.Username = "alice"
+	first := h.Validate(context.Background(), review)
+	s.False(first.Allowed)
+	s.NotContains(first.Result.Message, "GET /owner")
+	s.NotContains(h.Validate(context.Background(), review).Result.Message, "GET /owner", "repeated deliveries count once")
+
+	review.Request.UID = "retry"
+	second := h.Validate(context.Background(), review)
+	s.False(second.Allowed)
+	s.Contains(second.Result.Message, "denied 2 times")
+	s.Contains(second.Result.Message, "GET /owner")
+
+	review.Request.UserInfo.Username = "bob"
+	s.NotContains(h.Validate(context.Background(), review).Result.Message, "GET /owner", "denials are counted per user")
+
+	clk.Step(time.Minute + time.Second)
+	review.Request.UserInfo.Username = "alice"
+	review.Request.UID = "later"
+	s.NotContains(h.Validate(context.Background(), review).Result.Message, "GET /owner", "denials are counted per window")
+}
+
+func (s *HandlerSuite) TestDenialEvents() {
+	tc := testclient.NewSimpleClientset(poolService("other", "holder", "test"))
+	recorder := record.NewFakeRecorder(10)
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithDenialEvents(recorder))
+	s.Require().NoError(err)
+
+	_, err = h.ValidateBytes(context.Background(), reviewBytes(s, ar))
+	s.Require().NoError(err)
+	s.Require().Len(recorder.Events, 1)
+	s.Contains(<-recorder.Events, "Warning AnnotationConflict Service other/holder already has the same value")
+
+	dryRun := *ar.DeepCopy()
+	dryRun.Request.DryRun = new(bool)
+	*dryRun.Request.DryRun = true
+	_, err = h.ValidateBytes(context.Background(), reviewBytes(s, dryRun))
+	s.Require().NoError(err)
+	s.Empty(recorder.Events, "dry-run denials do not record events")
+
+	_, err = h.ValidateBytes(context.Background(), reviewBytes(s, arWithoutAnnotation))
+	s.Require().NoError(err)
+	s.Empty(recorder.Events, "admitted requests do not record events")
+}
+
+func (s *HandlerSuite) TestEmptyValues() {
+	empty := poolService("default", "claimant", "")
+	empty.APIVersion, empty.Kind = "v1", "Service"
+	raw, err := json.Marshal(empty)
+	s.Require().NoError(err)
+	review := *ar.DeepCopy()
+	review.Request.Name = empty.Name
+	review.Request.Object.Raw = raw
+
+	testCases := []struct {
+		treatment EmptyValueTreatment
+		allowed   bool
+		reason    response.Reason
+	}{
+		{"", true, response.ReasonNotPresent},
+		{EmptyAbsent, true, response.ReasonNotPresent},
+		{EmptyValue, false, response.ReasonConflict},
+	}
+	for _, tC := range testCases {
+		s.T().Run(string(tC.treatment), func(t *testing.T) {
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(t)),
+				WithClientset(testclient.NewSimpleClientset(poolService("other", "holder", ""))),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, EmptyValues: tC.treatment}}}))
+			require.NoError(t, err)
+
+			resp := h.Validate(context.Background(), review)
+			assert.Equal(t, tC.allowed, resp.Allowed)
+			assert.Equal(t, string(tC.reason), resp.AuditAnnotations[response.AuditAnnotationReason])
+		})
+	}
+}
+
+type panickingLeases struct{}
+
+func (panickingLeases) LookupLease(string, string, string) (string, time.Time, bool) {
+	panic("lease store corrupted")
+}
+
+func reviewBytes(s *HandlerSuite, review admissionv1.AdmissionReview) []byte {
+	review.TypeMeta = metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"}
+	data, err := json.Marshal(review)
+	s.Require().NoError(err)
+	return data
+}
+
+// barrierLeases blocks each lookup until parties lookups are in progress.
+type barrierLeases struct {
+	arrived *sync.WaitGroup
+}
+
+func (b barrierLeases) LookupLease(string, string, string) (string, time.Time, bool) {
+	b.arrived.Done()
+	b.arrived.Wait()
+	return "", time.Time{}, false
+}
+
+func (s *HandlerSuite) TestValidateBytesConcurrently() {
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	leased := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Lease: &metav1.Duration{Duration: time.Hour}}}}
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(testclient.NewSimpleClientset()), WithUniqueList(leased), WithLeaseLookup(barrierLeases{&arrived}))
+	s.Require().NoError(err)
+
+	allowed := make(chan bool, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			reviewed, err := h.ValidateBytes(context.Background(), reviewBytes(s, ar))
+			allowed <- err == nil && reviewed.Response.Allowed
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case a := <-allowed:
+			s.True(a)
+		case <-time.After(5 * time.Second):
+			s.FailNow("reviews are not validated concurrently")
+		}
+	}
+}
+
+func (s *HandlerSuite) TestUIDPropagation() {
+	tc := testclient.NewSimpleClientset(poolService("other", "holder", "taken"))
+	leased := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Lease: &metav1.Duration{Duration: time.Hour}}}}
+
+	unsupported := *ar.DeepCopy()
+	unsupported.Request.Resource.Resource = "configmaps"
+	undecodable := *ar.DeepCopy()
+	undecodable.Request.Object.Raw = []byte(`{"apiVersion": "v1", "kind": "Service", "metadata": []}`)
+	denied := createReview([]byte(`{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "test", "namespace": "default", "annotations": {"ncp/snat_pool": "taken"}}}`))
+
+	testCases := []struct {
+		desc    string
+		review  admissionv1.AdmissionReview
+		list    UniqueList
+		allowed bool
+	}{
+		{"unsupported resource", unsupported, nil, true},
+		{"decode failure", undecodable, nil, false},
+		{"allowed without annotation", arWithoutAnnotation, nil, true},
+		{"allowed with unique value", ar, nil, true},
+		{"denied", denied, nil, false},
+		{"panic", ar, leased, false},
+	}
+	for _, tC := range testCases {
+		s.Run(tC.desc, func() {
+			opts := []ValidationHandlerOption{WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithLeaseLookup(panickingLeases{})}
+			if tC.list != nil {
+				opts = append(opts, WithUniqueList(tC.list))
+			}
+			h, err := NewValidationHandlerV1(opts...)
+			s.Require().NoError(err)
+
+			tC.review.Request.UID = types.UID("uid-" + strings.ReplaceAll(tC.desc, " ", "-"))
+			reviewed, err := h.ValidateBytes(context.Background(), reviewBytes(s, tC.review))
+			s.Require().NoError(err)
+			s.Equal("admission.k8s.io/v1", reviewed.APIVersion)
+			s.Require().NotNil(reviewed.Response)
+			s.Equal(tC.review.Request.UID, reviewed.Response.UID)
+			s.Equal(tC.allowed, reviewed.Response.Allowed)
+		})
+	}
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc))
+	s.Require().NoError(err)
+	_, err = h.ValidateBytes(context.Background(), []byte(`{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`))
+	s.Error(err, "reviews without request have no UID to answer to")
+	_, err = h.ValidateBytes(context.Background(), []byte(`not json`))
+	s.Error(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	reviewed, err := h.ValidateBytes(ctx, reviewBytes(s, ar))
+	s.Require().NoError(err, "abandoned reviews are still answered")
+	s.Equal(ar.Request.UID, reviewed.Response.UID)
+	s.False(reviewed.Response.Allowed)
+	s.Contains(reviewed.Response.Result.Message, context.Canceled.Error())
+}
+
+func (s *HandlerSuite) TestScopes() {
+	s.Equal(Cluster, ParseScope(ClusterScope))
+	s.Equal(NamespaceScope("team-a"), ParseScope("team-a"))
+	s.True(Cluster.Contains("team-a"))
+	s.False(NamespaceScope("team-a").Contains("team-b"))
+
+	list := UniqueList{
+		"team-b":     {{Key: "b"}},
+		ClusterScope: {{Key: "c"}},
+		"team-a":     {{Key: "a"}},
+	}
+	s.Equal([]Scope{Cluster, NamespaceScope("team-a"), NamespaceScope("team-b")}, list.Scopes())
+	s.Equal([]ScopedAnnotation{
+		{ProtectedAnnotation: ProtectedAnnotation{Key: "a"}, Scope: NamespaceScope("team-a")},
+		{ProtectedAnnotation: ProtectedAnnotation{Key: "c"}, Scope: Cluster},
+	}, list.ProtectedInNamespace("team-a"), "annotations are ordered by key")
+	s.Len(list.ProtectedInNamespace(ClusterScope), 1)
+}
+
+func (s *HandlerSuite) TestPatternScopes() {
+	s.Equal(GlobScope("team-*"), ParseScope("team-*"))
+	s.Equal(RegexpScope("^prod-.*$"), ParseScope("^prod-.*$"))
+	s.True(GlobScope("team-*").Contains("team-a"))
+	s.False(GlobScope("team-*").Contains("prod-a"))
+	s.True(RegexpScope("^prod-[0-9]+$").Contains("prod-1"))
+	s.False(RegexpScope("^prod-[0-9]+$").Contains("prod-a"))
+	s.Empty(GlobScope("team-*").Namespace(), "patterns are listed across all namespaces")
+	s.NoError(ValidateScope("team-*"))
+	s.Error(ValidateScope("team-["))
+	s.Error(ValidateScope("^prod-("))
+	s.False(RegexpScope("^prod-(").Contains("prod-("))
+
+	list := UniqueList{
+		"team-*":     {{Key: AnnotationNcpSnatPool}},
+		"^prod-.*$":  {{Key: "b"}},
+		ClusterScope: {{Key: "c"}},
+	}
+	s.Equal([]ScopedAnnotation{
+		{ProtectedAnnotation: ProtectedAnnotation{Key: "c"}, Scope: Cluster},
+		{ProtectedAnnotation: ProtectedAnnotation{Key: AnnotationNcpSnatPool}, Scope: GlobScope("team-*")},
+	}, list.ProtectedInNamespace("team-a"))
+	s.Nil(list.WebhookNamespaceSelector(), "patterns may match namespaces created later")
+
+	tc := testclient.NewSimpleClientset(poolService("team-a", "holder", "test"), poolService("other", "holder", "other"))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(list))
+	s.Require().NoError(err)
+	review := func(namespace, value string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService(namespace, "claimant", value))
+		s.Require().NoError(err)
+		r := createReview(raw)
+		r.Request.Namespace = namespace
+		return r
+	}
+	s.False(h.Validate(context.Background(), review("team-b", "test")).Allowed, "values are shared by all matching namespaces")
+	s.True(h.Validate(context.Background(), review("team-b", "other")).Allowed, "holders outside of the pattern are ignored")
+	s.True(h.Validate(context.Background(), review("unrelated", "test")).Allowed)
+}
+
+func (s *HandlerSuite) TestSelectorScopes() {
+	s.Equal(SelectorScope("env=prod"), ParseScope("env=prod"))
+	s.Equal(SelectorScope("env in (prod,staging)"), ParseScope("env in (prod,staging)"))
+	s.Equal(NamespaceScope("env"), ParseScope("env"))
+	s.True(SelectorScope("env=prod").Matches(map[string]string{"env": "prod"}))
+	s.False(SelectorScope("env=prod").Matches(map[string]string{}))
+	s.False(SelectorScope("env=prod").Contains("prod"), "names do not tell the labels")
+	s.Error(ValidateScope("env in (prod"))
+
+	list := UniqueList{"env=prod": {{Key: AnnotationNcpSnatPool}}}
+	s.True(list.HasSelectors())
+	s.Empty(list.ProtectedInNamespace("prod-a"))
+	s.Len(list.ProtectedIn("prod-a", map[string]string{"env": "prod"}), 1)
+
+	namespace := func(name, env string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"env": env}}}
+	}
+	tc := testclient.NewSimpleClientset(
+		namespace("prod-a", "prod"), namespace("prod-b", "prod"), namespace("dev", "dev"),
+		poolService("prod-a", "holder", "test"), poolService("dev", "holder", "other"))
+
+	services, err := ListScope(context.Background(), tc, SelectorScope("env=prod"))
+	s.Require().NoError(err)
+	s.Len(services, 1)
+
+	review := func(namespace, value string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService(namespace, "claimant", value))
+		s.Require().NoError(err)
+		r := createReview(raw)
+		r.Request.Namespace = namespace
+		return r
+	}
+	check := func(h *AdmitHandlerV1) {
+		s.False(h.Validate(context.Background(), review("prod-b", "test")).Allowed, "values are shared by all selected namespaces")
+		s.True(h.Validate(context.Background(), review("prod-b", "other")).Allowed, "holders in other namespaces are ignored")
+		s.True(h.Validate(context.Background(), review("dev", "test")).Allowed)
+	}
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(list))
+	s.Require().NoError(err)
+	check(h)
+
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	informer := factory.Core().V1().Namespaces().Informer()
+	h, err = NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(list), WithNamespaceInformer(informer))
+	s.Require().NoError(err)
+	stop := make(chan struct{})
+	defer close(stop)
+	factory.Start(stop)
+	factory.WaitForCacheSync(stop)
+	check(h)
+}
+
+func (s *HandlerSuite) TestMessageCatalog() {
+	s.NoError(DefaultCatalog().Validate())
+	s.ErrorContains(Catalog{"annotation-conflicts": "taken"}.Validate(), "unknown key")
+	s.ErrorContains(Catalog{MessageConflict: "%s/%s ist vergeben"}.Validate(), "4 arguments")
+	s.ErrorContains(Catalog{MessageDegraded: "%s"}.Validate(), "0 arguments")
+	_, err := NewValidationHandlerV1(WithMessageCatalog(Catalog{MessageConflict: "%d"}))
+	s.Error(err)
+
+	tc := testclient.NewSimpleClientset(poolService("default", "holder", "test"))
+	raw, err := json.Marshal(poolService("default", "claimant", "test"))
+	s.Require().NoError(err)
+	list := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}
+
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(list),
+		WithMessageCatalog(Catalog{MessageConflict: "Der Wert \"%[4]s\" der Annotation \"%[3]s\" ist bereits an Service %[1]s/%[2]s vergeben"}),
+		WithMessageCatalog(Catalog{MessageImmutableRemoved: "Annotation \"%s\" darf nicht entfernt werden"}))
+	s.Require().NoError(err)
+	resp := h.Validate(context.Background(), createReview(raw))
+	s.False(resp.Allowed)
+	s.Equal(`Der Wert "test" der Annotation "ncp/snat_pool" ist bereits an Service default/holder vergeben`, resp.Result.Message)
+	s.Equal(`Annotation "ncp/snat_pool" darf nicht entfernt werden`, h.message(MessageImmutableRemoved, AnnotationNcpSnatPool), "later catalogs add to earlier ones")
+	s.Equal(DegradedWarning, h.message(MessageDegraded), "keys not in a catalog keep their default text")
+}
+
+func (s *HandlerSuite) TestDenialTemplate() {
+	_, err := ParseDenialTemplate("{{.Message")
+	s.Error(err, "syntax errors are found")
+	_, err = ParseDenialTemplate("{{.Holder}}")
+	s.Error(err, "unknown fields are found")
+	_, err = ParseDenialTemplate("{{if false}}x{{end}}")
+	s.ErrorContains(err, "empty")
+	_, err = NewValidationHandlerV1(WithDenialTemplate(nil))
+	s.Error(err)
+
+	t, err := ParseDenialTemplate(`{{.Message}}; see https://wiki.example.com/unik#{{.Reason}}{{if eq .Value "broken"}}{{index .Value 99}}{{end}}`)
+	s.Require().NoError(err)
+	tc := testclient.NewSimpleClientset(poolService("default", "holder", "test"), poolService("default", "other", "broken"))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}), WithDenialTemplate(t))
+	s.Require().NoError(err)
+
+	raw, err := json.Marshal(poolService("default", "claimant", "test"))
+	s.Require().NoError(err)
+	resp := h.Validate(context.Background(), createReview(raw))
+	s.False(resp.Allowed)
+	s.Equal(`Service default/holder already has the same value for annotation "ncp/snat_pool": "test"; see https://wiki.example.com/unik#annotation-conflict`, resp.Result.Message)
+
+	raw, err = json.Marshal(poolService("default", "claimant", "broken"))
+	s.Require().NoError(err)
+	resp = h.Validate(context.Background(), createReview(raw))
+	s.False(resp.Allowed)
+	s.Equal(`Service default/other already has the same value for annotation "ncp/snat_pool": "broken"`, resp.Result.Message, "failing templates fall back to the default message")
+}
+
+func (s *HandlerSuite) TestSelfOriginated() {
+	const self = "system:serviceaccount:unik:unik-admission-controller"
+	_, err := NewValidationHandlerV1(WithSelf(""))
+	s.Error(err)
+
+	tc := testclient.NewSimpleClientset(poolService("default", "holder", "test"))
+	raw, err := json.Marshal(poolService("default", "claimant", "test"))
+	s.Require().NoError(err)
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}),
+		WithUnsupportedAction(UnsupportedDeny), WithSelf(self))
+	s.Require().NoError(err)
+
+	review := func(username, manager string, dryRun bool) admissionv1.AdmissionReview {
+		r := createReview(raw)
+		r.Request.UserInfo.Username = username
+		r.Request.Options = runtime.RawExtension{Raw: []byte(`{"kind":"CreateOptions","apiVersion":"meta.k8s.io/v1","fieldManager":"` + manager + `"}`)}
+		r.Request.DryRun = &dryRun
+		return r
+	}
+	for _, tC := range []struct {
+		desc    string
+		ar      admissionv1.AdmissionReview
+		allowed bool
+	}{
+		{"own write", review(self, FieldManager, false), true},
+		{"own dry run", review(self, FieldManager, true), false},
+		{"own credentials, other field manager", review(self, "kubectl-client-side-apply", false), false},
+		{"other user", review("jane", FieldManager, false), false},
+	} {
+		s.Run(tC.desc, func() {
+			resp := h.Validate(context.Background(), tC.ar)
+			s.Equal(tC.allowed, resp.Allowed)
+		})
+	}
+
+	configMap := review(self, FieldManager, false)
+	configMap.Request.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	resp := h.Validate(context.Background(), configMap)
+	s.True(resp.Allowed, "own writes are admitted even if unsupported resources are denied")
+	s.Equal(string(response.ReasonSelf), resp.AuditAnnotations[response.AuditAnnotationReason])
+}
+
+func (s *HandlerSuite) TestNamespaceModes() {
+	_, err := NewValidationHandlerV1(WithNamespaceModes(-time.Second))
+	s.Error(err)
+
+	namespace := func(name, mode string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if mode != "" {
+			ns.Annotations = map[string]string{ModeAnnotation: mode}
+		}
+		return ns
+	}
+	objects := []runtime.Object{}
+	for name, mode := range map[string]string{"enforced": "", "warned": "warn", "off": "off", "invalid": "loud"} {
+		objects = append(objects, namespace(name, mode), poolService(name, "holder", name))
+	}
+	tc := testclient.NewSimpleClientset(objects...)
+	clock := testingclock.NewFakePassiveClock(time.Now())
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithClock(clock),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}), WithNamespaceModes(time.Minute))
+	s.Require().NoError(err)
+
+	review := func(namespace string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService(namespace, "claimant", namespace))
+		s.Require().NoError(err)
+		r := createReview(raw)
+		r.Request.Namespace = namespace
+		return r
+	}
+	for _, tC := range []struct {
+		namespace string
+		allowed   bool
+		warnings  int
+		reason    response.Reason
+	}{
+		{"enforced", false, 0, response.ReasonConflict},
+		{"warned", true, 1, response.ReasonConflict},
+		{"off", true, 0, response.ReasonModeOff},
+		{"invalid", false, 0, response.ReasonConflict},
+		{"unknown", true, 0, response.ReasonUnique},
+	} {
+		s.Run(tC.namespace, func() {
+			resp := h.Validate(context.Background(), review(tC.namespace))
+			s.Equal(tC.allowed, resp.Allowed)
+			s.Len(resp.Warnings, tC.warnings)
+			s.Equal(string(tC.reason), resp.AuditAnnotations[response.AuditAnnotationReason])
+		})
+	}
+	resp := h.Validate(context.Background(), review("warned"))
+	s.Equal(string(ModeWarn), resp.AuditAnnotations[response.AuditAnnotationMode])
+	s.Contains(resp.Warnings[0], "holder")
+
+	_, err = tc.CoreV1().Namespaces().Update(context.Background(), namespace("enforced", "off"), metav1.UpdateOptions{})
+	s.Require().NoError(err)
+	s.False(h.Validate(context.Background(), review("enforced")).Allowed, "modes are cached")
+	clock.SetTime(clock.Now().Add(time.Minute))
+	s.True(h.Validate(context.Background(), review("enforced")).Allowed)
+}
+
+func (s *HandlerSuite) TestExemptNamespaces() {
+	_, err := NewValidationHandlerV1(WithExemptNamespaces("Kube_System"))
+	s.Error(err)
+
+	tc := testclient.NewSimpleClientset(poolService("default", "holder", "test"), poolService("kube-system", "holder", "test"))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}),
+		WithUnsupportedAction(UnsupportedDeny), WithExemptNamespaces(DefaultExemptNamespaces...))
+	s.Require().NoError(err)
+
+	review := func(namespace string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService(namespace, "claimant", "test"))
+		s.Require().NoError(err)
+		r := createReview(raw)
+		r.Request.Namespace = namespace
+		return r
+	}
+	s.False(h.Validate(context.Background(), review("default")).Allowed)
+
+	tc.ClearActions()
+	resp := h.Validate(context.Background(), review("kube-system"))
+	s.True(resp.Allowed)
+	s.Equal(string(response.ReasonExempt), resp.AuditAnnotations[response.AuditAnnotationReason])
+	s.Empty(tc.Actions(), "services are not listed for exempt namespaces")
+
+	unsupported := review("kube-public")
+	unsupported.Request.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	s.True(h.Validate(context.Background(), unsupported).Allowed)
+}
+
+func (s *HandlerSuite) TestSharedValues() {
+	tc := testclient.NewSimpleClientset(poolService("default", "holder", "default"), poolService("default", "other", "test"))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Shared: []string{"default"}}}}))
+	s.Require().NoError(err)
+
+	review := func(value string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(poolService("default", "claimant", value))
+		s.Require().NoError(err)
+		return createReview(raw)
+	}
+	tc.ClearActions()
+	resp := h.Validate(context.Background(), review("default"))
+	s.True(resp.Allowed, "shared values may be held by any number of services")
+	s.Equal(string(response.ReasonShared), resp.AuditAnnotations[response.AuditAnnotationReason])
+	s.Equal(AnnotationNcpSnatPool+"=default", resp.AuditAnnotations[response.AuditAnnotationShared])
+	s.Empty(tc.Actions(), "services are not listed for shared values")
+
+	resp = h.Validate(context.Background(), review("test"))
+	s.False(resp.Allowed, "other values are still unique")
+	s.NotContains(resp.AuditAnnotations, response.AuditAnnotationShared)
+}
+
+func (s *HandlerSuite) TestWildcardKeys() {
+	holder := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "holder", Annotations: map[string]string{
+		AnnotationNcpSnatPool: "a",
+		"ncp/vip":             "b",
+	}}}
+	tc := testclient.NewSimpleClientset(holder)
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: "ncp/*"}, {Key: AnnotationNcpSnatPool, Shared: []string{"a"}}}}))
+	s.Require().NoError(err)
+
+	review := func(annotations map[string]string) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claimant", Annotations: annotations}})
+		s.Require().NoError(err)
+		return createReview(raw)
+	}
+
+	resp := h.Validate(context.Background(), review(map[string]string{"ncp/vip": "b"}))
+	s.False(resp.Allowed, "keys matching the wildcard are protected")
+	s.Contains(resp.Result.Message, "ncp/vip", "the concrete key is reported")
+
+	resp = h.Validate(context.Background(), review(map[string]string{"ncp/snat_pool": "b"}))
+	s.True(resp.Allowed, "each key is unique on its own")
+
+	resp = h.Validate(context.Background(), review(map[string]string{AnnotationNcpSnatPool: "a"}))
+	s.True(resp.Allowed, "a key declared explicitly takes precedence over the wildcard")
+	s.Equal(string(response.ReasonShared), resp.AuditAnnotations[response.AuditAnnotationReason])
+
+	resp = h.Validate(context.Background(), review(map[string]string{"example.com/vip": "b"}))
+	s.True(resp.Allowed)
+	s.Equal(string(response.ReasonNotPresent), resp.AuditAnnotations[response.AuditAnnotationReason])
+
+	owner, err := h.LookupOwner(context.Background(), "default", "ncp/vip", "b")
+	s.Require().NoError(err)
+	s.Equal("holder", owner.Name)
+}
+
+func (s *HandlerSuite) TestCompositeKeys() {
+	routed := func(name, pool, router string) *corev1.Service {
+		annotations := map[string]string{AnnotationNcpSnatPool: pool}
+		if router != "" {
+			annotations["ncp/router"] = router
+		}
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations}}
+	}
+	tc := testclient.NewSimpleClientset(routed("holder", "pool-a", "t1"), routed("legacy", "pool-b", ""))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Composite: []string{"ncp/router"}}}}))
+	s.Require().NoError(err)
+
+	review := func(svc *corev1.Service) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(svc)
+		s.Require().NoError(err)
+		return createReview(raw)
+	}
+
+	resp := h.Validate(context.Background(), review(routed("claimant", "pool-a", "t1")))
+	s.False(resp.Allowed, "services conflict if all components match")
+	s.Equal(`Service default/holder already has the same values for annotations "ncp/snat_pool", "ncp/router": "pool-a", "t1"`, resp.Result.Message)
+
+	resp = h.Validate(context.Background(), review(routed("claimant", "pool-a", "t2")))
+	s.True(resp.Allowed, "services differing in one component do not conflict")
+	s.Equal(string(response.ReasonUnique), resp.AuditAnnotations[response.AuditAnnotationReason])
+
+	resp = h.Validate(context.Background(), review(routed("claimant", "pool-b", "t1")))
+	s.True(resp.Allowed, "services lacking a component do not hold the key")
+
+	resp = h.Validate(context.Background(), review(routed("claimant", "pool-a", "")))
+	s.True(resp.Allowed)
+	s.Equal(string(response.ReasonNotPresent), resp.AuditAnnotations[response.AuditAnnotationReason], "incomplete keys are not checked")
+}
+
+func (s *HandlerSuite) TestMaxHolders() {
+	tc := testclient.NewSimpleClientset(poolService("default", "first", "a"), poolService("other", "second", "a"))
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, MaxHolders: 3}}}))
+	s.Require().NoError(err)
+
+	raw, err := json.Marshal(poolService("default", "third", "a"))
+	s.Require().NoError(err)
+	resp := h.Validate(context.Background(), createReview(raw))
+	s.True(resp.Allowed, "values may be shared up to the limit")
+
+	_, err = tc.CoreV1().Services("default").Create(context.Background(), poolService("default", "third", "a"), metav1.CreateOptions{})
+	s.Require().NoError(err)
+	raw, err = json.Marshal(poolService("default", "fourth", "a"))
+	s.Require().NoError(err)
+	resp = h.Validate(context.Background(), createReview(raw))
+	s.False(resp.Allowed)
+	s.Equal(string(response.ReasonConflict), resp.AuditAnnotations[response.AuditAnnotationReason])
+	s.Contains(resp.Result.Message, "already used by 3 Services, at most 3 may share it")
+}
+
+func TestResolve(t *testing.T) {
+	list := []ProtectedAnnotation{{Key: "example.com/*"}, {Key: "example.com/ip-*", Immutable: true}, {Key: "example.com/ip-v4"}}
+
+	a, found := Resolve(list, "example.com/ip-v6")
+	assert.True(t, found)
+	assert.Equal(t, ProtectedAnnotation{Key: "example.com/ip-v6", Immutable: true}, a, "the longest prefix wins")
+	a, found = Resolve(list, "example.com/ip-v4")
+	assert.True(t, found)
+	assert.Equal(t, ProtectedAnnotation{Key: "example.com/ip-v4"}, a)
+	_, found = Resolve(list, "example.org/ip")
+	assert.False(t, found)
+
+	assert.Equal(t, []ProtectedAnnotation{{Key: "example.com/ip-v4"}, {Key: "example.com/host"}, {Key: "example.com/ip-v6", Immutable: true}},
+		ExpandKeys(list, map[string]string{"example.com/ip-v6": "a", "example.com/ip-v4": "b"}, map[string]string{"example.com/host": "c", "other": "d"}))
+}
+
+func (s *HandlerSuite) TestWarningVerbosity() {
+	unsupported := *ar.DeepCopy()
+	unsupported.Request.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	missing := createReview(loadBalancerWithoutAnnotation)
+
+	testCases := []struct {
+		verbosity   WarningVerbosity
+		unsupported int
+		missing     int
+	}{
+		{WarningsFull, 1, 1},
+		{WarningsErrors, 0, 1},
+		{WarningsNone, 0, 0},
+	}
+	for _, tC := range testCases {
+		s.T().Run(string(tC.verbosity), func(t *testing.T) {
+			tc := testclient.NewSimpleClientset()
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(t)),
+				WithClientset(tc),
+				WithWarningVerbosity(tC.verbosity),
+				WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Required: &Requirement{Action: RequirementWarn}}}}))
+			require.NoError(t, err)
+
+			assert.Len(t, h.Validate(context.Background(), unsupported).Warnings, tC.unsupported)
+			assert.Len(t, h.Validate(context.Background(), missing).Warnings, tC.missing)
+		})
+	}
+
+	_, err := NewValidationHandlerV1(WithWarningVerbosity("loud"))
+	s.Error(err)
+}
+
+func (s *HandlerSuite) TestUnsupportedAction() {
+	unsupported := *ar.DeepCopy()
+	unsupported.Request.Resource = metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+	testCases := []struct {
+		action   UnsupportedAction
+		allowed  bool
+		warnings int
+	}{
+		{UnsupportedWarn, true, 1},
+		{UnsupportedAllow, true, 0},
+		{UnsupportedDeny, false, 0},
+	}
+	for _, tC := range testCases {
+		s.T().Run(string(tC.action), func(t *testing.T) {
+			h, err := NewValidationHandlerV1(
+				WithLogger(zaptest.NewLogger(t)),
+				WithClientset(testclient.NewSimpleClientset()),
+				WithUnsupportedAction(tC.action))
+			require.NoError(t, err)
+
+			resp := h.Validate(context.Background(), unsupported)
+			assert.Equal(t, tC.allowed, resp.Allowed)
+			assert.Len(t, resp.Warnings, tC.warnings)
+			assert.Equal(t, string(response.ReasonUnsupportedResource), resp.AuditAnnotations[response.AuditAnnotationReason])
+		})
+	}
+
+	_, err := NewValidationHandlerV1(WithUnsupportedAction("ignore"))
+	s.Error(err)
+}
+
+func (s *HandlerSuite) TestDeterministicOrder() {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "other",
+		Name:        "holder",
+		Annotations: map[string]string{"a": "x", "b": "x"},
+	}}
+	tc := testclient.NewSimpleClientset(svc)
+	required := &Requirement{Action: RequirementWarn}
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithUniqueList(UniqueList{
+		ClusterScope: {{Key: "d", Required: required}, {Key: "b"}, {Key: "c", Required: required}, {Key: "a"}},
+	}))
+	s.Require().NoError(err)
+
+	review := createReview([]byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"test","namespace":"default","annotations":{"a":"x","b":"x"}}}`))
+	for i := 0; i < 3; i++ {
+		resp := h.Validate(context.Background(), review)
+		s.False(resp.Allowed)
+		s.Contains(resp.Result.Message, `annotation "a"`, "the conflict of the first key is reported")
+	}
+
+	review = createReview([]byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"test","namespace":"default"}}`))
+	resp := h.Validate(context.Background(), review)
+	s.Require().Len(resp.Warnings, 2)
+	s.Contains(resp.Warnings[0], `annotation "c"`)
+	s.Contains(resp.Warnings[1], `annotation "d"`)
+}
+
+func (s *HandlerSuite) TestLookupOwner() {
+	tc := testclient.NewSimpleClientset(&serviceWithAnnotationOtherValue, &serviceNoAnnotation)
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc))
+	assert.NoError(s.T(), err)
+
+	owner, err := h.LookupOwner(context.Background(), "", AnnotationNcpSnatPool, "other")
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), owner)
+	assert.Equal(s.T(), serviceWithAnnotationOtherValue.Name, owner.Name)
+
+	owner, err = h.LookupOwner(context.Background(), "", AnnotationNcpSnatPool, "unused")
+	assert.NoError(s.T(), err)
+	assert.Nil(s.T(), owner)
+
+	_, err = h.LookupOwner(context.Background(), "", "unprotected", "other")
+	assert.ErrorIs(s.T(), err, ErrNotProtected)
+}
+
+func (s *HandlerSuite) TestHandlerDegraded() {
+	degraded := false
+	tc := testclient.NewSimpleClientset()
+	tc.Fake.PrependReactor("list", "services", emptyServiceList)
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithDegradedCheck(func() (string, bool) { return "test", degraded }))
+	assert.NoError(s.T(), err)
+
+	response := h.Validate(context.Background(), ar)
+	assert.True(s.T(), response.Allowed)
+	assert.NotContains(s.T(), response.Warnings, DegradedWarning)
+
+	degraded = true
+	response = h.Validate(context.Background(), ar)
+	assert.True(s.T(), response.Allowed)
+	assert.Contains(s.T(), response.Warnings, DegradedWarning)
+}
+
+func (s *HandlerSuite) TestDecisionTrace() {
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(testclient.NewSimpleClientset(poolService("team-a", "a", "other"), poolService("team-b", "b", "third"))),
+		WithUniqueList(UniqueList{
+			ClusterScope: {{Key: AnnotationNcpSnatPool}},
+			"default":    {{Key: "example.com/other"}},
+		}))
+	s.Require().NoError(err)
+
+	resp := h.Validate(context.Background(), ar)
+	s.True(resp.Allowed)
+	s.Equal("source=api; example.com/other@default: absent; "+AnnotationNcpSnatPool+"@*: scanned=2 reused=false holders=0 released=0",
+		resp.AuditAnnotations[response.AuditAnnotationTrace])
+}
+
+func (s *HandlerSuite) TestLocalityHint() {
+	tc := testclient.NewSimpleClientset(poolService("team-a", "a", "other"), poolService("team-c", "c", "test"))
+	var listed []string
+	tc.Fake.PrependReactor("list", "services",
+		func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			listed = append(listed, action.GetNamespace())
+			return false, nil, nil
+		})
+	h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Namespaces: []string{"team-a", "team-b"}}}}))
+	s.Require().NoError(err)
+
+	resp := h.Validate(context.Background(), ar)
+	s.True(resp.Allowed, "values outside of the hinted namespaces are not looked up")
+	s.Equal([]string{"default", "team-a", "team-b"}, listed)
+	s.Equal("source=api; "+AnnotationNcpSnatPool+"@*: locality=default,team-a,team-b scanned=1 reused=false holders=0 released=0",
+		resp.AuditAnnotations[response.AuditAnnotationTrace])
+}
+
+func (s *HandlerSuite) TestDecisionCache() {
+	tc := testclient.NewSimpleClientset()
+	lists := 0
+	tc.Fake.PrependReactor("list", "services",
+		func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			lists++
+			return false, nil, nil
+		})
+
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	clk := testingclock.NewFakeClock(time.Now())
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithClock(clk),
+		WithDecisionCache(time.Minute, factory.Core().V1().Services().Informer()))
+	assert.NoError(s.T(), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	lists = 0
+
+	first := h.Validate(context.Background(), ar)
+	second := h.Validate(context.Background(), ar)
+	assert.True(s.T(), first.Allowed)
+	assert.True(s.T(), second.Allowed)
+	assert.Equal(s.T(), ar.Request.UID, second.UID)
+	assert.Equal(s.T(), 1, lists, "second request should be answered from the cache")
+	assert.True(s.T(), strings.HasPrefix(first.AuditAnnotations[response.AuditAnnotationTrace], "source=api;"))
+	assert.True(s.T(), strings.HasPrefix(second.AuditAnnotations[response.AuditAnnotationTrace], "source=cache;"))
+
+	clk.Step(time.Minute + time.Second)
+	h.Validate(context.Background(), ar)
+	assert.Equal(s.T(), 2, lists, "expired decisions are not used")
+
+	// A service claiming the value invalidates the cached decision.
+	claimant := serviceWithAnnotationOtherValue.DeepCopy()
+	claimant.Annotations[AnnotationNcpSnatPool] = "test"
+	_, err = tc.CoreV1().Services("default").Create(ctx, claimant, metav1.CreateOptions{})
+	assert.NoError(s.T(), err)
+	assert.Eventually(s.T(), func() bool { return !h.Validate(context.Background(), ar).Allowed }, time.Second, 10*time.Millisecond)
+}
+
+// expiringLeases leases every value to other/gone until expires.
+type expiringLeases struct {
+	clk     *testingclock.FakeClock
+	expires time.Time
+}
+
+func (e expiringLeases) LookupLease(string, string, string) (string, time.Time, bool) {
+	return "other/gone", e.expires, e.clk.Now().Before(e.expires)
+}
+
+func (s *HandlerSuite) TestDecisionCacheLease() {
+	tc := testclient.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	clk := testingclock.NewFakeClock(time.Now())
+	leased := UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool, Lease: &metav1.Duration{Duration: time.Hour}}}}
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithClock(clk),
+		WithUniqueList(leased),
+		WithLeaseLookup(expiringLeases{clk, clk.Now().Add(10 * time.Second)}),
+		WithDecisionCache(time.Minute, factory.Core().V1().Services().Informer()))
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	s.False(h.Validate(context.Background(), ar).Allowed)
+	resp := h.Validate(context.Background(), ar)
+	s.False(resp.Allowed)
+	s.True(strings.HasPrefix(resp.AuditAnnotations[response.AuditAnnotationTrace], "source=cache;"), "denials are cached while the lease lasts")
+
+	clk.Step(11 * time.Second)
+	s.True(h.Validate(context.Background(), ar).Allowed, "denials are not cached past the expiry of the lease")
+}
+
+// localBus delivers the claims published on it to the subscribers of its peers.
+type localBus struct {
+	peers       []*localBus
+	subscribers []func(Claim)
+	published   []Claim
+}
+
+func (b *localBus) Publish(_ context.Context, c Claim) error {
+	b.published = append(b.published, c)
+	for _, peer := range b.peers {
+		for _, fn := range peer.subscribers {
+			fn(c)
+		}
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(fn func(Claim)) error {
+	b.subscribers = append(b.subscribers, fn)
+	return nil
+}
+
+func (s *HandlerSuite) TestMissingOptionalFields() {
+	tc := testclient.NewSimpleClientset(poolService("default", "web-x7k2p", "test"))
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(UniqueList{"default": {{
+			Key:      AnnotationNcpSnatPool,
+			Required: &Requirement{Operations: []admissionv1.Operation{admissionv1.Create}},
+		}}}))
+	s.Require().NoError(err)
+
+	review := func(namespace, name string, svc *corev1.Service) admissionv1.AdmissionReview {
+		r := *ar.DeepCopy()
+		r.Request.Namespace = namespace
+		r.Request.Name = name
+		r.Request.Object = runtime.RawExtension{}
+		if svc != nil {
+			raw, err := json.Marshal(svc)
+			s.Require().NoError(err)
+			r.Request.Object.Raw = raw
+		}
+		return r
+	}
+	generated := func(value string) *corev1.Service {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", GenerateName: "web-"}}
+		if value != "" {
+			svc.Annotations = map[string]string{AnnotationNcpSnatPool: value}
+		}
+		return svc
+	}
+
+	s.Run("generateName", func() {
+		resp := h.Validate(context.Background(), review("default", "", generated("test")))
+		s.False(resp.Allowed, "a service without name must not be mistaken for a holder")
+		s.Contains(resp.Result.Message, "default/web-x7k2p")
+
+		resp = h.Validate(context.Background(), review("default", "", generated("")))
+		s.False(resp.Allowed)
+		s.Equal(`Service default/web-* must carry annotation "ncp/snat_pool"`, resp.Result.Message)
+
+		s.True(h.Validate(context.Background(), review("default", "", generated("other"))).Allowed)
+	})
+
+	s.Run("namespace", func() {
+		resp := h.Validate(context.Background(), review("", "", generated("test")))
+		s.False(resp.Allowed, "the namespace of the object determines the scope")
+
+		unscoped := generated("test")
+		unscoped.Namespace = ""
+		resp = h.Validate(context.Background(), review("", "", unscoped))
+		s.False(resp.Allowed)
+		s.Equal(response.Errored("", errors.New("request carries no namespace")).Result, resp.Result)
+	})
+
+	s.Run("object", func() {
+		deletion := review("default", "web-x7k2p", nil)
+		deletion.Request.Operation = admissionv1.Delete
+		resp := h.Validate(context.Background(), deletion)
+		s.True(resp.Allowed)
+		s.Equal(string(response.ReasonNotPresent), resp.AuditAnnotations[response.AuditAnnotationReason])
+
+		resp = h.Validate(context.Background(), review("default", "web", nil))
+		s.False(resp.Allowed)
+		s.Equal(int32(http.StatusInternalServerError), resp.Result.Code)
+	})
+}
+
+func (s *HandlerSuite) TestSelfExclusion() {
+	holder := poolService("default", "web-x7k2p", "test")
+	holder.UID = "first"
+	tc := testclient.NewSimpleClientset(holder)
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithUniqueList(UniqueList{ClusterScope: {{Key: AnnotationNcpSnatPool}}}))
+	s.Require().NoError(err)
+
+	review := func(op admissionv1.Operation, svc *corev1.Service) admissionv1.AdmissionReview {
+		raw, err := json.Marshal(svc)
+		s.Require().NoError(err)
+		r := *ar.DeepCopy()
+		r.Request.Operation = op
+		r.Request.Name = ""
+		r.Request.Object = runtime.RawExtension{Raw: raw}
+		return r
+	}
+
+	// The apiserver has generated name and UID before calling the webhook.
+	self := holder.DeepCopy()
+	self.GenerateName = "web-"
+	s.True(h.Validate(context.Background(), review(admissionv1.Update, self)).Allowed, "a service is not its own holder")
+
+	resubmitted := poolService("default", "web-q4m9z", "test")
+	resubmitted.GenerateName = "web-"
+	resubmitted.UID = "second"
+	resp := h.Validate(context.Background(), review(admissionv1.Create, resubmitted))
+	s.False(resp.Allowed, "a resubmitted service conflicts with the one created first")
+	s.Contains(resp.Result.Message, "default/web-x7k2p")
+
+	recreated := holder.DeepCopy()
+	recreated.UID = "second"
+	s.False(h.Validate(context.Background(), review(admissionv1.Create, recreated)).Allowed, "services of the same name are told apart by UID")
+}
+
+func (s *HandlerSuite) TestClaimBus() {
+	tc := testclient.NewSimpleClientset()
+	busA, busB := &localBus{}, &localBus{}
+	busA.peers = []*localBus{busB}
+
+	a, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithClaimBus(busA))
+	s.Require().NoError(err)
+
+	// The informer of b is never started, so only the claim bus can
+	// invalidate its cached decisions.
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	b, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithDecisionCache(time.Minute, factory.Core().V1().Services().Informer()),
+		WithClaimBus(busB))
+	s.Require().NoError(err)
+	s.Require().True(b.Validate(context.Background(), ar).Allowed)
+
+	claimant := poolService("default", "claimant", "test")
+	raw, err := json.Marshal(claimant)
+	s.Require().NoError(err)
+	review := createReview(raw)
+	review.Request.Name = claimant.Name
+
+	dryRun := *review.DeepCopy()
+	dryRun.Request.DryRun = new(bool)
+	*dryRun.Request.DryRun = true
+	s.Require().True(a.Validate(context.Background(), dryRun).Allowed)
+	s.Empty(busA.published, "dry runs claim nothing")
+
+	s.Require().True(a.Validate(context.Background(), review).Allowed)
+	s.Equal([]Claim{{Key: AnnotationNcpSnatPool, Value: "test"}}, busA.published)
+
+	_, err = tc.CoreV1().Services("default").Create(context.Background(), claimant, metav1.CreateOptions{})
+	s.Require().NoError(err)
+	s.False(b.Validate(context.Background(), ar).Allowed, "the cached decision must have been dropped")
+}
+
+func (s *HandlerSuite) TestValueFilters() {
+	tc := testclient.NewSimpleClientset(poolService("team-a", "a", "other"))
+	lists := 0
+	tc.Fake.PrependReactor("list", "services",
+		func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+			lists++
+			return false, nil, nil
+		})
+
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithValueFilters(factory.Core().V1().Services().Informer(), 100, 0.01))
+	s.Require().NoError(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	lists = 0
+
+	resp := h.Validate(context.Background(), ar)
+	s.True(resp.Allowed)
+	s.Zero(lists, "unused values are answered by the filter")
+	s.Equal("source=api; "+AnnotationNcpSnatPool+"@*: filtered=unused", resp.AuditAnnotations[response.AuditAnnotationTrace])
+
+	// A service claiming the value turns the lookup into a hit.
+	_, err = tc.CoreV1().Services("team-b").Create(ctx, poolService("team-b", "b", "test"), metav1.CreateOptions{})
+	s.Require().NoError(err)
+	s.Eventually(func() bool { return !h.Validate(context.Background(), ar).Allowed }, time.Second, 10*time.Millisecond)
+	s.NotZero(lists)
+}
+
+func (s *HandlerSuite) TestValueFiltersAdmitted() {
+	tc := testclient.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithValueFilters(factory.Core().V1().Services().Informer(), 100, 0.01))
+	s.Require().NoError(err)
+
+	// The informer is stopped once synced, so it never sees the admitted service.
+	ctx, cancel := context.WithCancel(context.Background())
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	cancel()
+	factory.Shutdown()
+
+	s.True(h.Validate(context.Background(), ar).Allowed)
+	_, err = tc.CoreV1().Services("default").Create(context.Background(), poolService("default", "test", "test"), metav1.CreateOptions{})
+	s.Require().NoError(err)
+
+	raw, err := json.Marshal(poolService("team-b", "b", "test"))
+	s.Require().NoError(err)
+	review := createReview(raw)
+	review.Request.Namespace = "team-b"
+	review.Request.Name = "b"
+	resp := h.Validate(context.Background(), review)
+	s.False(resp.Allowed, "admitted values are checked live until the informer has seen them")
+
+	// Claims are kept when the filter is rebuilt.
+	h.values.filters[AnnotationNcpSnatPool].released = 100
+	s.False(h.Validate(context.Background(), review).Allowed)
+
+	h.values.update(nil, poolService("default", "test", "test"))
+	s.Empty(h.values.pending[AnnotationNcpSnatPool], "claims are dropped once the informer has seen them")
+
+	now := time.Now()
+	h.values.claim(AnnotationNcpSnatPool, "other", now)
+	s.False(h.values.build(AnnotationNcpSnatPool, now.Add(pendingClaimTTL+time.Second)).mayContain("other"), "claims the informer never sees expire")
+	s.Empty(h.values.pending[AnnotationNcpSnatPool])
+}
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("value-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.True(t, filter.mayContain(fmt.Sprintf("value-%d", i)), "no false negatives")
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if filter.mayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300)
+	assert.InDelta(t, 0.01, filter.estimatedFalsePositiveRate(), 0.01)
+	assert.False(t, filter.stale())
+
+	filter.released = 251
+	assert.True(t, filter.stale(), "filters are rebuilt once a quarter of their values is released")
+}
+
+func TestHandlerSuite(t *testing.T) {
+	suite.Run(t, new(HandlerSuite))
+}
+
+func (s *HandlerSuite) TestPostProcessors() {
+	RegisterPostProcessor("test-ticket", func(_ context.Context, _ admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) {
+		if resp.AuditAnnotations == nil {
+			resp.AuditAnnotations = make(map[string]string)
+		}
+		resp.AuditAnnotations["example.com/ticket"] = "OPS-1"
+	})
+	s.Contains(PostProcessors(), "test-ticket")
+	s.Panics(func() {
+		RegisterPostProcessor("test-ticket", func(context.Context, admissionv1.AdmissionReview, *admissionv1.AdmissionResponse) {})
+	})
+
+	_, err := NewValidationHandlerV1(WithRegisteredPostProcessors("unknown"))
+	s.Error(err)
+
+	tc := testclient.NewSimpleClientset(poolService("default", "other", "test"))
+	h, err := NewValidationHandlerV1(
+		WithLogger(zaptest.NewLogger(s.T())),
+		WithClientset(tc),
+		WithRegisteredPostProcessors("test-ticket"),
+		WithPostProcessor(func(_ context.Context, _ admissionv1.AdmissionReview, resp *admissionv1.AdmissionResponse) {
+			if resp.Result != nil {
+				resp.Result.Message += " (see OPS-1)"
+			}
+			resp.Allowed = true
+		}))
+	s.Require().NoError(err)
+
+	resp := h.Validate(context.Background(), ar)
+	s.False(resp.Allowed, "post-processors cannot change the decision")
+	s.Equal("OPS-1", resp.AuditAnnotations["example.com/ticket"])
+	s.Require().NotNil(resp.Result)
+	s.True(strings.HasSuffix(resp.Result.Message, " (see OPS-1)"), "post-processors run in order")
+}
+
+func (s *HandlerSuite) TestAttribution() {
+	user := authenticationv1.UserInfo{
+		Username: "system:serviceaccount:ci:deployer",
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:ci"},
+		Extra: map[string]authenticationv1.ExtraValue{
+			"authentication.kubernetes.io/pod-name":      {"runner-1"},
+			"authentication.kubernetes.io/credential-id": {"secret"},
+		},
+	}
+	a := Attribute(user, DefaultAttributionExtra)
+	s.Equal("ci/deployer", a.ServiceAccount)
+	s.Equal(map[string][]string{"authentication.kubernetes.io/pod-name": {"runner-1"}}, a.Extra, "only selected fields are included")
+	s.Equal("requested by system:serviceaccount:ci:deployer, authentication.kubernetes.io/pod-name=runner-1", a.String())
+	s.Empty(Attribute(authenticationv1.UserInfo{Username: "system:serviceaccount:ci"}, nil).ServiceAccount)
+
+	tc := testclient.NewSimpleClientset(poolService("default", "other", "test"))
+	denied := *ar.DeepCopy()
+	denied.Request.UserInfo = user
+	for _, attribute := range []bool{false, true} {
+		h, err := NewValidationHandlerV1(WithLogger(zaptest.NewLogger(s.T())), WithClientset(tc), WithAttribution([]string{"authentication.kubernetes.io/pod-name"}, attribute))
+		s.Require().NoError(err)
+		resp := h.Validate(context.Background(), denied)
+		s.Require().False(resp.Allowed)
+		s.Equal(attribute, strings.HasSuffix(resp.Result.Message, " (requested by system:serviceaccount:ci:deployer, authentication.kubernetes.io/pod-name=runner-1)"))
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ *     warnings.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package validator
+
+import "fmt"
+
+// WarningVerbosity controls which warnings are attached to allowed responses.
+// Warnings are shown to users by kubectl, so informational ones can be
+// perceived as noise.
+type WarningVerbosity string
+
+const (
+	// WarningsNone attaches no warnings at all.
+	WarningsNone WarningVerbosity = "none"
+	// WarningsErrors only attaches warnings about problems, like a missing
+	// required annotation or degraded operation.
+	WarningsErrors WarningVerbosity = "errors-only"
+	// WarningsFull also attaches informational warnings, for example about
+	// unsupported resources or nearly exhausted pools. It is the default.
+	WarningsFull WarningVerbosity = "full"
+)
+
+// ParseWarningVerbosity returns the verbosity called name.
+func ParseWarningVerbosity(name string) (WarningVerbosity, error) {
+	switch v := WarningVerbosity(name); v {
+	case WarningsNone, WarningsErrors, WarningsFull:
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown warning verbosity %q", name)
+}
+
+// WithWarningVerbosity sets which warnings are attached to allowed responses.
+func WithWarningVerbosity(v WarningVerbosity) ValidationHandlerOption {
+	return func(h *AdmitHandlerV1) error {
+		if _, err := ParseWarningVerbosity(string(v)); err != nil {
+			return err
+		}
+		h.verbosity = v
+		return nil
+	}
+}
+
+// problem returns msg as warning unless warnings are disabled.
+func (h *AdmitHandlerV1) problem(msg string) []string {
+	if h.verbosity == WarningsNone {
+		return nil
+	}
+	return []string{msg}
+}
+
+// info returns msg as warning if informational warnings are enabled.
+func (h *AdmitHandlerV1) info(msg string) []string {
+	if h.verbosity != WarningsFull {
+		return nil
+	}
+	return []string{msg}
+}
@@ -0,0 +1,84 @@
+/*
+ *     policy.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// policyFlags configure the protected annotation of the single annotation
+// binary. They form the base configuration of the controller and are what
+// "migrate legacy" translates into a configuration.
+type policyFlags struct {
+	immutable               bool
+	require                 string
+	releaseTerminatingAfter time.Duration
+	lease                   time.Duration
+	pool                    string
+	poolWarningThreshold    int
+}
+
+func (p *policyFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&p.immutable, "immutable", false, "deny updates changing or removing the "+validator.AnnotationNcpSnatPool+" annotation")
+	fs.StringVar(&p.require, "require", "", "require the "+validator.AnnotationNcpSnatPool+" annotation on LoadBalancer services; one of \"deny\" or \"warn\"")
+	fs.DurationVar(&p.releaseTerminatingAfter, "release-terminating-after", 0, "release the "+validator.AnnotationNcpSnatPool+" value of services terminating for longer than this, so it can be claimed again; 0 keeps it until the service is gone")
+	fs.DurationVar(&p.lease, "lease", 0, "keep the "+validator.AnnotationNcpSnatPool+" value of deleted services reserved for a service of the same name for this long; requires scanning")
+	fs.StringVar(&p.pool, "pool", "", "comma separated list of the values available for the "+validator.AnnotationNcpSnatPool+" annotation; enables utilization metrics")
+	fs.IntVar(&p.poolWarningThreshold, "pool-warning-threshold", 90, "warn when admitting services while more than this percentage of -pool is in use; 0 disables the warning")
+}
+
+// annotation returns the protected annotation configured by the flags.
+func (p *policyFlags) annotation() (validator.ProtectedAnnotation, error) {
+	snatPool := validator.ProtectedAnnotation{Key: validator.AnnotationNcpSnatPool, Immutable: p.immutable}
+	if p.releaseTerminatingAfter > 0 {
+		snatPool.ReleaseTerminatingAfter = &metav1.Duration{Duration: p.releaseTerminatingAfter}
+	}
+	if p.lease > 0 {
+		snatPool.Lease = &metav1.Duration{Duration: p.lease}
+	}
+	snatPool.Pool = splitList(p.pool)
+	snatPool.PoolWarningThreshold = p.poolWarningThreshold
+	switch action := validator.RequirementAction(p.require); action {
+	case "":
+	case validator.RequirementDeny, validator.RequirementWarn:
+		snatPool.Required = &validator.Requirement{
+			ServiceTypes: []corev1.ServiceType{corev1.ServiceTypeLoadBalancer},
+			Action:       action,
+		}
+	default:
+		return snatPool, fmt.Errorf("invalid value for -require: %q", p.require)
+	}
+	return snatPool, nil
+}
+
+// protectedList returns the annotations given by -protected-annotations.
+func protectedList() []validator.ProtectedAnnotation {
+	var list []validator.ProtectedAnnotation
+	for _, key := range splitList(protectedAnnotations) {
+		list = append(list, validator.ProtectedAnnotation{Key: key})
+	}
+	return list
+}
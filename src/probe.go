@@ -0,0 +1,63 @@
+/*
+ *     probe.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/unik-k8s/admission-controller/internal/probe"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// probeName is the name and annotation value of the canary service.
+const probeName = "unik-consistency-probe"
+
+// probeCanary returns the canary of the consistency probe: the dry-run
+// creation of a LoadBalancer service in namespace, carrying all
+// annotations protected there, as configured by current.
+func probeCanary(current func() *config.Config, namespace string) probe.Canary {
+	return func() admissionv1.AdmissionReview {
+		svc := corev1.Service{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: probeName, Annotations: map[string]string{}},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		}
+		for _, a := range current().Protected.ProtectedInNamespace(namespace) {
+			svc.Annotations[a.Key] = probeName
+		}
+		// Encoding a Service does not fail.
+		raw, _ := json.Marshal(svc)
+		dryRun := true
+		return admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+			UID:       probeName,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+			Namespace: namespace,
+			Name:      probeName,
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+			DryRun:    &dryRun,
+		}}
+	}
+}
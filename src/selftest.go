@@ -0,0 +1,312 @@
+/*
+ *     selftest.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// errSkipped marks self-test cases which could not be run in the cluster.
+var errSkipped = errors.New("skipped")
+
+// runSelftest implements the "selftest" command. It returns the exit code
+// of the process.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	namespace := fs.String("namespace", "default", "namespace the synthetic services are created in, with dry-run only")
+	configuration := fs.String("webhook-configuration", "unik-admission-controller", "name of the ValidatingWebhookConfiguration registering the webhook")
+	webhook := fs.String("webhook-name", "unik-k8s.github.com", "name of the webhook within -webhook-configuration")
+	annotation := fs.String("annotation", validator.AnnotationNcpSnatPool, "protected annotation used by the synthetic services")
+	clusterScoped := fs.Bool("cluster-scoped", true, "whether -annotation is protected cluster wide, so a value held in another namespace than -namespace must be denied, too")
+	timeout := fs.Duration("timeout", 30*time.Second, "maximum time for the whole self-test")
+	var kubeconfig kubeconfigFlags
+	kubeconfig.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s selftest [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	restConfig, err := kubeconfig.config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+		return 1
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to cluster: %s\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	st := &selftest{clientset: clientset, namespace: *namespace, annotation: *annotation, clusterScoped: *clusterScoped, webhook: *webhook}
+	if st.service, err = webhookService(ctx, clientset, *configuration, *webhook); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !st.run(ctx, os.Stdout) {
+		return 1
+	}
+	return 0
+}
+
+// selftest sends synthetic requests through the apiserver to a deployed
+// webhook. Services are only ever created with dry-run.
+type selftest struct {
+	clientset  kubernetes.Interface
+	namespace  string
+	annotation string
+	webhook    string
+	// clusterScoped is set if annotation is protected cluster wide.
+	clusterScoped bool
+	// service is the endpoint the webhook is registered with, if it is
+	// registered with a service.
+	service *admissionregistrationv1.ServiceReference
+}
+
+// run runs all cases, reporting each of them to out. It returns false if
+// any case failed.
+func (st *selftest) run(ctx context.Context, out io.Writer) bool {
+	cases := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"allow", st.allow},
+		{"deny", st.deny},
+		{"unsupported-kind", st.unsupported},
+		{"malformed", st.malformed},
+	}
+	passed := true
+	for _, c := range cases {
+		err := c.run(ctx)
+		switch {
+		case err == nil:
+			fmt.Fprintf(out, "PASS %s\n", c.name)
+		case errors.Is(err, errSkipped):
+			fmt.Fprintf(out, "SKIP %s: %s\n", c.name, err)
+		default:
+			fmt.Fprintf(out, "FAIL %s: %s\n", c.name, err)
+			passed = false
+		}
+	}
+	return passed
+}
+
+// allow creates a service with a value nobody else holds.
+func (st *selftest) allow(ctx context.Context) error {
+	_, err := st.create(ctx, "unik-selftest-"+randomSuffix())
+	return err
+}
+
+// deny creates a service claiming the value of an existing service.
+func (st *selftest) deny(ctx context.Context) error {
+	holder, err := st.holder(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = st.create(ctx, holder.Annotations[st.annotation])
+	switch {
+	case err == nil:
+		return fmt.Errorf("value %q of %s/%s was admitted twice", holder.Annotations[st.annotation], holder.Namespace, holder.Name)
+	case apierrors.IsForbidden(err) && strings.Contains(err.Error(), st.webhook):
+		return nil
+	default:
+		return fmt.Errorf("expected a denial by %s: %w", st.webhook, err)
+	}
+}
+
+// holder returns a service holding a value of the annotation, preferring
+// services in the namespace of the test. Services in other namespaces are
+// only used if the annotation is protected cluster wide, as namespaced
+// scopes only protect values within their namespaces.
+func (st *selftest) holder(ctx context.Context) (*corev1.Service, error) {
+	namespaces := []string{st.namespace}
+	if st.clusterScoped {
+		namespaces = append(namespaces, metav1.NamespaceAll)
+	}
+	for _, ns := range namespaces {
+		list, err := st.clientset.CoreV1().Services(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing services: %w", err)
+		}
+		for i, svc := range list.Items {
+			if _, found := svc.Annotations[st.annotation]; found {
+				return &list.Items[i], nil
+			}
+		}
+	}
+	if !st.clusterScoped {
+		return nil, fmt.Errorf("%w: no service in %s holds a value of %s", errSkipped, st.namespace, st.annotation)
+	}
+	return nil, fmt.Errorf("%w: no service holds a value of %s", errSkipped, st.annotation)
+}
+
+func (st *selftest) create(ctx context.Context, value string) (*corev1.Service, error) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "unik-selftest-" + randomSuffix(),
+			Annotations: map[string]string{st.annotation: value},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+	}
+	return st.clientset.CoreV1().Services(st.namespace).Create(ctx, svc, metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}})
+}
+
+// unsupported sends a review of a ConfigMap, which must be admitted. The
+// apiserver only sends services to the webhook, so the review is posted
+// to the webhook through the service proxy of the apiserver instead.
+func (st *selftest) unsupported(ctx context.Context) error {
+	raw, err := json.Marshal(&corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: st.namespace, Name: "unik-selftest"},
+	})
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionv1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "unik-selftest",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			Namespace: st.namespace,
+			Name:      "unik-selftest",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	status, data, err := st.post(ctx, body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("expected status %d, got %d: %s", http.StatusOK, status, data)
+	}
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(data, &review); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	switch {
+	case review.Response == nil:
+		return errors.New("response is missing")
+	case review.Response.UID != "unik-selftest":
+		return fmt.Errorf("response carries UID %q instead of the one of the request", review.Response.UID)
+	case !review.Response.Allowed:
+		return errors.New("unsupported kind was denied")
+	}
+	return nil
+}
+
+// malformed sends a body which is not an AdmissionReview, which must be
+// rejected as a bad request.
+func (st *selftest) malformed(ctx context.Context) error {
+	status, data, err := st.post(ctx, []byte(`{"request":`))
+	if err != nil {
+		return err
+	}
+	if status != http.StatusBadRequest {
+		return fmt.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, status, data)
+	}
+	return nil
+}
+
+// post sends body to the webhook through the service proxy of the apiserver.
+func (st *selftest) post(ctx context.Context, body []byte) (int, []byte, error) {
+	if st.service == nil {
+		return 0, nil, fmt.Errorf("%w: webhook is not registered with a service", errSkipped)
+	}
+	port := int32(443)
+	if st.service.Port != nil {
+		port = *st.service.Port
+	}
+	path := "/"
+	if st.service.Path != nil {
+		path = *st.service.Path
+	}
+
+	var status int
+	result := st.clientset.CoreV1().RESTClient().Post().
+		Namespace(st.service.Namespace).
+		Resource("services").
+		Name(fmt.Sprintf("https:%s:%d", st.service.Name, port)).
+		SubResource("proxy").
+		Suffix(path).
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do(ctx).
+		StatusCode(&status)
+	data, err := result.Raw()
+	if status == 0 {
+		return 0, nil, fmt.Errorf("posting to webhook: %w", err)
+	}
+	return status, data, nil
+}
+
+// webhookService returns the service webhook of configuration is
+// registered with, or nil if it is registered with a URL.
+func webhookService(ctx context.Context, clientset kubernetes.Interface, configuration, webhook string) (*admissionregistrationv1.ServiceReference, error) {
+	vwc, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, configuration, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("reading webhook configuration: %w", err)
+	}
+	for _, w := range vwc.Webhooks {
+		if w.Name == webhook {
+			return w.ClientConfig.Service, nil
+		}
+	}
+	return nil, fmt.Errorf("webhook %q not found in %q", webhook, configuration)
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
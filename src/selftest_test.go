@@ -0,0 +1,81 @@
+/*
+ *     selftest_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	testclient "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSelftest(t *testing.T) {
+	holder := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "team",
+		Name:        "holder",
+		Annotations: map[string]string{validator.AnnotationNcpSnatPool: "taken"},
+	}}
+
+	testCases := []struct {
+		desc          string
+		services      []runtime.Object
+		namespace     string
+		clusterScoped bool
+		enforced      bool
+		expected      string
+		passed        bool
+	}{
+		{"enforced", []runtime.Object{holder}, "team", true, true, "PASS allow\nPASS deny\n", true},
+		{"not enforced", []runtime.Object{holder}, "team", true, false, "PASS allow\nFAIL deny: value \"taken\" of team/holder was admitted twice\n", false},
+		{"no holder", nil, "team", true, true, "PASS allow\nSKIP deny: skipped: no service holds a value of " + validator.AnnotationNcpSnatPool + "\n", true},
+		{"holder in other namespace", []runtime.Object{holder}, "other", true, true, "PASS allow\nPASS deny\n", true},
+		{"holder out of namespaced scope", []runtime.Object{holder}, "other", false, false, "PASS allow\nSKIP deny: skipped: no service in other holds a value of " + validator.AnnotationNcpSnatPool + "\n", true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			tc := testclient.NewSimpleClientset(tC.services...)
+			tc.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				svc := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+				if tC.enforced && svc.Annotations[validator.AnnotationNcpSnatPool] == "taken" {
+					return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "services"}, svc.Name,
+						errors.New(`admission webhook "unik-k8s.github.com" denied the request`))
+				}
+				return true, svc, nil
+			})
+
+			var out bytes.Buffer
+			st := &selftest{clientset: tc, namespace: tC.namespace, annotation: validator.AnnotationNcpSnatPool, clusterScoped: tC.clusterScoped, webhook: "unik-k8s.github.com"}
+			assert.Equal(t, tC.passed, st.run(context.Background(), &out))
+			assert.Equal(t, tC.expected+
+				"SKIP unsupported-kind: skipped: webhook is not registered with a service\n"+
+				"SKIP malformed: skipped: webhook is not registered with a service\n", out.String())
+		})
+	}
+}
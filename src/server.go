@@ -0,0 +1,135 @@
+/*
+ *     server.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unik-k8s/admission-controller/internal/health"
+	"golang.org/x/net/http2"
+)
+
+// addrList collects the values of a flag which may be given multiple times.
+type addrList []string
+
+func (a *addrList) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *addrList) Set(v string) error {
+	*a = append(*a, v)
+	return nil
+}
+
+// splitList splits a comma separated flag value, dropping empty elements.
+func splitList(v string) []string {
+	var list []string
+	for _, e := range strings.Split(v, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// newServer creates a server with the timeouts and limits set by flags.
+// The zero values of http.Server would leave connections open indefinitely,
+// which makes the webhook vulnerable to slow clients exhausting its resources.
+func newServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+}
+
+// configureHTTP2 enables or disables HTTP/2 on srv according to the flags
+// and caps the number of idle connections. The apiserver keeps long-lived
+// connections to webhooks, which pile up during apiserver restarts.
+func configureHTTP2(srv *http.Server) error {
+	if !enableHTTP2 {
+		// A non-nil, empty map disables the automatic HTTP/2 upgrade.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	} else if err := http2.ConfigureServer(srv, &http2.Server{
+		MaxConcurrentStreams: http2MaxStreams,
+		IdleTimeout:          idleTimeout,
+	}); err != nil {
+		return fmt.Errorf("configuring HTTP/2: %w", err)
+	}
+
+	if maxIdleConns > 0 {
+		srv.ConnState = (&idleLimiter{max: maxIdleConns, idle: make(map[net.Conn]struct{})}).track
+	}
+	return nil
+}
+
+// idleLimiter closes connections becoming idle once max idle connections exist.
+type idleLimiter struct {
+	max  int
+	lock sync.Mutex
+	idle map[net.Conn]struct{}
+}
+
+func (l *idleLimiter) track(c net.Conn, state http.ConnState) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	switch state {
+	case http.StateIdle:
+		if len(l.idle) >= l.max {
+			c.Close()
+			return
+		}
+		l.idle[c] = struct{}{}
+	default:
+		delete(l.idle, c)
+	}
+}
+
+// certificateHealth checks that the serving certificate can be loaded and
+// has not expired, as the apiserver cannot call the webhook otherwise.
+func certificateHealth(certFile, keyFile string) health.Check {
+	return func(context.Context) error {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		if time.Now().After(leaf.NotAfter) {
+			return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
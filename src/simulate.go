@@ -0,0 +1,158 @@
+/*
+ *     simulate.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/pkg/api"
+	"github.com/unik-k8s/admission-controller/pkg/response"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// simulator is a handler.Simulator. Objects are reviewed against a snapshot
+// of the services in the cluster, to which admitted objects are added, so
+// simulations never write to the cluster or touch the live configuration.
+type simulator struct {
+	clientset kubernetes.Interface
+	// options create the validators of the simulations. They must not
+	// include a clientset, decision cache, escalation or events.
+	options []validator.ValidationHandlerOption
+}
+
+func (s *simulator) Simulate(ctx context.Context, req api.SimulateRequest) ([]api.SimulatedDecision, error) {
+	if err := req.Config.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", handler.ErrInvalidSimulation, err)
+	}
+	list := req.Config.Protected
+	if req.Domain != "" {
+		var found bool
+		if list, found = req.Config.Domains[req.Domain]; !found {
+			return nil, fmt.Errorf("%w: unknown domain %q", handler.ErrInvalidSimulation, req.Domain)
+		}
+	}
+	for i, svc := range req.Objects {
+		if svc.Namespace == "" || svc.Name == "" {
+			return nil, fmt.Errorf("%w: object %d: namespace and name are required", handler.ErrInvalidSimulation, i)
+		}
+	}
+
+	live, err := s.clientset.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing services: %w", err)
+	}
+	objects := make([]runtime.Object, len(live.Items))
+	for i := range live.Items {
+		objects[i] = &live.Items[i]
+	}
+	world := fake.NewSimpleClientset(objects...)
+
+	v, err := validator.NewValidationHandlerV1(append(slices.Clone(s.options),
+		validator.WithLogger(zap.NewNop()),
+		validator.WithClientset(world),
+		validator.WithUniqueList(list))...)
+	if err != nil {
+		return nil, fmt.Errorf("creating validator: %w", err)
+	}
+
+	decisions := make([]api.SimulatedDecision, 0, len(req.Objects))
+	for i, svc := range req.Objects {
+		services := world.CoreV1().Services(svc.Namespace)
+		old, err := services.Get(ctx, svc.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			old = nil
+		case err != nil:
+			return nil, err
+		}
+
+		review, err := simulatedReview(fmt.Sprintf("simulate-%d", i), svc, old)
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", i, err)
+		}
+		resp := v.Validate(ctx, review)
+		decision := api.SimulatedDecision{
+			Namespace: svc.Namespace,
+			Name:      svc.Name,
+			Allowed:   resp.Allowed,
+			Reason:    resp.AuditAnnotations[response.AuditAnnotationReason],
+			Warnings:  resp.Warnings,
+		}
+		if resp.Result != nil {
+			decision.Message = resp.Result.Message
+		}
+		decisions = append(decisions, decision)
+
+		if !resp.Allowed {
+			continue
+		}
+		if old == nil {
+			_, err = services.Create(ctx, &svc, metav1.CreateOptions{})
+		} else {
+			svc.ResourceVersion = old.ResourceVersion
+			_, err = services.Update(ctx, &svc, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("object %d: %w", i, err)
+		}
+	}
+	return decisions, nil
+}
+
+// simulatedReview returns a review for the creation of svc, or its update
+// if old is set.
+func simulatedReview(uid string, svc corev1.Service, old *corev1.Service) (admissionv1.AdmissionReview, error) {
+	svc.APIVersion, svc.Kind = "v1", "Service"
+	raw, err := json.Marshal(svc)
+	if err != nil {
+		return admissionv1.AdmissionReview{}, err
+	}
+	review := admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		UID:       types.UID(uid),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+		Name:      svc.Name,
+		Namespace: svc.Namespace,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+	if old != nil {
+		old = old.DeepCopy()
+		old.APIVersion, old.Kind = "v1", "Service"
+		if review.Request.OldObject.Raw, err = json.Marshal(old); err != nil {
+			return admissionv1.AdmissionReview{}, err
+		}
+		review.Request.Operation = admissionv1.Update
+	}
+	return review, nil
+}
@@ -0,0 +1,78 @@
+/*
+ *     simulate_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/pkg/api"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSimulate(t *testing.T) {
+	annotated := func(namespace, name, value string) corev1.Service {
+		return corev1.Service{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{"example.com/ip": value},
+		}}
+	}
+	live := annotated("a", "holder", "10.0.0.1")
+	tc := testclient.NewSimpleClientset(&live)
+	sim := &simulator{clientset: tc}
+
+	candidate := config.Config{Protected: validator.UniqueList{validator.ClusterScope: {{Key: "example.com/ip"}}}}
+	decisions, err := sim.Simulate(context.Background(), api.SimulateRequest{
+		Config: candidate,
+		Objects: []corev1.Service{
+			annotated("b", "conflict", "10.0.0.1"),
+			annotated("b", "first", "10.0.0.2"),
+			annotated("c", "second", "10.0.0.2"),
+			annotated("a", "holder", "10.0.0.3"),
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, decisions, 4)
+	assert.False(t, decisions[0].Allowed, "live services are taken into account")
+	assert.Contains(t, decisions[0].Message, "a/holder")
+	assert.True(t, decisions[1].Allowed)
+	assert.False(t, decisions[2].Allowed, "admitted objects are visible to later ones")
+	assert.True(t, decisions[3].Allowed, "existing objects are updated")
+
+	services, err := tc.CoreV1().Services("").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, services.Items, 1, "simulations do not write to the cluster")
+
+	_, err = sim.Simulate(context.Background(), api.SimulateRequest{Config: candidate, Domain: "dns"})
+	assert.True(t, errors.Is(err, handler.ErrInvalidSimulation))
+	_, err = sim.Simulate(context.Background(), api.SimulateRequest{
+		Config: config.Config{Protected: validator.UniqueList{"": {{Key: "example.com/ip"}}}},
+	})
+	assert.True(t, errors.Is(err, handler.ErrInvalidSimulation))
+}
@@ -0,0 +1,236 @@
+//go:build soak
+
+/*
+ *     soak_test.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+// Package soak drives the webhook for a long time with realistic churn and
+// asserts that memory and goroutines stay bounded. Run it with
+//
+//	mage soak
+//
+// or go test -tags soak ./soak -soak.duration=4h.
+package soak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/unik-k8s/admission-controller/internal/handler"
+	"github.com/unik-k8s/admission-controller/internal/scanner"
+	"github.com/unik-k8s/admission-controller/pkg/validator"
+	"go.uber.org/zap"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	testclient "k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	duration   = flag.Duration("soak.duration", time.Minute, "how long to drive the webhook")
+	warmup     = flag.Duration("soak.warmup", 10*time.Second, "time after which the baseline is taken")
+	sample     = flag.Duration("soak.sample", 5*time.Second, "interval in which memory and goroutines are checked")
+	objects    = flag.Int("soak.objects", 1000, "number of services kept in the cluster")
+	values     = flag.Int("soak.values", 2000, "number of distinct annotation values")
+	heapGrowth = flag.Float64("soak.heap-growth", 1.5, "factor by which the heap may grow beyond the baseline")
+	heapSlack  = flag.Uint64("soak.heap-slack", 16<<20, "bytes by which the heap may grow beyond the allowed factor")
+	goroutines = flag.Int("soak.goroutine-slack", 20, "number of goroutines which may be added to the baseline")
+)
+
+const annotation = "example.com/ip"
+
+func TestSoak(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tc := testclient.NewSimpleClientset()
+	list := func(lease time.Duration) validator.UniqueList {
+		return validator.UniqueList{validator.ClusterScope: {{Key: annotation, Lease: &metav1.Duration{Duration: lease}}}}
+	}
+
+	sc, err := scanner.NewScanner(
+		scanner.WithLogger(zap.NewNop()),
+		scanner.WithClientset(tc),
+		scanner.WithUniqueList(list(time.Second)),
+		scanner.WithInterval(time.Second))
+	require.NoError(t, err)
+	go sc.Run(ctx)
+
+	factory := informers.NewSharedInformerFactory(tc, 0)
+	informer := factory.Core().V1().Services().Informer()
+	v, err := validator.NewValidationHandlerV1(
+		validator.WithLogger(zap.NewNop()),
+		validator.WithClientset(tc),
+		validator.WithUniqueList(list(time.Second)),
+		validator.WithDecisionCache(time.Second, informer),
+		validator.WithValueFilters(informer, *objects, 0.01),
+		validator.WithDenialEscalation(3, time.Second),
+		validator.WithLeaseLookup(sc))
+	require.NoError(t, err)
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	h, err := handler.AdmissionReviewRequesthandler(v)
+	require.NoError(t, err)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	d := &driver{t: t, clientset: tc, url: srv.URL, client: srv.Client(), rnd: rand.New(rand.NewSource(1))}
+
+	var baseline *usage
+	start := time.Now()
+	next := start.Add(*warmup)
+	for i := 0; time.Since(start) < *duration; i++ {
+		d.step(ctx)
+		if i%500 == 0 {
+			// Configuration reloads flush caches and replace the list.
+			v.SetUniqueList(list(time.Duration(1+i%3) * time.Second))
+			sc.SetUniqueList(list(time.Duration(1+i%3) * time.Second))
+		}
+		if time.Now().Before(next) {
+			continue
+		}
+		next = time.Now().Add(*sample)
+
+		// The fake clientset records every action it served.
+		tc.ClearActions()
+
+		u := measure()
+		if baseline == nil {
+			baseline = &u
+			t.Logf("baseline: heap %d bytes, %d goroutines", u.heap, u.goroutines)
+			continue
+		}
+		t.Logf("%s: heap %d bytes, %d goroutines, %d reviews", time.Since(start).Round(time.Second), u.heap, u.goroutines, d.reviews)
+		require.LessOrEqual(t, u.heap, uint64(float64(baseline.heap)**heapGrowth)+*heapSlack, "heap grew beyond its bound")
+		require.LessOrEqual(t, u.goroutines, baseline.goroutines+*goroutines, "goroutines leaked")
+	}
+	require.NotNil(t, baseline, "-soak.duration must exceed -soak.warmup")
+}
+
+type usage struct {
+	heap       uint64
+	goroutines int
+}
+
+// measure returns the live heap after a full collection and the number of goroutines.
+func measure() usage {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return usage{heap: m.HeapAlloc, goroutines: runtime.NumGoroutine()}
+}
+
+// driver sends reviews to the webhook and applies the admitted changes to
+// the cluster, mimicking the apiserver.
+type driver struct {
+	t         *testing.T
+	clientset kubernetes.Interface
+	url       string
+	client    *http.Client
+	rnd       *rand.Rand
+	reviews   int
+}
+
+// step creates, updates or deletes one of the configured number of services.
+func (d *driver) step(ctx context.Context) {
+	i := d.rnd.Intn(*objects)
+	namespace, name := fmt.Sprintf("ns-%d", i%50), fmt.Sprintf("svc-%d", i)
+	services := d.clientset.CoreV1().Services(namespace)
+	old, err := services.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		old = nil
+	}
+
+	if old != nil && d.rnd.Intn(3) == 0 {
+		require.NoError(d.t, services.Delete(ctx, name, metav1.DeleteOptions{}))
+		return
+	}
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   namespace,
+		Name:        name,
+		Annotations: map[string]string{annotation: fmt.Sprintf("10.0.%d.%d", d.rnd.Intn(*values)/256, d.rnd.Intn(*values)%256)},
+	}}
+	if !d.review(svc, old) {
+		return
+	}
+	if old == nil {
+		_, err = services.Create(ctx, svc, metav1.CreateOptions{})
+	} else {
+		svc.ResourceVersion = old.ResourceVersion
+		_, err = services.Update(ctx, svc, metav1.UpdateOptions{})
+	}
+	require.NoError(d.t, err)
+}
+
+// review posts the review of svc to the webhook and returns whether it was admitted.
+func (d *driver) review(svc, old *corev1.Service) bool {
+	d.reviews++
+	req := &admissionv1.AdmissionRequest{
+		UID:       types.UID(fmt.Sprintf("soak-%d", d.reviews)),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: fmt.Sprintf("user-%d", d.rnd.Intn(10))},
+		Object:    raw(d.t, svc),
+	}
+	if old != nil {
+		req.Operation = admissionv1.Update
+		req.OldObject = raw(d.t, old)
+	}
+	body, err := json.Marshal(admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  req,
+	})
+	require.NoError(d.t, err)
+
+	resp, err := d.client.Post(d.url, "application/json", bytes.NewReader(body))
+	require.NoError(d.t, err)
+	defer resp.Body.Close()
+	require.Equal(d.t, http.StatusOK, resp.StatusCode)
+
+	var review admissionv1.AdmissionReview
+	require.NoError(d.t, json.NewDecoder(resp.Body).Decode(&review))
+	return review.Response.Allowed
+}
+
+func raw(t *testing.T, svc *corev1.Service) k8sruntime.RawExtension {
+	svc = svc.DeepCopy()
+	svc.APIVersion, svc.Kind = "v1", "Service"
+	data, err := json.Marshal(svc)
+	require.NoError(t, err)
+	return k8sruntime.RawExtension{Raw: data}
+}
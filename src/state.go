@@ -0,0 +1,57 @@
+/*
+ *     state.go is part of github.com/unik-k8s/admission-controller.
+ *
+ *     Copyright 2023 Markus W Mahlberg <07.federkleid-nagelhaut@icloud.com>
+ *
+ *     Licensed under the Apache License, Version 2.0 (the "License");
+ *     you may not use this file except in compliance with the License.
+ *     You may obtain a copy of the License at
+ *
+ *         http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *     Unless required by applicable law or agreed to in writing, software
+ *     distributed under the License is distributed on an "AS IS" BASIS,
+ *     WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *     See the License for the specific language governing permissions and
+ *     limitations under the License.
+ *
+ */
+
+package main
+
+import (
+	"context"
+
+	"github.com/unik-k8s/admission-controller/api/v1alpha1"
+	"github.com/unik-k8s/admission-controller/internal/featuregate"
+	"github.com/unik-k8s/admission-controller/internal/health"
+	"github.com/unik-k8s/admission-controller/internal/state"
+	"github.com/unik-k8s/admission-controller/pkg/config"
+)
+
+// collectState returns the state published in the UnikControllerState:
+// the build, the configuration in effect as returned by current, the
+// feature gates and the results of the checks of checker.
+func collectState(checker *health.Checker, current func() *config.Config) state.Collect {
+	return func(ctx context.Context) v1alpha1.UnikControllerStateStatus {
+		results, ready := checker.Check(ctx)
+		status := v1alpha1.UnikControllerStateStatus{
+			Version:      version,
+			Commit:       commit,
+			ConfigHash:   current().Hash(),
+			FeatureGates: make(map[string]bool),
+			Ready:        ready,
+		}
+		for f, enabled := range featuregate.Default.Features() {
+			status.FeatureGates[string(f)] = enabled
+		}
+		for _, r := range results {
+			subsystem := v1alpha1.SubsystemHealth{Name: r.Name, Critical: r.Criticality == health.Critical, Healthy: r.Err == nil}
+			if r.Err != nil {
+				subsystem.Message = r.Err.Error()
+			}
+			status.Subsystems = append(status.Subsystems, subsystem)
+		}
+		return status
+	}
+}